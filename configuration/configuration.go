@@ -61,6 +61,13 @@ type Configuration struct {
 	// used to gate requests.
 	Auth Auth `yaml:"auth,omitempty"`
 
+	// Robots configures robot accounts, which authenticate with scoped,
+	// expiring secrets over HTTP basic auth.
+	Robots Robots `yaml:"robots,omitempty"`
+
+	// Admin configures the administrative HTTP API.
+	Admin Admin `yaml:"admin,omitempty"`
+
 	// Middleware lists all middlewares to be used by the registry.
 	Middleware map[string][]Middleware `yaml:"middleware,omitempty"`
 
@@ -182,6 +189,10 @@ type Configuration struct {
 
 	Proxy Proxy `yaml:"proxy,omitempty"`
 
+	// OCILayout configures the registry to serve repositories from OCI
+	// image-layout directories instead of its storage.
+	OCILayout OCILayout `yaml:"ocilayout,omitempty"`
+
 	// Validation configures validation options for the registry.
 	Validation Validation `yaml:"validation,omitempty"`
 
@@ -196,6 +207,10 @@ type Configuration struct {
 			// the class in authorized resources.
 			Classes []string `yaml:"classes"`
 		} `yaml:"repository,omitempty"`
+
+		// Anonymous configures access which is granted to requests without
+		// credentials, even when an access controller is configured.
+		Anonymous Anonymous `yaml:"anonymous,omitempty"`
 	} `yaml:"policy,omitempty"`
 }
 
@@ -557,20 +572,94 @@ type Notifications struct {
 // Endpoint describes the configuration of an http webhook notification
 // endpoint.
 type Endpoint struct {
-	Name              string        `yaml:"name"`              // identifies the endpoint in the registry instance.
-	Disabled          bool          `yaml:"disabled"`          // disables the endpoint
-	URL               string        `yaml:"url"`               // post url for the endpoint.
-	Headers           http.Header   `yaml:"headers"`           // static headers that should be added to all requests
-	Timeout           time.Duration `yaml:"timeout"`           // HTTP timeout
-	Threshold         int           `yaml:"threshold"`         // circuit breaker threshold before backing off on failure
-	Backoff           time.Duration `yaml:"backoff"`           // backoff duration
-	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"` // target media types to ignore
-	Ignore            Ignore        `yaml:"ignore"`            // ignore event types
+	Name              string        `yaml:"name"`                 // identifies the endpoint in the registry instance.
+	Disabled          bool          `yaml:"disabled"`             // disables the endpoint
+	Type              string        `yaml:"type,omitempty"`       // sink type, "http" by default
+	Parameters        Parameters    `yaml:"parameters,omitempty"` // parameters of non-http sink types
+	URL               string        `yaml:"url"`                  // post url for the endpoint.
+	Headers           http.Header   `yaml:"headers"`              // static headers that should be added to all requests
+	Secret            string        `yaml:"secret,omitempty"`     // signs request bodies
+	Secrets           []string      `yaml:"secrets,omitempty"`    // additional signing secrets, for rotation
+	Timeout           time.Duration `yaml:"timeout"`              // HTTP timeout
+	Threshold         int           `yaml:"threshold"`            // circuit breaker threshold before backing off on failure
+	Backoff           time.Duration `yaml:"backoff"`              // backoff duration
+	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"`    // target media types to ignore
+	Ignore            Ignore        `yaml:"ignore"`               // ignore event types
+	Filter            Filter        `yaml:"filter,omitempty"`     // select events by repository, tag and actor
+	Queue             EndpointQueue `yaml:"queue"`                // queue of pending events
+	Batch             EndpointBatch `yaml:"batch,omitempty"`      // groups events into requests
+	DeadLetter        DeadLetter    `yaml:"deadletter,omitempty"` // events exceeding the retry budget
+	Format            string        `yaml:"format,omitempty"`     // "envelope" (default) or "cloudevents"
+	CloudEvents       CloudEvents   `yaml:"cloudevents,omitempty"`
+}
+
+// CloudEvents configures the CloudEvents format of an endpoint.
+type CloudEvents struct {
+	// Mode is the CloudEvents content mode: "structured" (the default) sends
+	// one event per request, "batch" sends a list of events.
+	Mode string `yaml:"mode,omitempty"`
+}
+
+// DeadLetter configures the retry budget of an endpoint and where the events
+// exceeding it are kept.
+type DeadLetter struct {
+	// MaxAttempts is the number of failed delivery attempts after which an
+	// event is dead-lettered. Zero retries events until they are delivered,
+	// unless a dead-letter store is configured, which defaults it to 10.
+	MaxAttempts int `yaml:"maxattempts,omitempty"`
+
+	// Type is where dead-lettered events are kept: "disk" for a log file on
+	// local disk or "storage" to store them through the storage driver. If
+	// empty, dead-lettered events are dropped.
+	Type string `yaml:"type,omitempty"`
+
+	// Path is the log file of a disk store, or the directory of a storage
+	// store in the storage driver.
+	Path string `yaml:"path,omitempty"`
+}
+
+// EndpointBatch configures how many events an endpoint receives per request.
+type EndpointBatch struct {
+	// MaxEvents is the maximum number of events sent in a single request.
+	// Zero or one sends each event in its own request.
+	MaxEvents int `yaml:"maxevents,omitempty"`
+
+	// MaxWait is how long to wait for more events to fill a batch once the
+	// first event is pending. Zero sends the events already pending without
+	// waiting.
+	MaxWait time.Duration `yaml:"maxwait,omitempty"`
+}
+
+// EndpointQueue configures the queue holding the events pending delivery to
+// an endpoint.
+type EndpointQueue struct {
+	// Type is where pending events are kept: "memory" (the default), "disk"
+	// for a write-ahead log file on local disk or "storage" to store them
+	// through the storage driver. Persistent queues survive restarts and
+	// deliver events at least once.
+	Type string `yaml:"type,omitempty"`
+
+	// Path is the log file of a disk queue, or the directory of a storage
+	// queue in the storage driver.
+	Path string `yaml:"path,omitempty"`
+
+	// MaxSize is the maximum number of pending events. Zero means the
+	// queue is unbounded.
+	MaxSize int `yaml:"maxsize,omitempty"`
+
+	// DropPolicy decides which events are dropped when the queue is full:
+	// "newest" (the default) drops incoming events, "oldest" drops the
+	// longest pending ones.
+	DropPolicy string `yaml:"droppolicy,omitempty"`
 }
 
 // Events configures notification events.
 type Events struct {
-	IncludeReferences bool `yaml:"includereferences"` // include reference data in manifest events
+	IncludeReferences bool `yaml:"includereferences"`        // include reference data in manifest events
+	IncludeUploads    bool `yaml:"includeuploads,omitempty"` // emit upload start, cancel and purge events
+	IncludeGC         bool `yaml:"includegc,omitempty"`      // emit events for content removed by garbage collection
+	IncludeConfig     bool `yaml:"includeconfig,omitempty"`  // include image configuration data in manifest push events
+	IncludeTags       bool `yaml:"includetags,omitempty"`    // emit tag create, update and delete events
 }
 
 // Ignore configures mediaTypes and actions of the event, that it won't be propagated
@@ -579,6 +668,62 @@ type Ignore struct {
 	Actions    []string `yaml:"actions"`    // ignore action types
 }
 
+// Filter selects the events published to an endpoint. An event is published
+// if it matches the include rules and does not match the exclude rules.
+type Filter struct {
+	// Include publishes only the events matching every non-empty list.
+	Include FilterRules `yaml:"include,omitempty"`
+
+	// Exclude drops the events matching any entry of any list.
+	Exclude FilterRules `yaml:"exclude,omitempty"`
+}
+
+// FilterRules match events by their target and actor.
+type FilterRules struct {
+	// Repositories are repository name patterns, in the syntax of
+	// path.Match.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Tags are regular expressions matching the whole tag. Events without
+	// a tag do not match.
+	Tags []string `yaml:"tags,omitempty"`
+
+	// Actors are the names of the actors initiating the events.
+	Actors []string `yaml:"actors,omitempty"`
+}
+
+// Robots configures robot accounts.
+type Robots struct {
+	// Enabled accepts robot credentials in addition to those of the
+	// configured access controller.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Store is where robots are kept: "storage" (the default) stores them
+	// through the storage driver, "redis" in the configured redis.
+	Store string `yaml:"store,omitempty"`
+
+	// Realm is the realm of the basic auth challenge issued to robots. It
+	// defaults to "registry".
+	Realm string `yaml:"realm,omitempty"`
+}
+
+// Admin configures the administrative HTTP API.
+type Admin struct {
+	// Token is the bearer token which authenticates requests to the
+	// admin API. The admin API is disabled when it is empty.
+	Token string `yaml:"token,omitempty"`
+}
+
+// Anonymous configures access which does not require authentication.
+type Anonymous struct {
+	// Repositories is a list of repository name patterns, in the syntax
+	// of path.Match, which may be pulled without credentials.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Catalog allows listing the catalog without credentials.
+	Catalog bool `yaml:"catalog,omitempty"`
+}
+
 // Middleware configures named middlewares to be applied at injection points.
 type Middleware struct {
 	// Name the middleware registers itself as
@@ -606,6 +751,14 @@ type Proxy struct {
 	TTL *time.Duration `yaml:"ttl,omitempty"`
 }
 
+// OCILayout configures the registry to serve repositories from OCI
+// image-layout directories, read-only
+type OCILayout struct {
+	// RootDirectory is the directory holding the image layouts, each at the
+	// path of the name of its repository
+	RootDirectory string `yaml:"rootdirectory,omitempty"`
+}
+
 type Validation struct {
 	// Enabled enables the other options in this section. This field is
 	// deprecated in favor of Disabled.
@@ -0,0 +1,46 @@
+// Package optionutil parses the generic options of the configuration, such as
+// the parameters of middlewares, auth providers and notification sinks.
+package optionutil
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Duration returns the optional duration key of options, given either as a
+// time.Duration or as a string accepted by time.ParseDuration.
+func Duration(options map[string]interface{}, key string, def time.Duration) (time.Duration, error) {
+	v, ok := options[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+
+	switch v := v.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		return d, nil
+	}
+
+	return 0, fmt.Errorf("%s must be a duration, got %T", key, v)
+}
+
+// Decode decodes a generic option value into out, as if out had been read
+// from the configuration file, by marshaling the value to YAML. Unknown
+// fields are an error if strict is set.
+func Decode(v interface{}, out interface{}, strict bool) error {
+	p, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if strict {
+		return yaml.UnmarshalStrict(p, out)
+	}
+	return yaml.Unmarshal(p, out)
+}
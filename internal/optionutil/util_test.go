@@ -0,0 +1,53 @@
+package optionutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuration(t *testing.T) {
+	options := map[string]interface{}{
+		"string":   "90s",
+		"duration": time.Minute,
+		"nil":      nil,
+		"invalid":  "soon",
+		"int":      3,
+	}
+
+	for key, expected := range map[string]time.Duration{
+		"string":   90 * time.Second,
+		"duration": time.Minute,
+		"nil":      time.Hour,
+		"missing":  time.Hour,
+	} {
+		d, err := Duration(options, key, time.Hour)
+		require.NoError(t, err, key)
+		require.Equal(t, expected, d, key)
+	}
+
+	_, err := Duration(options, "invalid", time.Hour)
+	require.ErrorContains(t, err, "invalid invalid")
+	_, err = Duration(options, "int", time.Hour)
+	require.ErrorContains(t, err, "int must be a duration, got int")
+}
+
+func TestDecode(t *testing.T) {
+	type rule struct {
+		Name  string   `yaml:"name"`
+		Paths []string `yaml:"paths"`
+	}
+	v := []interface{}{
+		map[interface{}]interface{}{"name": "a", "paths": []interface{}{"/a", "/b"}},
+		map[string]interface{}{"name": "b"},
+	}
+
+	var rules []rule
+	require.NoError(t, Decode(v, &rules, true))
+	require.Equal(t, []rule{{Name: "a", Paths: []string{"/a", "/b"}}, {Name: "b"}}, rules)
+
+	v = append(v, map[string]interface{}{"name": "c", "unknown": 1})
+	require.Error(t, Decode(v, &rules, true))
+	require.NoError(t, Decode(v, &rules, false))
+}
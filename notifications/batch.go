@@ -0,0 +1,38 @@
+package notifications
+
+import (
+	"fmt"
+
+	"github.com/distribution/distribution/v3/configuration"
+	events "github.com/docker/go-events"
+)
+
+// eventBatch is a group of events delivered together, in a single request
+// by the http sink. The sinks in between, like the retrying sink, handle a
+// batch as a single event, so a batch is retried as a whole.
+type eventBatch []events.Event
+
+// CheckBatch returns an error if endpoints sending events in the given
+// format cannot deliver batches as configured.
+func CheckBatch(format, mode string, batch configuration.EndpointBatch) error {
+	if batch.MaxEvents < 0 {
+		return fmt.Errorf("invalid batch maxevents %d", batch.MaxEvents)
+	}
+	if batch.MaxWait < 0 {
+		return fmt.Errorf("invalid batch maxwait %v", batch.MaxWait)
+	}
+
+	if batch.MaxEvents > 1 && format == "cloudevents" && (mode == "" || mode == "structured") {
+		return fmt.Errorf("cloudevents structured mode sends a single event per request, use batch mode to batch events")
+	}
+
+	return nil
+}
+
+// batchEvents returns the events of a batch, or the event itself.
+func batchEvents(event events.Event) []events.Event {
+	if batch, ok := event.(eventBatch); ok {
+		return batch
+	}
+	return []events.Event{event}
+}
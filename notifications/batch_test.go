@@ -0,0 +1,128 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	events "github.com/docker/go-events"
+)
+
+func TestDurableQueueBatching(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		sizes []int
+	)
+	sink := testSinkFn(func(event events.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sizes = append(sizes, len(batchEvents(event)))
+		return nil
+	})
+
+	metrics := newSafeMetrics("")
+	dq := newDurableQueue(sink, nil, configuration.EndpointQueue{},
+		configuration.EndpointBatch{MaxEvents: 3, MaxWait: time.Minute}, metrics.eventQueueListener())
+	for i := 0; i < 7; i++ {
+		if err := dq.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the remaining event is flushed without waiting on close
+	checkClose(t, dq)
+
+	if expected := []int{3, 3, 1}; !reflect.DeepEqual(sizes, expected) {
+		t.Fatalf("unexpected batch sizes: %v != %v", sizes, expected)
+	}
+	if metrics.Pending != 0 {
+		t.Fatalf("unexpected pending count: %d", metrics.Pending)
+	}
+}
+
+func TestDurableQueueBatchMaxWait(t *testing.T) {
+	delivered := make(chan int, 1)
+	sink := testSinkFn(func(event events.Event) error {
+		delivered <- len(batchEvents(event))
+		return nil
+	})
+
+	dq := newDurableQueue(sink, nil, configuration.EndpointQueue{},
+		configuration.EndpointBatch{MaxEvents: 10, MaxWait: 200 * time.Millisecond})
+	defer checkClose(t, dq)
+
+	for i := 0; i < 2; i++ {
+		if err := dq.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case n := <-delivered:
+		if n != 2 {
+			t.Fatalf("unexpected batch size: %d != 2", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for partial batch")
+	}
+}
+
+func TestHTTPSinkBatch(t *testing.T) {
+	var requests []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope Envelope
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, len(envelope.Events))
+	}))
+	defer server.Close()
+
+	metrics := newSafeMetrics("")
+	sink := newHTTPSink(server.URL, 0, nil, nil, nil, nil,
+		&endpointMetricsHTTPStatusListener{safeMetrics: metrics})
+	defer checkClose(t, sink)
+
+	batch := eventBatch{
+		createTestEvent("push", "library/test", "blob"),
+		createTestEvent("push", "library/test", "blob"),
+		createTestEvent("push", "library/test", "manifest"),
+	}
+	if err := sink.Write(batch); err != nil {
+		t.Fatalf("unexpected error writing batch: %v", err)
+	}
+
+	if !reflect.DeepEqual(requests, []int{3}) {
+		t.Fatalf("unexpected requests: %v", requests)
+	}
+	if metrics.Successes != 3 {
+		t.Fatalf("unexpected successes: %d != 3", metrics.Successes)
+	}
+}
+
+func TestCheckBatch(t *testing.T) {
+	for _, tc := range []struct {
+		format, mode string
+		batch        configuration.EndpointBatch
+		valid        bool
+	}{
+		{batch: configuration.EndpointBatch{MaxEvents: 100, MaxWait: time.Second}, valid: true},
+		{format: "cloudevents", mode: "batch", batch: configuration.EndpointBatch{MaxEvents: 100}, valid: true},
+		{format: "cloudevents", batch: configuration.EndpointBatch{MaxEvents: 1}, valid: true},
+		{format: "cloudevents", batch: configuration.EndpointBatch{MaxEvents: 100}},
+		{batch: configuration.EndpointBatch{MaxEvents: -1}},
+		{batch: configuration.EndpointBatch{MaxWait: -time.Second}},
+	} {
+		err := CheckBatch(tc.format, tc.mode, tc.batch)
+		if tc.valid && err != nil {
+			t.Errorf("unexpected error for %+v: %v", tc, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("expected error for %+v", tc)
+		}
+	}
+}
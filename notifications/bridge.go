@@ -1,10 +1,13 @@
 package notifications
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/internal/requestutil"
 	"github.com/distribution/reference"
 	events "github.com/docker/go-events"
@@ -13,12 +16,14 @@ import (
 )
 
 type bridge struct {
-	ub                URLBuilder
-	includeReferences bool
-	actor             ActorRecord
-	source            SourceRecord
-	request           RequestRecord
-	sink              events.Sink
+	ub      URLBuilder
+	ctx     context.Context
+	config  configuration.Events
+	blobs   func(ctx context.Context) distribution.BlobProvider
+	actor   ActorRecord
+	source  SourceRecord
+	request RequestRecord
+	sink    events.Sink
 }
 
 var _ Listener = &bridge{}
@@ -29,19 +34,53 @@ type URLBuilder interface {
 	BuildBlobURL(ref reference.Canonical) (string, error)
 }
 
+// BridgeOption configures optional behavior of a bridge.
+type BridgeOption func(*bridge)
+
+// WithContext sets the context of the request the bridge writes events for.
+// It is used to read image configurations and to log errors. Defaults to a
+// background context.
+func WithContext(ctx context.Context) BridgeOption {
+	return func(b *bridge) {
+		b.ctx = ctx
+	}
+}
+
+// WithEvents selects the optional event data and event types written by the
+// bridge. It replaces the includeReferences argument of NewBridge.
+func WithEvents(config configuration.Events) BridgeOption {
+	return func(b *bridge) {
+		b.config = config
+	}
+}
+
+// WithBlobs sets the function returning the blobs which provide the image
+// configurations of pushed manifests, when the events configuration includes
+// them. It is only called when a manifest push is described.
+func WithBlobs(blobs func(ctx context.Context) distribution.BlobProvider) BridgeOption {
+	return func(b *bridge) {
+		b.blobs = blobs
+	}
+}
+
 // NewBridge returns a notification listener that writes records to sink,
 // using the actor and source. Any urls populated in the events created by
 // this bridge will be created using the URLBuilder.
 // TODO(stevvooe): Update this to simply take a context.Context object.
-func NewBridge(ub URLBuilder, source SourceRecord, actor ActorRecord, request RequestRecord, sink events.Sink, includeReferences bool) Listener {
-	return &bridge{
-		ub:                ub,
-		includeReferences: includeReferences,
-		actor:             actor,
-		source:            source,
-		request:           request,
-		sink:              sink,
+func NewBridge(ub URLBuilder, source SourceRecord, actor ActorRecord, request RequestRecord, sink events.Sink, includeReferences bool, options ...BridgeOption) Listener {
+	b := &bridge{
+		ub:      ub,
+		ctx:     dcontext.Background(),
+		config:  configuration.Events{IncludeReferences: includeReferences},
+		actor:   actor,
+		source:  source,
+		request: request,
+		sink:    sink,
 	}
+	for _, option := range options {
+		option(b)
+	}
+	return b
 }
 
 // NewRequestRecord builds a RequestRecord for use in NewBridge from an
@@ -62,6 +101,15 @@ func (b *bridge) ManifestPushed(repo reference.Named, sm distribution.Manifest,
 		return err
 	}
 
+	if b.config.IncludeConfig && b.blobs != nil {
+		image, err := describeImage(b.ctx, b.blobs(b.ctx), sm)
+		if err != nil {
+			// the event is still useful without the image description
+			dcontext.GetLogger(b.ctx).Errorf("error describing image %s@%s: %v", repo.Name(), manifestEvent.Target.Digest, err)
+		}
+		manifestEvent.Target.Image = image
+	}
+
 	for _, option := range options {
 		if opt, ok := option.(distribution.WithTagOption); ok {
 			manifestEvent.Target.Tag = opt.Tag
@@ -119,6 +167,73 @@ func (b *bridge) TagDeleted(repo reference.Named, tag string) error {
 	return b.sink.Write(*event)
 }
 
+// TagEventsEnabled reports whether the events configuration includes tag
+// events.
+func (b *bridge) TagEventsEnabled() bool {
+	return b.config.IncludeTags
+}
+
+func (b *bridge) TagCreated(repo reference.Named, tag string, desc distribution.Descriptor) error {
+	return b.createTagEventAndWrite(EventActionTagCreate, repo, tag, desc, "")
+}
+
+func (b *bridge) TagUpdated(repo reference.Named, tag string, desc distribution.Descriptor, previous digest.Digest) error {
+	return b.createTagEventAndWrite(EventActionTagUpdate, repo, tag, desc, previous)
+}
+
+func (b *bridge) TagRemoved(repo reference.Named, tag string, desc distribution.Descriptor) error {
+	return b.createTagEventAndWrite(EventActionTagDelete, repo, tag, desc, "")
+}
+
+func (b *bridge) createTagEventAndWrite(action string, repo reference.Named, tag string, desc distribution.Descriptor, previous digest.Digest) error {
+	if !b.config.IncludeTags {
+		return nil
+	}
+
+	event := b.createEvent(action)
+	event.Target.Repository = repo.Name()
+	event.Target.Tag = tag
+	event.Target.MediaType = desc.MediaType
+	event.Target.Digest = desc.Digest
+	event.Target.Size = desc.Size
+	event.Target.Length = desc.Size
+	event.Target.PreviousDigest = previous
+
+	if desc.Digest != "" {
+		ref, err := reference.WithDigest(repo, desc.Digest)
+		if err != nil {
+			return err
+		}
+
+		event.Target.URL, err = b.ub.BuildManifestURL(ref)
+		if err != nil {
+			return err
+		}
+	}
+
+	return b.sink.Write(*event)
+}
+
+func (b *bridge) UploadStarted(repo reference.Named, id string) error {
+	return b.createUploadEventAndWrite(EventActionUploadStart, repo, id)
+}
+
+func (b *bridge) UploadCancelled(repo reference.Named, id string) error {
+	return b.createUploadEventAndWrite(EventActionUploadCancel, repo, id)
+}
+
+func (b *bridge) createUploadEventAndWrite(action string, repo reference.Named, id string) error {
+	if !b.config.IncludeUploads {
+		return nil
+	}
+
+	event := b.createEvent(action)
+	event.Target.Repository = repo.Name()
+	event.Target.UploadID = id
+
+	return b.sink.Write(*event)
+}
+
 func (b *bridge) RepoDeleted(repo reference.Named) error {
 	event := b.createEvent(EventActionDelete)
 	event.Target.Repository = repo.Name()
@@ -153,7 +268,7 @@ func (b *bridge) createManifestEvent(action string, repo reference.Named, sm dis
 	event.Target.Digest = desc.Digest
 	event.Target.Size = desc.Size
 	event.Target.Length = desc.Size
-	if b.includeReferences {
+	if b.config.IncludeReferences {
 		event.Target.References = append(event.Target.References, manifest.References()...)
 	}
 
@@ -1,9 +1,13 @@
 package notifications
 
 import (
+	"context"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/reference"
@@ -25,7 +29,11 @@ var (
 	ub = mustUB(v2.NewURLBuilderFromString("http://test.example.com/", false))
 
 	actor = ActorRecord{
-		Name: "test",
+		Name:   "test",
+		Type:   "robot",
+		Groups: []string{"ci"},
+		Claims: map[string]string{"iss": "test-issuer"},
+		IP:     "192.0.2.1",
 	}
 	request      = RequestRecord{}
 	tag          = "latest"
@@ -83,6 +91,76 @@ func TestEventBridgeManifestPushedWithTag(t *testing.T) {
 	}
 }
 
+func TestEventBridgeManifestPushedWithImage(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	blobs := testBlobProvider{}
+	config := blobs.putImageConfig(t, v1.MediaTypeImageConfig, v1.Image{
+		Created:  &created,
+		Platform: v1.Platform{OS: "linux", Architecture: "amd64"},
+	})
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var written []Event
+	l := NewBridge(ub, source, actor, request, testSinkFn(func(event events.Event) error {
+		written = append(written, event.(Event))
+		return nil
+	}), false, WithEvents(configuration.Events{IncludeConfig: true}), WithBlobs(func(context.Context) distribution.BlobProvider {
+		return blobs
+	}))
+
+	repoRef, _ := reference.WithName(repo)
+	if err := l.ManifestPushed(repoRef, m); err != nil {
+		t.Fatalf("unexpected error notifying manifest push: %v", err)
+	}
+	if err := l.ManifestPulled(repoRef, m); err != nil {
+		t.Fatalf("unexpected error notifying manifest pull: %v", err)
+	}
+
+	if len(written) != 2 {
+		t.Fatalf("unexpected number of events: %d", len(written))
+	}
+	image := written[0].Target.Image
+	if image == nil || image.Platform == nil || image.Platform.Architecture != "amd64" || image.Created == nil || !image.Created.Equal(created) {
+		t.Fatalf("unexpected image record on push event: %#v", image)
+	}
+	if written[1].Target.Image != nil {
+		t.Fatalf("unexpected image record on pull event: %#v", written[1].Target.Image)
+	}
+}
+
+func TestEventBridgeManifestPushedWithoutImage(t *testing.T) {
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    cfg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewBridge(ub, source, actor, request, testSinkFn(func(event events.Event) error {
+		if event.(Event).Target.Image != nil {
+			t.Fatalf("unexpected image record: %#v", event.(Event).Target.Image)
+		}
+		return nil
+	}), false, WithBlobs(func(context.Context) distribution.BlobProvider {
+		t.Fatal("unexpected lookup of the blobs")
+		return nil
+	}))
+
+	repoRef, _ := reference.WithName(repo)
+	if err := l.ManifestPushed(repoRef, m); err != nil {
+		t.Fatalf("unexpected error notifying manifest push: %v", err)
+	}
+}
+
 func TestEventBridgeManifestPulledWithTag(t *testing.T) {
 	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
 		checkCommonManifest(t, EventActionPull, event)
@@ -129,6 +207,82 @@ func TestEventBridgeTagDeleted(t *testing.T) {
 	}
 }
 
+func TestEventBridgeTagUpdated(t *testing.T) {
+	previous := digest.FromString("previous")
+	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
+		e := event.(Event)
+		if e.Action != EventActionTagUpdate {
+			t.Fatalf("unexpected event action: %q != %q", e.Action, EventActionTagUpdate)
+		}
+		if e.Target.Repository != repo || e.Target.Tag != tag {
+			t.Fatalf("unexpected event target: %q:%q", e.Target.Repository, e.Target.Tag)
+		}
+		if e.Target.Digest != dgst || e.Target.PreviousDigest != previous {
+			t.Fatalf("unexpected digests: %v (previous %v)", e.Target.Digest, e.Target.PreviousDigest)
+		}
+		checkCommonManifest(t, EventActionTagUpdate, event)
+		return nil
+	}))
+
+	repoRef, _ := reference.WithName(repo)
+	desc := distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: int64(len(payload))}
+	if err := l.TagUpdated(repoRef, tag, desc, previous); err != nil {
+		t.Fatalf("unexpected error notifying tag update: %v", err)
+	}
+}
+
+func TestEventBridgeUploadStarted(t *testing.T) {
+	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
+		checkDeleted(t, EventActionUploadStart, event)
+		if event.(Event).Action != EventActionUploadStart {
+			t.Fatalf("unexpected event action: %q != %q", event.(Event).Action, EventActionUploadStart)
+		}
+		if event.(Event).Target.UploadID != "upload-id" {
+			t.Fatalf("unexpected upload id on event target: %q", event.(Event).Target.UploadID)
+		}
+		return nil
+	}))
+
+	repoRef, _ := reference.WithName(repo)
+	if err := l.UploadStarted(repoRef, "upload-id"); err != nil {
+		t.Fatalf("unexpected error notifying upload start: %v", err)
+	}
+}
+
+func TestEventBridgeUploadsDisabled(t *testing.T) {
+	l := NewBridge(ub, source, actor, request, testSinkFn(func(event events.Event) error {
+		t.Fatalf("unexpected event: %#v", event)
+		return nil
+	}), false)
+
+	repoRef, _ := reference.WithName(repo)
+	if err := l.UploadStarted(repoRef, "upload-id"); err != nil {
+		t.Fatalf("unexpected error notifying upload start: %v", err)
+	}
+	if err := l.UploadCancelled(repoRef, "upload-id"); err != nil {
+		t.Fatalf("unexpected error notifying upload cancel: %v", err)
+	}
+}
+
+func TestEventBridgeTagsDisabled(t *testing.T) {
+	l := NewBridge(ub, source, actor, request, testSinkFn(func(event events.Event) error {
+		t.Fatalf("unexpected event: %#v", event)
+		return nil
+	}), false)
+
+	if l.TagEventsEnabled() {
+		t.Fatal("tag events should be disabled by default")
+	}
+	repoRef, _ := reference.WithName(repo)
+	desc := distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: int64(len(payload))}
+	if err := l.TagCreated(repoRef, tag, desc); err != nil {
+		t.Fatalf("unexpected error notifying tag creation: %v", err)
+	}
+	if err := l.TagRemoved(repoRef, tag, desc); err != nil {
+		t.Fatalf("unexpected error notifying tag removal: %v", err)
+	}
+}
+
 func TestEventBridgeRepoDeleted(t *testing.T) {
 	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
 		checkDeleted(t, EventActionDelete, event)
@@ -157,7 +311,7 @@ func createTestEnv(t *testing.T, fn testSinkFn) Listener {
 	dgst = digest.FromBytes(payload)
 	sm = deserializedManifest
 
-	return NewBridge(ub, source, actor, request, fn, true)
+	return NewBridge(ub, source, actor, request, fn, true, WithEvents(configuration.Events{IncludeReferences: true, IncludeUploads: true, IncludeTags: true}))
 }
 
 func checkDeleted(t *testing.T, action string, event events.Event) {
@@ -169,7 +323,7 @@ func checkDeleted(t *testing.T, action string, event events.Event) {
 		t.Fatalf("request not equal: %#v != %#v", event.(Event).Request, request)
 	}
 
-	if event.(Event).Actor != actor {
+	if !reflect.DeepEqual(event.(Event).Actor, actor) {
 		t.Fatalf("request not equal: %#v != %#v", event.(Event).Actor, actor)
 	}
 
@@ -206,7 +360,7 @@ func checkCommon(t *testing.T, event events.Event) {
 		t.Fatalf("request not equal: %#v != %#v", event.(Event).Request, request)
 	}
 
-	if event.(Event).Actor != actor {
+	if !reflect.DeepEqual(event.(Event).Actor, actor) {
 		t.Fatalf("request not equal: %#v != %#v", event.(Event).Actor, actor)
 	}
 
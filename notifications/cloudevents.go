@@ -0,0 +1,139 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+const (
+	// CloudEventsMediaType is the media type of a single event in the
+	// CloudEvents structured content mode.
+	CloudEventsMediaType = "application/cloudevents+json"
+
+	// CloudEventsBatchMediaType is the media type of a list of events in the
+	// CloudEvents batched content mode.
+	CloudEventsBatchMediaType = "application/cloudevents-batch+json"
+
+	// CloudEventsSpecVersion is the version of the CloudEvents specification
+	// implemented by CloudEvent.
+	CloudEventsSpecVersion = "1.0"
+
+	// CloudEventsTypePrefix prefixes the event action in the CloudEvents
+	// type attribute, e.g. "org.cncf.distribution.push".
+	CloudEventsTypePrefix = "org.cncf.distribution."
+)
+
+// CloudEvent is a registry event in the CloudEvents 1.0 JSON format. The
+// registry event itself is the event data.
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            Event     `json:"data"`
+}
+
+// NewCloudEvent maps a registry event to a CloudEvent. The action becomes
+// the type, the target the subject and the source the source.
+func NewCloudEvent(event Event) CloudEvent {
+	// the source must be a non-empty URI reference
+	source := "registry"
+	if event.Source.Addr != "" {
+		source = "//" + event.Source.Addr
+	}
+
+	subject := event.Target.Repository
+	switch {
+	case event.Target.Tag != "":
+		subject += ":" + event.Target.Tag
+	case event.Target.Digest != "":
+		subject += "@" + event.Target.Digest.String()
+	}
+
+	return CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              event.ID,
+		Source:          source,
+		Type:            CloudEventsTypePrefix + event.Action,
+		Subject:         subject,
+		Time:            event.Timestamp,
+		DataContentType: "application/json",
+		Data:            event,
+	}
+}
+
+// eventEncoder serializes the events sent in a single request.
+type eventEncoder interface {
+	// encode returns the media type and body for the events.
+	encode(events []events.Event) (string, []byte, error)
+}
+
+// newEventEncoder returns the encoder for a format: "envelope" (the default)
+// or "cloudevents". mode selects between the "structured" (the default) and
+// "batch" CloudEvents content modes.
+func newEventEncoder(format, mode string) (eventEncoder, error) {
+	switch format {
+	case "", "envelope":
+		return envelopeEncoder{}, nil
+	case "cloudevents":
+		switch mode {
+		case "", "structured":
+			return cloudEventsEncoder{}, nil
+		case "batch":
+			return cloudEventsEncoder{batch: true}, nil
+		default:
+			return nil, fmt.Errorf("unknown cloudevents mode %q", mode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown event format %q", format)
+	}
+}
+
+// CheckFormat returns an error if the event format or CloudEvents mode of an
+// endpoint is unknown.
+func CheckFormat(format, mode string) error {
+	_, err := newEventEncoder(format, mode)
+	return err
+}
+
+// envelopeEncoder encodes events in an Envelope.
+type envelopeEncoder struct{}
+
+func (envelopeEncoder) encode(events []events.Event) (string, []byte, error) {
+	p, err := json.MarshalIndent(Envelope{Events: events}, "", "   ")
+	return EventsMediaType, p, err
+}
+
+// cloudEventsEncoder encodes events as CloudEvents, as a single structured
+// event or as a batch.
+type cloudEventsEncoder struct {
+	batch bool
+}
+
+func (ce cloudEventsEncoder) encode(events []events.Event) (string, []byte, error) {
+	cloudEvents := make([]CloudEvent, 0, len(events))
+	for _, event := range events {
+		e, ok := event.(Event)
+		if !ok {
+			return "", nil, fmt.Errorf("cannot encode event of type %T as cloudevent", event)
+		}
+		cloudEvents = append(cloudEvents, NewCloudEvent(e))
+	}
+
+	if ce.batch {
+		p, err := json.Marshal(cloudEvents)
+		return CloudEventsBatchMediaType, p, err
+	}
+
+	if len(cloudEvents) != 1 {
+		return "", nil, fmt.Errorf("structured cloudevents hold a single event, got %d", len(cloudEvents))
+	}
+	p, err := json.Marshal(cloudEvents[0])
+	return CloudEventsMediaType, p, err
+}
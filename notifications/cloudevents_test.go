@@ -0,0 +1,112 @@
+package notifications
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewCloudEvent(t *testing.T) {
+	event := createTestEvent("push", "library/test", "manifest")
+	event.Target.Tag = "latest"
+	event.Source.Addr = "registry.local:5000"
+
+	ce := NewCloudEvent(event)
+	if ce.SpecVersion != "1.0" {
+		t.Fatalf("unexpected spec version %q", ce.SpecVersion)
+	}
+	if ce.ID != event.ID || ce.Time != event.Timestamp {
+		t.Fatalf("unexpected id or time: %q, %v", ce.ID, ce.Time)
+	}
+	if ce.Type != "org.cncf.distribution.push" {
+		t.Fatalf("unexpected type %q", ce.Type)
+	}
+	if ce.Subject != "library/test:latest" {
+		t.Fatalf("unexpected subject %q", ce.Subject)
+	}
+	if ce.Source != "//registry.local:5000" {
+		t.Fatalf("unexpected source %q", ce.Source)
+	}
+
+	event.Target.Tag = ""
+	event.Target.Digest = "sha256:c3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5"
+	if ce := NewCloudEvent(event); ce.Subject != "library/test@"+event.Target.Digest.String() {
+		t.Fatalf("unexpected subject %q", ce.Subject)
+	}
+
+	event.Source.Addr = ""
+	if ce := NewCloudEvent(event); ce.Source == "" {
+		t.Fatal("source must not be empty")
+	}
+}
+
+func TestHTTPSinkCloudEvents(t *testing.T) {
+	for _, tc := range []struct {
+		mode      string
+		mediaType string
+	}{
+		{mode: "structured", mediaType: CloudEventsMediaType},
+		{mode: "batch", mediaType: CloudEventsBatchMediaType},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			type request struct {
+				mediaType string
+				body      []byte
+			}
+			requests := make(chan request, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("error reading body: %v", err)
+				}
+				requests <- request{mediaType: r.Header.Get("Content-Type"), body: body}
+			}))
+			defer server.Close()
+
+			encoder, err := newEventEncoder("cloudevents", tc.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			event := createTestEvent("push", "library/test", "manifest")
+			sink := newHTTPSink(server.URL, 0, nil, nil, encoder, nil)
+			if err := sink.Write(event); err != nil {
+				t.Fatalf("unexpected error writing event: %v", err)
+			}
+
+			req := <-requests
+			if req.mediaType != tc.mediaType {
+				t.Fatalf("unexpected media type %q", req.mediaType)
+			}
+
+			var received []CloudEvent
+			if tc.mode == "batch" {
+				err = json.Unmarshal(req.body, &received)
+			} else {
+				received = make([]CloudEvent, 1)
+				err = json.Unmarshal(req.body, &received[0])
+			}
+			if err != nil {
+				t.Fatalf("error decoding cloudevents: %v", err)
+			}
+			if len(received) != 1 || received[0].ID != event.ID || received[0].Data.Target.Repository != "library/test" {
+				t.Fatalf("unexpected cloudevents: %+v", received)
+			}
+		})
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	for _, valid := range [][2]string{{"", ""}, {"envelope", ""}, {"cloudevents", ""}, {"cloudevents", "batch"}} {
+		if err := CheckFormat(valid[0], valid[1]); err != nil {
+			t.Errorf("unexpected error for format %q, mode %q: %v", valid[0], valid[1], err)
+		}
+	}
+	for _, invalid := range [][2]string{{"xml", ""}, {"cloudevents", "binary"}} {
+		if err := CheckFormat(invalid[0], invalid[1]); err == nil {
+			t.Errorf("expected error for format %q, mode %q", invalid[0], invalid[1])
+		}
+	}
+}
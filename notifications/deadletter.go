@@ -0,0 +1,171 @@
+package notifications
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultMaxAttempts is the retry budget of endpoints with a dead-letter
+// store.
+const defaultMaxAttempts = 10
+
+// deadLetterQueue keeps the events which could not be delivered to an
+// endpoint within its retry budget, until they are replayed.
+type deadLetterQueue struct {
+	mu     sync.Mutex
+	store  QueueStore
+	events []QueuedEvent // ordered by sequence number
+	seq    uint64
+}
+
+// newDeadLetterQueue returns a dead-letter queue backed by store, starting
+// with the events left in it.
+func newDeadLetterQueue(store QueueStore) *deadLetterQueue {
+	dl := &deadLetterQueue{
+		store:  store,
+		events: store.Pending(),
+	}
+	for _, qe := range dl.events {
+		if qe.Seq > dl.seq {
+			dl.seq = qe.Seq
+		}
+	}
+	return dl
+}
+
+// add stores the event, or each event of a batch.
+func (dl *deadLetterQueue) add(event events.Event) error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	for _, event := range batchEvents(event) {
+		e, ok := event.(Event)
+		if !ok {
+			return fmt.Errorf("deadletter: cannot store event of type %T", event)
+		}
+
+		qe := QueuedEvent{Seq: dl.seq + 1, Event: e}
+		if err := dl.store.Append(qe); err != nil {
+			return fmt.Errorf("deadletter: error storing event: %v", err)
+		}
+		dl.seq = qe.Seq
+		dl.events = append(dl.events, qe)
+	}
+
+	return nil
+}
+
+// list returns at most limit events with sequence numbers from start to
+// end, inclusive. A zero end or limit means no bound.
+func (dl *deadLetterQueue) list(start, end uint64, limit int) []QueuedEvent {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	i := sort.Search(len(dl.events), func(i int) bool { return dl.events[i].Seq >= start })
+
+	var list []QueuedEvent
+	for ; i < len(dl.events); i++ {
+		if (end > 0 && dl.events[i].Seq > end) || (limit > 0 && len(list) >= limit) {
+			break
+		}
+		list = append(list, dl.events[i])
+	}
+	return list
+}
+
+// remove deletes the event with the given sequence number.
+func (dl *deadLetterQueue) remove(seq uint64) error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	i := sort.Search(len(dl.events), func(i int) bool { return dl.events[i].Seq >= seq })
+	if i == len(dl.events) || dl.events[i].Seq != seq {
+		return nil
+	}
+
+	if err := dl.store.Remove(seq); err != nil {
+		return err
+	}
+	dl.events = append(dl.events[:i], dl.events[i+1:]...)
+	return nil
+}
+
+// len returns the number of dead-lettered events.
+func (dl *deadLetterQueue) len() int {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return len(dl.events)
+}
+
+// retryBudgetSink gives up on an event after a number of consecutive failed
+// attempts, moving it to the dead-letter queue, or dropping it if there is
+// none. It must be wrapped by a retrying sink, which writes an event again
+// until the write succeeds: consecutive failures are always attempts to
+// write the same event.
+type retryBudgetSink struct {
+	events.Sink
+	maxAttempts int
+	deadLetters *deadLetterQueue
+	metrics     *safeMetrics
+
+	mu       sync.Mutex
+	attempts int
+}
+
+func newRetryBudgetSink(sink events.Sink, maxAttempts int, deadLetters *deadLetterQueue, metrics *safeMetrics) *retryBudgetSink {
+	return &retryBudgetSink{
+		Sink:        sink,
+		maxAttempts: maxAttempts,
+		deadLetters: deadLetters,
+		metrics:     metrics,
+	}
+}
+
+// Write attempts to write the event, reporting success once the retry
+// budget of the event is exhausted and it has been dead-lettered.
+func (rbs *retryBudgetSink) Write(event events.Event) error {
+	err := rbs.Sink.Write(event)
+
+	rbs.mu.Lock()
+	defer rbs.mu.Unlock()
+
+	if err == nil {
+		rbs.attempts = 0
+		return nil
+	}
+	if err == ErrSinkClosed {
+		return err
+	}
+
+	rbs.attempts++
+	if rbs.attempts < rbs.maxAttempts {
+		return err
+	}
+
+	n := len(batchEvents(event))
+	if rbs.deadLetters == nil {
+		logrus.Errorf("retrybudgetsink: dropping %d events after %d attempts to write to %v: %v", n, rbs.attempts, rbs.Sink, err)
+	} else if dlerr := rbs.deadLetters.add(event); dlerr != nil {
+		// keep retrying rather than losing the events
+		logrus.Errorf("retrybudgetsink: error dead-lettering %d events: %v", n, dlerr)
+		return err
+	} else {
+		logrus.Warnf("retrybudgetsink: dead-lettered %d events after %d attempts to write to %v: %v", n, rbs.attempts, rbs.Sink, err)
+	}
+	rbs.attempts = 0
+
+	rbs.metrics.Lock()
+	rbs.metrics.DeadLetters += n
+	rbs.metrics.Unlock()
+	eventsCounter.WithValues("DeadLetters", rbs.metrics.EndpointName).Inc(float64(n))
+
+	return nil
+}
+
+func (rbs *retryBudgetSink) String() string {
+	return fmt.Sprint(rbs.Sink)
+}
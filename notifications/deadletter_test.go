@@ -0,0 +1,158 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	events "github.com/docker/go-events"
+)
+
+func TestRetryBudgetSink(t *testing.T) {
+	store, err := NewFileQueueStore(filepath.Join(t.TempDir(), "deadletters.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters := newDeadLetterQueue(store)
+
+	// the first event always fails, the others are delivered
+	attempts := 0
+	var delivered []string
+	sink := testSinkFn(func(event events.Event) error {
+		repo := batchEvents(event)[0].(Event).Target.Repository
+		if repo == "broken" {
+			attempts++
+			return fmt.Errorf("unavailable")
+		}
+		delivered = append(delivered, repo)
+		return nil
+	})
+
+	metrics := newSafeMetrics("")
+	budget := newRetryBudgetSink(sink, 3, deadLetters, metrics)
+	retrying := events.NewRetryingSink(budget, events.NewBreaker(100, time.Millisecond))
+
+	for _, repo := range []string{"broken", "first", "second"} {
+		if err := retrying.Write(createTestEvent("push", repo, "blob")); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+	}
+
+	if attempts != 3 {
+		t.Fatalf("unexpected number of attempts: %d != 3", attempts)
+	}
+	if !reflect.DeepEqual(delivered, []string{"first", "second"}) {
+		t.Fatalf("unexpected delivered events: %v", delivered)
+	}
+	if metrics.DeadLetters != 1 {
+		t.Fatalf("unexpected dead letters count: %d != 1", metrics.DeadLetters)
+	}
+
+	// a batch is dead-lettered as individual events
+	if err := retrying.Write(eventBatch{
+		createTestEvent("push", "broken", "blob"),
+		createTestEvent("push", "broken", "manifest"),
+	}); err != nil {
+		t.Fatalf("unexpected error writing batch: %v", err)
+	}
+
+	list := deadLetters.list(0, 0, 0)
+	if len(list) != 3 || list[0].Seq != 1 || list[2].Seq != 3 {
+		t.Fatalf("unexpected dead letters: %+v", list)
+	}
+	if list := deadLetters.list(2, 3, 1); len(list) != 1 || list[0].Seq != 2 {
+		t.Fatalf("unexpected dead letters in range: %+v", list)
+	}
+
+	if err := deadLetters.remove(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// dead letters survive a restart
+	store, err = NewFileQueueStore(store.(*fileQueueStore).path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	deadLetters = newDeadLetterQueue(store)
+	list = deadLetters.list(0, 0, 0)
+	if len(list) != 2 || list[0].Seq != 1 || list[1].Seq != 3 {
+		t.Fatalf("unexpected dead letters after restart: %+v", list)
+	}
+	if err := deadLetters.add(createTestEvent("push", "broken", "blob")); err != nil {
+		t.Fatal(err)
+	}
+	if list := deadLetters.list(4, 0, 0); len(list) != 1 {
+		t.Fatalf("expected new dead letter to follow recovered ones: %+v", deadLetters.list(0, 0, 0))
+	}
+}
+
+// wrappingDriver wraps the errors of the storage driver, as storage
+// middleware may do.
+type wrappingDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d wrappingDriver) List(ctx context.Context, path string) ([]string, error) {
+	list, err := d.StorageDriver.List(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("wrapped: %w", err)
+	}
+	return list, nil
+}
+
+func (d wrappingDriver) Delete(ctx context.Context, path string) error {
+	if err := d.StorageDriver.Delete(ctx, path); err != nil {
+		return fmt.Errorf("wrapped: %w", err)
+	}
+	return nil
+}
+
+func TestDeadLettersOfInstances(t *testing.T) {
+	ctx := context.Background()
+	driver := wrappingDriver{inmemory.New()}
+	open := func(instance string) (QueueStore, *deadLetterQueue) {
+		store, err := NewDriverQueueStore(ctx, driver, "/deadletters/endpoint", instance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store, newDeadLetterQueue(store)
+	}
+
+	// both instances dead-letter an event with the same sequence number
+	storeA, a := open("registry-0")
+	_, b := open("registry-1")
+	if err := a.add(createTestEvent("push", "library/a", "blob")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.add(createTestEvent("push", "library/b", "blob")); err != nil {
+		t.Fatal(err)
+	}
+
+	for instance, repo := range map[string]string{"registry-0": "library/a", "registry-1": "library/b"} {
+		_, dl := open(instance)
+		list := dl.list(0, 0, 0)
+		if len(list) != 1 || list[0].Seq != 1 || list[0].Event.Target.Repository != repo {
+			t.Fatalf("unexpected dead letters of %s: %+v", instance, list)
+		}
+	}
+
+	// removing an event already gone is not an error
+	if err := storeA.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.remove(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, dl := open("registry-0"); dl.len() != 0 {
+		t.Fatalf("unexpected dead letters after removal: %+v", dl.list(0, 0, 0))
+	}
+}
@@ -1,23 +1,40 @@
 package notifications
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/distribution/distribution/v3/configuration"
 	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
 )
 
 // EndpointConfig covers the optional configuration parameters for an active
 // endpoint.
 type EndpointConfig struct {
 	Headers           http.Header
+	Secrets           []string `json:"-"`
 	Timeout           time.Duration
 	Threshold         int
 	Backoff           time.Duration
 	IgnoredMediaTypes []string
 	Transport         *http.Transport `json:"-"`
 	Ignore            configuration.Ignore
+	Filter            configuration.Filter
+	Queue             configuration.EndpointQueue
+	Batch             configuration.EndpointBatch
+	DeadLetter        configuration.DeadLetter
+	Format            string
+	CloudEvents       configuration.CloudEvents
+
+	// QueueStore persists the pending events. If nil, they are only kept
+	// in memory.
+	QueueStore QueueStore `json:"-"`
+
+	// DeadLetterStore keeps the events exceeding the retry budget. If nil,
+	// they are dropped.
+	DeadLetterStore QueueStore `json:"-"`
 }
 
 // defaults set any zero-valued fields to a reasonable default.
@@ -44,34 +61,123 @@ func (ec *EndpointConfig) defaults() {
 // succeed for callers but events may be queued internally.
 type Endpoint struct {
 	events.Sink
-	url  string
-	name string
+	url      string
+	name     string
+	sinkType string
 
 	EndpointConfig
 
-	metrics *safeMetrics
+	metrics     *safeMetrics
+	queue       events.Sink
+	pause       *pausableSink
+	deadLetters *deadLetterQueue
 }
 
 // NewEndpoint returns a running endpoint, ready to receive events.
 func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
+	endpoint := newEndpoint(name, url, "http", config)
+
+	encoder, err := newEventEncoder(endpoint.Format, endpoint.CloudEvents.Mode)
+	if err != nil {
+		logrus.Errorf("endpoint %s: %v, using the default format", name, err)
+		encoder = envelopeEncoder{}
+	}
+	if err := CheckBatch(endpoint.Format, endpoint.CloudEvents.Mode, endpoint.Batch); err != nil {
+		logrus.Errorf("endpoint %s: %v, sending events one by one", name, err)
+		endpoint.Batch = configuration.EndpointBatch{}
+	}
+
+	endpoint.start(newHTTPSink(
+		endpoint.url, endpoint.Timeout, endpoint.Headers, endpoint.Secrets,
+		encoder, endpoint.Transport, endpoint.metrics.httpStatusListener()))
+	return endpoint
+}
+
+// NewSinkEndpoint returns a running endpoint which delivers events to a sink
+// of the type registered with RegisterSink, created with options. The format,
+// CloudEvents mode and secrets of the endpoint are passed on to the sink in
+// the "format", "cloudeventsmode" and "secrets" options. Only http endpoints
+// support batching and headers.
+func NewSinkEndpoint(name, sinkType string, options map[string]interface{}, config EndpointConfig) (*Endpoint, error) {
+	if config.Batch.MaxEvents > 1 {
+		return nil, fmt.Errorf("batching is not supported by %s endpoints", sinkType)
+	}
+	if len(config.Headers) > 0 {
+		return nil, fmt.Errorf("headers are not supported by %s endpoints", sinkType)
+	}
+	if err := CheckFilter(config.Filter); err != nil {
+		return nil, err
+	}
+
+	sinkOptions := make(map[string]interface{}, len(options)+3)
+	for k, v := range options {
+		sinkOptions[k] = v
+	}
+	if config.Format != "" {
+		sinkOptions[sinkFormatOption] = config.Format
+	}
+	if config.CloudEvents.Mode != "" {
+		sinkOptions[sinkCloudEventsModeOption] = config.CloudEvents.Mode
+	}
+	if len(config.Secrets) > 0 {
+		sinkOptions[sinkSecretsOption] = config.Secrets
+	}
+
+	endpoint := newEndpoint(name, "", sinkType, config)
+
+	sink, err := newSink(sinkType, sinkOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint.start(&metricsSink{Sink: sink, safeMetrics: endpoint.metrics})
+	return endpoint, nil
+}
+
+func newEndpoint(name, url, sinkType string, config EndpointConfig) *Endpoint {
 	var endpoint Endpoint
 	endpoint.name = name
 	endpoint.url = url
+	endpoint.sinkType = sinkType
 	endpoint.EndpointConfig = config
 	endpoint.defaults()
 	endpoint.metrics = newSafeMetrics(name)
+	return &endpoint
+}
 
-	// Configures the inmemory queue, retry, http pipeline.
-	endpoint.Sink = newHTTPSink(
-		endpoint.url, endpoint.Timeout, endpoint.Headers,
-		endpoint.Transport, endpoint.metrics.httpStatusListener())
-	endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
-	endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
-	mediaTypes := append(config.Ignore.MediaTypes, config.IgnoredMediaTypes...)
-	endpoint.Sink = newIgnoredSink(endpoint.Sink, mediaTypes, config.Ignore.Actions)
+// start configures the queue and retry pipeline in front of sink and
+// registers the endpoint.
+func (e *Endpoint) start(sink events.Sink) {
+	maxAttempts := e.DeadLetter.MaxAttempts
+	if e.DeadLetterStore != nil {
+		e.deadLetters = newDeadLetterQueue(e.DeadLetterStore)
+		if maxAttempts <= 0 {
+			maxAttempts = defaultMaxAttempts
+		}
+	}
+	if maxAttempts > 0 {
+		sink = newRetryBudgetSink(sink, maxAttempts, e.deadLetters, e.metrics)
+	}
 
-	register(&endpoint)
-	return &endpoint
+	e.pause = newPausableSink(events.NewRetryingSink(sink, events.NewBreaker(e.Threshold, e.Backoff)))
+	if e.QueueStore != nil || e.Queue.MaxSize > 0 || e.Batch.MaxEvents > 1 {
+		e.queue = newDurableQueue(e.pause, e.QueueStore, e.Queue, e.Batch, e.metrics.eventQueueListener())
+	} else {
+		e.queue = newEventQueue(e.pause, e.metrics.eventQueueListener())
+	}
+	e.Sink = e.queue
+	mediaTypes := append(e.Ignore.MediaTypes, e.IgnoredMediaTypes...)
+	e.Sink = newIgnoredSink(e.Sink, mediaTypes, e.Ignore.Actions)
+
+	filtered, err := newFilteredSink(e.Sink, e.Filter)
+	if err != nil {
+		// an invalid filter cannot select the events, so publish none
+		logrus.Errorf("endpoint %s: %v, discarding all events", e.name, err)
+		filtered = events.NewFilter(e.Sink, events.MatcherFunc(func(events.Event) bool { return false }))
+	}
+	e.Sink = filtered
+
+	register(e)
 }
 
 // Name returns the name of the endpoint, generally used for debugging.
@@ -84,6 +190,81 @@ func (e *Endpoint) URL() string {
 	return e.url
 }
 
+// Type returns the sink type of the endpoint.
+func (e *Endpoint) Type() string {
+	return e.sinkType
+}
+
+// Pause holds back the delivery of events, which are queued until the
+// endpoint is resumed.
+func (e *Endpoint) Pause() {
+	e.pause.setPaused(true)
+}
+
+// Resume resumes the delivery of events.
+func (e *Endpoint) Resume() {
+	e.pause.setPaused(false)
+}
+
+// Paused reports whether the delivery of events is paused.
+func (e *Endpoint) Paused() bool {
+	return e.pause.isPaused()
+}
+
+// DeadLetters returns at most limit of the events which exceeded the retry
+// budget, with sequence numbers from start to end inclusive. A zero end or
+// limit means no bound. It returns nil if the endpoint has no dead-letter
+// store.
+func (e *Endpoint) DeadLetters(start, end uint64, limit int) []QueuedEvent {
+	if e.deadLetters == nil {
+		return nil
+	}
+	return e.deadLetters.list(start, end, limit)
+}
+
+// DeadLetterCount returns the number of events in the dead-letter store.
+func (e *Endpoint) DeadLetterCount() int {
+	if e.deadLetters == nil {
+		return 0
+	}
+	return e.deadLetters.len()
+}
+
+// ReplayDeadLetters queues the dead-lettered events with sequence numbers
+// from start to end inclusive for delivery again, removing them from the
+// dead-letter store. It returns the number of events replayed.
+func (e *Endpoint) ReplayDeadLetters(start, end uint64) (int, error) {
+	if e.deadLetters == nil {
+		return 0, ErrNoDeadLetterStore
+	}
+
+	replayed := 0
+	for _, qe := range e.deadLetters.list(start, end, 0) {
+		if err := e.queue.Write(qe.Event); err != nil {
+			return replayed, err
+		}
+		if err := e.deadLetters.remove(qe.Seq); err != nil {
+			return replayed, fmt.Errorf("error removing replayed event %d, it may be replayed again: %v", qe.Seq, err)
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// Close shuts down the endpoint. Events held back by a pause are not
+// delivered.
+func (e *Endpoint) Close() error {
+	e.pause.abort()
+	err := e.Sink.Close()
+	if e.DeadLetterStore != nil {
+		if serr := e.DeadLetterStore.Close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
 // ReadMetrics populates em with metrics from the endpoint.
 func (e *Endpoint) ReadMetrics(em *EndpointMetrics) {
 	e.metrics.Lock()
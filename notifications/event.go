@@ -6,6 +6,8 @@ import (
 
 	"github.com/distribution/distribution/v3"
 	events "github.com/docker/go-events"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // EventAction constants used in action field of Event.
@@ -14,6 +16,28 @@ const (
 	EventActionPush   = "push"
 	EventActionMount  = "mount"
 	EventActionDelete = "delete"
+
+	// EventActionTagCreate, EventActionTagUpdate and EventActionTagDelete
+	// are the actions of tag lifecycle events, sent in addition to the
+	// manifest push and delete events.
+	EventActionTagCreate = "tag.create"
+	EventActionTagUpdate = "tag.update"
+	EventActionTagDelete = "tag.delete"
+
+	// EventActionUploadStart, EventActionUploadCancel and
+	// EventActionUploadPurge are the actions of upload lifecycle events. They
+	// are only sent when events.includeuploads is enabled.
+	EventActionUploadStart  = "upload.start"
+	EventActionUploadCancel = "upload.cancel"
+	EventActionUploadPurge  = "upload.purge"
+
+	// EventActionGCManifestDelete, EventActionGCBlobUnlink and
+	// EventActionGCBlobDelete are sent by the garbage collector for each
+	// manifest revision, repository layer link and blob it removes. They are
+	// only sent when events.includegc is enabled.
+	EventActionGCManifestDelete = "gc.manifest.delete"
+	EventActionGCBlobUnlink     = "gc.blob.unlink"
+	EventActionGCBlobDelete     = "gc.blob.delete"
 )
 
 const (
@@ -73,8 +97,19 @@ type Event struct {
 		// Tag provides the tag
 		Tag string `json:"tag,omitempty"`
 
+		// PreviousDigest is the digest a tag pointed to before it was
+		// updated. It is only set on tag.update events.
+		PreviousDigest digest.Digest `json:"previousDigest,omitempty"`
+
+		// UploadID identifies the upload session of upload events.
+		UploadID string `json:"uploadId,omitempty"`
+
 		// References provides the references descriptors.
 		References []distribution.Descriptor `json:"references,omitempty"`
+
+		// Image describes the image of a pushed manifest. It is only set
+		// when events.includeconfig is enabled.
+		Image *ImageRecord `json:"image,omitempty"`
 	} `json:"target,omitempty"`
 
 	// Request covers the request that generated the event.
@@ -99,6 +134,19 @@ type ActorRecord struct {
 	// request context that generated the event.
 	Name string `json:"name,omitempty"`
 
+	// Type describes the kind of actor: "user", "robot" or "anonymous".
+	Type string `json:"type,omitempty"`
+
+	// Groups lists the groups the actor is a member of, if known.
+	Groups []string `json:"groups,omitempty"`
+
+	// Claims carries selected attributes of the actor's identity, such as
+	// token claims or the subject of a client certificate.
+	Claims map[string]string `json:"claims,omitempty"`
+
+	// IP is the address of the client, taking proxy headers into account.
+	IP string `json:"ip,omitempty"`
+
 	// TODO(stevvooe): Look into setting a session cookie to get this
 	// without docker daemon.
 	//    SessionID
@@ -108,6 +156,26 @@ type ActorRecord struct {
 	//    Command
 }
 
+// ImageRecord describes the image of a manifest event, saving consumers from
+// fetching the manifest and its configuration from the registry.
+type ImageRecord struct {
+	// Platform is the platform of an image manifest, read from its image
+	// configuration.
+	Platform *v1.Platform `json:"platform,omitempty"`
+
+	// Created is the creation time recorded in the image configuration.
+	Created *time.Time `json:"created,omitempty"`
+
+	// Labels are the labels of the image configuration.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations are the annotations of an OCI image manifest or index.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Manifests describes the manifests of an index, with their platforms.
+	Manifests []distribution.Descriptor `json:"manifests,omitempty"`
+}
+
 // RequestRecord covers the request that generated the event.
 type RequestRecord struct {
 	// ID uniquely identifies the request that initiated the event.
@@ -147,3 +215,7 @@ type SourceRecord struct {
 // closed. If encountered, the error should be considered terminal and
 // retries will not be successful.
 var ErrSinkClosed = fmt.Errorf("sink: closed")
+
+// ErrNoDeadLetterStore is returned when replaying the dead-lettered events
+// of an endpoint without a dead-letter store.
+var ErrNoDeadLetterStore = fmt.Errorf("endpoint has no dead-letter store")
@@ -0,0 +1,132 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/optionutil"
+	events "github.com/docker/go-events"
+)
+
+func init() {
+	if err := RegisterSink("exec", newExecSink); err != nil {
+		panic(err)
+	}
+}
+
+// maxExecOutput limits the command output reported in errors.
+const maxExecOutput = 1024
+
+// execSink runs a command for each event, writing the event envelope, or the
+// event in the format of the endpoint, to its standard input. The media type
+// is passed in the REGISTRY_CONTENT_TYPE environment variable and, if the
+// endpoint has secrets, the signature in REGISTRY_SIGNATURE. A non-zero exit
+// status fails the write.
+type execSink struct {
+	mu       sync.Mutex
+	command  string
+	args     []string
+	timeout  time.Duration
+	closed   bool
+	encoding sinkEncoding
+}
+
+// newExecSink creates an exec sink from the options "command" (required),
+// "args", a list of arguments, and "timeout", after which the command is
+// killed. The command is run directly, not through a shell.
+func newExecSink(options map[string]interface{}) (events.Sink, error) {
+	command, err := stringOption(options, "command", "")
+	if err != nil {
+		return nil, err
+	}
+	if command == "" {
+		return nil, fmt.Errorf("exec sink requires a command")
+	}
+
+	var args []string
+	if v, ok := options["args"]; ok && v != nil {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("args must be a list, got %T", v)
+		}
+		for _, arg := range list {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("args must be strings, got %T", arg)
+			}
+			args = append(args, s)
+		}
+	}
+
+	timeout, err := optionutil.Duration(options, "timeout", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding, err := newSinkEncoding(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &execSink{
+		command:  command,
+		args:     args,
+		timeout:  timeout,
+		encoding: encoding,
+	}, nil
+}
+
+// Write runs the command with the event envelope on its standard input.
+func (es *execSink) Write(event events.Event) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.closed {
+		return ErrSinkClosed
+	}
+
+	mediaType, p, signature, err := es.encoding.encode(event, marshalEnvelope)
+	if err != nil {
+		return fmt.Errorf("%v: error marshaling event envelope: %v", es, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), es.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, es.command, es.args...)
+	cmd.Stdin = bytes.NewReader(p)
+	cmd.Env = append(os.Environ(), "REGISTRY_CONTENT_TYPE="+mediaType)
+	if signature != "" {
+		cmd.Env = append(cmd.Env, "REGISTRY_SIGNATURE="+signature)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if len(output) > maxExecOutput {
+			output = output[:maxExecOutput]
+		}
+		return fmt.Errorf("%v: %v: %s", es, err, bytes.TrimSpace(output))
+	}
+
+	return nil
+}
+
+// Close prevents further commands from running.
+func (es *execSink) Close() error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.closed {
+		return fmt.Errorf("execsink: already closed")
+	}
+
+	es.closed = true
+	return nil
+}
+
+func (es *execSink) String() string {
+	return fmt.Sprintf("execSink{%s}", es.command)
+}
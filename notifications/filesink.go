@@ -0,0 +1,166 @@
+package notifications
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	events "github.com/docker/go-events"
+)
+
+func init() {
+	if err := RegisterSink("file", newFileSink); err != nil {
+		panic(err)
+	}
+}
+
+const (
+	defaultFileSinkMaxSize    = 100 << 20
+	defaultFileSinkMaxBackups = 5
+)
+
+// fileSink appends events as JSON lines to a local file, either as is or in
+// the format of the endpoint. Events cannot be signed. Once the file
+// reaches its maximum size, it is rotated: the file is renamed with the
+// suffix ".1", older files are shifted to ".2", ".3" and so on, and the
+// oldest are removed.
+type fileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	closed     bool
+	encoding   sinkEncoding
+}
+
+// newFileSink creates a file sink from the options "path" (required),
+// "maxsize" in bytes and "maxbackups".
+func newFileSink(options map[string]interface{}) (events.Sink, error) {
+	path, err := stringOption(options, "path", "")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("file sink requires a path")
+	}
+
+	maxSize, err := intOption(options, "maxsize", defaultFileSinkMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	maxBackups, err := intOption(options, "maxbackups", defaultFileSinkMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := newSinkEncoding(options)
+	if err != nil {
+		return nil, err
+	}
+	if len(encoding.secrets) > 0 {
+		return nil, fmt.Errorf("file sink does not support secrets")
+	}
+
+	fs := &fileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: int(maxBackups),
+		encoding:   encoding,
+	}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+func (fs *fileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(fs.path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fs.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	fs.file = f
+	fs.size = fi.Size()
+	return nil
+}
+
+// Write appends the event to the file, rotating it first if the event would
+// exceed the maximum size.
+func (fs *fileSink) Write(event events.Event) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return ErrSinkClosed
+	}
+
+	_, p, _, err := fs.encoding.encode(event, marshalEvent)
+	if err != nil {
+		return fmt.Errorf("%v: error marshaling event: %v", fs, err)
+	}
+	p = append(p, '\n')
+
+	if fs.maxSize > 0 && fs.size > 0 && fs.size+int64(len(p)) > fs.maxSize {
+		if err := fs.rotate(); err != nil {
+			return fmt.Errorf("%v: error rotating file: %v", fs, err)
+		}
+	}
+
+	n, err := fs.file.Write(p)
+	fs.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("%v: error writing event: %v", fs, err)
+	}
+
+	return nil
+}
+
+func (fs *fileSink) rotate() error {
+	if err := fs.file.Close(); err != nil {
+		return err
+	}
+
+	if fs.maxBackups > 0 {
+		for i := fs.maxBackups - 1; i > 0; i-- {
+			if err := os.Rename(fmt.Sprintf("%s.%d", fs.path, i), fmt.Sprintf("%s.%d", fs.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(fs.path, fs.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(fs.path); err != nil {
+		return err
+	}
+
+	return fs.open()
+}
+
+// Close closes the file.
+func (fs *fileSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return fmt.Errorf("filesink: already closed")
+	}
+
+	fs.closed = true
+	return fs.file.Close()
+}
+
+func (fs *fileSink) String() string {
+	return fmt.Sprintf("fileSink{%s}", fs.path)
+}
@@ -0,0 +1,137 @@
+package notifications
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/distribution/distribution/v3/configuration"
+	events "github.com/docker/go-events"
+)
+
+// filterRules is the compiled form of configuration.FilterRules.
+type filterRules struct {
+	repositories []string
+	tags         []*regexp.Regexp
+	actors       map[string]bool
+}
+
+func compileFilterRules(config configuration.FilterRules) (filterRules, error) {
+	rules := filterRules{
+		repositories: config.Repositories,
+	}
+
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return filterRules{}, fmt.Errorf("invalid repository pattern %q: %v", pattern, err)
+		}
+	}
+
+	for _, expr := range config.Tags {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return filterRules{}, fmt.Errorf("invalid tag expression %q: %v", expr, err)
+		}
+		rules.tags = append(rules.tags, re)
+	}
+
+	if len(config.Actors) > 0 {
+		rules.actors = make(map[string]bool)
+		for _, actor := range config.Actors {
+			rules.actors[actor] = true
+		}
+	}
+
+	return rules, nil
+}
+
+func (fr filterRules) empty() bool {
+	return len(fr.repositories) == 0 && len(fr.tags) == 0 && len(fr.actors) == 0
+}
+
+func (fr filterRules) matchRepository(event Event) bool {
+	for _, pattern := range fr.repositories {
+		if matched, _ := path.Match(pattern, event.Target.Repository); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (fr filterRules) matchTag(event Event) bool {
+	if event.Target.Tag == "" {
+		return false
+	}
+	for _, re := range fr.tags {
+		if re.MatchString(event.Target.Tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// includes reports whether the event matches every non-empty list.
+func (fr filterRules) includes(event Event) bool {
+	return (len(fr.repositories) == 0 || fr.matchRepository(event)) &&
+		(len(fr.tags) == 0 || fr.matchTag(event)) &&
+		(len(fr.actors) == 0 || fr.actors[event.Actor.Name])
+}
+
+// excludes reports whether the event matches any entry of any list.
+func (fr filterRules) excludes(event Event) bool {
+	return fr.matchRepository(event) || fr.matchTag(event) || fr.actors[event.Actor.Name]
+}
+
+// CheckFilter returns an error if the filter has invalid patterns.
+func CheckFilter(filter configuration.Filter) error {
+	if _, err := compileFilterRules(filter.Include); err != nil {
+		return err
+	}
+	_, err := compileFilterRules(filter.Exclude)
+	return err
+}
+
+// filteredSink passes along the events selected by an endpoint filter and
+// discards the rest.
+type filteredSink struct {
+	events.Sink
+	include filterRules
+	exclude filterRules
+}
+
+// newFilteredSink returns a sink passing the events selected by filter to
+// sink, or sink itself if the filter is empty.
+func newFilteredSink(sink events.Sink, filter configuration.Filter) (events.Sink, error) {
+	include, err := compileFilterRules(filter.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compileFilterRules(filter.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	if include.empty() && exclude.empty() {
+		return sink, nil
+	}
+
+	return &filteredSink{
+		Sink:    sink,
+		include: include,
+		exclude: exclude,
+	}, nil
+}
+
+// Write passes the event along if it is included and not excluded.
+func (fs *filteredSink) Write(event events.Event) error {
+	e, ok := event.(Event)
+	if !ok {
+		return fs.Sink.Write(event)
+	}
+
+	if !fs.include.includes(e) || fs.exclude.excludes(e) {
+		return nil
+	}
+
+	return fs.Sink.Write(event)
+}
@@ -0,0 +1,89 @@
+package notifications
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestFilteredSink(t *testing.T) {
+	newEvent := func(repo, tag, actor string) Event {
+		event := createTestEvent("push", repo, "manifest")
+		event.Target.Tag = tag
+		event.Actor.Name = actor
+		return event
+	}
+
+	deploy := configuration.Filter{
+		Include: configuration.FilterRules{
+			Repositories: []string{"prod/*"},
+			Tags:         []string{`v?\d+\.\d+\.\d+`},
+		},
+	}
+	scanning := configuration.Filter{
+		Exclude: configuration.FilterRules{
+			Repositories: []string{"cache/*"},
+			Actors:       []string{"scanner"},
+		},
+	}
+	ci := configuration.Filter{
+		Include: configuration.FilterRules{Actors: []string{"ci"}},
+		Exclude: configuration.FilterRules{Tags: []string{"dev-.*"}},
+	}
+
+	for _, tc := range []struct {
+		name      string
+		filter    configuration.Filter
+		event     Event
+		published bool
+	}{
+		{"no filter", configuration.Filter{}, newEvent("cache/alpine", "", ""), true},
+		{"prod semver", deploy, newEvent("prod/api", "v1.2.3", "ci"), true},
+		{"prod semver without prefix", deploy, newEvent("prod/api", "1.2.3", "ci"), true},
+		{"prod partial semver", deploy, newEvent("prod/api", "v1.2.3-rc1", "ci"), false},
+		{"prod latest", deploy, newEvent("prod/api", "latest", "ci"), false},
+		{"prod digest", deploy, newEvent("prod/api", "", "ci"), false},
+		{"nested prod", deploy, newEvent("prod/team/api", "v1.2.3", "ci"), false},
+		{"staging semver", deploy, newEvent("staging/api", "v1.2.3", "ci"), false},
+		{"scanned", scanning, newEvent("library/alpine", "", "alice"), true},
+		{"cache", scanning, newEvent("cache/alpine", "latest", "alice"), false},
+		{"scanner", scanning, newEvent("library/alpine", "latest", "scanner"), false},
+		{"ci release", ci, newEvent("library/alpine", "3.18", "ci"), true},
+		{"ci untagged", ci, newEvent("library/alpine", "", "ci"), true},
+		{"ci dev", ci, newEvent("library/alpine", "dev-1234", "ci"), false},
+		{"user", ci, newEvent("library/alpine", "3.18", "alice"), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := &testSink{}
+			s, err := newFilteredSink(ts, tc.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Write(tc.event); err != nil {
+				t.Fatalf("error writing event: %v", err)
+			}
+
+			if published := ts.event != nil; published != tc.published {
+				t.Fatalf("unexpected result: published=%v, expected %v", published, tc.published)
+			}
+		})
+	}
+}
+
+func TestCheckFilter(t *testing.T) {
+	if err := CheckFilter(configuration.Filter{
+		Include: configuration.FilterRules{Repositories: []string{"prod/*"}, Tags: []string{`v\d+`}},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, filter := range []configuration.Filter{
+		{Include: configuration.FilterRules{Repositories: []string{"prod/["}}},
+		{Exclude: configuration.FilterRules{Tags: []string{"v("}}},
+	} {
+		if err := CheckFilter(filter); err == nil {
+			t.Errorf("expected error for %+v", filter)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package notifications
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"sync"
@@ -15,7 +14,9 @@ import (
 // very lightweight in that it only makes an attempt at an http request.
 // Reliability should be provided by the caller.
 type httpSink struct {
-	url string
+	url     string
+	secrets []string
+	encoder eventEncoder
 
 	mu        sync.Mutex
 	closed    bool
@@ -27,13 +28,20 @@ type httpSink struct {
 }
 
 // newHTTPSink returns an unreliable, single-flight http sink. Wrap in other
-// sinks for increased reliability.
-func newHTTPSink(u string, timeout time.Duration, headers http.Header, transport *http.Transport, listeners ...httpStatusListener) *httpSink {
+// sinks for increased reliability. If secrets are provided, request bodies
+// are signed with each of them. Events are encoded in an Envelope unless
+// another encoder is provided.
+func newHTTPSink(u string, timeout time.Duration, headers http.Header, secrets []string, encoder eventEncoder, transport *http.Transport, listeners ...httpStatusListener) *httpSink {
 	if transport == nil {
 		transport = http.DefaultTransport.(*http.Transport)
 	}
+	if encoder == nil {
+		encoder = envelopeEncoder{}
+	}
 	return &httpSink{
 		url:       u,
+		secrets:   secrets,
+		encoder:   encoder,
 		listeners: listeners,
 		client: &http.Client{
 			Transport: &headerRoundTripper{
@@ -53,8 +61,9 @@ type httpStatusListener interface {
 }
 
 // Accept makes an attempt to notify the endpoint, returning an error if it
-// fails. It is the caller's responsibility to retry on error. The events are
-// accepted or rejected as a group.
+// fails. It is the caller's responsibility to retry on error. The events of
+// an eventBatch are sent in a single request and are accepted or rejected as
+// a group.
 func (hs *httpSink) Write(event events.Event) error {
 	hs.mu.Lock()
 	defer hs.mu.Unlock()
@@ -64,27 +73,42 @@ func (hs *httpSink) Write(event events.Event) error {
 		return ErrSinkClosed
 	}
 
-	envelope := Envelope{
-		Events: []events.Event{event},
-	}
+	batch := batchEvents(event)
 
 	// TODO(stevvooe): It is not ideal to keep re-encoding the request body on
 	// retry but we are going to do it to keep the code simple. It is likely
 	// we could change the event struct to manage its own buffer.
 
-	p, err := json.MarshalIndent(envelope, "", "   ")
+	mediaType, p, err := hs.encoder.encode(batch)
+	if err != nil {
+		for _, listener := range hs.listeners {
+			for _, e := range batch {
+				listener.err(err, e)
+			}
+		}
+		return fmt.Errorf("%v: error marshaling events: %v", hs, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hs.url, bytes.NewReader(p))
 	if err != nil {
 		for _, listener := range hs.listeners {
-			listener.err(err, event)
+			for _, e := range batch {
+				listener.err(err, e)
+			}
 		}
-		return fmt.Errorf("%v: error marshaling event envelope: %v", hs, err)
+		return fmt.Errorf("%v: error creating request: %v", hs, err)
+	}
+	req.Header.Set("Content-Type", mediaType)
+	if len(hs.secrets) > 0 {
+		req.Header.Set(SignatureHeader, Sign(p, time.Now(), hs.secrets...))
 	}
 
-	body := bytes.NewReader(p)
-	resp, err := hs.client.Post(hs.url, EventsMediaType, body)
+	resp, err := hs.client.Do(req)
 	if err != nil {
 		for _, listener := range hs.listeners {
-			listener.err(err, event)
+			for _, e := range batch {
+				listener.err(err, e)
+			}
 		}
 
 		return fmt.Errorf("%v: error posting: %v", hs, err)
@@ -96,7 +120,9 @@ func (hs *httpSink) Write(event events.Event) error {
 	switch {
 	case resp.StatusCode >= 200 && resp.StatusCode < 400:
 		for _, listener := range hs.listeners {
-			listener.success(resp.StatusCode, event)
+			for _, e := range batch {
+				listener.success(resp.StatusCode, e)
+			}
 		}
 
 		// TODO(stevvooe): This is a little accepting: we may want to support
@@ -106,7 +132,9 @@ func (hs *httpSink) Write(event events.Event) error {
 		return nil
 	default:
 		for _, listener := range hs.listeners {
-			listener.failure(resp.StatusCode, event)
+			for _, e := range batch {
+				listener.failure(resp.StatusCode, e)
+			}
 		}
 		return fmt.Errorf("%v: response status %v unaccepted", hs, resp.Status)
 	}
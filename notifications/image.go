@@ -0,0 +1,82 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxImageConfigSize bounds the size of the image configurations read to
+// describe pushed manifests. Larger configurations are skipped.
+const maxImageConfigSize = 4 << 20
+
+// describeImage returns the image record of manifest m, or nil if m is
+// neither an image manifest nor an index. The image configuration of an
+// image manifest is read from blobs, if set.
+func describeImage(ctx context.Context, blobs distribution.BlobProvider, m distribution.Manifest) (*ImageRecord, error) {
+	switch m := m.(type) {
+	case *ocischema.DeserializedManifest:
+		record := &ImageRecord{Annotations: m.Annotations}
+		return record, describeImageConfig(ctx, blobs, m.Config, record)
+	case *schema2.DeserializedManifest:
+		record := &ImageRecord{}
+		return record, describeImageConfig(ctx, blobs, m.Config, record)
+	case *ocischema.DeserializedImageIndex:
+		return &ImageRecord{Annotations: m.Annotations, Manifests: indexManifests(m.References())}, nil
+	case *manifestlist.DeserializedManifestList:
+		return &ImageRecord{Manifests: indexManifests(m.References())}, nil
+	}
+	return nil, nil
+}
+
+// describeImageConfig populates record with the platform, creation time and
+// labels of the image configuration desc. Configurations of other artifacts
+// are ignored.
+func describeImageConfig(ctx context.Context, blobs distribution.BlobProvider, desc distribution.Descriptor, record *ImageRecord) error {
+	switch desc.MediaType {
+	case v1.MediaTypeImageConfig, schema2.MediaTypeImageConfig:
+	default:
+		return nil
+	}
+	if blobs == nil || desc.Size > maxImageConfigSize {
+		return nil
+	}
+
+	p, err := blobs.Get(ctx, desc.Digest)
+	if err != nil {
+		return err
+	}
+
+	var config v1.Image
+	if err := json.Unmarshal(p, &config); err != nil {
+		return err
+	}
+
+	if config.OS != "" || config.Architecture != "" {
+		platform := config.Platform
+		record.Platform = &platform
+	}
+	record.Created = config.Created
+	record.Labels = config.Config.Labels
+	return nil
+}
+
+// indexManifests returns the descriptors of the manifests of an index,
+// without their annotations.
+func indexManifests(refs []distribution.Descriptor) []distribution.Descriptor {
+	manifests := make([]distribution.Descriptor, 0, len(refs))
+	for _, ref := range refs {
+		manifests = append(manifests, distribution.Descriptor{
+			MediaType: ref.MediaType,
+			Digest:    ref.Digest,
+			Size:      ref.Size,
+			Platform:  ref.Platform,
+		})
+	}
+	return manifests
+}
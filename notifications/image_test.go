@@ -0,0 +1,170 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type testBlobProvider map[digest.Digest][]byte
+
+func (bp testBlobProvider) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	p, ok := bp[dgst]
+	if !ok {
+		return distribution.Descriptor{}, distribution.ErrBlobUnknown
+	}
+	return distribution.Descriptor{Digest: dgst, Size: int64(len(p))}, nil
+}
+
+func (bp testBlobProvider) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	p, ok := bp[dgst]
+	if !ok {
+		return nil, distribution.ErrBlobUnknown
+	}
+	return p, nil
+}
+
+func (bp testBlobProvider) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	return nil, distribution.ErrUnsupported
+}
+
+// putImageConfig stores an image configuration in bp and returns its
+// descriptor.
+func (bp testBlobProvider) putImageConfig(t *testing.T, mediaType string, config v1.Image) distribution.Descriptor {
+	p, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromBytes(p)
+	bp[dgst] = p
+	return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(p))}
+}
+
+func TestDescribeImage(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	platform := v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	labels := map[string]string{"org.opencontainers.image.source": "https://example.com/repo"}
+	annotations := map[string]string{"org.opencontainers.image.revision": "abc"}
+	blobs := testBlobProvider{}
+
+	config := v1.Image{Created: &created, Platform: platform}
+	config.Config.Labels = labels
+	ociConfig := blobs.putImageConfig(t, v1.MediaTypeImageConfig, config)
+	dockerConfig := blobs.putImageConfig(t, schema2.MediaTypeImageConfig, config)
+
+	ociManifest, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   v1.MediaTypeImageManifest,
+		Config:      ociConfig,
+		Annotations: annotations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dockerManifest, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: schema2.MediaTypeManifest,
+		Config:    dockerConfig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    distribution.Descriptor{MediaType: artifactType, Digest: digest.FromString("unknown"), Size: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := distribution.Descriptor{
+		MediaType:   v1.MediaTypeImageManifest,
+		Digest:      digest.FromString("child"),
+		Size:        100,
+		Platform:    &platform,
+		Annotations: map[string]string{"child": "annotation"},
+	}
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{child}, annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := manifestlist.FromDescriptors([]manifestlist.ManifestDescriptor{{
+		Descriptor: distribution.Descriptor{MediaType: schema2.MediaTypeManifest, Digest: child.Digest, Size: child.Size},
+		Platform:   manifestlist.PlatformSpec{OS: platform.OS, Architecture: platform.Architecture, Variant: platform.Variant},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexChild := child
+	indexChild.Annotations = nil
+	listChild := indexChild
+	listChild.MediaType = schema2.MediaTypeManifest
+
+	for _, tc := range []struct {
+		name     string
+		manifest distribution.Manifest
+		expected *ImageRecord
+	}{
+		{
+			name:     "oci image",
+			manifest: ociManifest,
+			expected: &ImageRecord{Platform: &platform, Created: &created, Labels: labels, Annotations: annotations},
+		},
+		{
+			name:     "docker image",
+			manifest: dockerManifest,
+			expected: &ImageRecord{Platform: &platform, Created: &created, Labels: labels},
+		},
+		{
+			name:     "artifact",
+			manifest: artifact,
+			expected: &ImageRecord{},
+		},
+		{
+			name:     "oci index",
+			manifest: index,
+			expected: &ImageRecord{Annotations: annotations, Manifests: []distribution.Descriptor{indexChild}},
+		},
+		{
+			name:     "manifest list",
+			manifest: list,
+			expected: &ImageRecord{Manifests: []distribution.Descriptor{listChild}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			image, err := describeImage(context.Background(), blobs, tc.manifest)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if image.Created != nil && !image.Created.Equal(created) {
+				t.Fatalf("unexpected creation time: %v", image.Created)
+			}
+			image.Created, tc.expected.Created = nil, nil
+			if !reflect.DeepEqual(image, tc.expected) {
+				t.Fatalf("unexpected image record:\n%#v\n!=\n%#v", image, tc.expected)
+			}
+		})
+	}
+
+	// missing configurations are reported, keeping the manifest data
+	image, err := describeImage(context.Background(), testBlobProvider{}, ociManifest)
+	if err == nil {
+		t.Fatal("expected error describing image with a missing configuration")
+	}
+	if !reflect.DeepEqual(image, &ImageRecord{Annotations: annotations}) {
+		t.Fatalf("unexpected image record: %#v", image)
+	}
+}
@@ -33,11 +33,30 @@ type RepoListener interface {
 	RepoDeleted(repo reference.Named) error
 }
 
+// TagListener describes a listener that can respond to tag lifecycle events.
+// The tag service only reads the previous digest of a tag for these events,
+// and dispatches them, if TagEventsEnabled returns true.
+type TagListener interface {
+	TagEventsEnabled() bool
+	TagCreated(repo reference.Named, tag string, desc distribution.Descriptor) error
+	TagUpdated(repo reference.Named, tag string, desc distribution.Descriptor, previous digest.Digest) error
+	TagRemoved(repo reference.Named, tag string, desc distribution.Descriptor) error
+}
+
+// UploadListener describes a listener that can respond to upload lifecycle
+// events.
+type UploadListener interface {
+	UploadStarted(repo reference.Named, id string) error
+	UploadCancelled(repo reference.Named, id string) error
+}
+
 // Listener combines all repository events into a single interface.
 type Listener interface {
 	ManifestListener
 	BlobListener
 	RepoListener
+	TagListener
+	UploadListener
 }
 
 type repositoryListener struct {
@@ -197,6 +216,10 @@ func (bsl *blobServiceListener) Create(ctx context.Context, options ...distribut
 			dcontext.GetLogger(ctx).Errorf("error dispatching blob mount to listener: %v", err)
 		}
 		return nil, err
+	case nil:
+		if err := bsl.parent.listener.UploadStarted(bsl.parent.Repository.Named(), wr.ID()); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error dispatching upload start to listener: %v", err)
+		}
 	}
 	return bsl.decorateWriter(wr), err
 }
@@ -240,6 +263,17 @@ func (bwl *blobWriterListener) Commit(ctx context.Context, desc distribution.Des
 	return committed, err
 }
 
+func (bwl *blobWriterListener) Cancel(ctx context.Context) error {
+	err := bwl.BlobWriter.Cancel(ctx)
+	if err == nil {
+		if err := bwl.parent.parent.listener.UploadCancelled(bwl.parent.parent.Repository.Named(), bwl.ID()); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error dispatching upload cancel to listener: %v", err)
+		}
+	}
+
+	return err
+}
+
 type tagServiceListener struct {
 	distribution.TagService
 	parent *repositoryListener
@@ -252,7 +286,50 @@ func (rl *repositoryListener) Tags(ctx context.Context) distribution.TagService
 	}
 }
 
+// Tag dispatches a tag created event if the tag is new, or a tag updated
+// event if it pointed to another digest. The previous digest is read before
+// tagging, so concurrent updates of the same tag may report a stale one. A
+// tag whose previous digest cannot be read is reported as created.
+func (tagSL *tagServiceListener) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	if !tagSL.parent.listener.TagEventsEnabled() {
+		return tagSL.TagService.Tag(ctx, tag, desc)
+	}
+
+	previous, err := tagSL.TagService.Get(ctx, tag)
+	created := err != nil
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); !ok {
+			dcontext.GetLogger(ctx).Errorf("error reading the previous digest of tag %s for the listener: %v", tag, err)
+		}
+	}
+
+	if err := tagSL.TagService.Tag(ctx, tag, desc); err != nil {
+		return err
+	}
+
+	named := tagSL.parent.Repository.Named()
+	switch {
+	case created:
+		if err := tagSL.parent.listener.TagCreated(named, tag, desc); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error dispatching tag created to listener: %v", err)
+		}
+	case previous.Digest != desc.Digest:
+		if err := tagSL.parent.listener.TagUpdated(named, tag, desc, previous.Digest); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error dispatching tag updated to listener: %v", err)
+		}
+	}
+	return nil
+}
+
 func (tagSL *tagServiceListener) Untag(ctx context.Context, tag string) error {
+	tagEvents := tagSL.parent.listener.TagEventsEnabled()
+	var previous distribution.Descriptor
+	if tagEvents {
+		// the descriptor is only used for the event, a tag which cannot be
+		// read is still removed.
+		previous, _ = tagSL.TagService.Get(ctx, tag)
+	}
+
 	if err := tagSL.TagService.Untag(ctx, tag); err != nil {
 		return err
 	}
@@ -260,5 +337,12 @@ func (tagSL *tagServiceListener) Untag(ctx context.Context, tag string) error {
 		dcontext.GetLogger(ctx).Errorf("error dispatching tag deleted to listener: %v", err)
 		return err
 	}
+	if !tagEvents {
+		return nil
+	}
+	if err := tagSL.parent.listener.TagRemoved(tagSL.parent.Repository.Named(), tag, previous); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error dispatching tag removed to listener: %v", err)
+		return err
+	}
 	return nil
 }
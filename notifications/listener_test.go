@@ -2,6 +2,8 @@ package notifications
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -27,7 +29,8 @@ func TestListener(t *testing.T) {
 		t.Fatalf("error creating registry: %v", err)
 	}
 	tl := &testListener{
-		ops: make(map[string]int),
+		ops:  make(map[string]int),
+		tags: true,
 	}
 
 	repoRef, _ := reference.WithName("foo/bar")
@@ -53,7 +56,11 @@ func TestListener(t *testing.T) {
 		"layer:pull":      3,
 		"layer:delete":    3,
 		"tag:delete":      1,
+		"tag:create":      1,
+		"tag:remove":      1,
 		"repo:delete":     1,
+		"upload:start":    4,
+		"upload:cancel":   1,
 	}
 
 	if !reflect.DeepEqual(tl.ops, expectedOps) {
@@ -61,8 +68,144 @@ func TestListener(t *testing.T) {
 	}
 }
 
+func TestTagListener(t *testing.T) {
+	ctx := dcontext.Background()
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New(), storage.EnableDelete)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repoRef, _ := reference.WithName("foo/bar")
+	repository, err := registry.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	tl := &testListener{
+		ops:  make(map[string]int),
+		tags: true,
+	}
+	repository, _ = Listen(repository, registry.(distribution.RepositoryRemover), tl)
+	tags := repository.Tags(ctx)
+
+	first := distribution.Descriptor{Digest: digest.FromString("first")}
+	second := distribution.Descriptor{Digest: digest.FromString("second")}
+	for _, desc := range []distribution.Descriptor{first, first, second} {
+		if err := tags.Tag(ctx, "latest", desc); err != nil {
+			t.Fatalf("unexpected error tagging: %v", err)
+		}
+	}
+	if err := tags.Untag(ctx, "latest"); err != nil {
+		t.Fatalf("unexpected error untagging: %v", err)
+	}
+
+	expectedOps := map[string]int{
+		"tag:create": 1,
+		"tag:update": 1,
+		"tag:delete": 1,
+		"tag:remove": 1,
+	}
+	if !reflect.DeepEqual(tl.ops, expectedOps) {
+		t.Fatalf("counts do not match:\n%v\n !=\n%v", tl.ops, expectedOps)
+	}
+	if tl.previous != first.Digest {
+		t.Fatalf("unexpected previous digest: %v != %v", tl.previous, first.Digest)
+	}
+	if tl.removed.Digest != second.Digest {
+		t.Fatalf("unexpected removed digest: %v != %v", tl.removed.Digest, second.Digest)
+	}
+}
+
+func TestTagListenerLookups(t *testing.T) {
+	ctx := dcontext.Background()
+	desc := distribution.Descriptor{Digest: digest.FromString("first")}
+
+	for _, tc := range []struct {
+		name        string
+		tags        bool
+		getErr      error
+		expectedOps map[string]int
+		expectedGet int
+	}{
+		{
+			name:        "tag events disabled",
+			expectedOps: map[string]int{"tag:delete": 1},
+		},
+		{
+			name:        "lookup failure",
+			tags:        true,
+			getErr:      fmt.Errorf("unavailable"),
+			expectedOps: map[string]int{"tag:create": 1, "tag:delete": 1, "tag:remove": 1},
+			expectedGet: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := &testListener{
+				ops:  make(map[string]int),
+				tags: tc.tags,
+			}
+			ts := &lookupTagService{err: tc.getErr}
+			repoRef, _ := reference.WithName("foo/bar")
+			tags := &tagServiceListener{
+				TagService: ts,
+				parent:     &repositoryListener{Repository: namedRepository{named: repoRef}, listener: tl},
+			}
+
+			if err := tags.Tag(ctx, "latest", desc); err != nil {
+				t.Fatalf("unexpected error tagging: %v", err)
+			}
+			if err := tags.Untag(ctx, "latest"); err != nil {
+				t.Fatalf("unexpected error untagging: %v", err)
+			}
+			if !reflect.DeepEqual(tl.ops, tc.expectedOps) {
+				t.Fatalf("counts do not match:\n%v\n !=\n%v", tl.ops, tc.expectedOps)
+			}
+			if ts.gets != tc.expectedGet {
+				t.Fatalf("unexpected number of lookups: %d != %d", ts.gets, tc.expectedGet)
+			}
+		})
+	}
+}
+
+// lookupTagService counts the lookups of tags, which fail with err.
+type lookupTagService struct {
+	distribution.TagService
+	err  error
+	gets int
+}
+
+func (ts *lookupTagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	ts.gets++
+	return distribution.Descriptor{}, ts.err
+}
+
+func (ts *lookupTagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	return nil
+}
+
+func (ts *lookupTagService) Untag(ctx context.Context, tag string) error {
+	return nil
+}
+
+// namedRepository is a repository which only has a name.
+type namedRepository struct {
+	distribution.Repository
+	named reference.Named
+}
+
+func (r namedRepository) Named() reference.Named {
+	return r.named
+}
+
 type testListener struct {
-	ops map[string]int
+	ops      map[string]int
+	tags     bool
+	previous digest.Digest
+	removed  distribution.Descriptor
+}
+
+func (tl *testListener) TagEventsEnabled() bool {
+	return tl.tags
 }
 
 func (tl *testListener) ManifestPushed(repo reference.Named, m distribution.Manifest, options ...distribution.ManifestServiceOption) error {
@@ -105,11 +248,38 @@ func (tl *testListener) TagDeleted(repo reference.Named, tag string) error {
 	return nil
 }
 
+func (tl *testListener) TagCreated(repo reference.Named, tag string, desc distribution.Descriptor) error {
+	tl.ops["tag:create"]++
+	return nil
+}
+
+func (tl *testListener) TagUpdated(repo reference.Named, tag string, desc distribution.Descriptor, previous digest.Digest) error {
+	tl.ops["tag:update"]++
+	tl.previous = previous
+	return nil
+}
+
+func (tl *testListener) TagRemoved(repo reference.Named, tag string, desc distribution.Descriptor) error {
+	tl.ops["tag:remove"]++
+	tl.removed = desc
+	return nil
+}
+
 func (tl *testListener) RepoDeleted(repo reference.Named) error {
 	tl.ops["repo:delete"]++
 	return nil
 }
 
+func (tl *testListener) UploadStarted(repo reference.Named, id string) error {
+	tl.ops["upload:start"]++
+	return nil
+}
+
+func (tl *testListener) UploadCancelled(repo reference.Named, id string) error {
+	tl.ops["upload:cancel"]++
+	return nil
+}
+
 // checkTestRepository takes the registry through all of its operations,
 // carrying out generic checks.
 func checkTestRepository(t *testing.T, repository distribution.Repository, remover distribution.RepositoryRemover) {
@@ -176,6 +346,15 @@ func checkTestRepository(t *testing.T, repository distribution.Repository, remov
 		}
 	}
 
+	// start an upload and abandon it
+	wr, err := blobs.Create(ctx)
+	if err != nil {
+		t.Fatalf("error creating upload: %v", err)
+	}
+	if err := wr.Cancel(ctx); err != nil {
+		t.Fatalf("error cancelling upload: %v", err)
+	}
+
 	sm, err := schema2.FromStruct(m)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,73 @@
+package notifications
+
+import (
+	events "github.com/docker/go-events"
+	"github.com/opencontainers/go-digest"
+)
+
+// MaintenanceListener describes a listener that can respond to content
+// removed by maintenance tasks that run outside of a request, such as the
+// upload purger and the garbage collector. Its method set satisfies
+// storage.GCListener.
+type MaintenanceListener interface {
+	UploadPurged(repo string, id string) error
+	ManifestRemoved(repo string, dgst digest.Digest) error
+	LayerRemoved(repo string, dgst digest.Digest) error
+	BlobRemoved(dgst digest.Digest) error
+}
+
+type maintenanceBridge struct {
+	actor  ActorRecord
+	source SourceRecord
+	sink   events.Sink
+}
+
+var _ MaintenanceListener = &maintenanceBridge{}
+
+// NewMaintenanceBridge returns a maintenance listener that writes records to
+// sink, using the actor and source. The events carry no request record and
+// no urls.
+func NewMaintenanceBridge(source SourceRecord, actor ActorRecord, sink events.Sink) MaintenanceListener {
+	return &maintenanceBridge{
+		actor:  actor,
+		source: source,
+		sink:   sink,
+	}
+}
+
+func (b *maintenanceBridge) UploadPurged(repo string, id string) error {
+	event := b.createEvent(EventActionUploadPurge)
+	event.Target.Repository = repo
+	event.Target.UploadID = id
+
+	return b.sink.Write(*event)
+}
+
+func (b *maintenanceBridge) ManifestRemoved(repo string, dgst digest.Digest) error {
+	return b.createDigestEventAndWrite(EventActionGCManifestDelete, repo, dgst)
+}
+
+func (b *maintenanceBridge) LayerRemoved(repo string, dgst digest.Digest) error {
+	return b.createDigestEventAndWrite(EventActionGCBlobUnlink, repo, dgst)
+}
+
+func (b *maintenanceBridge) BlobRemoved(dgst digest.Digest) error {
+	return b.createDigestEventAndWrite(EventActionGCBlobDelete, "", dgst)
+}
+
+func (b *maintenanceBridge) createDigestEventAndWrite(action string, repo string, dgst digest.Digest) error {
+	event := b.createEvent(action)
+	event.Target.Repository = repo
+	event.Target.Digest = dgst
+
+	return b.sink.Write(*event)
+}
+
+// createEvent creates an event with actor and source populated.
+func (b *maintenanceBridge) createEvent(action string) *Event {
+	event := createEvent(action)
+	event.Source = b.source
+	event.Actor = b.actor
+
+	return event
+}
@@ -0,0 +1,57 @@
+package notifications
+
+import (
+	"testing"
+
+	events "github.com/docker/go-events"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestMaintenanceBridge(t *testing.T) {
+	var written []Event
+	l := NewMaintenanceBridge(source, ActorRecord{Name: "garbage-collect"}, testSinkFn(func(event events.Event) error {
+		written = append(written, event.(Event))
+		return nil
+	}))
+
+	dgst := digest.FromString("removed")
+	if err := l.UploadPurged(repo, "upload-id"); err != nil {
+		t.Fatalf("unexpected error notifying upload purge: %v", err)
+	}
+	if err := l.ManifestRemoved(repo, dgst); err != nil {
+		t.Fatalf("unexpected error notifying manifest removal: %v", err)
+	}
+	if err := l.LayerRemoved(repo, dgst); err != nil {
+		t.Fatalf("unexpected error notifying layer removal: %v", err)
+	}
+	if err := l.BlobRemoved(dgst); err != nil {
+		t.Fatalf("unexpected error notifying blob removal: %v", err)
+	}
+
+	expected := []struct {
+		action     string
+		repository string
+		digest     digest.Digest
+		uploadID   string
+	}{
+		{EventActionUploadPurge, repo, "", "upload-id"},
+		{EventActionGCManifestDelete, repo, dgst, ""},
+		{EventActionGCBlobUnlink, repo, dgst, ""},
+		{EventActionGCBlobDelete, "", dgst, ""},
+	}
+	if len(written) != len(expected) {
+		t.Fatalf("unexpected number of events: %d != %d", len(written), len(expected))
+	}
+	for i, e := range expected {
+		event := written[i]
+		if event.Action != e.action || event.Target.Repository != e.repository || event.Target.Digest != e.digest || event.Target.UploadID != e.uploadID {
+			t.Errorf("unexpected event %d: %q %#v", i, event.Action, event.Target)
+		}
+		if event.Source != source || event.Actor.Name != "garbage-collect" {
+			t.Errorf("unexpected event %d source or actor: %#v %#v", i, event.Source, event.Actor)
+		}
+		if event.ID == "" || event.Timestamp.IsZero() {
+			t.Errorf("event %d is missing id or timestamp", i)
+		}
+	}
+}
@@ -46,15 +46,19 @@ func init() {
 		var names []interface{}
 		for _, v := range endpoints.registered {
 			var epjson struct {
-				Name string `json:"name"`
-				URL  string `json:"url"`
+				Name   string `json:"name"`
+				Type   string `json:"type"`
+				URL    string `json:"url"`
+				Paused bool   `json:"paused"`
 				EndpointConfig
 
 				Metrics EndpointMetrics
 			}
 
 			epjson.Name = v.Name()
+			epjson.Type = v.Type()
 			epjson.URL = v.URL()
+			epjson.Paused = v.Paused()
 			epjson.EndpointConfig = v.EndpointConfig
 
 			v.ReadMetrics(&epjson.Metrics)
@@ -75,12 +79,13 @@ func init() {
 // number of events. The goal of this to export it via expvar but we may find
 // some other future solution to be better.
 type EndpointMetrics struct {
-	Pending   int            // events pending in queue
-	Events    int            // total events incoming
-	Successes int            // total events written successfully
-	Failures  int            // total events failed
-	Errors    int            // total events errored
-	Statuses  map[string]int // status code histogram, per call event
+	Pending     int            // events pending in queue
+	Events      int            // total events incoming
+	Successes   int            // total events written successfully
+	Failures    int            // total events failed
+	Errors      int            // total events errored
+	DeadLetters int            // total events exceeding the retry budget
+	Statuses    map[string]int // status code histogram, per call event
 }
 
 // safeMetrics guards the metrics implementation with a lock and provides a
@@ -174,6 +179,33 @@ func (eqc *endpointMetricsEventQueueListener) egress(event events.Event) {
 	pendingGauge.WithValues(eqc.EndpointName).Dec(1)
 }
 
+// metricsSink updates the endpoint counters for sinks which do not report
+// the outcome of writes themselves.
+type metricsSink struct {
+	events.Sink
+	*safeMetrics
+}
+
+func (ms *metricsSink) Write(event events.Event) error {
+	err := ms.Sink.Write(event)
+
+	ms.safeMetrics.Lock()
+	defer ms.safeMetrics.Unlock()
+	if err != nil {
+		ms.Errors++
+		eventsCounter.WithValues("Errors", ms.EndpointName).Inc(1)
+	} else {
+		ms.Successes++
+		eventsCounter.WithValues("Successes", ms.EndpointName).Inc(1)
+	}
+
+	return err
+}
+
+func (ms *metricsSink) String() string {
+	return fmt.Sprint(ms.Sink)
+}
+
 // register places the endpoint into expvar so that stats are tracked.
 func register(e *Endpoint) {
 	endpoints.mu.Lock()
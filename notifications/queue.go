@@ -0,0 +1,273 @@
+package notifications
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// QueuedEvent is an event pending delivery, identified by its position in
+// the queue.
+type QueuedEvent struct {
+	Seq   uint64 `json:"seq"`
+	Event Event  `json:"event"`
+}
+
+// QueueStore persists the events pending delivery to an endpoint so that
+// they survive restarts. Events are appended before they are queued and
+// removed once delivered, so delivery is at least once.
+type QueueStore interface {
+	// Pending returns the events left over from a previous run, in order.
+	Pending() []QueuedEvent
+
+	// Append stores a new pending event.
+	Append(event QueuedEvent) error
+
+	// Remove deletes the event with the given sequence number.
+	Remove(seq uint64) error
+
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// durableQueue accepts events into a bounded queue backed by a QueueStore
+// for asynchronous consumption by a sink. Without a store, it behaves like
+// eventQueue with a maximum size. When batching, pending events are written
+// to the sink together as an eventBatch.
+type durableQueue struct {
+	sink       events.Sink
+	store      QueueStore
+	maxSize    int
+	dropOldest bool
+	maxEvents  int
+	maxWait    time.Duration
+	events     *list.List
+	seq        uint64
+	listeners  []eventQueueListener
+	cond       *sync.Cond
+	mu         sync.Mutex
+	closed     bool
+	done       chan struct{}
+}
+
+// newDurableQueue returns a queue to the provided sink, starting with the
+// events left in store by a previous run. store may be nil.
+func newDurableQueue(sink events.Sink, store QueueStore, config configuration.EndpointQueue, batch configuration.EndpointBatch, listeners ...eventQueueListener) *durableQueue {
+	dq := durableQueue{
+		sink:       sink,
+		store:      store,
+		maxSize:    config.MaxSize,
+		dropOldest: config.DropPolicy == "oldest",
+		maxEvents:  batch.MaxEvents,
+		maxWait:    batch.MaxWait,
+		events:     list.New(),
+		listeners:  listeners,
+		done:       make(chan struct{}),
+	}
+	dq.cond = sync.NewCond(&dq.mu)
+
+	if store != nil {
+		for _, qe := range store.Pending() {
+			dq.events.PushBack(queuedEvent{QueuedEvent: qe, event: qe.Event})
+			if qe.Seq > dq.seq {
+				dq.seq = qe.Seq
+			}
+			for _, listener := range dq.listeners {
+				listener.ingress(qe.Event)
+			}
+		}
+		if dq.events.Len() > 0 {
+			logrus.Infof("durablequeue: recovered %d pending events", dq.events.Len())
+		}
+	}
+
+	go dq.run()
+	return &dq
+}
+
+// Write stores the event and queues it, failing if the queue has been closed
+// or the event could not be stored. When the queue is full, either the event
+// or the oldest pending event is dropped.
+func (dq *durableQueue) Write(event events.Event) error {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	if dq.closed {
+		return ErrSinkClosed
+	}
+
+	if dq.maxSize > 0 && dq.events.Len() >= dq.maxSize {
+		if !dq.dropOldest {
+			logrus.Warnf("durablequeue: queue to %v is full, dropping event", dq.sink)
+			return nil
+		}
+
+		front := dq.events.Front()
+		dq.events.Remove(front)
+		logrus.Warnf("durablequeue: queue to %v is full, dropping oldest event", dq.sink)
+		dq.remove(front.Value.(queuedEvent))
+	}
+
+	qe := QueuedEvent{Seq: dq.seq + 1}
+	if dq.store != nil {
+		e, ok := event.(Event)
+		if !ok {
+			return fmt.Errorf("durablequeue: cannot store event of type %T", event)
+		}
+		qe.Event = e
+		if err := dq.store.Append(qe); err != nil {
+			return fmt.Errorf("durablequeue: error storing event: %v", err)
+		}
+	}
+	dq.seq = qe.Seq
+
+	for _, listener := range dq.listeners {
+		listener.ingress(event)
+	}
+	dq.events.PushBack(queuedEvent{QueuedEvent: qe, event: event})
+	dq.cond.Broadcast()
+
+	return nil
+}
+
+// Close shuts down the queue. Without a store, pending events are flushed
+// first. With a store, events which have not been delivered yet stay in the
+// store and are delivered after a restart.
+func (dq *durableQueue) Close() error {
+	dq.mu.Lock()
+	if dq.closed {
+		dq.mu.Unlock()
+		return fmt.Errorf("durablequeue: already closed")
+	}
+	dq.closed = true
+	dq.cond.Broadcast()
+	dq.mu.Unlock()
+
+	if dq.store == nil {
+		<-dq.done
+		return dq.sink.Close()
+	}
+
+	// closing the sink aborts an ongoing write
+	err := dq.sink.Close()
+	<-dq.done
+	if serr := dq.store.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// queuedEvent keeps the original event next to its stored form, as the
+// event is only stored when the queue has a store.
+type queuedEvent struct {
+	QueuedEvent
+	event events.Event
+}
+
+// run is the main goroutine to flush events to the target sink.
+func (dq *durableQueue) run() {
+	defer close(dq.done)
+
+	for {
+		pending, ok := dq.next()
+		if !ok {
+			return
+		}
+
+		var event events.Event
+		if len(pending) == 1 && dq.maxEvents <= 1 {
+			event = pending[0].event
+		} else {
+			batch := make(eventBatch, len(pending))
+			for i, qe := range pending {
+				batch[i] = qe.event
+			}
+			event = batch
+		}
+
+		if err := dq.sink.Write(event); err != nil {
+			if dq.store != nil && dq.isClosed() {
+				return // left in the store for the next run
+			}
+			logrus.Warnf("durablequeue: error writing %d events to %v, these events will be lost: %v", len(pending), dq.sink, err)
+		}
+
+		for _, qe := range pending {
+			dq.remove(qe)
+		}
+	}
+}
+
+// next returns the oldest pending events, at most maxEvents of them,
+// blocking until one arrives. When batching, it waits up to maxWait for the
+// batch to fill. It returns false once the queue is closed and, without a
+// store, empty.
+func (dq *durableQueue) next() ([]queuedEvent, bool) {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	for dq.events.Len() < 1 || (dq.closed && dq.store != nil) {
+		if dq.closed {
+			return nil, false
+		}
+		dq.cond.Wait()
+	}
+
+	if dq.maxEvents > 1 && dq.maxWait > 0 && dq.events.Len() < dq.maxEvents {
+		deadline := time.Now().Add(dq.maxWait)
+		timer := time.AfterFunc(dq.maxWait, func() {
+			dq.mu.Lock()
+			defer dq.mu.Unlock()
+			dq.cond.Broadcast()
+		})
+		for dq.events.Len() < dq.maxEvents && !dq.closed && time.Now().Before(deadline) {
+			dq.cond.Wait()
+		}
+		timer.Stop()
+
+		if dq.closed && dq.store != nil {
+			return nil, false
+		}
+	}
+
+	n := dq.events.Len()
+	if dq.maxEvents > 1 && n > dq.maxEvents {
+		n = dq.maxEvents
+	} else if dq.maxEvents <= 1 {
+		n = 1
+	}
+
+	pending := make([]queuedEvent, 0, n)
+	for i := 0; i < n; i++ {
+		front := dq.events.Front()
+		dq.events.Remove(front)
+		pending = append(pending, front.Value.(queuedEvent))
+	}
+
+	return pending, true
+}
+
+// remove deletes a delivered or dropped event from the store and updates
+// the listeners.
+func (dq *durableQueue) remove(qe queuedEvent) {
+	if dq.store != nil {
+		if err := dq.store.Remove(qe.Seq); err != nil {
+			logrus.Errorf("durablequeue: error removing event %d from store, it may be delivered again: %v", qe.Seq, err)
+		}
+	}
+
+	for _, listener := range dq.listeners {
+		listener.egress(qe.event)
+	}
+}
+
+func (dq *durableQueue) isClosed() bool {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+	return dq.closed
+}
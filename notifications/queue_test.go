@@ -0,0 +1,265 @@
+package notifications
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	events "github.com/docker/go-events"
+)
+
+func TestDurableQueueRecovery(t *testing.T) {
+	const nevents = 10
+	logPath := filepath.Join(t.TempDir(), "queue", "endpoint.wal")
+
+	for _, tc := range []struct {
+		name  string
+		store func(t *testing.T) QueueStore
+	}{
+		{
+			name: "disk",
+			store: func(t *testing.T) QueueStore {
+				store, err := NewFileQueueStore(logPath)
+				if err != nil {
+					t.Fatalf("unexpected error opening store: %v", err)
+				}
+				return store
+			},
+		},
+		{
+			name: "storage",
+			store: func() func(t *testing.T) QueueStore {
+				driver := inmemory.New()
+				return func(t *testing.T) QueueStore {
+					store, err := NewDriverQueueStore(context.Background(), driver, "/notifications/endpoint", "registry-0")
+					if err != nil {
+						t.Fatalf("unexpected error opening store: %v", err)
+					}
+					return store
+				}
+			}(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the endpoint is down: nothing is delivered before the
+			// registry stops.
+			metrics := newSafeMetrics("")
+			dq := newDurableQueue(newBlockingSink(), tc.store(t), configuration.EndpointQueue{}, configuration.EndpointBatch{}, metrics.eventQueueListener())
+			for i := 0; i < nevents; i++ {
+				if err := dq.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+					t.Fatalf("error writing event: %v", err)
+				}
+			}
+			if metrics.Pending != nevents {
+				t.Fatalf("unexpected pending count: %d != %d", metrics.Pending, nevents)
+			}
+			checkClose(t, dq)
+
+			// after a restart, every event is delivered.
+			var ts testSink
+			metrics = newSafeMetrics("")
+			store := tc.store(t)
+			if pending := len(store.Pending()); pending != nevents {
+				t.Fatalf("unexpected number of recovered events: %d != %d", pending, nevents)
+			}
+			dq = newDurableQueue(&ts, store, configuration.EndpointQueue{}, configuration.EndpointBatch{}, metrics.eventQueueListener())
+			waitPending(t, metrics, 0)
+			checkClose(t, dq)
+
+			ts.mu.Lock()
+			if ts.count != nevents {
+				t.Fatalf("recovered events did not make it to the sink: %d != %d", ts.count, nevents)
+			}
+			ts.mu.Unlock()
+
+			// delivered events are gone from the store.
+			store = tc.store(t)
+			if pending := len(store.Pending()); pending != 0 {
+				t.Fatalf("unexpected number of events left in the store: %d", pending)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDurableQueueDropPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		kept   []string
+	}{
+		{policy: "newest", kept: []string{"first", "second"}},
+		{policy: "oldest", kept: []string{"third", "fourth"}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			// hold back the first event until every event is queued.
+			started := make(chan struct{}, 5)
+			release := make(chan struct{})
+			var (
+				mu    sync.Mutex
+				repos []string
+			)
+			sink := testSinkFn(func(event events.Event) error {
+				started <- struct{}{}
+				<-release
+				mu.Lock()
+				defer mu.Unlock()
+				repos = append(repos, event.(Event).Target.Repository)
+				return nil
+			})
+
+			metrics := newSafeMetrics("")
+			dq := newDurableQueue(sink, nil, configuration.EndpointQueue{MaxSize: 2, DropPolicy: tc.policy}, configuration.EndpointBatch{}, metrics.eventQueueListener())
+			if err := dq.Write(createTestEvent("push", "inflight", "blob")); err != nil {
+				t.Fatal(err)
+			}
+			<-started // in flight, no longer queued
+
+			for _, repo := range []string{"first", "second", "third", "fourth"} {
+				if err := dq.Write(createTestEvent("push", repo, "blob")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			close(release)
+			checkClose(t, dq)
+
+			expected := append([]string{"inflight"}, tc.kept...)
+			if len(repos) != len(expected) {
+				t.Fatalf("unexpected delivered events: %v != %v", repos, expected)
+			}
+			for i := range expected {
+				if repos[i] != expected[i] {
+					t.Fatalf("unexpected delivered events: %v != %v", repos, expected)
+				}
+			}
+			if metrics.Pending != 0 {
+				t.Fatalf("unexpected pending count: %d", metrics.Pending)
+			}
+		})
+	}
+}
+
+func TestFileQueueStoreCompaction(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "endpoint.wal")
+	store, err := NewFileQueueStore(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const nevents = compactThreshold + 10
+	for seq := uint64(1); seq <= nevents; seq++ {
+		if err := store.Append(QueuedEvent{Seq: seq, Event: createTestEvent("push", "library/test", "blob")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for seq := uint64(1); seq <= compactThreshold; seq++ {
+		if err := store.Remove(seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileQueueStore(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	pending := store.Pending()
+	if len(pending) != nevents-compactThreshold {
+		t.Fatalf("unexpected number of pending events: %d", len(pending))
+	}
+	if pending[0].Seq != compactThreshold+1 || pending[0].Event.Target.Repository != "library/test" {
+		t.Fatalf("unexpected first pending event: %+v", pending[0])
+	}
+}
+
+func TestDriverQueueStoreInstances(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	open := func(instance string) QueueStore {
+		store, err := NewDriverQueueStore(ctx, driver, "/notifications/endpoint", instance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+
+	// two instances sharing the storage number their events alike
+	a, b := open("registry-0"), open("registry-1")
+	for _, tc := range []struct {
+		store QueueStore
+		repo  string
+	}{{a, "library/a"}, {b, "library/b"}} {
+		for seq := uint64(1); seq <= 2; seq++ {
+			if err := tc.store.Append(QueuedEvent{Seq: seq, Event: createTestEvent("push", tc.repo, "blob")}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := a.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// each instance recovers its own events only
+	for _, tc := range []struct {
+		instance string
+		repo     string
+		seqs     []uint64
+	}{{"registry-0", "library/a", []uint64{2}}, {"registry-1", "library/b", []uint64{1, 2}}} {
+		pending := open(tc.instance).Pending()
+		if len(pending) != len(tc.seqs) {
+			t.Fatalf("unexpected number of events recovered by %s: %d", tc.instance, len(pending))
+		}
+		for i, qe := range pending {
+			if qe.Seq != tc.seqs[i] || qe.Event.Target.Repository != tc.repo {
+				t.Fatalf("unexpected event recovered by %s: %+v", tc.instance, qe)
+			}
+		}
+	}
+
+	if _, err := NewDriverQueueStore(ctx, driver, "/notifications/endpoint", "a/b"); err == nil {
+		t.Fatal("expected an error for an invalid instance")
+	}
+}
+
+// blockingSink simulates an unreachable endpoint: writes block until the
+// sink is closed.
+type blockingSink struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{closed: make(chan struct{})}
+}
+
+func (bs *blockingSink) Write(event events.Event) error {
+	<-bs.closed
+	return ErrSinkClosed
+}
+
+func (bs *blockingSink) Close() error {
+	bs.once.Do(func() { close(bs.closed) })
+	return nil
+}
+
+func waitPending(t *testing.T, metrics *safeMetrics, pending int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		metrics.Lock()
+		current := metrics.Pending
+		metrics.Unlock()
+		if current == pending {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %d pending events", pending)
+}
@@ -0,0 +1,314 @@
+package notifications
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/sirupsen/logrus"
+)
+
+// fileQueueStore keeps pending events in a write-ahead log on local disk.
+// Each line of the log either adds an event or acknowledges the delivery of
+// an earlier one. The log is compacted once enough events were delivered.
+type fileQueueStore struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	pending map[uint64]json.RawMessage
+	acked   int
+}
+
+// compactThreshold is the number of acknowledgements after which the log is
+// rewritten to only contain the pending events.
+const compactThreshold = 1000
+
+// walRecord is a line of the write-ahead log.
+type walRecord struct {
+	Seq   uint64          `json:"seq"`
+	Event json.RawMessage `json:"event,omitempty"`
+	Ack   bool            `json:"ack,omitempty"`
+}
+
+// NewFileQueueStore opens the write-ahead log at path, creating it if needed,
+// and recovers the events pending in it.
+func NewFileQueueStore(path string) (QueueStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("disk queue requires a path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	fs := &fileQueueStore{
+		path:    path,
+		pending: make(map[uint64]json.RawMessage),
+	}
+	if err := fs.replay(); err != nil {
+		return nil, err
+	}
+	if err := fs.compact(); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// replay reads the log into the pending map.
+func (fs *fileQueueStore) replay() error {
+	f, err := os.Open(fs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// a crash may leave a partial last line behind
+			logrus.Warnf("filequeuestore: skipping invalid record in %s: %v", fs.path, err)
+			continue
+		}
+		if record.Ack {
+			delete(fs.pending, record.Seq)
+		} else {
+			fs.pending[record.Seq] = record.Event
+		}
+	}
+
+	return scanner.Err()
+}
+
+// compact rewrites the log with the pending events only.
+func (fs *fileQueueStore) compact() error {
+	tmp := fs.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, seq := range fs.sortedSeqs() {
+		if err := enc.Encode(walRecord{Seq: seq, Event: fs.pending[seq]}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fs.path); err != nil {
+		return err
+	}
+
+	if fs.file != nil {
+		fs.file.Close()
+	}
+	fs.file, err = os.OpenFile(fs.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	fs.acked = 0
+	return err
+}
+
+func (fs *fileQueueStore) sortedSeqs() []uint64 {
+	seqs := make([]uint64, 0, len(fs.pending))
+	for seq := range fs.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
+
+func (fs *fileQueueStore) Pending() []QueuedEvent {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return decodePending(fs.sortedSeqs(), func(seq uint64) []byte { return fs.pending[seq] })
+}
+
+func (fs *fileQueueStore) Append(qe QueuedEvent) error {
+	event, err := json.Marshal(qe.Event)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.write(walRecord{Seq: qe.Seq, Event: event}); err != nil {
+		return err
+	}
+	// the event must be on disk before it is accepted
+	if err := fs.file.Sync(); err != nil {
+		return err
+	}
+	fs.pending[qe.Seq] = event
+
+	return nil
+}
+
+func (fs *fileQueueStore) Remove(seq uint64) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.pending[seq]; !ok {
+		return nil
+	}
+
+	// an acknowledgement lost in a crash only causes a redelivery, so it
+	// is not synced
+	if err := fs.write(walRecord{Seq: seq, Ack: true}); err != nil {
+		return err
+	}
+	delete(fs.pending, seq)
+
+	fs.acked++
+	if fs.acked >= compactThreshold {
+		return fs.compact()
+	}
+	return nil
+}
+
+func (fs *fileQueueStore) write(record walRecord) error {
+	p, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = fs.file.Write(append(p, '\n'))
+	return err
+}
+
+func (fs *fileQueueStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.file.Close()
+}
+
+func (fs *fileQueueStore) String() string {
+	return fmt.Sprintf("filequeuestore(%s)", fs.path)
+}
+
+// driverQueueStore keeps each pending event in its own file in the storage
+// driver, named after its sequence number, in the directory of the registry
+// instance.
+type driverQueueStore struct {
+	ctx     context.Context
+	driver  storagedriver.StorageDriver
+	root    string
+	pending []QueuedEvent
+}
+
+// NewDriverQueueStore returns a store which keeps pending events in the
+// directory named instance below root in the storage driver, and recovers the
+// events pending there. Registry instances sharing the storage must use
+// different instance names, as each numbers its events on its own.
+func NewDriverQueueStore(ctx context.Context, driver storagedriver.StorageDriver, root, instance string) (QueueStore, error) {
+	if instance == "" || strings.Contains(instance, "/") {
+		return nil, fmt.Errorf("invalid queue store instance %q", instance)
+	}
+	root = path.Join(root, instance)
+	ds := &driverQueueStore{
+		ctx:    ctx,
+		driver: driver,
+		root:   root,
+	}
+
+	paths, err := driver.List(ctx, root)
+	if err != nil {
+		if errors.As(err, new(storagedriver.PathNotFoundError)) {
+			return ds, nil
+		}
+		return nil, err
+	}
+
+	seqs := make([]uint64, 0, len(paths))
+	contents := make(map[uint64][]byte, len(paths))
+	for _, p := range paths {
+		seq, err := strconv.ParseUint(path.Base(p), 10, 64)
+		if err != nil {
+			continue
+		}
+		content, err := driver.GetContent(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		seqs = append(seqs, seq)
+		contents[seq] = content
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	ds.pending = decodePending(seqs, func(seq uint64) []byte { return contents[seq] })
+
+	return ds, nil
+}
+
+func (ds *driverQueueStore) eventPath(seq uint64) string {
+	return path.Join(ds.root, fmt.Sprintf("%020d", seq))
+}
+
+func (ds *driverQueueStore) Pending() []QueuedEvent {
+	return ds.pending
+}
+
+func (ds *driverQueueStore) Append(qe QueuedEvent) error {
+	content, err := json.Marshal(qe.Event)
+	if err != nil {
+		return err
+	}
+
+	return ds.driver.PutContent(ds.ctx, ds.eventPath(qe.Seq), content)
+}
+
+func (ds *driverQueueStore) Remove(seq uint64) error {
+	err := ds.driver.Delete(ds.ctx, ds.eventPath(seq))
+	if errors.As(err, new(storagedriver.PathNotFoundError)) {
+		return nil
+	}
+	return err
+}
+
+func (ds *driverQueueStore) Close() error {
+	return nil
+}
+
+func (ds *driverQueueStore) String() string {
+	return fmt.Sprintf("driverqueuestore(%s)", ds.root)
+}
+
+// decodePending decodes the stored events, skipping the ones which cannot be
+// decoded.
+func decodePending(seqs []uint64, content func(seq uint64) []byte) []QueuedEvent {
+	pending := make([]QueuedEvent, 0, len(seqs))
+	for _, seq := range seqs {
+		var event Event
+		if err := json.Unmarshal(content(seq), &event); err != nil {
+			logrus.Warnf("queuestore: skipping invalid event %d: %v", seq, err)
+			continue
+		}
+		pending = append(pending, QueuedEvent{Seq: seq, Event: event})
+	}
+	return pending
+}
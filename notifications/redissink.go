@@ -0,0 +1,129 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/optionutil"
+	events "github.com/docker/go-events"
+	"github.com/redis/go-redis/v9"
+)
+
+func init() {
+	if err := RegisterSink("redis", newRedisSink); err != nil {
+		panic(err)
+	}
+}
+
+// defaultRedisStream is the stream events are added to by default.
+const defaultRedisStream = "registry:events"
+
+// redisSink adds events to a redis stream with XADD. Each entry has the
+// fields "action", "repository", "event", the event in the format of the
+// endpoint, "contenttype", its media type, and "signature" if the endpoint
+// has secrets.
+type redisSink struct {
+	mu       sync.Mutex
+	client   redis.UniversalClient
+	stream   string
+	maxLen   int64
+	timeout  time.Duration
+	closed   bool
+	encoding sinkEncoding
+}
+
+// newRedisSink creates a redis sink from the options "stream", "maxlen", to
+// cap the stream length approximately, and "timeout". The registry passes
+// its redis client in the "redis" option.
+func newRedisSink(options map[string]interface{}) (events.Sink, error) {
+	client, ok := options["redis"].(redis.UniversalClient)
+	if !ok || client == nil {
+		return nil, fmt.Errorf("redis sink requires redis configuration")
+	}
+
+	stream, err := stringOption(options, "stream", defaultRedisStream)
+	if err != nil {
+		return nil, err
+	}
+	maxLen, err := intOption(options, "maxlen", 0)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := optionutil.Duration(options, "timeout", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding, err := newSinkEncoding(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisSink{
+		client:   client,
+		stream:   stream,
+		maxLen:   maxLen,
+		timeout:  timeout,
+		encoding: encoding,
+	}, nil
+}
+
+// Write adds the event to the stream.
+func (rs *redisSink) Write(event events.Event) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.closed {
+		return ErrSinkClosed
+	}
+
+	mediaType, p, signature, err := rs.encoding.encode(event, marshalEvent)
+	if err != nil {
+		return fmt.Errorf("%v: error marshaling event: %v", rs, err)
+	}
+
+	values := map[string]interface{}{"event": p, "contenttype": mediaType}
+	if signature != "" {
+		values["signature"] = signature
+	}
+	if e, ok := event.(Event); ok {
+		values["action"] = e.Action
+		values["repository"] = e.Target.Repository
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rs.timeout)
+	defer cancel()
+
+	args := &redis.XAddArgs{
+		Stream: rs.stream,
+		Values: values,
+	}
+	if rs.maxLen > 0 {
+		args.MaxLen = rs.maxLen
+		args.Approx = true
+	}
+	if err := rs.client.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("%v: error adding event: %v", rs, err)
+	}
+
+	return nil
+}
+
+// Close prevents further writes. The redis client is shared and stays open.
+func (rs *redisSink) Close() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.closed {
+		return fmt.Errorf("redissink: already closed")
+	}
+
+	rs.closed = true
+	return nil
+}
+
+func (rs *redisSink) String() string {
+	return fmt.Sprintf("redisSink{%s}", rs.stream)
+}
@@ -0,0 +1,116 @@
+package notifications
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header carrying the signatures of a notification
+// request body. Its value has the form
+//
+//	t=<unix timestamp>,v1=<signature>[,v1=<signature>...]
+//
+// where each signature is the hex encoded HMAC-SHA256 of
+// "<unix timestamp>.<body>" with one of the secrets of the endpoint. Several
+// signatures are sent while secrets are rotated.
+const SignatureHeader = "Registry-Signature"
+
+// signatureScheme is the key of signatures in the signature header.
+const signatureScheme = "v1"
+
+var (
+	// ErrSignatureMissing is returned when a request carries no signature.
+	ErrSignatureMissing = errors.New("notifications: missing signature")
+
+	// ErrSignatureInvalid is returned when no signature matches any of the
+	// secrets.
+	ErrSignatureInvalid = errors.New("notifications: invalid signature")
+
+	// ErrSignatureExpired is returned when the signature timestamp is outside
+	// of the tolerance.
+	ErrSignatureExpired = errors.New("notifications: signature expired")
+)
+
+// Sign returns the value of the signature header for body, signed at
+// timestamp with each of the secrets.
+func Sign(body []byte, timestamp time.Time, secrets ...string) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	parts := make([]string, 0, len(secrets)+1)
+	parts = append(parts, "t="+ts)
+	for _, secret := range secrets {
+		parts = append(parts, signatureScheme+"="+hex.EncodeToString(computeSignature(ts, body, secret)))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// VerifySignature checks the signature header of a notification request
+// against body. It succeeds if one of the signatures was made with one of the
+// secrets and, if tolerance is positive, the signature timestamp is no more
+// than tolerance away from now. A receiver typically calls it with the value
+// of SignatureHeader and the raw request body:
+//
+//	body, err := io.ReadAll(r.Body)
+//	...
+//	err = notifications.VerifySignature(r.Header.Get(notifications.SignatureHeader), body, 5*time.Minute, secret)
+func VerifySignature(header string, body []byte, tolerance time.Duration, secrets ...string) error {
+	if header == "" {
+		return ErrSignatureMissing
+	}
+
+	var (
+		ts         string
+		signatures [][]byte
+	)
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			ts = value
+		case signatureScheme:
+			if signature, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, signature)
+			}
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrSignatureInvalid
+	}
+
+	if tolerance > 0 {
+		age := time.Since(time.Unix(unix, 0))
+		if age > tolerance || age < -tolerance {
+			return ErrSignatureExpired
+		}
+	}
+
+	for _, secret := range secrets {
+		expected := computeSignature(ts, body, secret)
+		for _, signature := range signatures {
+			if hmac.Equal(expected, signature) {
+				return nil
+			}
+		}
+	}
+
+	return ErrSignatureInvalid
+}
+
+func computeSignature(ts string, body []byte, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,60 @@
+package notifications
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"events":[]}`)
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name      string
+		header    string
+		body      []byte
+		tolerance time.Duration
+		secrets   []string
+		err       error
+	}{
+		{name: "valid", header: Sign(body, now, "new"), body: body, secrets: []string{"new"}},
+		{name: "rotated receiver", header: Sign(body, now, "new"), body: body, secrets: []string{"old", "new"}},
+		{name: "rotated sender", header: Sign(body, now, "new", "old"), body: body, secrets: []string{"old"}},
+		{name: "wrong secret", header: Sign(body, now, "new"), body: body, secrets: []string{"other"}, err: ErrSignatureInvalid},
+		{name: "tampered body", header: Sign(body, now, "new"), body: []byte(`{"events":[{}]}`), secrets: []string{"new"}, err: ErrSignatureInvalid},
+		{name: "missing", header: "", body: body, secrets: []string{"new"}, err: ErrSignatureMissing},
+		{name: "malformed", header: "v1=zz", body: body, secrets: []string{"new"}, err: ErrSignatureInvalid},
+		{name: "expired", header: Sign(body, now.Add(-time.Hour), "new"), body: body, tolerance: 5 * time.Minute, secrets: []string{"new"}, err: ErrSignatureExpired},
+		{name: "within tolerance", header: Sign(body, now.Add(-time.Minute), "new"), body: body, tolerance: 5 * time.Minute, secrets: []string{"new"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := VerifySignature(tc.header, tc.body, tc.tolerance, tc.secrets...); err != tc.err {
+				t.Fatalf("unexpected error: %v != %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestHTTPSinkSignature(t *testing.T) {
+	verified := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("error reading body: %v", err)
+		}
+		verified <- VerifySignature(r.Header.Get(SignatureHeader), body, time.Minute, "secret")
+	}))
+	defer server.Close()
+
+	sink := newHTTPSink(server.URL, 0, nil, []string{"secret"}, nil, nil)
+	if err := sink.Write(createTestEvent("push", "library/test", "manifest")); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+
+	if err := <-verified; err != nil {
+		t.Fatalf("unexpected error verifying signature: %v", err)
+	}
+}
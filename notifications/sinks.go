@@ -159,6 +159,65 @@ func (imts *ignoredSink) Write(event events.Event) error {
 	return imts.Sink.Write(event)
 }
 
+// Close closes the underlying sink, flushing the events passed along.
 func (imts *ignoredSink) Close() error {
-	return nil
+	return imts.Sink.Close()
+}
+
+// pausableSink holds back writes while paused. Events stay in the queue
+// in front of it until delivery is resumed.
+type pausableSink struct {
+	events.Sink
+	mu      sync.Mutex
+	cond    *sync.Cond
+	paused  bool
+	aborted bool
+}
+
+func newPausableSink(sink events.Sink) *pausableSink {
+	ps := &pausableSink{Sink: sink}
+	ps.cond = sync.NewCond(&ps.mu)
+	return ps
+}
+
+// Write blocks while the sink is paused, then writes the event.
+func (ps *pausableSink) Write(event events.Event) error {
+	ps.mu.Lock()
+	for ps.paused && !ps.aborted {
+		ps.cond.Wait()
+	}
+	if ps.paused {
+		ps.mu.Unlock()
+		return ErrSinkClosed
+	}
+	ps.mu.Unlock()
+
+	return ps.Sink.Write(event)
+}
+
+// setPaused pauses or resumes writes.
+func (ps *pausableSink) setPaused(paused bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.paused = paused
+	ps.cond.Broadcast()
+}
+
+func (ps *pausableSink) isPaused() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.paused
+}
+
+// abort fails the writes held back by a pause, so that the queue in front
+// can be closed.
+func (ps *pausableSink) abort() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.aborted = true
+	ps.cond.Broadcast()
+}
+
+func (ps *pausableSink) String() string {
+	return fmt.Sprint(ps.Sink)
 }
@@ -1,8 +1,13 @@
 package notifications
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -121,6 +126,105 @@ func TestIgnoredSink(t *testing.T) {
 	}
 }
 
+func TestEndpointPauseAndDeadLetters(t *testing.T) {
+	var failing atomic.Bool
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var envelope struct {
+			Events []Event `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, event := range envelope.Events {
+			received <- event.Target.Repository
+		}
+	}))
+	defer server.Close()
+
+	store, err := NewFileQueueStore(filepath.Join(t.TempDir(), "deadletters.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := NewEndpoint("deadletters", server.URL, EndpointConfig{
+		Threshold:       100,
+		Backoff:         time.Millisecond,
+		DeadLetterStore: store,
+	})
+
+	expectReceived := func(repo string) {
+		t.Helper()
+		select {
+		case r := <-received:
+			if r != repo {
+				t.Fatalf("unexpected event received: %q != %q", r, repo)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", repo)
+		}
+	}
+
+	// the default retry budget applies with a dead-letter store
+	failing.Store(true)
+	if err := endpoint.Write(createTestEvent("push", "outage", "blob")); err != nil {
+		t.Fatal(err)
+	}
+	waitPending(t, endpoint.metrics, 0)
+	if n := endpoint.DeadLetterCount(); n != 1 {
+		t.Fatalf("unexpected dead letter count: %d != 1", n)
+	}
+	failing.Store(false)
+
+	endpoint.Pause()
+	if err := endpoint.Write(createTestEvent("push", "held", "blob")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-received:
+		t.Fatalf("received %q while paused", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+	endpoint.Resume()
+	expectReceived("held")
+
+	replayed, err := endpoint.ReplayDeadLetters(0, 0)
+	if err != nil || replayed != 1 {
+		t.Fatalf("unexpected replay result: %d, %v", replayed, err)
+	}
+	expectReceived("outage")
+	if n := endpoint.DeadLetterCount(); n != 0 {
+		t.Fatalf("unexpected dead letter count after replay: %d", n)
+	}
+
+	waitPending(t, endpoint.metrics, 0)
+	var metrics EndpointMetrics
+	endpoint.ReadMetrics(&metrics)
+	if metrics.DeadLetters != 1 || metrics.Successes != 2 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+
+	// closing does not wait for a paused endpoint
+	endpoint.Pause()
+	if err := endpoint.Write(createTestEvent("push", "dropped", "blob")); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan error)
+	go func() { closed <- endpoint.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("unexpected error closing endpoint: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out closing paused endpoint")
+	}
+}
+
 type testSink struct {
 	event  events.Event
 	count  int
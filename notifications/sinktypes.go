@@ -0,0 +1,172 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+// SinkInitFunc creates a sink delivering events to the destination described
+// by options. The sink only makes a single attempt per write: retries,
+// circuit breaking and queueing are added by the endpoint.
+type SinkInitFunc func(options map[string]interface{}) (events.Sink, error)
+
+var sinkTypes = struct {
+	sync.Mutex
+	initFuncs map[string]SinkInitFunc
+}{initFuncs: make(map[string]SinkInitFunc)}
+
+// RegisterSink makes a sink type available to notification endpoints by the
+// provided name.
+func RegisterSink(name string, initFunc SinkInitFunc) error {
+	sinkTypes.Lock()
+	defer sinkTypes.Unlock()
+
+	if _, exists := sinkTypes.initFuncs[name]; exists {
+		return fmt.Errorf("sink type already registered: %s", name)
+	}
+
+	sinkTypes.initFuncs[name] = initFunc
+	return nil
+}
+
+// newSink constructs a sink of the named type with the given options.
+func newSink(name string, options map[string]interface{}) (events.Sink, error) {
+	sinkTypes.Lock()
+	initFunc, exists := sinkTypes.initFuncs[name]
+	sinkTypes.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("no sink type registered with name: %s", name)
+	}
+
+	return initFunc(options)
+}
+
+// stringOption returns an optional string option.
+func stringOption(options map[string]interface{}, key, def string) (string, error) {
+	v, ok := options[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string, got %T", key, v)
+	}
+	return s, nil
+}
+
+// intOption returns an optional integer option.
+func intOption(options map[string]interface{}, key string, def int64) (int64, error) {
+	v, ok := options[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint64:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		return i, nil
+	}
+
+	return 0, fmt.Errorf("%s must be an integer, got %T", key, v)
+}
+
+// Options which NewSinkEndpoint passes on to sinks from the configuration of
+// their endpoint.
+const (
+	sinkFormatOption          = "format"
+	sinkCloudEventsModeOption = "cloudeventsmode"
+	sinkSecretsOption         = "secrets"
+)
+
+// sinkEncoding encodes the events written to a sink in the format of its
+// endpoint, and signs them with the secrets of the endpoint.
+type sinkEncoding struct {
+	// encoder is nil when the endpoint uses the default format, which
+	// each sink encodes in its own way.
+	encoder eventEncoder
+	secrets []string
+}
+
+// newSinkEncoding reads the encoding of a sink from the options passed on by
+// NewSinkEndpoint.
+func newSinkEncoding(options map[string]interface{}) (sinkEncoding, error) {
+	var se sinkEncoding
+
+	format, err := stringOption(options, sinkFormatOption, "")
+	if err != nil {
+		return se, err
+	}
+	mode, err := stringOption(options, sinkCloudEventsModeOption, "")
+	if err != nil {
+		return se, err
+	}
+	if format != "" && format != "envelope" {
+		if se.encoder, err = newEventEncoder(format, mode); err != nil {
+			return se, err
+		}
+	}
+
+	if v, ok := options[sinkSecretsOption]; ok && v != nil {
+		secrets, ok := v.([]string)
+		if !ok {
+			return se, fmt.Errorf("%s must be a list of strings, got %T", sinkSecretsOption, v)
+		}
+		se.secrets = secrets
+	}
+
+	return se, nil
+}
+
+// encode returns the media type and body of event, and its signature if the
+// sink has secrets. def encodes the event in the default format.
+func (se sinkEncoding) encode(event events.Event, def func(events.Event) (string, []byte, error)) (string, []byte, string, error) {
+	var (
+		mediaType string
+		p         []byte
+		err       error
+	)
+	if se.encoder != nil {
+		mediaType, p, err = se.encoder.encode([]events.Event{event})
+	} else {
+		mediaType, p, err = def(event)
+	}
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	var signature string
+	if len(se.secrets) > 0 {
+		signature = Sign(p, time.Now(), se.secrets...)
+	}
+	return mediaType, p, signature, nil
+}
+
+// marshalEvent encodes a single event in JSON.
+func marshalEvent(event events.Event) (string, []byte, error) {
+	p, err := json.Marshal(event)
+	return "application/json", p, err
+}
+
+// marshalEnvelope encodes a single event in an Envelope.
+func marshalEnvelope(event events.Event) (string, []byte, error) {
+	p, err := json.Marshal(Envelope{Events: []events.Event{event}})
+	return EventsMediaType, p, err
+}
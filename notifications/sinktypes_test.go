@@ -0,0 +1,235 @@
+package notifications
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+func TestRegisterSink(t *testing.T) {
+	for _, name := range []string{"file", "exec", "redis"} {
+		if err := RegisterSink(name, newFileSink); err == nil {
+			t.Errorf("expected error registering sink type %q twice", name)
+		}
+	}
+
+	if _, err := newSink("unknown", nil); err == nil {
+		t.Fatal("expected error creating sink of unknown type")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events", "events.jsonl")
+	event := createTestEvent("push", "library/test", "manifest")
+	p, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineSize := len(p) + 1
+
+	// room for two events per file
+	sink, err := newSink("file", map[string]interface{}{
+		"path":       path,
+		"maxsize":    2 * lineSize,
+		"maxbackups": 2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating file sink: %v", err)
+	}
+
+	for i := 0; i < 7; i++ {
+		if err := sink.Write(event); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+	}
+	checkClose(t, sink)
+
+	for file, lines := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2} {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatalf("unexpected error opening %s: %v", file, err)
+		}
+		count := 0
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e Event
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatalf("invalid event in %s: %v", file, err)
+			}
+			count++
+		}
+		f.Close()
+		if count != lines {
+			t.Errorf("unexpected number of events in %s: %d != %d", file, count, lines)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected old backups to be removed: %v", err)
+	}
+
+	if _, err := newSink("file", nil); err == nil {
+		t.Fatal("expected error creating file sink without path")
+	}
+}
+
+func TestExecSink(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("test requires /bin/sh")
+	}
+
+	output := filepath.Join(t.TempDir(), "envelope.json")
+	sink, err := newSink("exec", map[string]interface{}{
+		"command": "/bin/sh",
+		"args":    []interface{}{"-c", "cat > " + output},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating exec sink: %v", err)
+	}
+
+	if err := sink.Write(createTestEvent("push", "library/test", "manifest")); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	checkClose(t, sink)
+
+	p, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope struct {
+		Events []Event `json:"events"`
+	}
+	if err := json.Unmarshal(p, &envelope); err != nil {
+		t.Fatalf("invalid envelope: %v", err)
+	}
+	if len(envelope.Events) != 1 || envelope.Events[0].Target.Repository != "library/test" {
+		t.Fatalf("unexpected envelope: %s", p)
+	}
+
+	failing, err := newSink("exec", map[string]interface{}{
+		"command": "/bin/sh",
+		"args":    []interface{}{"-c", "echo rejected >&2; exit 3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := failing.Write(createTestEvent("push", "library/test", "manifest")); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Fatalf("expected error with command output, got %v", err)
+	}
+}
+
+func TestExecSinkEncoding(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("test requires /bin/sh")
+	}
+
+	dir := t.TempDir()
+	sink, err := newSink("exec", map[string]interface{}{
+		"command":         "/bin/sh",
+		"args":            []interface{}{"-c", "cd " + dir + ` && cat > body && printf %s "$REGISTRY_CONTENT_TYPE" > type && printf %s "$REGISTRY_SIGNATURE" > signature`},
+		"format":          "cloudevents",
+		"cloudeventsmode": "structured",
+		"secrets":         []string{"secret"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating exec sink: %v", err)
+	}
+
+	if err := sink.Write(createTestEvent("push", "library/test", "manifest")); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	checkClose(t, sink)
+
+	read := func(name string) []byte {
+		p, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	body := read("body")
+	var ce CloudEvent
+	if err := json.Unmarshal(body, &ce); err != nil {
+		t.Fatalf("invalid cloudevent: %v", err)
+	}
+	if ce.Type != CloudEventsTypePrefix+"push" {
+		t.Fatalf("unexpected cloudevent type %q", ce.Type)
+	}
+	if mediaType := string(read("type")); mediaType != CloudEventsMediaType {
+		t.Fatalf("unexpected media type %q", mediaType)
+	}
+	if err := VerifySignature(string(read("signature")), body, time.Minute, "secret"); err != nil {
+		t.Fatalf("unexpected signature error: %v", err)
+	}
+}
+
+func TestRedisSinkRequiresClient(t *testing.T) {
+	if _, err := newSink("redis", map[string]interface{}{"stream": "events"}); err == nil {
+		t.Fatal("expected error creating redis sink without redis")
+	}
+}
+
+func TestSinkEndpointEncoding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	endpoint, err := NewSinkEndpoint("file-endpoint", "file", map[string]interface{}{"path": path}, EndpointConfig{Format: "cloudevents"})
+	if err != nil {
+		t.Fatalf("unexpected error creating endpoint: %v", err)
+	}
+	if err := endpoint.Write(createTestEvent("push", "library/test", "manifest")); err != nil {
+		t.Fatal(err)
+	}
+	if err := endpoint.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ce CloudEvent
+	if err := json.Unmarshal(p, &ce); err != nil {
+		t.Fatalf("invalid cloudevent: %v", err)
+	}
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		t.Fatalf("unexpected cloudevent: %s", p)
+	}
+
+	// file sinks cannot carry signatures
+	if _, err := NewSinkEndpoint("signed-file-endpoint", "file", map[string]interface{}{"path": path}, EndpointConfig{Secrets: []string{"secret"}}); err == nil {
+		t.Fatal("expected error creating a file endpoint with a secret")
+	}
+	if _, err := NewSinkEndpoint("header-file-endpoint", "file", map[string]interface{}{"path": path}, EndpointConfig{Headers: http.Header{"X-Test": {"1"}}}); err == nil {
+		t.Fatal("expected error creating a file endpoint with headers")
+	}
+}
+
+func TestSinkEndpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	endpoint, err := NewSinkEndpoint("file-endpoint", "file", map[string]interface{}{"path": path}, EndpointConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error creating endpoint: %v", err)
+	}
+	if endpoint.Type() != "file" {
+		t.Fatalf("unexpected endpoint type %q", endpoint.Type())
+	}
+
+	var sink events.Sink = endpoint
+	if err := sink.Write(createTestEvent("push", "library/test", "manifest")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var metrics EndpointMetrics
+	endpoint.ReadMetrics(&metrics)
+	if metrics.Successes != 1 || metrics.Pending != 0 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+}
@@ -1536,6 +1536,115 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+	{
+		Name:        RouteNameBlobUploadParts,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/uploads/{uuid:[a-zA-Z0-9-_.=]+}/parts",
+		Entity:      "Blob Upload Parts",
+		Description: "Obtain URLs to upload the parts of a blob upload directly to the storage backend. This endpoint is only available when the registry is configured with presigned uploads and a storage driver supporting them. Clients should never assemble URLs for this endpoint and should only take it through the `Docker-Upload-Parts-Location` header on related API requests.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodPost,
+				Description: "Presign the upload of parts of the upload identified by `uuid`. The parts are uploaded with a `PUT` of their content to their URL, and form the content of the blob in the order of their numbers. All the parts but the last must be at least 5MB in size. Once all the parts are uploaded, the upload is completed with a `PUT` to the `Location` returned, without a body.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+							contentLengthZeroHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							uuidParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "first",
+								Type:        "integer",
+								Format:      "<part number>",
+								Description: "Number of the first part to presign, from 1 to 10000. Defaults to 1.",
+							},
+							{
+								Name:        "count",
+								Type:        "integer",
+								Format:      "<count>",
+								Required:    true,
+								Description: "Number of consecutive parts to presign, at most 1000.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Name:        "Parts Presigned",
+								Description: "The parts can be uploaded to the URLs returned, until they expire. The updated upload location is available in the `Location` header.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Format:      "/v2/<name>/blobs/uploads/<uuid>",
+										Description: "The location of the upload. Clients should use the contents verbatim to complete the upload, adding parameters where required.",
+									},
+									dockerUploadUUIDHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"parts": [
+		{
+			"partNumber": <part number>,
+			"url": "<url>"
+		},
+		...
+	],
+	"expiresAt": "<time>"
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "There was an error processing the upload or the parts requested are invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeNameInvalid,
+									errcode.ErrorCodeBlobUploadInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The upload is unknown to the registry. The upload must be restarted.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeBlobUploadUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "Presigned uploads are not enabled, or not supported by the storage driver.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
 	{
 		Name:        RouteNameCatalog,
 		Path:        "/v2/_catalog",
@@ -15,6 +15,7 @@ const (
 	RouteNameBlob            = "blob"
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
+	RouteNameBlobUploadParts = "blob-upload-parts"
 	RouteNameCatalog         = "catalog"
 )
 
@@ -202,6 +202,21 @@ func (ub *URLBuilder) BuildBlobUploadChunkURL(name reference.Named, uuid string,
 	return appendValuesURL(uploadURL, values...).String(), nil
 }
 
+// BuildBlobUploadPartsURL constructs a url to presign the upload of parts of
+// the upload identified by uuid, including any url values. Like the upload
+// url, it is provided by server implementations during the blob upload
+// process.
+func (ub *URLBuilder) BuildBlobUploadPartsURL(name reference.Named, uuid string, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameBlobUploadParts)
+
+	partsURL, err := route.URL("name", name.Name(), "uuid", uuid)
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(partsURL, values...).String(), nil
+}
+
 // cloneRoute returns a clone of the named route from the router. Routes
 // must be cloned to avoid modifying them during url generation.
 func (ub *URLBuilder) cloneRoute(name string) clonedRoute {
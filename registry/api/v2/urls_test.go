@@ -118,6 +118,16 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				})
 			},
 		},
+		{
+			description:  "build blob upload parts url",
+			expectedPath: "/v2/foo/bar/blobs/uploads/uuid-part/parts?count=2",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildBlobUploadPartsURL(fooBarRef, "uuid-part", url.Values{
+					"count": []string{"2"},
+				})
+			},
+		},
 	}
 }
 
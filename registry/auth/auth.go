@@ -55,10 +55,32 @@ func init() {
 	accessControllers = make(map[string]InitFunc)
 }
 
+// AnonymousUserName is the user name of grants issued to requests which
+// carry no credentials.
+const AnonymousUserName = "anonymous"
+
+// User types describe what kind of client a UserInfo identifies.
+const (
+	UserTypeUser      = "user"
+	UserTypeRobot     = "robot"
+	UserTypeAnonymous = "anonymous"
+)
+
 // UserInfo carries information about
 // an authenticated/authorized client.
 type UserInfo struct {
 	Name string
+
+	// Type is one of UserTypeUser, UserTypeRobot or UserTypeAnonymous. It
+	// may be empty if the access controller cannot tell.
+	Type string
+
+	// Groups lists the groups the user is a member of, if known.
+	Groups []string
+
+	// Claims carries selected attributes of the identity, such as token
+	// claims or the subject of a client certificate, keyed by name.
+	Claims map[string]string
 }
 
 // Resource describes a resource by type and name.
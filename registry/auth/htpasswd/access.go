@@ -35,6 +35,12 @@ type accessController struct {
 	modtime  time.Time
 	mu       sync.Mutex
 	htpasswd *htpasswd
+
+	// groupsPath is the path of an optional group file, listing the
+	// groups of the users.
+	groupsPath    string
+	groupsModtime time.Time
+	groups        map[string][]string
 }
 
 var _ auth.AccessController = &accessController{}
@@ -53,7 +59,15 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 	if err := createHtpasswdFile(path); err != nil {
 		return nil, err
 	}
-	return &accessController{realm: realm.(string), path: path}, nil
+
+	var groupsPath string
+	if groupsOpt, present := options["groups"]; present {
+		if groupsPath, ok = groupsOpt.(string); !ok || groupsPath == "" {
+			return nil, fmt.Errorf(`"groups" must be a path for htpasswd access controller`)
+		}
+	}
+
+	return &accessController{realm: realm.(string), path: path, groupsPath: groupsPath}, nil
 }
 
 func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) (*auth.Grant, error) {
@@ -93,6 +107,11 @@ func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.
 	localHTPasswd := ac.htpasswd
 	ac.mu.Unlock()
 
+	groups, err := ac.userGroups(username)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := localHTPasswd.authenticateUser(username, password); err != nil {
 		dcontext.GetLogger(req.Context()).Errorf("error authenticating user %q: %v", username, err)
 		return nil, &challenge{
@@ -101,7 +120,38 @@ func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.
 		}
 	}
 
-	return &auth.Grant{User: auth.UserInfo{Name: username}}, nil
+	return &auth.Grant{User: auth.UserInfo{Name: username, Type: auth.UserTypeUser, Groups: groups}}, nil
+}
+
+// userGroups returns the groups of username listed in the group file, if
+// one is configured, parsing the file again whenever it changed.
+func (ac *accessController) userGroups(username string) ([]string, error) {
+	if ac.groupsPath == "" {
+		return nil, nil
+	}
+
+	fstat, err := os.Stat(ac.groupsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.groups == nil || !ac.groupsModtime.Equal(fstat.ModTime()) {
+		f, err := os.Open(ac.groupsPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		groups, err := parseHTGroup(f)
+		if err != nil {
+			return nil, err
+		}
+		ac.groups, ac.groupsModtime = groups, fstat.ModTime()
+	}
+
+	return ac.groups[username], nil
 }
 
 // challenge implements the auth.Challenge interface.
@@ -6,7 +6,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/auth"
 )
@@ -154,3 +157,50 @@ func TestCreateHtpasswdFile(t *testing.T) {
 		t.Fatalf("failed to find default user in file %s", string(content))
 	}
 }
+
+func TestAccessControllerGroups(t *testing.T) {
+	dir := t.TempDir()
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	groupsPath := filepath.Join(dir, "htgroup")
+	if err := os.WriteFile(htpasswdPath, []byte("frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(groupsPath, []byte("hobbits: frodo bilbo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	accessController, err := newAccessController(map[string]interface{}{
+		"realm":  "The-Shire",
+		"path":   htpasswdPath,
+		"groups": groupsPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("frodo", "baggins")
+	grant, err := accessController.Authorized(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(grant.User.Groups, []string{"hobbits"}) {
+		t.Fatalf("unexpected groups: %v", grant.User.Groups)
+	}
+
+	// changes to the group file are picked up.
+	if err := os.WriteFile(groupsPath, []byte("hobbits: frodo\nringbearers: frodo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	modtime := time.Now().Add(time.Second)
+	if err := os.Chtimes(groupsPath, modtime, modtime); err != nil {
+		t.Fatal(err)
+	}
+	grant, err = accessController.Authorized(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(grant.User.Groups, []string{"hobbits", "ringbearers"}) {
+		t.Fatalf("unexpected groups: %v", grant.User.Groups)
+	}
+}
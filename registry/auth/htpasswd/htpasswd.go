@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/distribution/distribution/v3/registry/auth"
@@ -82,3 +83,40 @@ func parseHTPasswd(rd io.Reader) (map[string][]byte, error) {
 
 	return entries, nil
 }
+
+// parseHTGroup parses the contents of a group file in the format of the
+// Apache AuthGroupFile directive, where each line lists the members of a
+// group as "group: user1 user2". It returns the sorted groups of each user.
+func parseHTGroup(rd io.Reader) (map[string][]string, error) {
+	groups := map[string][]string{}
+	scanner := bufio.NewScanner(rd)
+	var line int
+	for scanner.Scan() {
+		line++ // 1-based line numbering
+		t := strings.TrimSpace(scanner.Text())
+
+		if len(t) < 1 || t[0] == '#' {
+			continue
+		}
+
+		group, members, ok := strings.Cut(t, ":")
+		group = strings.TrimSpace(group)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("htgroup: invalid entry at line %d: %q", line, scanner.Text())
+		}
+
+		for _, user := range strings.Fields(members) {
+			groups[user] = append(groups[user], group)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		sort.Strings(g)
+	}
+
+	return groups, nil
+}
@@ -81,3 +81,26 @@ asdf
 		}
 	}
 }
+
+func TestParseHTGroup(t *testing.T) {
+	groups, err := parseHTGroup(strings.NewReader(`
+# groups of the fellowship
+hobbits: frodo bilbo
+ringbearers:frodo
+elves:
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"frodo": {"hobbits", "ringbearers"},
+		"bilbo": {"hobbits"},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("unexpected groups: %v != %v", groups, expected)
+	}
+
+	if _, err := parseHTGroup(strings.NewReader("hobbits frodo\n")); err == nil {
+		t.Fatal("expected error for entry without a colon")
+	}
+}
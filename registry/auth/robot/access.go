@@ -0,0 +1,101 @@
+package robot
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// accessController authenticates robots with HTTP basic auth and passes any
+// other request on to the next access controller.
+type accessController struct {
+	realm string
+	store Store
+	next  auth.AccessController
+}
+
+var _ auth.AccessController = &accessController{}
+
+// NewAccessController returns an access controller which accepts robot
+// credentials from store. Requests which do not carry robot credentials are
+// authorized by next; if next is nil, they are challenged.
+func NewAccessController(realm string, store Store, next auth.AccessController) auth.AccessController {
+	return &accessController{
+		realm: realm,
+		store: store,
+		next:  next,
+	}
+}
+
+// Authorized checks the robot secret, its expiry and whether its scopes
+// cover every requested access.
+func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) (*auth.Grant, error) {
+	username, secret, ok := req.BasicAuth()
+	if !ok || !strings.HasPrefix(username, UserPrefix) {
+		if ac.next != nil {
+			return ac.next.Authorized(req, accessRecords...)
+		}
+		return nil, &challenge{realm: ac.realm, err: auth.ErrInvalidCredential}
+	}
+
+	ctx := req.Context()
+	robot, err := ac.store.Get(ctx, strings.TrimPrefix(username, UserPrefix))
+	if err != nil {
+		if err != ErrRobotUnknown {
+			return nil, err
+		}
+		dcontext.GetLogger(ctx).Warnf("unknown robot %q", username)
+		return nil, &challenge{realm: ac.realm, err: auth.ErrAuthenticationFailure}
+	}
+
+	if !robot.verifySecret(secret) {
+		dcontext.GetLogger(ctx).Warnf("invalid secret for robot %q", username)
+		return nil, &challenge{realm: ac.realm, err: auth.ErrAuthenticationFailure}
+	}
+
+	if robot.Expired() {
+		dcontext.GetLogger(ctx).Warnf("robot %q expired at %s", username, robot.ExpiresAt)
+		return nil, &challenge{realm: ac.realm, err: ErrRobotExpired}
+	}
+
+	resources := make([]auth.Resource, 0, len(accessRecords))
+	for _, access := range accessRecords {
+		if !robot.allows(access) {
+			return nil, &challenge{realm: ac.realm, err: fmt.Errorf("robot %q is not allowed to %s %s", robot.Name, access.Action, access.Name)}
+		}
+		resources = append(resources, access.Resource)
+	}
+
+	return &auth.Grant{
+		User: auth.UserInfo{
+			Name:   robot.UserName(),
+			Type:   auth.UserTypeRobot,
+			Groups: robot.Groups,
+			Claims: map[string]string{
+				"robot.expires": robot.ExpiresAt.UTC().Format(time.RFC3339),
+			},
+		},
+		Resources: resources,
+	}, nil
+}
+
+// challenge implements the auth.Challenge interface.
+type challenge struct {
+	realm string
+	err   error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders sets the basic challenge header on the response.
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", ch.realm))
+}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("robot authentication challenge for realm %q: %s", ch.realm, ch.err)
+}
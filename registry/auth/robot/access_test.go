@@ -0,0 +1,95 @@
+package robot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+type nextController struct{}
+
+func (nextController) Authorized(req *http.Request, access ...auth.Access) (*auth.Grant, error) {
+	return &auth.Grant{User: auth.UserInfo{Name: "next"}}, nil
+}
+
+func TestAccessController(t *testing.T) {
+	ctx := context.Background()
+	store := NewDriverStore(inmemory.New())
+
+	rb, secret, err := New("builder", "", []Scope{{Repository: "ci/*", Actions: []string{"pull"}}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.Groups = []string{"ci"}
+	if err := store.Create(ctx, rb); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, expiredSecret, err := New("old", "", []Scope{{Repository: "*", Actions: []string{"*"}}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := store.Create(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	pull := auth.Access{Resource: auth.Resource{Type: "repository", Name: "ci/app"}, Action: "pull"}
+	push := auth.Access{Resource: auth.Resource{Type: "repository", Name: "ci/app"}, Action: "push"}
+
+	for _, tc := range []struct {
+		name     string
+		username string
+		password string
+		next     auth.AccessController
+		access   auth.Access
+		user     string
+	}{
+		{name: "valid", username: "robot$builder", password: secret, access: pull, user: "robot$builder"},
+		{name: "wrong secret", username: "robot$builder", password: "wrong", access: pull},
+		{name: "unknown robot", username: "robot$nobody", password: secret, access: pull},
+		{name: "out of scope", username: "robot$builder", password: secret, access: push},
+		{name: "expired", username: "robot$old", password: expiredSecret, access: pull},
+		{name: "no robot, no next", username: "bilbo", password: "baggins", access: pull},
+		{name: "no robot, next", username: "bilbo", password: "baggins", next: nextController{}, access: pull, user: "next"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ac := NewAccessController("registry", store, tc.next)
+
+			req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+			req.SetBasicAuth(tc.username, tc.password)
+
+			grant, err := ac.Authorized(req, tc.access)
+			if tc.user == "" {
+				ch, ok := err.(auth.Challenge)
+				if !ok {
+					t.Fatalf("expected challenge, got %v", err)
+				}
+				w := httptest.NewRecorder()
+				ch.SetHeaders(req, w)
+				if got := w.Header().Get("WWW-Authenticate"); got != `Basic realm="registry"` {
+					t.Fatalf("unexpected challenge header %q", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if grant.User.Name != tc.user {
+				t.Fatalf("unexpected user %q, want %q", grant.User.Name, tc.user)
+			}
+			if tc.user == "robot$builder" && grant.User.Type != auth.UserTypeRobot {
+				t.Fatalf("unexpected user type %q", grant.User.Type)
+			}
+			if tc.user == "robot$builder" && (len(grant.User.Groups) != 1 || grant.User.Groups[0] != "ci") {
+				t.Fatalf("unexpected user groups %v", grant.User.Groups)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// Package robot implements robot accounts: non-human credentials made of a
+// long random secret, scoped to repository patterns and actions, which expire.
+//
+// Robots authenticate with HTTP basic auth, using the robot name prefixed
+// with UserPrefix as user name and the secret as password. Only a hash of
+// the secret is stored, either through the storage driver or in redis.
+package robot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// UserPrefix distinguishes robot user names from other users in HTTP basic
+// auth credentials.
+const UserPrefix = "robot$"
+
+// secretSize is the number of random bytes in a robot secret.
+const secretSize = 32
+
+var nameRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// Errors returned by robot stores and the access controller.
+var (
+	ErrRobotUnknown = errors.New("unknown robot")
+	ErrRobotExists  = errors.New("robot already exists")
+	ErrRobotExpired = errors.New("robot credentials expired")
+)
+
+// Scope grants actions on the repositories matching a pattern.
+type Scope struct {
+	// Repository is a repository name pattern, in the syntax of path.Match.
+	Repository string `json:"repository"`
+
+	// Actions lists the granted actions, such as "pull", "push" and
+	// "delete". "*" grants every action.
+	Actions []string `json:"actions"`
+}
+
+// Robot describes a robot account.
+type Robot struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Scopes      []Scope `json:"scopes"`
+	// Groups lists the groups the robot is reported as a member of, for
+	// notifications and external authorization policies.
+	Groups    []string  `json:"groups,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// SecretHash is the hex encoded SHA-256 hash of the secret. The secret
+	// itself is only returned once, when the robot is created.
+	SecretHash string `json:"secretHash,omitempty"`
+}
+
+// New creates a robot which expires after ttl and returns it with its
+// secret.
+func New(name, description string, scopes []Scope, ttl time.Duration) (*Robot, string, error) {
+	if !nameRegexp.MatchString(name) {
+		return nil, "", fmt.Errorf("invalid robot name %q", name)
+	}
+	if ttl <= 0 {
+		return nil, "", fmt.Errorf("robot expiry must be positive")
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("robot requires at least one scope")
+	}
+	for _, scope := range scopes {
+		if _, err := path.Match(scope.Repository, ""); err != nil || scope.Repository == "" {
+			return nil, "", fmt.Errorf("invalid repository pattern %q", scope.Repository)
+		}
+		if len(scope.Actions) == 0 {
+			return nil, "", fmt.Errorf("scope for %q requires at least one action", scope.Repository)
+		}
+	}
+
+	var secretBytes [secretSize]byte
+	if _, err := rand.Read(secretBytes[:]); err != nil {
+		return nil, "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes[:])
+
+	now := time.Now().UTC()
+	return &Robot{
+		Name:        name,
+		Description: description,
+		Scopes:      scopes,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+		SecretHash:  hashSecret(secret),
+	}, secret, nil
+}
+
+// UserName returns the user name the robot authenticates with.
+func (r *Robot) UserName() string {
+	return UserPrefix + r.Name
+}
+
+// Expired returns true if the robot credentials expired.
+func (r *Robot) Expired() bool {
+	return time.Now().After(r.ExpiresAt)
+}
+
+// verifySecret returns true if secret matches the stored hash.
+func (r *Robot) verifySecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(r.SecretHash)) == 1
+}
+
+// allows returns true if one of the robot scopes grants access.
+func (r *Robot) allows(access auth.Access) bool {
+	if access.Type != "repository" {
+		return false
+	}
+
+	for _, scope := range r.Scopes {
+		if matched, _ := path.Match(scope.Repository, access.Name); !matched {
+			continue
+		}
+		for _, action := range scope.Actions {
+			if action == "*" || action == access.Action {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// hashSecret returns the hex encoded SHA-256 hash of secret. Secrets are long
+// and random, so a fast hash is sufficient.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store persists robot accounts.
+type Store interface {
+	// Get returns the named robot or ErrRobotUnknown.
+	Get(ctx context.Context, name string) (*Robot, error)
+
+	// Create stores a new robot, failing with ErrRobotExists if a robot
+	// with the same name exists.
+	Create(ctx context.Context, robot *Robot) error
+
+	// List returns all robots.
+	List(ctx context.Context) ([]*Robot, error)
+
+	// Delete removes the named robot, revoking its credentials.
+	Delete(ctx context.Context, name string) error
+}
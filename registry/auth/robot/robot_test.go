@@ -0,0 +1,108 @@
+package robot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestNewRobot(t *testing.T) {
+	scopes := []Scope{{Repository: "ci/*", Actions: []string{"pull", "push"}}}
+
+	rb, secret, err := New("builder", "ci builder", scopes, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error creating robot: %v", err)
+	}
+	if rb.UserName() != "robot$builder" {
+		t.Fatalf("unexpected user name %q", rb.UserName())
+	}
+	if secret == "" || rb.SecretHash == secret {
+		t.Fatal("secret must be returned and only stored hashed")
+	}
+	if !rb.verifySecret(secret) || rb.verifySecret(secret+"x") {
+		t.Fatal("secret verification failed")
+	}
+	if rb.Expired() {
+		t.Fatal("new robot must not be expired")
+	}
+
+	for _, tc := range []struct {
+		access  auth.Access
+		allowed bool
+	}{
+		{auth.Access{Resource: auth.Resource{Type: "repository", Name: "ci/app"}, Action: "pull"}, true},
+		{auth.Access{Resource: auth.Resource{Type: "repository", Name: "ci/app"}, Action: "push"}, true},
+		{auth.Access{Resource: auth.Resource{Type: "repository", Name: "ci/app"}, Action: "delete"}, false},
+		{auth.Access{Resource: auth.Resource{Type: "repository", Name: "prod/app"}, Action: "pull"}, false},
+		{auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}, false},
+	} {
+		if allowed := rb.allows(tc.access); allowed != tc.allowed {
+			t.Errorf("allows(%v) = %v, want %v", tc.access, allowed, tc.allowed)
+		}
+	}
+
+	for _, invalid := range []struct {
+		name   string
+		scopes []Scope
+		ttl    time.Duration
+	}{
+		{"Invalid Name", scopes, time.Hour},
+		{"builder", scopes, 0},
+		{"builder", nil, time.Hour},
+		{"builder", []Scope{{Repository: "[", Actions: []string{"pull"}}}, time.Hour},
+		{"builder", []Scope{{Repository: "ci/*"}}, time.Hour},
+	} {
+		if _, _, err := New(invalid.name, "", invalid.scopes, invalid.ttl); err == nil {
+			t.Errorf("expected error creating robot %q with scopes %v and ttl %s", invalid.name, invalid.scopes, invalid.ttl)
+		}
+	}
+}
+
+func TestDriverStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewDriverStore(inmemory.New())
+
+	robots, err := store.List(ctx)
+	if err != nil || len(robots) != 0 {
+		t.Fatalf("expected empty list, got %v, %v", robots, err)
+	}
+
+	for _, name := range []string{"b", "a"} {
+		rb, _, err := New(name, "", []Scope{{Repository: "*", Actions: []string{"pull"}}}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Create(ctx, rb); err != nil {
+			t.Fatalf("unexpected error creating robot: %v", err)
+		}
+		if err := store.Create(ctx, rb); err != ErrRobotExists {
+			t.Fatalf("expected ErrRobotExists, got %v", err)
+		}
+	}
+
+	rb, err := store.Get(ctx, "a")
+	if err != nil || rb.Name != "a" {
+		t.Fatalf("unexpected result getting robot: %v, %v", rb, err)
+	}
+
+	robots, err = store.List(ctx)
+	if err != nil || len(robots) != 2 || robots[0].Name != "a" || robots[1].Name != "b" {
+		t.Fatalf("unexpected list result: %v, %v", robots, err)
+	}
+
+	if err := store.Delete(ctx, "a"); err != nil {
+		t.Fatalf("unexpected error deleting robot: %v", err)
+	}
+	if err := store.Delete(ctx, "a"); err != ErrRobotUnknown {
+		t.Fatalf("expected ErrRobotUnknown, got %v", err)
+	}
+	if _, err := store.Get(ctx, "a"); err != ErrRobotUnknown {
+		t.Fatalf("expected ErrRobotUnknown, got %v", err)
+	}
+	if _, err := store.Get(ctx, "../a"); err != ErrRobotUnknown {
+		t.Fatalf("expected ErrRobotUnknown for invalid name, got %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package robot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/redis/go-redis/v9"
+)
+
+// NewStore returns the store of the given kind: "storage" (the default) keeps
+// robots through driver, "redis" in the redis client pool.
+func NewStore(kind string, driver storagedriver.StorageDriver, pool redis.UniversalClient) (Store, error) {
+	switch kind {
+	case "", "storage":
+		return NewDriverStore(driver), nil
+	case "redis":
+		if pool == nil {
+			return nil, fmt.Errorf("robot store %q requires redis configuration", kind)
+		}
+		return NewRedisStore(pool), nil
+	default:
+		return nil, fmt.Errorf("unknown robot store %q", kind)
+	}
+}
+
+// rootPath is where the driver store keeps robots, one JSON document per
+// robot. It lives outside of the registry content tree.
+const rootPath = "/docker/registry/robots"
+
+// driverStore stores robots through a storage driver.
+type driverStore struct {
+	driver storagedriver.StorageDriver
+}
+
+// NewDriverStore returns a Store which keeps robots in the storage driver.
+func NewDriverStore(driver storagedriver.StorageDriver) Store {
+	return &driverStore{driver: driver}
+}
+
+func (s *driverStore) robotPath(name string) string {
+	return path.Join(rootPath, name)
+}
+
+func (s *driverStore) Get(ctx context.Context, name string) (*Robot, error) {
+	if !nameRegexp.MatchString(name) {
+		return nil, ErrRobotUnknown
+	}
+
+	content, err := s.driver.GetContent(ctx, s.robotPath(name))
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, ErrRobotUnknown
+		}
+		return nil, err
+	}
+
+	var robot Robot
+	if err := json.Unmarshal(content, &robot); err != nil {
+		return nil, err
+	}
+
+	return &robot, nil
+}
+
+func (s *driverStore) Create(ctx context.Context, robot *Robot) error {
+	if _, err := s.Get(ctx, robot.Name); err == nil {
+		return ErrRobotExists
+	} else if err != ErrRobotUnknown {
+		return err
+	}
+
+	content, err := json.Marshal(robot)
+	if err != nil {
+		return err
+	}
+
+	return s.driver.PutContent(ctx, s.robotPath(robot.Name), content)
+}
+
+func (s *driverStore) List(ctx context.Context) ([]*Robot, error) {
+	paths, err := s.driver.List(ctx, rootPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	robots := make([]*Robot, 0, len(paths))
+	for _, p := range paths {
+		robot, err := s.Get(ctx, path.Base(p))
+		if err != nil {
+			if err == ErrRobotUnknown {
+				continue
+			}
+			return nil, err
+		}
+		robots = append(robots, robot)
+	}
+
+	return robots, nil
+}
+
+func (s *driverStore) Delete(ctx context.Context, name string) error {
+	if !nameRegexp.MatchString(name) {
+		return ErrRobotUnknown
+	}
+
+	err := s.driver.Delete(ctx, s.robotPath(name))
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return ErrRobotUnknown
+	}
+
+	return err
+}
+
+// redisKey is the redis hash holding robots, keyed by name.
+const redisKey = "registry:robots"
+
+// redisStore stores robots in a redis hash.
+type redisStore struct {
+	pool redis.UniversalClient
+}
+
+// NewRedisStore returns a Store which keeps robots in redis.
+func NewRedisStore(pool redis.UniversalClient) Store {
+	return &redisStore{pool: pool}
+}
+
+func (s *redisStore) Get(ctx context.Context, name string) (*Robot, error) {
+	content, err := s.pool.HGet(ctx, redisKey, name).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrRobotUnknown
+		}
+		return nil, err
+	}
+
+	var robot Robot
+	if err := json.Unmarshal(content, &robot); err != nil {
+		return nil, err
+	}
+
+	return &robot, nil
+}
+
+func (s *redisStore) Create(ctx context.Context, robot *Robot) error {
+	content, err := json.Marshal(robot)
+	if err != nil {
+		return err
+	}
+
+	created, err := s.pool.HSetNX(ctx, redisKey, robot.Name, content).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrRobotExists
+	}
+
+	return nil
+}
+
+func (s *redisStore) List(ctx context.Context) ([]*Robot, error) {
+	all, err := s.pool.HGetAll(ctx, redisKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	robots := make([]*Robot, 0, len(all))
+	for _, content := range all {
+		var robot Robot
+		if err := json.Unmarshal([]byte(content), &robot); err != nil {
+			return nil, err
+		}
+		robots = append(robots, &robot)
+	}
+	sort.Slice(robots, func(i, j int) bool { return robots[i].Name < robots[j].Name })
+
+	return robots, nil
+}
+
+func (s *redisStore) Delete(ctx context.Context, name string) error {
+	deleted, err := s.pool.HDel(ctx, redisKey, name).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrRobotUnknown
+	}
+
+	return nil
+}
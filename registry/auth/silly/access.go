@@ -67,7 +67,7 @@ func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.
 		return nil, &challenge
 	}
 
-	return &auth.Grant{User: auth.UserInfo{Name: "silly"}}, nil
+	return &auth.Grant{User: auth.UserInfo{Name: "silly", Type: auth.UserTypeUser}}, nil
 }
 
 type challenge struct {
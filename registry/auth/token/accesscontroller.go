@@ -13,6 +13,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/go-jose/go-jose/v4"
 	"github.com/sirupsen/logrus"
@@ -135,7 +136,7 @@ func (ac authChallenge) challengeParams(r *http.Request) string {
 		str = fmt.Sprintf("%s,scope=%q", str, scope)
 	}
 
-	if ac.err == ErrInvalidToken || ac.err == ErrMalformedToken {
+	if ac.err == ErrInvalidToken || ac.err == ErrMalformedToken || ac.err == ErrRevokedToken {
 		str = fmt.Sprintf("%s,error=%q", str, "invalid_token")
 	} else if ac.err == ErrInsufficientScope {
 		str = fmt.Sprintf("%s,error=%q", str, "insufficient_scope")
@@ -159,12 +160,20 @@ type accessController struct {
 	rootCerts         *x509.CertPool
 	trustedKeys       map[string]crypto.PublicKey
 	signingAlgorithms []jose.SignatureAlgorithm
+	revocation        RevocationStore
+	groupsClaim       string
+	claims            []string
 }
 
 const (
 	defaultAutoRedirectPath = "/auth/token"
+	defaultGroupsClaim      = "groups"
 )
 
+// defaultClaims lists the token claims carried with the identity of the
+// user when no claims option is configured.
+var defaultClaims = []string{"iss", "jti", "aud"}
+
 // tokenAccessOptions is a convenience type for handling
 // options to the constructor of an accessController.
 type tokenAccessOptions struct {
@@ -176,6 +185,9 @@ type tokenAccessOptions struct {
 	rootCertBundle    string
 	jwks              string
 	signingAlgorithms []string
+	revocation        map[string]interface{}
+	groupsClaim       string
+	claims            []string
 }
 
 // checkOptions gathers the necessary options
@@ -235,6 +247,52 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		opts.signingAlgorithms = signingAlgorithmsVals
 	}
 
+	if revocation, ok := options["revocation"]; ok {
+		switch revocation := revocation.(type) {
+		case map[string]interface{}:
+			opts.revocation = revocation
+		case map[interface{}]interface{}:
+			opts.revocation = make(map[string]interface{}, len(revocation))
+			for k, v := range revocation {
+				key, ok := k.(string)
+				if !ok {
+					return opts, errors.New("revocation options must be keyed by strings")
+				}
+				opts.revocation[key] = v
+			}
+		default:
+			return opts, errors.New("token auth requires a valid option map: revocation")
+		}
+	}
+
+	opts.groupsClaim = defaultGroupsClaim
+	if v, ok := options["groupsclaim"]; ok {
+		groupsClaim, ok := v.(string)
+		if !ok || groupsClaim == "" {
+			return opts, errors.New("token auth requires a valid option string: groupsclaim")
+		}
+		opts.groupsClaim = groupsClaim
+	}
+
+	opts.claims = defaultClaims
+	if v, ok := options["claims"]; ok {
+		switch v := v.(type) {
+		case []string:
+			opts.claims = v
+		case []interface{}:
+			opts.claims = make([]string, 0, len(v))
+			for _, claim := range v {
+				claim, ok := claim.(string)
+				if !ok {
+					return opts, errors.New("claims must be a list of claim names")
+				}
+				opts.claims = append(opts.claims, claim)
+			}
+		default:
+			return opts, errors.New("claims must be a list of claim names")
+		}
+	}
+
 	return opts, nil
 }
 
@@ -356,6 +414,14 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		signAlgos = defaultSigningAlgorithms
 	}
 
+	var revocation RevocationStore
+	if config.revocation != nil {
+		revocation, err = newRevocationStore(config.revocation, options["redis"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &accessController{
 		realm:             config.realm,
 		autoRedirect:      config.autoRedirect,
@@ -365,6 +431,9 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		rootCerts:         rootPool,
 		trustedKeys:       trustedKeys,
 		signingAlgorithms: signAlgos,
+		revocation:        revocation,
+		groupsClaim:       config.groupsClaim,
+		claims:            config.claims,
 	}, nil
 }
 
@@ -404,6 +473,18 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 		return nil, challenge
 	}
 
+	if ac.revocation != nil {
+		revoked, err := ac.revocation.Revoked(req.Context(), claims.JWTID, claims.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check token revocation: %v", err)
+		}
+		if revoked {
+			dcontext.GetLogger(req.Context()).Infof("rejecting revoked token %q for subject %q", claims.JWTID, claims.Subject)
+			challenge.err = ErrRevokedToken
+			return nil, challenge
+		}
+	}
+
 	accessSet := claims.accessSet()
 	for _, access := range accessItems {
 		if !accessSet.contains(access) {
@@ -413,7 +494,7 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 	}
 
 	return &auth.Grant{
-		User:      auth.UserInfo{Name: claims.Subject},
+		User:      claims.userInfo(ac.groupsClaim, ac.claims),
 		Resources: claims.resources(),
 	}, nil
 }
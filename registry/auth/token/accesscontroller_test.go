@@ -86,4 +86,22 @@ func TestCheckOptions(t *testing.T) {
 	if ta.autoRedirectPath != "/auth/token" {
 		t.Fatal("autoredirectpath should be /auth/token")
 	}
+	if ta.groupsClaim != "groups" || len(ta.claims) != 3 {
+		t.Fatalf("unexpected default claims: %q, %v", ta.groupsClaim, ta.claims)
+	}
+
+	options["groupsclaim"] = "roles"
+	options["claims"] = []interface{}{"iss", "email"}
+	ta, err = checkOptions(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ta.groupsClaim != "roles" || len(ta.claims) != 2 || ta.claims[1] != "email" {
+		t.Fatalf("unexpected claims: %q, %v", ta.groupsClaim, ta.claims)
+	}
+
+	options["claims"] = "email"
+	if _, err := checkOptions(options); err == nil {
+		t.Fatal("expected error for invalid claims")
+	}
 }
@@ -0,0 +1,299 @@
+package token
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/optionutil"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrRevokedToken is returned when a token has been revoked, either by its
+// ID or by its subject.
+var ErrRevokedToken = errors.New("token has been revoked")
+
+// defaultRevocationCacheTTL is how long a negative revocation lookup is
+// cached when no cachettl is configured.
+const defaultRevocationCacheTTL = 30 * time.Second
+
+// RevocationStore reports whether tokens have been revoked before they
+// expire.
+type RevocationStore interface {
+	// Revoked returns true if the token with the given ID (the "jti"
+	// claim), or every token issued to the given subject, has been revoked.
+	Revoked(ctx context.Context, jti, subject string) (bool, error)
+}
+
+// RevocationStoreInitFunc is the type of a RevocationStore factory function
+// and is used to register the constructor for different store backends.
+type RevocationStoreInitFunc func(options map[string]interface{}) (RevocationStore, error)
+
+var revocationStores = map[string]RevocationStoreInitFunc{
+	"file":  newFileRevocationStore,
+	"redis": newRedisRevocationStore,
+}
+
+// RegisterRevocationStore is used to register a RevocationStoreInitFunc for a
+// revocation store backend with the given name.
+func RegisterRevocationStore(name string, initFunc RevocationStoreInitFunc) error {
+	if _, exists := revocationStores[name]; exists {
+		return fmt.Errorf("name already registered: %s", name)
+	}
+
+	revocationStores[name] = initFunc
+
+	return nil
+}
+
+// newRevocationStore constructs the revocation store described by the
+// "revocation" option of the token access controller. The shared redis
+// client of the registry, if any, is passed in as the "redis" option.
+func newRevocationStore(options map[string]interface{}, client interface{}) (RevocationStore, error) {
+	storeType, ok := options["type"].(string)
+	if !ok || storeType == "" {
+		return nil, errors.New("token revocation requires a valid option string: type")
+	}
+
+	initFunc, ok := revocationStores[storeType]
+	if !ok {
+		return nil, fmt.Errorf("no token revocation store registered with name: %s", storeType)
+	}
+
+	storeOptions := make(map[string]interface{}, len(options)+1)
+	for k, v := range options {
+		storeOptions[k] = v
+	}
+	if client != nil {
+		storeOptions["redis"] = client
+	}
+
+	store, err := initFunc(storeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := optionutil.Duration(options, "cachettl", defaultRevocationCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("token revocation: %v", err)
+	}
+	if ttl <= 0 {
+		return store, nil
+	}
+
+	return newCachedRevocationStore(store, ttl), nil
+}
+
+// cachedRevocationStore caches negative lookups of a RevocationStore, so
+// that valid tokens do not incur a lookup on every request. Positive lookups
+// are never cached: a revoked token stays revoked.
+type cachedRevocationStore struct {
+	RevocationStore
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+	// expiries lists the cached entries in the order they expire in, which
+	// is the order they were cached in since the TTL is fixed. An entry
+	// cached again is listed again, and its older listing is skipped.
+	expiries *list.List
+}
+
+type revocationCacheEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newCachedRevocationStore(store RevocationStore, ttl time.Duration) *cachedRevocationStore {
+	return &cachedRevocationStore{
+		RevocationStore: store,
+		ttl:             ttl,
+		entries:         make(map[string]time.Time),
+		expiries:        list.New(),
+	}
+}
+
+func (c *cachedRevocationStore) Revoked(ctx context.Context, jti, subject string) (bool, error) {
+	key := jti + "\x00" + subject
+	now := time.Now()
+
+	c.mu.Lock()
+	expires, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(expires) {
+		return false, nil
+	}
+
+	revoked, err := c.RevocationStore.Revoked(ctx, jti, subject)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep(now)
+	expires = now.Add(c.ttl)
+	c.entries[key] = expires
+	c.expiries.PushBack(revocationCacheEntry{key: key, expires: expires})
+
+	return false, nil
+}
+
+// sweep evicts the entries expired at now, from the front of the expiry
+// list. It must be called with the lock held.
+func (c *cachedRevocationStore) sweep(now time.Time) {
+	for e := c.expiries.Front(); e != nil; e = c.expiries.Front() {
+		entry := e.Value.(revocationCacheEntry)
+		if !now.After(entry.expires) {
+			return
+		}
+		c.expiries.Remove(e)
+		if expires, ok := c.entries[entry.key]; ok && expires.Equal(entry.expires) {
+			delete(c.entries, entry.key)
+		}
+	}
+}
+
+// fileRevocationStore reads revoked token IDs and subjects from a local
+// file. Each non-empty line holds either "jti:<id>" or "sub:<subject>";
+// lines starting with "#" are ignored. The file is reloaded whenever its
+// modification time changes.
+type fileRevocationStore struct {
+	path string
+
+	mu       sync.Mutex
+	modtime  time.Time
+	ids      map[string]struct{}
+	subjects map[string]struct{}
+}
+
+func newFileRevocationStore(options map[string]interface{}) (RevocationStore, error) {
+	path, ok := options["path"].(string)
+	if !ok || path == "" {
+		return nil, errors.New("file token revocation store requires a valid option string: path")
+	}
+
+	store := &fileRevocationStore{path: path}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *fileRevocationStore) Revoked(ctx context.Context, jti, subject string) (bool, error) {
+	if err := s.reload(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ids[jti]; ok && jti != "" {
+		return true, nil
+	}
+	if _, ok := s.subjects[subject]; ok && subject != "" {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// reload parses the revocation file if it changed since it was last read.
+func (s *fileRevocationStore) reload() error {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("unable to stat token revocation file %q: %v", s.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ids != nil && s.modtime.Equal(fi.ModTime()) {
+		return nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("unable to open token revocation file %q: %v", s.path, err)
+	}
+	defer f.Close()
+
+	ids := make(map[string]struct{})
+	subjects := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kind, value, ok := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return fmt.Errorf("invalid entry in token revocation file %q, line %d", s.path, lineno)
+		}
+
+		switch strings.TrimSpace(kind) {
+		case "jti":
+			ids[value] = struct{}{}
+		case "sub":
+			subjects[value] = struct{}{}
+		default:
+			return fmt.Errorf("invalid entry type %q in token revocation file %q, line %d", kind, s.path, lineno)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read token revocation file %q: %v", s.path, err)
+	}
+
+	s.ids, s.subjects, s.modtime = ids, subjects, fi.ModTime()
+	return nil
+}
+
+// redisRevocationStore looks up revoked token IDs and subjects in two redis
+// sets, "<prefix>:jti" and "<prefix>:sub".
+type redisRevocationStore struct {
+	pool   redis.UniversalClient
+	prefix string
+}
+
+const defaultRevocationPrefix = "registry:revoked"
+
+func newRedisRevocationStore(options map[string]interface{}) (RevocationStore, error) {
+	pool, ok := options["redis"].(redis.UniversalClient)
+	if !ok || pool == nil {
+		return nil, errors.New("redis token revocation store requires redis to be configured")
+	}
+
+	prefix := defaultRevocationPrefix
+	if v, ok := options["prefix"]; ok {
+		if prefix, ok = v.(string); !ok || prefix == "" {
+			return nil, errors.New("redis token revocation store requires a valid option string: prefix")
+		}
+	}
+
+	return &redisRevocationStore{pool: pool, prefix: prefix}, nil
+}
+
+func (s *redisRevocationStore) Revoked(ctx context.Context, jti, subject string) (bool, error) {
+	if jti != "" {
+		revoked, err := s.pool.SIsMember(ctx, s.prefix+":jti", jti).Result()
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	if subject != "" {
+		return s.pool.SIsMember(ctx, s.prefix+":sub", subject).Result()
+	}
+
+	return false, nil
+}
@@ -0,0 +1,228 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileRevocationStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked")
+	if err := os.WriteFile(path, []byte("# revoked tokens\njti:abc\n\nsub: mallory\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := newFileRevocationStore(map[string]interface{}{"path": path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		jti, subject string
+		revoked      bool
+	}{
+		{"abc", "alice", true},
+		{"def", "mallory", true},
+		{"def", "alice", false},
+		{"", "", false},
+	} {
+		revoked, err := store.Revoked(ctx, tc.jti, tc.subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != tc.revoked {
+			t.Errorf("Revoked(%q, %q) = %v, expected %v", tc.jti, tc.subject, revoked, tc.revoked)
+		}
+	}
+
+	// Changes to the file are picked up.
+	if err := os.WriteFile(path, []byte("sub:alice\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	modtime := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, modtime, modtime); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := store.Revoked(ctx, "def", "alice"); err != nil || !revoked {
+		t.Fatalf("expected reloaded file to revoke alice: %v, %v", revoked, err)
+	}
+
+	if err := os.WriteFile(path, []byte("bogus\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFileRevocationStore(map[string]interface{}{"path": path}); err == nil {
+		t.Fatal("expected error for invalid revocation file")
+	}
+}
+
+type countingRevocationStore struct {
+	revoked map[string]bool
+	calls   int
+}
+
+func (s *countingRevocationStore) Revoked(ctx context.Context, jti, subject string) (bool, error) {
+	s.calls++
+	return s.revoked[jti], nil
+}
+
+func TestCachedRevocationStore(t *testing.T) {
+	store := &countingRevocationStore{revoked: map[string]bool{"bad": true}}
+	cached := newCachedRevocationStore(store, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if revoked, _ := cached.Revoked(ctx, "good", "foo"); revoked {
+			t.Fatal("expected token not to be revoked")
+		}
+		if revoked, _ := cached.Revoked(ctx, "bad", "foo"); !revoked {
+			t.Fatal("expected token to be revoked")
+		}
+	}
+
+	// one lookup for the cached negative result, one per positive result.
+	if store.calls != 4 {
+		t.Fatalf("expected 4 lookups, got %d", store.calls)
+	}
+}
+
+func TestCachedRevocationStoreExpiry(t *testing.T) {
+	store := &countingRevocationStore{}
+	cached := newCachedRevocationStore(store, 10*time.Millisecond)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := cached.Revoked(ctx, fmt.Sprint(i), "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// expired entries are looked up again, and swept when caching a lookup.
+	if _, err := cached.Revoked(ctx, "0", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if store.calls != 4 {
+		t.Fatalf("expected 4 lookups, got %d", store.calls)
+	}
+	if len(cached.entries) != 1 || cached.expiries.Len() != 1 {
+		t.Fatalf("expected expired entries to be swept, got %d entries and %d expiries", len(cached.entries), cached.expiries.Len())
+	}
+}
+
+func TestRevocationStoreCacheTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		cachettl interface{}
+		cached   bool
+	}{
+		{nil, true},
+		{"1m", true},
+		{time.Minute, true},
+		{"0s", false},
+	} {
+		options := map[string]interface{}{"type": "file", "path": path}
+		if tc.cachettl != nil {
+			options["cachettl"] = tc.cachettl
+		}
+		store, err := newRevocationStore(options, nil)
+		if err != nil {
+			t.Fatalf("cachettl %v: %v", tc.cachettl, err)
+		}
+		if _, cached := store.(*cachedRevocationStore); cached != tc.cached {
+			t.Errorf("cachettl %v: cached = %v, expected %v", tc.cachettl, cached, tc.cached)
+		}
+	}
+
+	for _, cachettl := range []interface{}{"soon", 30} {
+		options := map[string]interface{}{"type": "file", "path": path, "cachettl": cachettl}
+		if _, err := newRevocationStore(options, nil); err == nil || !strings.Contains(err.Error(), "cachettl") {
+			t.Errorf("cachettl %v: expected an error about cachettl, got %v", cachettl, err)
+		}
+	}
+}
+
+func TestAccessControllerRevocation(t *testing.T) {
+	rootKeys, err := makeRootKeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootCertBundleFilename, err := writeTempRootCerts(rootKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootCertBundleFilename)
+
+	revocationFilename := filepath.Join(t.TempDir(), "revoked")
+	if err := os.WriteFile(revocationFilename, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := "test-issuer.example.com"
+	service := "test-service.example.com"
+
+	accessController, err := newAccessController(map[string]interface{}{
+		"realm":          "https://auth.example.com/token/",
+		"issuer":         issuer,
+		"service":        service,
+		"rootcertbundle": rootCertBundleFilename,
+		"revocation": map[interface{}]interface{}{
+			"type":     "file",
+			"path":     revocationFilename,
+			"cachettl": "0s",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, err := makeSigningKeyWithChain(rootKeys[0], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := makeTestToken(jwk, issuer, service, []*ResourceActions{}, time.Now(), time.Now().Add(5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Raw))
+
+	if _, err := accessController.Authorized(req); err != nil {
+		t.Fatalf("accessController returned unexpected error: %s", err)
+	}
+
+	if err := os.WriteFile(revocationFilename, []byte("sub:foo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	modtime := time.Now().Add(time.Second)
+	if err := os.Chtimes(revocationFilename, modtime, modtime); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = accessController.Authorized(req)
+	challenge, ok := err.(*authChallenge)
+	if !ok {
+		t.Fatalf("accessController did not return a challenge: %v", err)
+	}
+	if challenge.err != ErrRevokedToken {
+		t.Fatalf("accessController did not get expected error - got %s - expected %s", challenge.err, ErrRevokedToken)
+	}
+	if params := challenge.challengeParams(req); !strings.HasSuffix(params, `error="invalid_token"`) {
+		t.Fatalf("expected invalid_token challenge, got %s", params)
+	}
+}
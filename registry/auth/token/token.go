@@ -3,8 +3,10 @@ package token
 import (
 	"crypto"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-jose/go-jose/v4"
@@ -82,6 +84,10 @@ type ClaimSet struct {
 
 	// Private claims
 	Access []*ResourceActions `json:"access"`
+
+	// raw holds every claim of the token, by name, so that claims other
+	// than the ones above can be passed on with the identity.
+	raw map[string]interface{}
 }
 
 // Token is a JSON Web Token.
@@ -126,7 +132,7 @@ func (t *Token) Verify(verifyOpts VerifyOptions) (*ClaimSet, error) {
 	// NOTE(milosgajdos): Claims both verifies the signature
 	// and returns the claims within the payload
 	var claims ClaimSet
-	err = t.JWT.Claims(signingKey, &claims)
+	err = t.JWT.Claims(signingKey, &claims, &claims.raw)
 	if err != nil {
 		return nil, err
 	}
@@ -314,3 +320,67 @@ func (c *ClaimSet) resources() []auth.Resource {
 
 	return resources
 }
+
+// userInfo returns the identity described by this claim set. The groups of
+// the user are read from the groupsClaim claim, which holds either a list of
+// strings or a single string, and the claims named in claimNames are carried
+// as claims of the identity.
+func (c *ClaimSet) userInfo(groupsClaim string, claimNames []string) auth.UserInfo {
+	var groups []string
+	switch v := c.raw[groupsClaim].(type) {
+	case string:
+		if v != "" {
+			groups = []string{v}
+		}
+	case []interface{}:
+		for _, group := range v {
+			if group, ok := group.(string); ok && group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	claims := make(map[string]string, len(claimNames))
+	for _, name := range claimNames {
+		if value := claimString(c.raw[name]); value != "" {
+			claims[name] = value
+		}
+	}
+
+	return auth.UserInfo{
+		Name:   c.Subject,
+		Type:   auth.UserTypeUser,
+		Groups: groups,
+		Claims: claims,
+	}
+}
+
+// claimString formats the value of a claim: strings as is, lists of strings
+// joined by commas and other values in JSON.
+func claimString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			s, ok := value.(string)
+			if !ok {
+				values = nil
+				break
+			}
+			values = append(values, s)
+		}
+		if values != nil {
+			return strings.Join(values, ",")
+		}
+	}
+
+	p, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(p)
+}
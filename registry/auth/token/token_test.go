@@ -15,6 +15,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -570,6 +571,9 @@ func TestAccessController(t *testing.T) {
 	if grant.User.Name != "foo" {
 		t.Fatalf("expected user name %q, got %q", "foo", grant.User.Name)
 	}
+	if grant.User.Claims["iss"] != issuer || grant.User.Claims["aud"] != service {
+		t.Fatalf("unexpected user claims: %v", grant.User.Claims)
+	}
 
 	// 5. Supply a token with full admin rights, which is represented as "*".
 	token, err = makeTestToken(
@@ -593,6 +597,43 @@ func TestAccessController(t *testing.T) {
 	}
 }
 
+func TestClaimSetUserInfo(t *testing.T) {
+	var claims ClaimSet
+	if err := json.Unmarshal([]byte(`{
+		"iss": "issuer",
+		"sub": "foo",
+		"aud": ["a", "b"],
+		"jti": "id",
+		"roles": ["dev", "ops"],
+		"team": "core",
+		"org": {"id": 1}
+	}`), &claims.raw); err != nil {
+		t.Fatal(err)
+	}
+	claims.Subject = "foo"
+
+	user := claims.userInfo("groups", defaultClaims)
+	if len(user.Groups) != 0 {
+		t.Fatalf("unexpected groups: %v", user.Groups)
+	}
+	if !reflect.DeepEqual(user.Claims, map[string]string{"iss": "issuer", "aud": "a,b", "jti": "id"}) {
+		t.Fatalf("unexpected default claims: %v", user.Claims)
+	}
+
+	user = claims.userInfo("roles", []string{"team", "org", "missing"})
+	if !reflect.DeepEqual(user.Groups, []string{"dev", "ops"}) {
+		t.Fatalf("unexpected groups: %v", user.Groups)
+	}
+	if !reflect.DeepEqual(user.Claims, map[string]string{"team": "core", "org": `{"id":1}`}) {
+		t.Fatalf("unexpected claims: %v", user.Claims)
+	}
+
+	user = claims.userInfo("team", nil)
+	if !reflect.DeepEqual(user.Groups, []string{"core"}) || len(user.Claims) != 0 {
+		t.Fatalf("unexpected identity: %v", user)
+	}
+}
+
 // This tests that newAccessController can handle PEM blocks in the certificate
 // file other than certificates, for example a private key.
 func TestNewAccessControllerPemBlock(t *testing.T) {
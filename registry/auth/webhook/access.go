@@ -0,0 +1,480 @@
+// Package webhook provides an access controller that delegates authorization
+// decisions to an external HTTP policy service, such as Open Policy Agent.
+//
+// For every request, the access controller posts the authenticated identity
+// and the requested access to the configured endpoint and honors the
+// allow/deny decision and the list of resources which come back. Identity is
+// established by an optional nested access controller (the "authenticator"),
+// for example htpasswd or token. Without an authenticator every request is
+// sent to the policy service as anonymous.
+//
+// Decisions are cached for a configurable TTL and calls to the endpoint are
+// guarded by a circuit breaker, so an unavailable policy service fails
+// requests quickly instead of piling them up.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/optionutil"
+	"github.com/distribution/distribution/v3/internal/requestutil"
+	"github.com/distribution/distribution/v3/registry/auth"
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// init registers the webhook auth backend.
+func init() {
+	if err := auth.Register("webhook", auth.InitFunc(newAccessController)); err != nil {
+		logrus.Errorf("failed to register webhook auth: %v", err)
+	}
+}
+
+const (
+	defaultTimeout   = 5 * time.Second
+	defaultCacheTTL  = time.Minute
+	defaultThreshold = 5
+	defaultBackoff   = 10 * time.Second
+)
+
+// Errors used and exported by this package.
+var (
+	ErrAccessDenied = errors.New("access denied by policy")
+	ErrBreakerOpen  = errors.New("authorization endpoint unavailable: circuit breaker open")
+)
+
+// Input is the document posted to the authorization endpoint, wrapped in an
+// "input" field as expected by OPA-style policy services.
+type Input struct {
+	// User is the identity established by the authenticator. The name is
+	// empty for anonymous requests.
+	User User `json:"user"`
+
+	// Access lists every action requested on every resource.
+	Access []Access `json:"access"`
+
+	// Request carries a few details of the incoming HTTP request.
+	Request RequestInfo `json:"request"`
+}
+
+// User describes the authenticated client.
+type User struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type,omitempty"`
+	Groups []string          `json:"groups,omitempty"`
+	Claims map[string]string `json:"claims,omitempty"`
+}
+
+// Resource describes a resource by type and name.
+type Resource struct {
+	Type  string `json:"type"`
+	Class string `json:"class,omitempty"`
+	Name  string `json:"name"`
+}
+
+// Access describes an action requested on a resource.
+type Access struct {
+	Resource
+	Action string `json:"action"`
+}
+
+// RequestInfo describes the HTTP request being authorized.
+type RequestInfo struct {
+	Method     string `json:"method"`
+	Host       string `json:"host"`
+	Path       string `json:"path"`
+	RemoteAddr string `json:"remoteaddr"`
+}
+
+// Decision is the document expected from the authorization endpoint, wrapped
+// in a "result" field.
+type Decision struct {
+	// Allow reports whether the request is granted.
+	Allow bool `json:"allow"`
+
+	// Resources is the list of resources which have been authorized for the
+	// request. It is passed along unchanged in the auth.Grant.
+	Resources []Resource `json:"resources,omitempty"`
+
+	// Reason optionally explains a denial. It is logged, not returned to
+	// the client.
+	Reason string `json:"reason,omitempty"`
+}
+
+type request struct {
+	Input Input `json:"input"`
+}
+
+type response struct {
+	Result *Decision `json:"result"`
+}
+
+// accessController implements the auth.AccessController interface.
+type accessController struct {
+	realm         string
+	url           string
+	headers       http.Header
+	client        *http.Client
+	authenticator auth.AccessController
+	breaker       *events.Breaker
+	cache         *decisionCache
+	cacheFields   []string
+}
+
+var _ auth.AccessController = &accessController{}
+
+// webhookOptions is a convenience type for handling options to the
+// constructor of an accessController.
+type webhookOptions struct {
+	realm         string
+	url           string
+	headers       http.Header
+	timeout       time.Duration
+	cacheTTL      time.Duration
+	cacheFields   []string
+	threshold     int
+	backoff       time.Duration
+	authenticator string
+	authOptions   map[string]interface{}
+}
+
+// checkOptions gathers the necessary options for an accessController from
+// the given map.
+func checkOptions(options map[string]interface{}) (webhookOptions, error) {
+	opts := webhookOptions{
+		timeout:   defaultTimeout,
+		cacheTTL:  defaultCacheTTL,
+		threshold: defaultThreshold,
+		backoff:   defaultBackoff,
+		headers:   make(http.Header),
+	}
+
+	realm, ok := options["realm"].(string)
+	if !ok || realm == "" {
+		return opts, fmt.Errorf(`"realm" must be set for webhook access controller`)
+	}
+	opts.realm = realm
+
+	endpoint, ok := options["url"].(string)
+	if !ok || endpoint == "" {
+		return opts, fmt.Errorf(`"url" must be set for webhook access controller`)
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return opts, fmt.Errorf("invalid webhook url: %v", err)
+	}
+	opts.url = endpoint
+
+	var err error
+	if opts.timeout, err = optionutil.Duration(options, "timeout", opts.timeout); err != nil {
+		return opts, err
+	}
+	if opts.cacheTTL, err = optionutil.Duration(options, "cachettl", opts.cacheTTL); err != nil {
+		return opts, err
+	}
+	if opts.backoff, err = optionutil.Duration(options, "backoff", opts.backoff); err != nil {
+		return opts, err
+	}
+
+	if v, ok := options["cacherequestfields"]; ok {
+		fields, ok := v.([]interface{})
+		if !ok {
+			return opts, fmt.Errorf("webhook auth cacherequestfields must be a list, got %T", v)
+		}
+		for _, field := range fields {
+			name, ok := field.(string)
+			if !ok || !slices.Contains(requestFields, name) {
+				return opts, fmt.Errorf("webhook auth cacherequestfields: invalid field %v, expected one of %s", field, strings.Join(requestFields, ", "))
+			}
+			opts.cacheFields = append(opts.cacheFields, name)
+		}
+	}
+
+	if v, ok := options["threshold"]; ok {
+		threshold, ok := v.(int)
+		if !ok || threshold <= 0 {
+			return opts, fmt.Errorf("webhook auth requires a positive integer option: threshold")
+		}
+		opts.threshold = threshold
+	}
+
+	if v, ok := options["headers"]; ok {
+		headers, err := stringMap(v)
+		if err != nil {
+			return opts, fmt.Errorf("webhook auth headers: %v", err)
+		}
+		for k, v := range headers {
+			opts.headers.Set(k, fmt.Sprint(v))
+		}
+	}
+
+	if v, ok := options["authenticator"]; ok {
+		authenticator, err := stringMap(v)
+		if err != nil {
+			return opts, fmt.Errorf("webhook auth authenticator: %v", err)
+		}
+		if len(authenticator) != 1 {
+			return opts, fmt.Errorf("webhook auth authenticator must name exactly one access controller")
+		}
+		for name, params := range authenticator {
+			opts.authenticator = name
+			if params != nil {
+				if opts.authOptions, err = stringMap(params); err != nil {
+					return opts, fmt.Errorf("webhook auth authenticator %q: %v", name, err)
+				}
+			}
+		}
+		if opts.authenticator == "webhook" {
+			return opts, fmt.Errorf("webhook auth authenticator cannot be webhook")
+		}
+	}
+
+	return opts, nil
+}
+
+// stringMap converts a map decoded from YAML into a map keyed by strings.
+func stringMap(v interface{}) (map[string]interface{}, error) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, nil
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v", k)
+			}
+			out[key] = v
+		}
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("expected a map, got %T", v)
+}
+
+// newAccessController creates an accessController using the given options.
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	config, err := checkOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	ac := &accessController{
+		realm:   config.realm,
+		url:     config.url,
+		headers: config.headers,
+		client:  &http.Client{Timeout: config.timeout},
+		breaker: events.NewBreaker(config.threshold, config.backoff),
+	}
+
+	if config.cacheTTL > 0 {
+		ac.cache = newDecisionCache(config.cacheTTL)
+		ac.cacheFields = config.cacheFields
+	}
+
+	if config.authenticator != "" {
+		authOptions := make(map[string]interface{}, len(config.authOptions)+1)
+		for k, v := range config.authOptions {
+			authOptions[k] = v
+		}
+		// pass the shared redis client on to the authenticator.
+		if client, ok := options["redis"]; ok {
+			authOptions["redis"] = client
+		}
+
+		ac.authenticator, err = auth.GetAccessController(config.authenticator, authOptions)
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure webhook authenticator (%s): %v", config.authenticator, err)
+		}
+	}
+
+	return ac, nil
+}
+
+// Authorized authenticates the request with the configured authenticator, if
+// any, and asks the authorization endpoint for a decision on the requested
+// access.
+func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Access) (*auth.Grant, error) {
+	var user auth.UserInfo
+	if ac.authenticator != nil {
+		// Authenticate only: the policy service decides on the access.
+		grant, err := ac.authenticator.Authorized(req)
+		if err != nil {
+			return nil, err
+		}
+		user = grant.User
+	}
+
+	decision, err := ac.decide(req, user, accessItems)
+	if err != nil {
+		return nil, err
+	}
+
+	if !decision.Allow {
+		dcontext.GetLogger(req.Context()).Infof("webhook denied access to %q: %s", user.Name, decision.Reason)
+		return nil, &challenge{
+			realm:  ac.realm,
+			scheme: authScheme(req),
+			err:    ErrAccessDenied,
+		}
+	}
+
+	resources := make([]auth.Resource, 0, len(decision.Resources))
+	for _, r := range decision.Resources {
+		resources = append(resources, auth.Resource{
+			Type:  r.Type,
+			Class: r.Class,
+			Name:  r.Name,
+		})
+	}
+
+	return &auth.Grant{
+		User:      user,
+		Resources: resources,
+	}, nil
+}
+
+// decide returns the decision for the given user and access, from the cache
+// if possible.
+func (ac *accessController) decide(req *http.Request, user auth.UserInfo, accessItems []auth.Access) (*Decision, error) {
+	in := newInput(req, user, accessItems)
+
+	var key string
+	if ac.cache != nil {
+		var err error
+		if key, err = cacheKey(in, ac.cacheFields); err != nil {
+			return nil, err
+		}
+		if decision, ok := ac.cache.get(key); ok {
+			return decision, nil
+		}
+	}
+
+	if backoff := ac.breaker.Proceed(nil); backoff > 0 {
+		return nil, ErrBreakerOpen
+	}
+
+	decision, err := ac.query(req, in)
+	if err != nil {
+		ac.breaker.Failure(nil, err)
+		return nil, err
+	}
+	ac.breaker.Success(nil)
+
+	if ac.cache != nil {
+		ac.cache.put(key, decision)
+	}
+
+	return decision, nil
+}
+
+// newInput builds the input document describing the given user, access and
+// request.
+func newInput(req *http.Request, user auth.UserInfo, accessItems []auth.Access) Input {
+	access := make([]Access, 0, len(accessItems))
+	for _, item := range accessItems {
+		access = append(access, Access{
+			Resource: Resource{
+				Type:  item.Type,
+				Class: item.Class,
+				Name:  item.Name,
+			},
+			Action: item.Action,
+		})
+	}
+
+	return Input{
+		User: User{
+			Name:   user.Name,
+			Type:   user.Type,
+			Groups: user.Groups,
+			Claims: user.Claims,
+		},
+		Access: access,
+		Request: RequestInfo{
+			Method:     req.Method,
+			Host:       req.Host,
+			Path:       req.URL.Path,
+			RemoteAddr: requestutil.RemoteAddr(req),
+		},
+	}
+}
+
+// query posts the input document to the authorization endpoint and decodes
+// its decision. The request is used for its context only.
+func (ac *accessController) query(req *http.Request, in Input) (*Decision, error) {
+	body, err := json.Marshal(request{Input: in})
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(req.Context(), http.MethodPost, ac.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range ac.headers {
+		r.Header[k] = v
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := ac.client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("error querying authorization endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("authorization endpoint returned unexpected status: %s", resp.Status)
+	}
+
+	var result response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding authorization decision: %v", err)
+	}
+	if result.Result == nil {
+		return nil, fmt.Errorf("authorization endpoint returned no result")
+	}
+
+	return result.Result, nil
+}
+
+// authScheme returns the scheme the client authenticated with, so that a
+// denial challenges it using the same scheme.
+func authScheme(req *http.Request) string {
+	prefix, _, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(prefix, "bearer") {
+		return "Bearer"
+	}
+	return "Basic"
+}
+
+// challenge implements the auth.Challenge interface.
+type challenge struct {
+	realm  string
+	scheme string
+	err    error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders sets the challenge header on the response.
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	header := fmt.Sprintf("%s realm=%q", ch.scheme, ch.realm)
+	if ch.scheme == "Bearer" {
+		header = fmt.Sprintf("%s,error=%q", header, "insufficient_scope")
+	}
+	w.Header().Set("WWW-Authenticate", header)
+}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("webhook authorization challenge for realm %q: %s", ch.realm, ch.err)
+}
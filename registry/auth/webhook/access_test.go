@@ -0,0 +1,294 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	_ "github.com/distribution/distribution/v3/registry/auth/silly"
+)
+
+func newPolicyServer(t *testing.T, calls *int32, decide func(in Input) Decision) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("error decoding policy input: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		decision := decide(req.Input)
+		if err := json.NewEncoder(w).Encode(response{Result: &decision}); err != nil {
+			t.Errorf("error encoding policy decision: %v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestWebhookAccessController(t *testing.T) {
+	var calls int32
+	server := newPolicyServer(t, &calls, func(in Input) Decision {
+		if in.User.Name != "silly" {
+			return Decision{Allow: false, Reason: "unknown user"}
+		}
+		for _, access := range in.Access {
+			if access.Action != "pull" {
+				return Decision{Allow: false, Reason: "read only"}
+			}
+		}
+		return Decision{
+			Allow:     true,
+			Resources: []Resource{{Type: "repository", Name: "foo/bar"}},
+		}
+	})
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm": "test-realm",
+		"url":   server.URL,
+		"authenticator": map[interface{}]interface{}{
+			"silly": map[interface{}]interface{}{
+				"realm":   "test-realm",
+				"service": "test-service",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+
+	pull := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "pull"}
+	push := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "push"}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/tags/list", nil)
+	if _, err := ac.Authorized(req, pull); err == nil {
+		t.Fatal("expected unauthenticated request to be challenged")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("expected challenge, got %T: %v", err, err)
+	}
+	if calls != 0 {
+		t.Fatalf("unauthenticated request should not reach the policy service")
+	}
+
+	req.Header.Set("Authorization", "Bearer sillytoken")
+	grant, err := ac.Authorized(req, pull)
+	if err != nil {
+		t.Fatalf("unexpected error authorizing pull: %v", err)
+	}
+	if grant.User.Name != "silly" {
+		t.Fatalf("unexpected user: %q", grant.User.Name)
+	}
+	if len(grant.Resources) != 1 || grant.Resources[0].Name != "foo/bar" {
+		t.Fatalf("unexpected resources: %v", grant.Resources)
+	}
+
+	_, err = ac.Authorized(req, pull, push)
+	ch, ok := err.(auth.Challenge)
+	if !ok {
+		t.Fatalf("expected challenge for push, got %v", err)
+	}
+	w := httptest.NewRecorder()
+	ch.SetHeaders(req, w)
+	if got, want := w.Header().Get("WWW-Authenticate"), `Bearer realm="test-realm",error="insufficient_scope"`; got != want {
+		t.Fatalf("unexpected challenge header: %q != %q", got, want)
+	}
+
+	// Both decisions are cached now.
+	before := atomic.LoadInt32(&calls)
+	if _, err := ac.Authorized(req, pull); err != nil {
+		t.Fatalf("unexpected error authorizing pull: %v", err)
+	}
+	if _, err := ac.Authorized(req, push, pull); err == nil {
+		t.Fatal("expected cached denial")
+	}
+	if after := atomic.LoadInt32(&calls); after != before {
+		t.Fatalf("expected cached decisions, got %d new calls", after-before)
+	}
+}
+
+func TestWebhookAnonymous(t *testing.T) {
+	var calls int32
+	server := newPolicyServer(t, &calls, func(in Input) Decision {
+		return Decision{Allow: in.User.Name == "" && in.Request.Method == http.MethodGet}
+	})
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":    "test-realm",
+		"url":      server.URL,
+		"cachettl": "0s",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/", nil)
+	grant, err := ac.Authorized(req)
+	if err != nil {
+		t.Fatalf("unexpected error authorizing anonymous request: %v", err)
+	}
+	if grant.User.Name != "" {
+		t.Fatalf("expected anonymous user, got %q", grant.User.Name)
+	}
+
+	if _, err := ac.Authorized(req); err != nil {
+		t.Fatalf("unexpected error authorizing anonymous request: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected uncached decisions, got %d calls", calls)
+	}
+}
+
+func TestWebhookCacheKey(t *testing.T) {
+	var calls int32
+	server := newPolicyServer(t, &calls, func(in Input) Decision {
+		return Decision{Allow: in.Request.RemoteAddr == "10.0.0.1:1234"}
+	})
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":              "test-realm",
+		"url":                server.URL,
+		"cacherequestfields": []interface{}{"remoteaddr"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+
+	pull := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "pull"}
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/tags/list", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if _, err := ac.Authorized(req, pull); err != nil {
+		t.Fatalf("unexpected error authorizing request: %v", err)
+	}
+	if _, err := ac.Authorized(req, pull); err != nil {
+		t.Fatalf("unexpected error authorizing request: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected cached decision, got %d calls", calls)
+	}
+
+	// The decision for another client is not taken from the cache.
+	req.RemoteAddr = "10.0.0.2:1234"
+	if _, err := ac.Authorized(req, pull); err == nil {
+		t.Fatal("expected request from another address to be denied")
+	}
+	if calls != 2 {
+		t.Fatalf("expected uncached decision, got %d calls", calls)
+	}
+
+	in := newInput(req, auth.UserInfo{Name: "user", Groups: []string{"dev"}}, []auth.Access{pull})
+	key, err := cacheKey(in, nil)
+	if err != nil {
+		t.Fatalf("unexpected error building cache key: %v", err)
+	}
+	in.User.Groups = []string{"admin"}
+	if other, _ := cacheKey(in, nil); other == key {
+		t.Fatal("expected cache key to depend on the groups of the user")
+	}
+}
+
+func TestWebhookCacheKeyRequest(t *testing.T) {
+	var calls int32
+	server := newPolicyServer(t, &calls, func(in Input) Decision {
+		return Decision{Allow: true}
+	})
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm": "test-realm",
+		"url":   server.URL,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+
+	// Requests for different blobs with the same scope share a decision.
+	pull := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "pull"}
+	for _, path := range []string{
+		"/v2/foo/bar/blobs/sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		"/v2/foo/bar/blobs/sha256:fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		if _, err := ac.Authorized(req, pull); err != nil {
+			t.Fatalf("unexpected error authorizing request: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected cached decision, got %d calls", calls)
+	}
+
+	// The configured fields of the request are part of the key.
+	in := newInput(httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/tags/list", nil), auth.UserInfo{Name: "user"}, []auth.Access{pull})
+	key, err := cacheKey(in, []string{"method"})
+	if err != nil {
+		t.Fatalf("unexpected error building cache key: %v", err)
+	}
+	in.Request.Path = "/v2/foo/bar/manifests/latest"
+	if other, _ := cacheKey(in, []string{"method"}); other != key {
+		t.Fatal("expected cache key not to depend on the path of the request")
+	}
+	in.Request.Method = http.MethodHead
+	if other, _ := cacheKey(in, []string{"method"}); other == key {
+		t.Fatal("expected cache key to depend on the method of the request")
+	}
+}
+
+func TestWebhookCircuitBreaker(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":     "test-realm",
+		"url":       server.URL,
+		"threshold": 2,
+		"backoff":   time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/", nil)
+	for i := 0; i < 2; i++ {
+		if _, err := ac.Authorized(req); err == nil || err == ErrBreakerOpen {
+			t.Fatalf("expected endpoint error, got %v", err)
+		}
+	}
+
+	if _, err := ac.Authorized(req); err != ErrBreakerOpen {
+		t.Fatalf("expected open circuit breaker, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected breaker to stop calls to the endpoint, got %d calls", calls)
+	}
+}
+
+func TestCheckOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options map[string]interface{}
+	}{
+		{name: "no realm", options: map[string]interface{}{"url": "http://example.com"}},
+		{name: "no url", options: map[string]interface{}{"realm": "r"}},
+		{name: "bad timeout", options: map[string]interface{}{"realm": "r", "url": "http://example.com", "timeout": "soon"}},
+		{name: "bad cache request fields", options: map[string]interface{}{"realm": "r", "url": "http://example.com", "cacherequestfields": []interface{}{"query"}}},
+		{name: "bad threshold", options: map[string]interface{}{"realm": "r", "url": "http://example.com", "threshold": -1}},
+		{name: "recursive", options: map[string]interface{}{"realm": "r", "url": "http://example.com", "authenticator": map[string]interface{}{"webhook": nil}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := checkOptions(tc.options); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds the decision cache. Once reached, expired entries
+// are evicted and, if the cache is still full, it is reset.
+const maxCacheEntries = 10000
+
+// decisionCache caches authorization decisions for a fixed TTL.
+type decisionCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	decision *Decision
+	expires  time.Time
+}
+
+func newDecisionCache(ttl time.Duration) *decisionCache {
+	return &decisionCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// get returns the cached decision for key, if present and not expired.
+func (c *decisionCache) get(key string) (*Decision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return entry.decision, true
+}
+
+// put caches decision under key.
+func (c *decisionCache) put(key string, decision *Decision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			c.entries = make(map[string]cacheEntry)
+		}
+	}
+
+	c.entries[key] = cacheEntry{
+		decision: decision,
+		expires:  now.Add(c.ttl),
+	}
+}
+
+// requestFields lists the fields of the request which may be part of the
+// cache key.
+var requestFields = []string{"method", "host", "path", "remoteaddr"}
+
+// cacheKey builds a key identifying the user and the requested access, along
+// with the fields of the request listed in fields, so that a decision is
+// reused for every request the policy service is expected to decide alike.
+// The other fields of the request, such as the path, differ between requests
+// for the same access, as do blob digests, and are left out. The requested
+// access is sorted first, since its order does not matter.
+func cacheKey(in Input, fields []string) (string, error) {
+	access := make([]Access, len(in.Access))
+	copy(access, in.Access)
+	sort.Slice(access, func(i, j int) bool {
+		a, b := access[i], access[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Class != b.Class {
+			return a.Class < b.Class
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Action < b.Action
+	})
+	in.Access = access
+
+	var request RequestInfo
+	for _, field := range fields {
+		switch field {
+		case "method":
+			request.Method = in.Request.Method
+		case "host":
+			request.Host = in.Request.Host
+		case "path":
+			request.Path = in.Request.Path
+		case "remoteaddr":
+			request.RemoteAddr = in.Request.RemoteAddr
+		}
+	}
+	in.Request = request
+
+	p, err := json.Marshal(in)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(p)
+	return hex.EncodeToString(sum[:]), nil
+}
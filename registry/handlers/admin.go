@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth/robot"
+	"github.com/gorilla/mux"
+)
+
+const adminErrGroup = "registry.api.admin"
+
+var (
+	// errorCodeAdminUnknown is returned when the admin API resource does
+	// not exist.
+	errorCodeAdminUnknown = errcode.Register(adminErrGroup, errcode.ErrorDescriptor{
+		Value:          "ADMIN_UNKNOWN",
+		Message:        "unknown admin resource",
+		Description:    "The requested admin resource does not exist.",
+		HTTPStatusCode: http.StatusNotFound,
+	})
+
+	// errorCodeAdminInvalid is returned when an admin request is invalid.
+	errorCodeAdminInvalid = errcode.Register(adminErrGroup, errcode.ErrorDescriptor{
+		Value:          "ADMIN_INVALID",
+		Message:        "invalid admin request",
+		Description:    "The admin request could not be parsed or validated.",
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// errorCodeAdminConflict is returned when an admin resource already
+	// exists.
+	errorCodeAdminConflict = errcode.Register(adminErrGroup, errcode.ErrorDescriptor{
+		Value:          "ADMIN_CONFLICT",
+		Message:        "admin resource already exists",
+		Description:    "The admin resource to create already exists.",
+		HTTPStatusCode: http.StatusConflict,
+	})
+)
+
+// registerAdmin mounts the admin API below <prefix>/admin/ on the app router.
+// Every request must carry the configured admin token as a bearer token.
+func (app *App) registerAdmin(prefix string) {
+	router := app.router.PathPrefix(strings.TrimSuffix(prefix, "/") + "/admin").Subrouter()
+	router.Use(app.adminAuth)
+
+	app.registerAdminEndpoints(router)
+
+	if app.robots != nil {
+		router.Path("/robots").Methods(http.MethodGet).HandlerFunc(app.listRobots)
+		router.Path("/robots").Methods(http.MethodPost).HandlerFunc(app.createRobot)
+		router.Path("/robots/{name}").Methods(http.MethodGet).HandlerFunc(app.getRobot)
+		router.Path("/robots/{name}").Methods(http.MethodDelete).HandlerFunc(app.revokeRobot)
+	}
+}
+
+// adminAuth checks the admin bearer token before handing the request on.
+func (app *App) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(prefix, "bearer") ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(app.Config.Admin.Token)) != 1 {
+			dcontext.GetLogger(r.Context()).Warnf("unauthorized admin request: %s %s", r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="registry-admin"`)
+			serveAdminError(r, w, errcode.ErrorCodeUnauthorized)
+			return
+		}
+
+		dcontext.GetLogger(r.Context()).Infof("admin request: %s %s", r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveAdminError writes err as a JSON error response.
+func serveAdminError(r *http.Request, w http.ResponseWriter, err error) {
+	if err := errcode.ServeJSON(w, err); err != nil {
+		dcontext.GetLogger(r.Context()).Errorf("error serving error json: %v", err)
+	}
+}
+
+// serveAdminJSON writes v as a JSON response with the given status.
+func serveAdminJSON(r *http.Request, w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		dcontext.GetLogger(r.Context()).Errorf("error encoding admin response: %v", err)
+	}
+}
+
+// robotRequest is the body of a robot creation request.
+type robotRequest struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Scopes      []robot.Scope `json:"scopes"`
+	Groups      []string      `json:"groups,omitempty"`
+	// ExpiresIn is a duration, such as "720h".
+	ExpiresIn string `json:"expiresIn"`
+}
+
+// robotResponse describes a robot without its secret hash. Secret is only
+// set in the response to a creation request.
+type robotResponse struct {
+	Name        string        `json:"name"`
+	UserName    string        `json:"username"`
+	Description string        `json:"description,omitempty"`
+	Scopes      []robot.Scope `json:"scopes"`
+	Groups      []string      `json:"groups,omitempty"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	ExpiresAt   time.Time     `json:"expiresAt"`
+	Expired     bool          `json:"expired"`
+	Secret      string        `json:"secret,omitempty"`
+}
+
+func newRobotResponse(r *robot.Robot) robotResponse {
+	return robotResponse{
+		Name:        r.Name,
+		UserName:    r.UserName(),
+		Description: r.Description,
+		Scopes:      r.Scopes,
+		Groups:      r.Groups,
+		CreatedAt:   r.CreatedAt,
+		ExpiresAt:   r.ExpiresAt,
+		Expired:     r.Expired(),
+	}
+}
+
+func (app *App) listRobots(w http.ResponseWriter, r *http.Request) {
+	robots, err := app.robots.List(r.Context())
+	if err != nil {
+		serveAdminError(r, w, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	response := struct {
+		Robots []robotResponse `json:"robots"`
+	}{Robots: make([]robotResponse, 0, len(robots))}
+	for _, rb := range robots {
+		response.Robots = append(response.Robots, newRobotResponse(rb))
+	}
+
+	serveAdminJSON(r, w, http.StatusOK, response)
+}
+
+func (app *App) getRobot(w http.ResponseWriter, r *http.Request) {
+	rb, err := app.robots.Get(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		if err == robot.ErrRobotUnknown {
+			serveAdminError(r, w, errorCodeAdminUnknown.WithDetail(mux.Vars(r)["name"]))
+			return
+		}
+		serveAdminError(r, w, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	serveAdminJSON(r, w, http.StatusOK, newRobotResponse(rb))
+}
+
+func (app *App) createRobot(w http.ResponseWriter, r *http.Request) {
+	var req robotRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		serveAdminError(r, w, errorCodeAdminInvalid.WithDetail(err.Error()))
+		return
+	}
+
+	ttl, err := time.ParseDuration(req.ExpiresIn)
+	if err != nil {
+		serveAdminError(r, w, errorCodeAdminInvalid.WithDetail(fmt.Sprintf("invalid expiresIn: %v", err)))
+		return
+	}
+
+	rb, secret, err := robot.New(req.Name, req.Description, req.Scopes, ttl)
+	if err != nil {
+		serveAdminError(r, w, errorCodeAdminInvalid.WithDetail(err.Error()))
+		return
+	}
+	rb.Groups = req.Groups
+
+	if err := app.robots.Create(r.Context(), rb); err != nil {
+		if err == robot.ErrRobotExists {
+			serveAdminError(r, w, errorCodeAdminConflict.WithDetail(req.Name))
+			return
+		}
+		serveAdminError(r, w, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	dcontext.GetLogger(r.Context()).Infof("created robot %q, expires at %s", rb.Name, rb.ExpiresAt)
+
+	response := newRobotResponse(rb)
+	response.Secret = secret
+	serveAdminJSON(r, w, http.StatusCreated, response)
+}
+
+func (app *App) revokeRobot(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := app.robots.Delete(r.Context(), name); err != nil {
+		if err == robot.ErrRobotUnknown {
+			serveAdminError(r, w, errorCodeAdminUnknown.WithDetail(name))
+			return
+		}
+		serveAdminError(r, w, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	dcontext.GetLogger(r.Context()).Infof("revoked robot %q", name)
+	w.WriteHeader(http.StatusNoContent)
+}
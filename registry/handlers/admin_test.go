@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/auth/robot"
+)
+
+func TestAdminRobots(t *testing.T) {
+	ctx := dcontext.Background()
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+	}
+	config.Robots.Enabled = true
+	config.Admin.Token = "admin-token"
+
+	app := NewApp(ctx, &config)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	do := func(method, path, token string, body interface{}) *http.Response {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req, err := http.NewRequest(method, server.URL+path, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := do(http.MethodGet, "/admin/robots", "wrong", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status for wrong admin token: %d", resp.StatusCode)
+	}
+
+	create := robotRequest{
+		Name:      "builder",
+		Scopes:    []robot.Scope{{Repository: "ci/*", Actions: []string{"pull"}}},
+		ExpiresIn: "1h",
+	}
+	resp = do(http.MethodPost, "/admin/robots", "admin-token", create)
+	var created robotResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || created.Secret == "" || created.UserName != "robot$builder" {
+		t.Fatalf("unexpected create response %d: %+v", resp.StatusCode, created)
+	}
+
+	resp = do(http.MethodPost, "/admin/robots", "admin-token", create)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("unexpected status creating duplicate robot: %d", resp.StatusCode)
+	}
+
+	create.ExpiresIn = "soon"
+	resp = do(http.MethodPost, "/admin/robots", "admin-token", create)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status creating invalid robot: %d", resp.StatusCode)
+	}
+
+	resp = do(http.MethodGet, "/admin/robots", "admin-token", nil)
+	var list struct {
+		Robots []robotResponse `json:"robots"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(list.Robots) != 1 || list.Robots[0].Name != "builder" || list.Robots[0].Secret != "" {
+		t.Fatalf("unexpected robot list: %+v", list)
+	}
+
+	pull := func() int {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/v2/ci/app/tags/list", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(created.UserName, created.Secret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := pull(); status != http.StatusNotFound {
+		t.Fatalf("unexpected status pulling with robot credentials: %d", status)
+	}
+
+	resp = do(http.MethodDelete, "/admin/robots/builder", "admin-token", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status revoking robot: %d", resp.StatusCode)
+	}
+
+	resp = do(http.MethodDelete, "/admin/robots/builder", "admin-token", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status revoking unknown robot: %d", resp.StatusCode)
+	}
+
+	if status := pull(); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status pulling with revoked robot credentials: %d", status)
+	}
+}
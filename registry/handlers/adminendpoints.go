@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultDeadLetterLimit is the number of dead-lettered events listed
+	// when the request has no limit.
+	defaultDeadLetterLimit = 100
+
+	// maxDeadLetterLimit is the largest number of dead-lettered events
+	// listed in a single response.
+	maxDeadLetterLimit = 1000
+)
+
+// registerAdminEndpoints mounts the notification endpoint routes of the
+// admin API on router.
+func (app *App) registerAdminEndpoints(router *mux.Router) {
+	router.Path("/notifications/endpoints").Methods(http.MethodGet).HandlerFunc(app.listEndpoints)
+	router.Path("/notifications/endpoints/{name}").Methods(http.MethodGet).HandlerFunc(app.getEndpoint)
+	router.Path("/notifications/endpoints/{name}/pause").Methods(http.MethodPost).HandlerFunc(app.pauseEndpoint)
+	router.Path("/notifications/endpoints/{name}/resume").Methods(http.MethodPost).HandlerFunc(app.resumeEndpoint)
+	router.Path("/notifications/endpoints/{name}/deadletters").Methods(http.MethodGet).HandlerFunc(app.listDeadLetters)
+	router.Path("/notifications/endpoints/{name}/deadletters/replay").Methods(http.MethodPost).HandlerFunc(app.replayDeadLetters)
+}
+
+// endpointResponse describes a notification endpoint and its metrics.
+type endpointResponse struct {
+	Name        string                        `json:"name"`
+	Type        string                        `json:"type"`
+	URL         string                        `json:"url,omitempty"`
+	Paused      bool                          `json:"paused"`
+	DeadLetters int                           `json:"deadLetters"`
+	Metrics     notifications.EndpointMetrics `json:"metrics"`
+}
+
+func newEndpointResponse(e *notifications.Endpoint) endpointResponse {
+	response := endpointResponse{
+		Name:        e.Name(),
+		Type:        e.Type(),
+		URL:         e.URL(),
+		Paused:      e.Paused(),
+		DeadLetters: e.DeadLetterCount(),
+	}
+	e.ReadMetrics(&response.Metrics)
+	return response
+}
+
+// replayRequest is the body of a dead-letter replay request. It selects the
+// dead-lettered events with sequence numbers from Start to End inclusive; a
+// zero End replays every event from Start.
+type replayRequest struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// endpoint returns the endpoint named in the request, serving an error if
+// there is none.
+func (app *App) endpoint(w http.ResponseWriter, r *http.Request) (*notifications.Endpoint, bool) {
+	name := mux.Vars(r)["name"]
+	for _, e := range app.events.endpoints {
+		if e.Name() == name {
+			return e, true
+		}
+	}
+
+	serveAdminError(r, w, errorCodeAdminUnknown.WithDetail(name))
+	return nil, false
+}
+
+func (app *App) listEndpoints(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Endpoints []endpointResponse `json:"endpoints"`
+	}{Endpoints: make([]endpointResponse, 0, len(app.events.endpoints))}
+	for _, e := range app.events.endpoints {
+		response.Endpoints = append(response.Endpoints, newEndpointResponse(e))
+	}
+
+	serveAdminJSON(r, w, http.StatusOK, response)
+}
+
+func (app *App) getEndpoint(w http.ResponseWriter, r *http.Request) {
+	e, ok := app.endpoint(w, r)
+	if !ok {
+		return
+	}
+
+	serveAdminJSON(r, w, http.StatusOK, newEndpointResponse(e))
+}
+
+func (app *App) pauseEndpoint(w http.ResponseWriter, r *http.Request) {
+	e, ok := app.endpoint(w, r)
+	if !ok {
+		return
+	}
+
+	e.Pause()
+	dcontext.GetLogger(r.Context()).Infof("paused notification endpoint %q", e.Name())
+	serveAdminJSON(r, w, http.StatusOK, newEndpointResponse(e))
+}
+
+func (app *App) resumeEndpoint(w http.ResponseWriter, r *http.Request) {
+	e, ok := app.endpoint(w, r)
+	if !ok {
+		return
+	}
+
+	e.Resume()
+	dcontext.GetLogger(r.Context()).Infof("resumed notification endpoint %q", e.Name())
+	serveAdminJSON(r, w, http.StatusOK, newEndpointResponse(e))
+}
+
+func (app *App) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	e, ok := app.endpoint(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	var (
+		start, end uint64
+		limit      = defaultDeadLetterLimit
+		err        error
+	)
+	if v := q.Get("start"); v != "" {
+		if start, err = strconv.ParseUint(v, 10, 64); err != nil {
+			serveAdminError(r, w, errorCodeAdminInvalid.WithDetail(fmt.Sprintf("invalid start: %v", err)))
+			return
+		}
+	}
+	if v := q.Get("end"); v != "" {
+		if end, err = strconv.ParseUint(v, 10, 64); err != nil {
+			serveAdminError(r, w, errorCodeAdminInvalid.WithDetail(fmt.Sprintf("invalid end: %v", err)))
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			serveAdminError(r, w, errorCodeAdminInvalid.WithDetail(fmt.Sprintf("invalid limit: %q", v)))
+			return
+		}
+	}
+	if limit > maxDeadLetterLimit {
+		limit = maxDeadLetterLimit
+	}
+
+	response := struct {
+		DeadLetters []notifications.QueuedEvent `json:"deadLetters"`
+	}{DeadLetters: e.DeadLetters(start, end, limit)}
+	if response.DeadLetters == nil {
+		response.DeadLetters = []notifications.QueuedEvent{}
+	}
+
+	serveAdminJSON(r, w, http.StatusOK, response)
+}
+
+func (app *App) replayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	e, ok := app.endpoint(w, r)
+	if !ok {
+		return
+	}
+
+	var req replayRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		serveAdminError(r, w, errorCodeAdminInvalid.WithDetail(err.Error()))
+		return
+	}
+	if req.End != 0 && req.End < req.Start {
+		serveAdminError(r, w, errorCodeAdminInvalid.WithDetail("end is before start"))
+		return
+	}
+
+	replayed, err := e.ReplayDeadLetters(req.Start, req.End)
+	if err != nil {
+		if err == notifications.ErrNoDeadLetterStore {
+			serveAdminError(r, w, errorCodeAdminInvalid.WithDetail(err.Error()))
+			return
+		}
+		dcontext.GetLogger(r.Context()).Errorf("error replaying dead letters of %q after %d events: %v", e.Name(), replayed, err)
+		serveAdminError(r, w, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	dcontext.GetLogger(r.Context()).Infof("replayed %d dead letters of notification endpoint %q", replayed, e.Name())
+	serveAdminJSON(r, w, http.StatusOK, struct {
+		Replayed int `json:"replayed"`
+	}{Replayed: replayed})
+}
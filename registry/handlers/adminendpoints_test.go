@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/notifications"
+)
+
+func TestAdminEndpoints(t *testing.T) {
+	var failing atomic.Bool
+	var received atomic.Int32
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received.Add(1)
+	}))
+	defer hook.Close()
+
+	ctx := dcontext.Background()
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Admin.Token = "admin-token"
+	config.Notifications.Endpoints = []configuration.Endpoint{{
+		Name:       "hook",
+		URL:        hook.URL,
+		Timeout:    time.Second,
+		Threshold:  100,
+		Backoff:    time.Millisecond,
+		DeadLetter: configuration.DeadLetter{MaxAttempts: 2, Type: "storage"},
+	}}
+
+	app := NewApp(ctx, &config)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	do := func(method, path string, body, response interface{}) int {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req, err := http.NewRequest(method, server.URL+path, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer admin-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if response != nil && resp.StatusCode < 300 {
+			if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var list struct {
+		Endpoints []endpointResponse `json:"endpoints"`
+	}
+	if status := do(http.MethodGet, "/admin/notifications/endpoints", nil, &list); status != http.StatusOK {
+		t.Fatalf("unexpected status listing endpoints: %d", status)
+	}
+	if len(list.Endpoints) != 1 || list.Endpoints[0].Name != "hook" || list.Endpoints[0].Type != "http" || list.Endpoints[0].Paused {
+		t.Fatalf("unexpected endpoints: %+v", list.Endpoints)
+	}
+
+	if status := do(http.MethodGet, "/admin/notifications/endpoints/unknown", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("unexpected status for unknown endpoint: %d", status)
+	}
+
+	var endpoint endpointResponse
+	if status := do(http.MethodPost, "/admin/notifications/endpoints/hook/pause", nil, &endpoint); status != http.StatusOK || !endpoint.Paused {
+		t.Fatalf("unexpected pause response %d: %+v", status, endpoint)
+	}
+	if status := do(http.MethodPost, "/admin/notifications/endpoints/hook/resume", nil, &endpoint); status != http.StatusOK || endpoint.Paused {
+		t.Fatalf("unexpected resume response %d: %+v", status, endpoint)
+	}
+
+	// an event exceeding the retry budget is dead-lettered
+	failing.Store(true)
+	event := notifications.Event{Action: notifications.EventActionPush}
+	event.Target.Repository = "library/test"
+	if err := app.events.sink.Write(event); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); endpoint.DeadLetters != 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for dead letter: %+v", endpoint)
+		}
+		do(http.MethodGet, "/admin/notifications/endpoints/hook", nil, &endpoint)
+	}
+
+	var deadLetters struct {
+		DeadLetters []notifications.QueuedEvent `json:"deadLetters"`
+	}
+	if status := do(http.MethodGet, "/admin/notifications/endpoints/hook/deadletters?start=1&limit=10", nil, &deadLetters); status != http.StatusOK {
+		t.Fatalf("unexpected status listing dead letters: %d", status)
+	}
+	if len(deadLetters.DeadLetters) != 1 || deadLetters.DeadLetters[0].Seq != 1 || deadLetters.DeadLetters[0].Event.Target.Repository != "library/test" {
+		t.Fatalf("unexpected dead letters: %+v", deadLetters.DeadLetters)
+	}
+	if status := do(http.MethodGet, "/admin/notifications/endpoints/hook/deadletters?limit=none", nil, nil); status != http.StatusBadRequest {
+		t.Fatalf("unexpected status for invalid limit: %d", status)
+	}
+
+	failing.Store(false)
+	var replay struct {
+		Replayed int `json:"replayed"`
+	}
+	if status := do(http.MethodPost, "/admin/notifications/endpoints/hook/deadletters/replay", replayRequest{Start: 1, End: 1}, &replay); status != http.StatusOK || replay.Replayed != 1 {
+		t.Fatalf("unexpected replay response %d: %+v", status, replay)
+	}
+	for deadline := time.Now().Add(5 * time.Second); received.Load() != 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for replayed event")
+		}
+	}
+
+	do(http.MethodGet, "/admin/notifications/endpoints/hook", nil, &endpoint)
+	if endpoint.DeadLetters != 0 || endpoint.Metrics.DeadLetters != 1 {
+		t.Fatalf("unexpected endpoint after replay: %+v", endpoint)
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// anonymousAccess decides whether a request without credentials may proceed
+// without consulting the access controller.
+type anonymousAccess struct {
+	repositories []string
+	catalog      bool
+}
+
+// newAnonymousAccess validates the configured repository patterns. It
+// returns nil if no anonymous access is configured.
+func newAnonymousAccess(config configuration.Anonymous) (*anonymousAccess, error) {
+	if len(config.Repositories) == 0 && !config.Catalog {
+		return nil, nil
+	}
+
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid anonymous repository pattern %q: %v", pattern, err)
+		}
+	}
+
+	return &anonymousAccess{
+		repositories: config.Repositories,
+		catalog:      config.Catalog,
+	}, nil
+}
+
+// grant returns a grant for an anonymous user if the request carries no
+// credentials and every requested access is allowed anonymously. Otherwise
+// it returns nil and the request goes through the access controller.
+func (aa *anonymousAccess) grant(r *http.Request, accessRecords []auth.Access) *auth.Grant {
+	if aa == nil || len(accessRecords) == 0 || r.Header.Get("Authorization") != "" {
+		return nil
+	}
+
+	var resources []auth.Resource
+	for _, access := range accessRecords {
+		if !aa.allows(access) {
+			return nil
+		}
+		resources = append(resources, access.Resource)
+	}
+
+	return &auth.Grant{
+		User: auth.UserInfo{
+			Name: auth.AnonymousUserName,
+			Type: auth.UserTypeAnonymous,
+		},
+		Resources: resources,
+	}
+}
+
+// allows returns true if access is granted without credentials.
+func (aa *anonymousAccess) allows(access auth.Access) bool {
+	switch access.Type {
+	case "repository":
+		if access.Action != "pull" {
+			return false
+		}
+		for _, pattern := range aa.repositories {
+			if matched, _ := path.Match(pattern, access.Name); matched {
+				return true
+			}
+		}
+	case "registry":
+		return aa.catalog && access.Name == "catalog" && access.Action == "*"
+	}
+
+	return false
+}
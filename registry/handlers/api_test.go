@@ -18,6 +18,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
@@ -27,12 +28,13 @@ import (
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
-	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/distribution/reference"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 var headerConfig = http.Header{
@@ -1266,6 +1268,332 @@ func TestStartPushReadOnly(t *testing.T) {
 	checkResponse(t, "starting push in read-only mode", resp, http.StatusMethodNotAllowed)
 }
 
+type presigningDriverFactory struct {
+	driver storagedriver.StorageDriver
+}
+
+func (factory *presigningDriverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return factory.driver, nil
+}
+
+// presigningDriver presigns part uploads to a test server, which appends the
+// parts to the content in the order they are uploaded.
+type presigningDriver struct {
+	storagedriver.StorageDriver
+	server *httptest.Server
+}
+
+func (d *presigningDriver) PresignUploadParts(ctx context.Context, path string, partNumbers []int, expiry time.Duration) ([]string, error) {
+	urls := make([]string, 0, len(partNumbers))
+	for _, n := range partNumbers {
+		urls = append(urls, fmt.Sprintf("%s%s?partNumber=%d", d.server.URL, path, n))
+	}
+	return urls, nil
+}
+
+func newPresigningDriver() *presigningDriver {
+	d := &presigningDriver{StorageDriver: inmemory.New()}
+	d.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fw, err := d.Writer(r.Context(), r.URL.Path, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer fw.Close()
+		if _, err := io.Copy(fw, r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := fw.Commit(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+	return d
+}
+
+func TestBlobUploadPresignedParts(t *testing.T) {
+	d := newPresigningDriver()
+	defer d.server.Close()
+	factory.Register("presigning", &presigningDriverFactory{driver: d})
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"presigning": configuration.Parameters{},
+			"redirect":   configuration.Parameters{"uploads": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	if err != nil {
+		t.Fatalf("unexpected error building layer upload url: %v", err)
+	}
+	resp, err := http.Post(layerUploadURL, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error starting layer push: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "starting layer push", resp, http.StatusAccepted)
+
+	partsURL := resp.Header.Get("Docker-Upload-Parts-Location")
+	if partsURL == "" {
+		t.Fatalf("expected a Docker-Upload-Parts-Location header")
+	}
+
+	resp, err = http.Post(partsURL+"&count=0", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error presigning parts: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "presigning no parts", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "presigning no parts", resp, errcode.ErrorCodeBlobUploadInvalid)
+
+	resp, err = http.Post(partsURL+"&count=2", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error presigning parts: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "presigning parts", resp, http.StatusOK)
+
+	var parts presignedPartsAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parts); err != nil {
+		t.Fatalf("error decoding presigned parts: %v", err)
+	}
+	if len(parts.Parts) != 2 || parts.Parts[0].PartNumber != 1 || parts.Parts[1].PartNumber != 2 {
+		t.Fatalf("unexpected presigned parts: %v", parts.Parts)
+	}
+	uploadURL := resp.Header.Get("Location")
+
+	content := []byte("presigned layer content")
+	dgst := digest.FromBytes(content)
+	for i, part := range [][]byte{content[:10], content[10:]} {
+		req, err := http.NewRequest(http.MethodPut, parts.Parts[i].URL, bytes.NewReader(part))
+		if err != nil {
+			t.Fatalf("unexpected error creating part request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error uploading part: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status uploading part: %d", resp.StatusCode)
+		}
+	}
+
+	// data cannot be mixed with presigned parts
+	resp, err = doPushChunk(t, uploadURL, bytes.NewReader([]byte("chunk")), chunkOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error pushing chunk: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "pushing chunk to presigned upload", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "pushing chunk to presigned upload", resp, errcode.ErrorCodeBlobUploadInvalid)
+
+	layerURL := finishUpload(t, env.builder, imageName, uploadURL, dgst)
+
+	resp, err = http.Get(layerURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching layer: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching layer", resp, http.StatusOK)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading layer: %v", err)
+	}
+	if !bytes.Equal(body, content) {
+		t.Fatalf("unexpected layer content: %q != %q", body, content)
+	}
+}
+
+func TestBlobUploadPresignedPartsDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	location, uuid := startPushLayer(t, env, imageName)
+
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatalf("error parsing location: %v", err)
+	}
+	partsURL, err := env.builder.BuildBlobUploadPartsURL(imageName, uuid, url.Values{
+		"_state": u.Query()["_state"],
+		"count":  []string{"1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error building parts url: %v", err)
+	}
+
+	resp, err := http.Post(partsURL, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error presigning parts: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "presigning parts", resp, http.StatusMethodNotAllowed)
+}
+
+func TestOCILayout(t *testing.T) {
+	root := t.TempDir()
+	dir := path.Join(root, "library", "ubuntu")
+	writeBlob := func(p []byte) v1.Descriptor {
+		dgst := digest.FromBytes(p)
+		if err := os.MkdirAll(path.Join(dir, "blobs", "sha256"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(dir, "blobs", "sha256", dgst.Encoded()), p, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return v1.Descriptor{Digest: dgst, Size: int64(len(p))}
+	}
+	configDesc := writeBlob([]byte(`{"architecture":"amd64","os":"linux"}`))
+	configDesc.MediaType = v1.MediaTypeImageConfig
+	layer := writeBlob([]byte("layer"))
+	layer.MediaType = v1.MediaTypeImageLayer
+	manifest, err := json.Marshal(v1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []v1.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestDesc := writeBlob(manifest)
+	manifestDesc.MediaType = v1.MediaTypeImageManifest
+	manifestDesc.Annotations = map[string]string{v1.AnnotationRefName: "latest"}
+	index, err := json.Marshal(v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []v1.Descriptor{manifestDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "index.json"), index, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Catalog: configuration.Catalog{
+			MaxEntries: 5,
+		},
+		OCILayout: configuration.OCILayout{RootDirectory: root},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("library/ubuntu")
+
+	catalogURL, err := env.builder.BuildCatalogURL()
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+	resp, err := http.Get(catalogURL)
+	if err != nil {
+		t.Fatalf("unexpected error getting catalog: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting catalog", resp, http.StatusOK)
+	var catalog catalogAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&catalog); err != nil {
+		t.Fatalf("error decoding catalog: %v", err)
+	}
+	if !reflect.DeepEqual(catalog.Repositories, []string{"library/ubuntu"}) {
+		t.Fatalf("unexpected repositories: %v", catalog.Repositories)
+	}
+
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	if err != nil {
+		t.Fatalf("unexpected error building tags url: %v", err)
+	}
+	resp, err = http.Get(tagsURL)
+	if err != nil {
+		t.Fatalf("unexpected error getting tags: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting tags", resp, http.StatusOK)
+	var tags tagsAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		t.Fatalf("error decoding tags: %v", err)
+	}
+	if !reflect.DeepEqual(tags.Tags, []string{"latest"}) {
+		t.Fatalf("unexpected tags: %v", tags.Tags)
+	}
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, manifestURL, nil)
+	req.Header.Set("Accept", v1.MediaTypeImageManifest)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting manifest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{manifestDesc.Digest.String()},
+		"Content-Type":          []string{v1.MediaTypeImageManifest},
+	})
+	p, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	if !bytes.Equal(p, manifest) {
+		t.Fatalf("unexpected manifest: %s", p)
+	}
+
+	layerRef, _ := reference.WithDigest(imageName, layer.Digest)
+	blobURL, err := env.builder.BuildBlobURL(layerRef)
+	if err != nil {
+		t.Fatalf("unexpected error building blob url: %v", err)
+	}
+	resp, err = http.Get(blobURL)
+	if err != nil {
+		t.Fatalf("unexpected error getting blob: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting blob", resp, http.StatusOK)
+	p, err = io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading blob: %v", err)
+	}
+	if string(p) != "layer" {
+		t.Fatalf("unexpected blob: %s", p)
+	}
+
+	// pushes are rejected
+	uploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	if err != nil {
+		t.Fatalf("unexpected error building upload url: %v", err)
+	}
+	resp, err = http.Post(uploadURL, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "starting upload", resp, http.StatusMethodNotAllowed)
+}
+
 func httpDelete(url string) (*http.Response, error) {
 	req, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
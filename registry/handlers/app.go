@@ -5,14 +5,17 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"expvar"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -24,13 +27,16 @@ import (
 	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/health/checks"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/requestutil"
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/robot"
 	registrymiddleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
+	"github.com/distribution/distribution/v3/registry/ocilayout"
 	"github.com/distribution/distribution/v3/registry/proxy"
 	"github.com/distribution/distribution/v3/registry/storage"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
@@ -65,9 +71,12 @@ type App struct {
 
 	router           *mux.Router                    // main application router, configured with dispatchers
 	driver           storagedriver.StorageDriver    // driver maintains the app global storage driver instance.
+	driverClosers    []io.Closer                    // driverClosers are the storage middlewares to close on shutdown.
 	registry         distribution.Namespace         // registry is the primary registry backend for the app instance.
 	repoRemover      distribution.RepositoryRemover // repoRemover provides ability to delete repos
 	accessController auth.AccessController          // main access controller for application
+	anonymous        *anonymousAccess               // access granted to requests without credentials
+	robots           robot.Store                    // robot accounts, if enabled
 
 	// httpHost is a parsed representation of the http.host parameter from
 	// the configuration. Only the Scheme and Host fields are used.
@@ -75,8 +84,9 @@ type App struct {
 
 	// events contains notification related configuration.
 	events struct {
-		sink   events.Sink
-		source notifications.SourceRecord
+		sink      events.Sink
+		source    notifications.SourceRecord
+		endpoints []*notifications.Endpoint
 	}
 
 	redis redis.UniversalClient
@@ -86,6 +96,10 @@ type App struct {
 
 	// readOnly is true if the registry is in a read-only maintenance mode
 	readOnly bool
+
+	// presignUploads is true if clients may upload blob data directly to the
+	// storage backend, through presigned URLs
+	presignUploads bool
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -109,6 +123,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	app.register(v2.RouteNameBlob, blobDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
+	app.register(v2.RouteNameBlobUploadParts, blobUploadPartsDispatcher)
 
 	// override the storage driver's UA string for registry outbound HTTP requests
 	storageParams := config.Storage.Parameters()
@@ -150,9 +165,10 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
-	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
+	// The upload purger works on the storage driver without middleware.
+	purgeDriver := app.driver
 
-	app.driver, err = applyStorageMiddleware(app, app.driver, config.Middleware["storage"])
+	app.driver, app.driverClosers, err = storagemiddleware.Apply(app, app.driver, config.Middleware["storage"])
 	if err != nil {
 		panic(err)
 	}
@@ -162,10 +178,16 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	if !app.isCache {
 		app.configureSecret(config)
 	}
-	app.configureEvents(config)
 	app.configureRedis(config)
+	app.configureEvents(config)
 	app.configureLogHook(config)
 
+	var purgeListener notifications.MaintenanceListener
+	if config.Notifications.EventConfig.IncludeUploads {
+		purgeListener = notifications.NewMaintenanceBridge(app.events.source, notifications.ActorRecord{Name: "upload-purger"}, app.events.sink)
+	}
+	startUploadPurger(app, purgeDriver, dcontext.GetLogger(app), purgeConfig, purgeListener)
+
 	options := registrymiddleware.GetRegistryOptions()
 
 	if config.HTTP.Host != "" {
@@ -206,14 +228,17 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 
 	// configure redirects
-	var redirectDisabled bool
+	var redirectDisabled, redirectUploads bool
 	if redirectConfig, ok := config.Storage["redirect"]; ok {
-		v := redirectConfig["disable"]
-		switch v := v.(type) {
-		case bool:
-			redirectDisabled = v
-		default:
-			panic(fmt.Sprintf("invalid type for redirect config: %#v", redirectConfig))
+		if v, ok := redirectConfig["disable"]; ok {
+			if redirectDisabled, ok = v.(bool); !ok {
+				panic(fmt.Sprintf("invalid type for redirect config: %#v", redirectConfig))
+			}
+		}
+		if v, ok := redirectConfig["uploads"]; ok {
+			if redirectUploads, ok = v.(bool); !ok {
+				panic(fmt.Sprintf("invalid type for redirect config: %#v", redirectConfig))
+			}
 		}
 	}
 	if redirectDisabled {
@@ -221,6 +246,14 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	} else {
 		options = append(options, storage.EnableRedirect)
 	}
+	if redirectUploads {
+		if _, ok := app.driver.(storagedriver.UploadPresigner); ok {
+			app.presignUploads = true
+			dcontext.GetLogger(app).Infof("backend upload redirection enabled")
+		} else {
+			dcontext.GetLogger(app).Warnf("backend upload redirection not supported by the %s storage driver or hidden by its storage middleware", app.driver.Name())
+		}
+	}
 
 	if !config.Validation.Enabled {
 		config.Validation.Enabled = !config.Validation.Disabled
@@ -330,6 +363,20 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	// serve the repositories from OCI image layouts instead of the storage
+	if root := config.OCILayout.RootDirectory; root != "" {
+		if config.Proxy.RemoteURL != "" {
+			panic("ocilayout cannot be configured with proxy")
+		}
+		app.registry, err = ocilayout.NewRegistry(app, root)
+		if err != nil {
+			panic("could not create registry: " + err.Error())
+		}
+		// the layouts are only updated outside of the registry
+		app.readOnly = true
+		dcontext.GetLogger(app).Infof("serving repositories from the OCI image layouts in %s", root)
+	}
+
 	app.registry, err = applyRegistryMiddleware(app, app.registry, app.driver, config.Middleware["registry"])
 	if err != nil {
 		panic(err)
@@ -338,7 +385,17 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	authType := config.Auth.Type()
 
 	if authType != "" && !strings.EqualFold(authType, "none") {
-		accessController, err := auth.GetAccessController(config.Auth.Type(), config.Auth.Parameters())
+		authParams := make(map[string]interface{})
+		for k, v := range config.Auth.Parameters() {
+			authParams[k] = v
+		}
+		// share the redis client with access controllers that can use it,
+		// e.g. for token revocation lists.
+		if app.redis != nil {
+			authParams["redis"] = app.redis
+		}
+
+		accessController, err := auth.GetAccessController(config.Auth.Type(), authParams)
 		if err != nil {
 			panic(fmt.Sprintf("unable to configure authorization (%s): %v", authType, err))
 		}
@@ -346,6 +403,30 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		dcontext.GetLogger(app).Debugf("configured %q access controller", authType)
 	}
 
+	if config.Robots.Enabled {
+		app.robots, err = robot.NewStore(config.Robots.Store, app.driver, app.redis)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure robot accounts: %v", err))
+		}
+		realm := config.Robots.Realm
+		if realm == "" {
+			realm = "registry"
+		}
+		app.accessController = robot.NewAccessController(realm, app.robots, app.accessController)
+		dcontext.GetLogger(app).Debugf("configured robot accounts with %q store", config.Robots.Store)
+	}
+
+	if app.accessController != nil {
+		app.anonymous, err = newAnonymousAccess(config.Policy.Anonymous)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if config.Admin.Token != "" {
+		app.registerAdmin(config.HTTP.Prefix)
+	}
+
 	// configure as a pull through cache
 	if config.Proxy.RemoteURL != "" {
 		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
@@ -447,12 +528,15 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 	}
 }
 
-// Shutdown close the underlying registry
+// Shutdown close the underlying registry and the storage middlewares holding
+// resources
 func (app *App) Shutdown() error {
+	var errs []error
 	if r, ok := app.registry.(proxy.Closer); ok {
-		return r.Close()
+		errs = append(errs, r.Close())
 	}
-	return nil
+	errs = append(errs, storagemiddleware.Close(app.driverClosers))
+	return errors.Join(errs...)
 }
 
 // register a handler with the application, by route name. The handler will be
@@ -493,17 +577,67 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 			continue
 		}
 
-		dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
-		endpoint := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
+		if err := notifications.CheckFormat(endpoint.Format, endpoint.CloudEvents.Mode); err != nil {
+			panic(fmt.Sprintf("unable to configure endpoint %s: %v", endpoint.Name, err))
+		}
+		if err := notifications.CheckBatch(endpoint.Format, endpoint.CloudEvents.Mode, endpoint.Batch); err != nil {
+			panic(fmt.Sprintf("unable to configure endpoint %s: %v", endpoint.Name, err))
+		}
+		if err := notifications.CheckFilter(endpoint.Filter); err != nil {
+			panic(fmt.Sprintf("unable to configure endpoint %s: %v", endpoint.Name, err))
+		}
+
+		queueStore, err := app.configureEventQueue(endpoint)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure queue for endpoint %s: %v", endpoint.Name, err))
+		}
+		deadLetterStore, err := app.configureDeadLetters(endpoint)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure dead letters for endpoint %s: %v", endpoint.Name, err))
+		}
+
+		config := notifications.EndpointConfig{
 			Timeout:           endpoint.Timeout,
 			Threshold:         endpoint.Threshold,
 			Backoff:           endpoint.Backoff,
 			Headers:           endpoint.Headers,
+			Secrets:           endpointSecrets(endpoint),
 			IgnoredMediaTypes: endpoint.IgnoredMediaTypes,
 			Ignore:            endpoint.Ignore,
-		})
+			Filter:            endpoint.Filter,
+			Queue:             endpoint.Queue,
+			QueueStore:        queueStore,
+			Format:            endpoint.Format,
+			CloudEvents:       endpoint.CloudEvents,
+			Batch:             endpoint.Batch,
+			DeadLetter:        endpoint.DeadLetter,
+			DeadLetterStore:   deadLetterStore,
+		}
+
+		if endpoint.Type == "" || endpoint.Type == "http" {
+			dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
+			sink := notifications.NewEndpoint(endpoint.Name, endpoint.URL, config)
+			sinks = append(sinks, sink)
+			app.events.endpoints = append(app.events.endpoints, sink)
+			continue
+		}
 
-		sinks = append(sinks, endpoint)
+		params := make(map[string]interface{})
+		for k, v := range endpoint.Parameters {
+			params[k] = v
+		}
+		// share the redis client with sinks that can use it
+		if app.redis != nil {
+			params["redis"] = app.redis
+		}
+
+		dcontext.GetLogger(app).Infof("configuring %s endpoint %v", endpoint.Type, endpoint.Name)
+		sink, err := notifications.NewSinkEndpoint(endpoint.Name, endpoint.Type, params, config)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure endpoint %s: %v", endpoint.Name, err))
+		}
+		sinks = append(sinks, sink)
+		app.events.endpoints = append(app.events.endpoints, sink)
 	}
 
 	// NOTE(stevvooe): Moving to a new queuing implementation is as easy as
@@ -530,6 +664,72 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	}
 }
 
+// endpointSecrets returns the secrets signing the requests to a notification
+// endpoint.
+func endpointSecrets(endpoint configuration.Endpoint) []string {
+	var secrets []string
+	if endpoint.Secret != "" {
+		secrets = append(secrets, endpoint.Secret)
+	}
+	return append(secrets, endpoint.Secrets...)
+}
+
+// configureEventQueue opens the store of the pending events of a
+// notification endpoint. It returns nil for in-memory queues.
+func (app *App) configureEventQueue(endpoint configuration.Endpoint) (notifications.QueueStore, error) {
+	switch endpoint.Queue.DropPolicy {
+	case "", "newest", "oldest":
+	default:
+		return nil, fmt.Errorf("unknown drop policy %q", endpoint.Queue.DropPolicy)
+	}
+
+	switch endpoint.Queue.Type {
+	case "", "memory":
+		return nil, nil
+	case "disk", "storage":
+		return app.openEventStore(endpoint.Queue.Type, endpoint.Queue.Path, path.Join("/docker/registry/notifications", endpoint.Name))
+	default:
+		return nil, fmt.Errorf("unknown queue type %q", endpoint.Queue.Type)
+	}
+}
+
+// configureDeadLetters opens the store of the events exceeding the retry
+// budget of a notification endpoint. It returns nil if they are dropped.
+func (app *App) configureDeadLetters(endpoint configuration.Endpoint) (notifications.QueueStore, error) {
+	if endpoint.DeadLetter.MaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid maxattempts %d", endpoint.DeadLetter.MaxAttempts)
+	}
+
+	switch endpoint.DeadLetter.Type {
+	case "":
+		return nil, nil
+	case "disk", "storage":
+		return app.openEventStore(endpoint.DeadLetter.Type, endpoint.DeadLetter.Path, path.Join("/docker/registry/deadletters", endpoint.Name))
+	default:
+		return nil, fmt.Errorf("unknown dead letter type %q", endpoint.DeadLetter.Type)
+	}
+}
+
+// openEventStore opens a "disk" store at the log file p, or a "storage"
+// store at the directory p in the storage driver, defaulting to root. The
+// events of a "storage" store are kept in a directory of their own for each
+// registry instance, named after its hostname so that it is found again after
+// a restart.
+func (app *App) openEventStore(kind, p, root string) (notifications.QueueStore, error) {
+	if kind == "disk" {
+		return notifications.NewFileQueueStore(p)
+	}
+
+	if p == "" {
+		p = root
+	}
+	instance, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("unable to name the store of the instance: %v", err)
+	}
+	return notifications.NewDriverQueueStore(app, app.driver, p, instance)
+}
+
 func (app *App) configureRedis(cfg *configuration.Configuration) {
 	if len(cfg.Redis.Options.Addrs) == 0 {
 		dcontext.GetLogger(app).Infof("redis not configured")
@@ -691,7 +891,11 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		}
 
 		// Add username to request logging
-		context.Context = dcontext.WithLogger(context.Context, dcontext.GetLogger(context.Context, userNameKey))
+		context.Context = dcontext.WithLogger(context.Context, dcontext.GetLogger(context.Context,
+			userNameKey,
+			userTypeKey,
+			userGroupsKey,
+			userClaimsKey))
 
 		// sync up context on the request.
 		r = r.WithContext(context)
@@ -732,7 +936,7 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 			context.Repository, context.RepositoryRemover = notifications.Listen(
 				repository,
 				context.App.repoRemover,
-				app.eventBridge(context, r))
+				app.eventBridge(context, r, repository))
 
 			context.Repository, err = applyRepoMiddleware(app, context.Repository, app.Config.Middleware["repository"])
 			if err != nil {
@@ -853,7 +1057,15 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 		accessRecords = appendCatalogAccessRecord(accessRecords, r)
 	}
 
-	grant, err := app.accessController.Authorized(r.WithContext(context.Context), accessRecords...)
+	var (
+		grant *auth.Grant
+		err   error
+	)
+	// requests without credentials may be granted access without
+	// consulting the access controller, if configured.
+	if grant = app.anonymous.grant(r, accessRecords); grant == nil {
+		grant, err = app.accessController.Authorized(r.WithContext(context.Context), accessRecords...)
+	}
 	if err != nil {
 		switch err := err.(type) {
 		case auth.Challenge:
@@ -878,10 +1090,10 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 		return fmt.Errorf("access controller returned neither an access grant nor an error")
 	}
 
-	ctx := withUser(context.Context, grant.User)
+	ctx := withUser(context.Context, withClientCertificate(grant.User, r))
 	ctx = withResources(ctx, grant.Resources)
 
-	dcontext.GetLogger(ctx, userNameKey).Info("authorized request")
+	dcontext.GetLogger(ctx, userNameKey, userTypeKey).Info("authorized request")
 	// TODO(stevvooe): This pattern needs to be cleaned up a bit. One context
 	// should be replaced by another, rather than replacing the context on a
 	// mutable object.
@@ -891,13 +1103,27 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 
 // eventBridge returns a bridge for the current request, configured with the
 // correct actor and source.
-func (app *App) eventBridge(ctx *Context, r *http.Request) notifications.Listener {
+func (app *App) eventBridge(ctx *Context, r *http.Request, repository distribution.Repository) notifications.Listener {
 	actor := notifications.ActorRecord{
 		Name: getUserName(ctx, r),
+		IP:   requestutil.RemoteIP(r),
+	}
+	if user, ok := getUser(ctx); ok {
+		actor.Type = user.Type
+		actor.Groups = user.Groups
+		actor.Claims = user.Claims
 	}
 	request := notifications.NewRequestRecord(dcontext.GetRequestID(ctx), r)
 
-	return notifications.NewBridge(ctx.urlBuilder, app.events.source, actor, request, app.events.sink, app.Config.Notifications.EventConfig.IncludeReferences)
+	config := app.Config.Notifications.EventConfig
+	return notifications.NewBridge(ctx.urlBuilder, app.events.source, actor, request, app.events.sink, config.IncludeReferences,
+		notifications.WithContext(ctx),
+		notifications.WithEvents(config),
+		// image configurations are read from the undecorated repository,
+		// so that describing an image does not produce pull events
+		notifications.WithBlobs(func(ctx context.Context) distribution.BlobProvider {
+			return repository.Blobs(ctx)
+		}))
 }
 
 // nameRequired returns true if the route requires a name.
@@ -999,18 +1225,6 @@ func applyRepoMiddleware(ctx context.Context, repository distribution.Repository
 	return repository, nil
 }
 
-// applyStorageMiddleware wraps a storage driver with the configured middlewares
-func applyStorageMiddleware(ctx context.Context, driver storagedriver.StorageDriver, middlewares []configuration.Middleware) (storagedriver.StorageDriver, error) {
-	for _, mw := range middlewares {
-		smw, err := storagemiddleware.Get(ctx, mw.Name, mw.Options, driver)
-		if err != nil {
-			return nil, fmt.Errorf("unable to configure storage middleware (%s): %v", mw.Name, err)
-		}
-		driver = smw
-	}
-	return driver, nil
-}
-
 // uploadPurgeDefaultConfig provides a default configuration for upload
 // purging to be used in the absence of configuration in the
 // configuration file
@@ -1028,8 +1242,9 @@ func badPurgeUploadConfig(reason string) {
 }
 
 // startUploadPurger schedules a goroutine which will periodically
-// check upload directories for old files and delete them
-func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}) {
+// check upload directories for old files and delete them. If listener is
+// set, it is notified of each purged upload.
+func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}, listener notifications.MaintenanceListener) {
 	if config["enabled"] == false {
 		return
 	}
@@ -1089,7 +1304,18 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		time.Sleep(jitter)
 
 		for {
-			storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
+			deleted, _ := storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
+			if listener != nil && !dryRunBool {
+				for _, p := range deleted {
+					name, id, ok := storage.UploadFromPath(p)
+					if !ok {
+						continue
+					}
+					if err := listener.UploadPurged(name, id); err != nil {
+						log.Errorf("error dispatching upload purge to listener: %v", err)
+					}
+				}
+			}
 			log.Infof("Starting upload purge in %s", intervalDuration)
 			time.Sleep(intervalDuration)
 		}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -275,3 +277,149 @@ func TestAppendAccessRecords(t *testing.T) {
 		t.Fatal("Actual access record differs from expected")
 	}
 }
+
+// TestAnonymousAccess ensures that requests without credentials are granted
+// anonymous access for the configured repositories only.
+func TestAnonymousAccess(t *testing.T) {
+	ctx := dcontext.Background()
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+	}
+	config.Policy.Anonymous.Repositories = []string{"library/*"}
+
+	app := NewApp(ctx, &config)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		repo   string
+		status int
+	}{
+		{name: "pull public", method: http.MethodGet, repo: "library/ubuntu", status: http.StatusNotFound},
+		{name: "pull private", method: http.MethodGet, repo: "private/ubuntu", status: http.StatusUnauthorized},
+		{name: "pull nested", method: http.MethodGet, repo: "library/nested/ubuntu", status: http.StatusUnauthorized},
+		{name: "delete public", method: http.MethodDelete, repo: "library/ubuntu", status: http.StatusUnauthorized},
+		{name: "catalog", method: http.MethodGet, status: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// build the URLs by hand: other tests in this package pin the
+			// shared v2 routes to their own test server host.
+			u := server.URL + "/v2/_catalog"
+			if tc.repo != "" {
+				u = server.URL + "/v2/" + tc.repo + "/tags/list"
+			}
+
+			req, err := http.NewRequest(tc.method, u, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error during request: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("unexpected status code: %d != %d", resp.StatusCode, tc.status)
+			}
+		})
+	}
+}
+
+func TestAnonymousAccessGrant(t *testing.T) {
+	aa, err := newAnonymousAccess(configuration.Anonymous{
+		Repositories: []string{"library/*"},
+		Catalog:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pull := auth.Access{Resource: auth.Resource{Type: "repository", Name: "library/ubuntu"}, Action: "pull"}
+	push := auth.Access{Resource: auth.Resource{Type: "repository", Name: "library/ubuntu"}, Action: "push"}
+	catalog := auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	for _, records := range [][]auth.Access{{pull}, {catalog}} {
+		grant := aa.grant(req, records)
+		if grant == nil {
+			t.Fatalf("expected anonymous grant for %v", records)
+		}
+		if grant.User.Name != auth.AnonymousUserName {
+			t.Fatalf("unexpected user: %q", grant.User.Name)
+		}
+	}
+
+	if grant := aa.grant(req, []auth.Access{pull, push}); grant != nil {
+		t.Fatalf("unexpected anonymous grant for push: %v", grant)
+	}
+	if grant := aa.grant(req, nil); grant != nil {
+		t.Fatalf("unexpected anonymous grant for base route: %v", grant)
+	}
+
+	req.Header.Set("Authorization", "Bearer token")
+	if grant := aa.grant(req, []auth.Access{pull}); grant != nil {
+		t.Fatalf("unexpected anonymous grant for request with credentials: %v", grant)
+	}
+
+	if _, err := newAnonymousAccess(configuration.Anonymous{Repositories: []string{"["}}); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+}
+
+func TestUserContext(t *testing.T) {
+	user := auth.UserInfo{
+		Name:   "ci",
+		Type:   auth.UserTypeRobot,
+		Groups: []string{"builders"},
+		Claims: map[string]string{"iss": "issuer"},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/v2/", nil)
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{{
+		Subject: pkix.Name{CommonName: "ci.example.com"},
+		Issuer:  pkix.Name{CommonName: "ca.example.com"},
+	}}}
+
+	ctx := withUser(dcontext.Background(), withClientCertificate(user, req))
+
+	if got := ctx.Value(userNameKey); got != "ci" {
+		t.Fatalf("unexpected user name: %v", got)
+	}
+	if got := ctx.Value(userTypeKey); got != auth.UserTypeRobot {
+		t.Fatalf("unexpected user type: %v", got)
+	}
+	if got := ctx.Value(userGroupsKey); !reflect.DeepEqual(got, []string{"builders"}) {
+		t.Fatalf("unexpected user groups: %v", got)
+	}
+
+	expectedClaims := map[string]string{
+		"iss":          "issuer",
+		"x509.subject": "CN=ci.example.com",
+		"x509.issuer":  "CN=ca.example.com",
+	}
+	if got := ctx.Value(userClaimsKey); !reflect.DeepEqual(got, expectedClaims) {
+		t.Fatalf("unexpected user claims: %v", got)
+	}
+	if len(user.Claims) != 1 {
+		t.Fatalf("client certificate claims should not modify the original user: %v", user.Claims)
+	}
+
+	anonymous := withUser(dcontext.Background(), auth.UserInfo{Name: "anonymous"})
+	if got := anonymous.Value(userGroupsKey); got != nil {
+		t.Fatalf("expected no groups, got %v", got)
+	}
+}
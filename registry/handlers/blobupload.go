@@ -134,6 +134,11 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if buh.State.Presigned {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeBlobUploadInvalid.WithDetail("upload has presigned parts, data cannot be patched"))
+		return
+	}
+
 	ct := r.Header.Get("Content-Type")
 	if ct != "" && ct != "application/octet-stream" {
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(fmt.Errorf("bad Content-Type")))
@@ -205,6 +210,11 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 		return
 	}
 
+	if buh.State.Presigned && r.ContentLength != 0 {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeBlobUploadInvalid.WithDetail("upload has presigned parts, data cannot be put"))
+		return
+	}
+
 	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PUT"); err != nil {
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
 		return
@@ -309,7 +319,7 @@ func (buh *blobUploadHandler) ResumeBlobUpload(ctx *Context, r *http.Request) ht
 	}
 	buh.Upload = upload
 
-	if size := upload.Size(); size != buh.State.Offset {
+	if size := upload.Size(); !buh.State.Presigned && size != buh.State.Offset {
 		dcontext.GetLogger(ctx).Errorf("upload resumed at wrong offset: %d != %d", size, buh.State.Offset)
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeRangeInvalid.WithDetail(err))
@@ -353,6 +363,19 @@ func (buh *blobUploadHandler) blobUploadResponse(w http.ResponseWriter, r *http.
 	w.Header().Set("Docker-Upload-UUID", buh.UUID)
 	w.Header().Set("Location", uploadURL)
 
+	if buh.presignUploads {
+		partsURL, err := buh.urlBuilder.BuildBlobUploadPartsURL(
+			buh.Repository.Named(), buh.Upload.ID(),
+			url.Values{
+				"_state": []string{token},
+			})
+		if err != nil {
+			dcontext.GetLogger(buh).Infof("error building upload parts url: %s", err)
+			return err
+		}
+		w.Header().Set("Docker-Upload-Parts-Location", partsURL)
+	}
+
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Range", fmt.Sprintf("0-%d", endRange))
 
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+const (
+	// presignedPartExpiry is how long presigned part URLs stay valid.
+	presignedPartExpiry = time.Hour
+
+	// maxPartNumber is the highest part number, as in S3 multipart uploads.
+	maxPartNumber = 10000
+
+	// maxPresignedParts is the maximum number of parts presigned by a request.
+	maxPresignedParts = 1000
+)
+
+// blobUploadPartsDispatcher constructs and returns the handler presigning the
+// upload of parts of blob data directly to the storage backend.
+func blobUploadPartsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	buh := &blobUploadHandler{
+		Context: ctx,
+		UUID:    getUploadUUID(ctx),
+	}
+
+	handler := handlers.MethodHandler{}
+	if !ctx.readOnly {
+		handler[http.MethodPost] = http.HandlerFunc(buh.PresignUploadParts)
+	}
+	return handler
+}
+
+type presignedPart struct {
+	PartNumber int    `json:"partNumber"`
+	URL        string `json:"url"`
+}
+
+type presignedPartsAPIResponse struct {
+	Parts     []presignedPart `json:"parts"`
+	ExpiresAt time.Time       `json:"expiresAt"`
+}
+
+// PresignUploadParts returns presigned URLs to upload parts of the blob data
+// directly to the storage backend. The upload is then completed with a PUT
+// without body to the returned location.
+func (buh *blobUploadHandler) PresignUploadParts(w http.ResponseWriter, r *http.Request) {
+	if !buh.presignUploads {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnsupported.WithDetail("presigned uploads are not enabled"))
+		return
+	}
+
+	state, err := hmacKey(buh.Config.HTTP.Secret).unpackUploadState(r.FormValue("_state"))
+	if err != nil {
+		dcontext.GetLogger(buh).Infof("error resolving upload: %v", err)
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeBlobUploadInvalid.WithDetail(err))
+		return
+	}
+	if state.Name != buh.Repository.Named().Name() || state.UUID != buh.UUID {
+		dcontext.GetLogger(buh).Infof("mismatched upload state: %q/%q != %q/%q", state.Name, state.UUID, buh.Repository.Named().Name(), buh.UUID)
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeBlobUploadInvalid.WithDetail("mismatched upload state"))
+		return
+	}
+
+	first, count, err := parsePartRange(r.FormValue("first"), r.FormValue("count"))
+	if err != nil {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeBlobUploadInvalid.WithDetail(err.Error()))
+		return
+	}
+	partNumbers := make([]int, count)
+	for i := range partNumbers {
+		partNumbers[i] = first + i
+	}
+
+	expiresAt := time.Now().Add(presignedPartExpiry).UTC()
+	urls, err := storage.PresignUploadParts(buh, buh.driver, state.Name, state.UUID, partNumbers, presignedPartExpiry)
+	if err != nil {
+		switch err {
+		case distribution.ErrBlobUploadUnknown:
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeBlobUploadUnknown.WithDetail(err))
+		case distribution.ErrUnsupported:
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnsupported)
+		default:
+			dcontext.GetLogger(buh).Errorf("error presigning upload parts: %v", err)
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	// The offset of the upload is not tracked anymore, parts being uploaded
+	// directly to the storage backend.
+	state.Presigned = true
+	state.Offset = 0
+	token, err := hmacKey(buh.Config.HTTP.Secret).packUploadState(state)
+	if err != nil {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	uploadURL, err := buh.urlBuilder.BuildBlobUploadChunkURL(buh.Repository.Named(), state.UUID, url.Values{"_state": []string{token}})
+	if err != nil {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	partsURL, err := buh.urlBuilder.BuildBlobUploadPartsURL(buh.Repository.Named(), state.UUID, url.Values{"_state": []string{token}})
+	if err != nil {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	resp := presignedPartsAPIResponse{
+		Parts:     make([]presignedPart, len(urls)),
+		ExpiresAt: expiresAt,
+	}
+	for i, u := range urls {
+		resp.Parts[i] = presignedPart{PartNumber: partNumbers[i], URL: u}
+	}
+
+	w.Header().Set("Docker-Upload-UUID", state.UUID)
+	w.Header().Set("Location", uploadURL)
+	w.Header().Set("Docker-Upload-Parts-Location", partsURL)
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// parsePartRange parses the first and count query parameters of a request
+// presigning parts.
+func parsePartRange(firstParam, countParam string) (first, count int, err error) {
+	first = 1
+	if firstParam != "" {
+		first, err = strconv.Atoi(firstParam)
+		if err != nil || first < 1 || first > maxPartNumber {
+			return 0, 0, fmt.Errorf("first must be a part number between 1 and %d", maxPartNumber)
+		}
+	}
+
+	count, err = strconv.Atoi(countParam)
+	if err != nil || count < 1 || count > maxPresignedParts {
+		return 0, 0, fmt.Errorf("count must be between 1 and %d", maxPresignedParts)
+	}
+	if first+count-1 > maxPartNumber {
+		return 0, 0, fmt.Errorf("parts beyond part number %d", maxPartNumber)
+	}
+	return first, count, nil
+}
@@ -85,6 +85,18 @@ const (
 	// userNameKey is used to get the user name from
 	// a user context
 	userNameKey = "auth.user.name"
+
+	// userTypeKey is used to get the user type from
+	// a user context
+	userTypeKey = "auth.user.type"
+
+	// userGroupsKey is used to get the user groups from
+	// a user context
+	userGroupsKey = "auth.user.groups"
+
+	// userClaimsKey is used to get the user claims from
+	// a user context
+	userClaimsKey = "auth.user.claims"
 )
 
 // getUserName attempts to resolve a username from the context and request. If
@@ -104,6 +116,31 @@ func getUserName(ctx context.Context, r *http.Request) string {
 	return username
 }
 
+// getUser returns the authorized user info from the context, if any.
+func getUser(ctx context.Context) (auth.UserInfo, bool) {
+	user, ok := ctx.Value(userKey).(auth.UserInfo)
+	return user, ok
+}
+
+// withClientCertificate adds the subject and issuer of a verified TLS client
+// certificate to the claims of user.
+func withClientCertificate(user auth.UserInfo, r *http.Request) auth.UserInfo {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return user
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	claims := make(map[string]string, len(user.Claims)+2)
+	for k, v := range user.Claims {
+		claims[k] = v
+	}
+	claims["x509.subject"] = cert.Subject.String()
+	claims["x509.issuer"] = cert.Issuer.String()
+	user.Claims = claims
+
+	return user
+}
+
 // withUser returns a context with the authorized user info.
 func withUser(ctx context.Context, user auth.UserInfo) context.Context {
 	return userInfoContext{
@@ -123,6 +160,18 @@ func (uic userInfoContext) Value(key interface{}) interface{} {
 		return uic.user
 	case userNameKey:
 		return uic.user.Name
+	case userTypeKey:
+		if uic.user.Type != "" {
+			return uic.user.Type
+		}
+	case userGroupsKey:
+		if len(uic.user.Groups) > 0 {
+			return uic.user.Groups
+		}
+	case userClaimsKey:
+		if len(uic.user.Claims) > 0 {
+			return uic.user.Claims
+		}
 	}
 
 	return uic.Context.Value(key)
@@ -22,6 +22,11 @@ type blobUploadState struct {
 
 	// StartedAt is the original start time of the upload.
 	StartedAt time.Time
+
+	// Presigned is set once parts of the upload have been presigned, to be
+	// uploaded directly to the storage backend. The offset is not tracked
+	// anymore then.
+	Presigned bool `json:",omitempty"`
 }
 
 type hmacKey string
@@ -28,6 +28,11 @@ var blobUploadStates = []blobUploadState{
 		UUID:   "dead-1234-beef-0987",
 		Offset: 8675309,
 	},
+	{
+		Name:      "presigned",
+		UUID:      "abcd-1234-qwer-0987",
+		Presigned: true,
+	},
 }
 
 var secrets = []string{
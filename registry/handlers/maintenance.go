@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/notifications"
+	events "github.com/docker/go-events"
+)
+
+// NewMaintenanceListener returns a listener publishing the events of a
+// maintenance task run outside of the registry, such as the garbage-collect
+// command, to the notification endpoints of config. The events are queued in
+// memory only: persistent queues and dead letter stores belong to the running
+// registry and are not opened. The returned sink must be closed to flush the
+// queued events.
+func NewMaintenanceListener(ctx context.Context, config *configuration.Configuration, actor notifications.ActorRecord) (notifications.MaintenanceListener, events.Sink) {
+	cfg := *config
+	cfg.Notifications.Endpoints = make([]configuration.Endpoint, len(config.Notifications.Endpoints))
+	for i, endpoint := range config.Notifications.Endpoints {
+		endpoint.Queue.Type = ""
+		endpoint.Queue.Path = ""
+		endpoint.DeadLetter.Type = ""
+		endpoint.DeadLetter.Path = ""
+		cfg.Notifications.Endpoints[i] = endpoint
+	}
+
+	app := &App{
+		Config:  &cfg,
+		Context: ctx,
+	}
+	app.configureRedis(&cfg)
+	app.configureEvents(&cfg)
+
+	return notifications.NewMaintenanceBridge(app.events.source, actor, app.events.sink), app.events.sink
+}
@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/distribution/distribution/v3/configuration"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+// maintenanceExcluded lists the storage middleware not applied by the
+// maintenance commands: replicate, whose secondaries are reconciled with the
+// storage by `registry replicate verify` instead, and faultinject.
+var maintenanceExcluded = []string{"replicate", "faultinject"}
+
+// maintenanceDriver constructs the storage driver of config for a maintenance
+// command, wrapped in its enabled storage middleware other than
+// maintenanceExcluded, so that the command sees the content as the registry
+// serves it. If below is set, only the middleware configured before the one
+// named below are applied. The middleware named in exclude are skipped as
+// well. The middleware do not start their background work, and must be closed
+// with storagemiddleware.Close once the command is done.
+func maintenanceDriver(ctx context.Context, config *configuration.Configuration, below string, exclude ...string) (storagedriver.StorageDriver, []io.Closer, error) {
+	driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to construct %s driver: %v", config.Storage.Type(), err)
+	}
+
+	chain := config.Middleware["storage"]
+	if below != "" {
+		for i, mw := range chain {
+			if mw.Name == below && !mw.Disabled {
+				chain = chain[:i]
+				break
+			}
+		}
+	}
+	return storagemiddleware.Apply(storagemiddleware.WithMaintenance(ctx), driver, chain, append(exclude, maintenanceExcluded...)...)
+}
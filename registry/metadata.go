@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	metadata "github.com/distribution/distribution/v3/registry/storage/driver/middleware/metadata"
+	"github.com/spf13/cobra"
+)
+
+var metadataImportConcurrency int
+
+func init() {
+	RootCmd.AddCommand(MetadataCmd)
+	MetadataCmd.AddCommand(metadataImportCmd)
+	metadataImportCmd.Flags().IntVar(&metadataImportConcurrency, "concurrency", runtime.GOMAXPROCS(0), "number of storage operations run at a time")
+}
+
+// MetadataCmd is the cobra command that corresponds to the metadata subcommand
+var MetadataCmd = &cobra.Command{
+	Use:   "metadata",
+	Short: "`metadata` manages the store of the metadata storage middleware",
+	Long:  "`metadata` manages the embedded store keeping the repository links of the metadata storage middleware",
+}
+
+var metadataImportCmd = &cobra.Command{
+	Use:   "import <config>",
+	Short: "`import` copies the repository links of the storage into the metadata store",
+	Long:  "`import` copies the tag, manifest revision and layer links of the storage into the store of the metadata storage middleware. The registry must be stopped during the import.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		var options map[string]interface{}
+		for _, mw := range config.Middleware["storage"] {
+			if mw.Name == "metadata" && !mw.Disabled {
+				options = mw.Options
+			}
+		}
+		if options == nil {
+			fmt.Fprintln(os.Stderr, "the metadata storage middleware is not configured")
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		// the links are read from the storage as seen by the middleware
+		driver, closers, err := maintenanceDriver(ctx, config, "metadata")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		imported, err := metadata.Import(ctx, driver, options, metadataImportConcurrency)
+		if closeErr := storagemiddleware.Close(closers); closeErr != nil {
+			dcontext.GetLogger(ctx).Errorf("unable to close storage middleware: %v", closeErr)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to import metadata: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("imported %d files\n", imported)
+	},
+}
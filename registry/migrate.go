@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateConcurrency int
+	migrateVerify      bool
+	migratePrune       bool
+	migrateCorrupt     bool
+)
+
+func init() {
+	RootCmd.AddCommand(MigrateCmd)
+	MigrateCmd.Flags().IntVar(&migrateConcurrency, "concurrency", storage.DefaultConcurrencyLimit, "number of files copied at a time")
+	MigrateCmd.Flags().BoolVar(&migrateVerify, "verify", false, "check the digest of the blobs already in the destination")
+	MigrateCmd.Flags().BoolVar(&migratePrune, "prune", false, "delete tags and repositories of the destination missing from the source")
+	MigrateCmd.Flags().BoolVar(&migrateCorrupt, "allow-corrupt", false, "copy the repositories even if blobs of the source are corrupt, without the links referencing them")
+}
+
+// MigrateCmd is the cobra command that corresponds to the migrate subcommand
+var MigrateCmd = &cobra.Command{
+	Use:   "migrate <src-config> <dst-config>",
+	Short: "`migrate` copies the content of a registry storage to another",
+	Long:  "`migrate` copies the blobs and repositories of the storage configured by src-config to the storage configured by dst-config, skipping the blobs already copied. Run it again with the source registry read-only for a final, incremental copy.",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		srcConfig, err := resolveConfiguration(args[:1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "source configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		dstConfig, err := resolveConfiguration(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "destination configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, srcConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		// the content is copied as the registries serve it
+		src, srcClosers, err := maintenanceDriver(ctx, srcConfig, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "source storage: %v\n", err)
+			os.Exit(1)
+		}
+		dst, dstClosers, err := maintenanceDriver(ctx, dstConfig, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "destination storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		stats, err := storage.Migrate(ctx, src, dst, storage.MigrateOpts{
+			Concurrency:  migrateConcurrency,
+			Verify:       migrateVerify,
+			Prune:        migratePrune,
+			AllowCorrupt: migrateCorrupt,
+		})
+		if closeErr := storagemiddleware.Close(append(srcClosers, dstClosers...)); closeErr != nil {
+			dcontext.GetLogger(ctx).Errorf("unable to close storage middleware: %v", closeErr)
+		}
+		fmt.Printf("blobs: %d copied (%d bytes), %d skipped, %d corrupt\n", stats.BlobsCopied, stats.BytesCopied, stats.BlobsSkipped, stats.BlobsCorrupt)
+		fmt.Printf("links: %d copied, %d skipped, %d pruned, %d corrupt\n", stats.LinksCopied, stats.LinksSkipped, stats.LinksPruned, stats.LinksCorrupt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate: %v\n", err)
+			os.Exit(1)
+		}
+		if stats.BlobsCorrupt > 0 {
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,175 @@
+package ocilayout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// tagRegexp matches the annotations naming a reference with a tag only.
+var tagRegexp = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+
+// layout is an OCI image-layout directory.
+type layout struct {
+	dir string
+}
+
+// isLayout reports whether dir is an OCI image-layout directory.
+func isLayout(dir string) bool {
+	p, err := os.ReadFile(filepath.Join(dir, v1.ImageLayoutFile))
+	if err != nil {
+		return false
+	}
+	var imageLayout v1.ImageLayout
+	return json.Unmarshal(p, &imageLayout) == nil && imageLayout.Version != ""
+}
+
+// index returns the descriptors of the index.json file of the layout.
+func (l layout) index() ([]v1.Descriptor, error) {
+	p, err := os.ReadFile(filepath.Join(l.dir, v1.ImageIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	var index v1.Index
+	if err := json.Unmarshal(p, &index); err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %v", v1.ImageIndexFile, l.dir, err)
+	}
+	return index.Manifests, nil
+}
+
+// tags returns the descriptors of the index.json file of the layout named by
+// a tag in their org.opencontainers.image.ref.name annotation, by tag. The
+// annotation is either a tag or a reference with a tag. When a tag names
+// several descriptors, the first one is used.
+func (l layout) tags() (map[string]distribution.Descriptor, error) {
+	descriptors, err := l.index()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]distribution.Descriptor)
+	for _, desc := range descriptors {
+		tag := refNameTag(desc.Annotations[v1.AnnotationRefName])
+		if tag == "" {
+			continue
+		}
+		if _, ok := tags[tag]; !ok {
+			tags[tag] = distribution.Descriptor{
+				MediaType:   desc.MediaType,
+				Digest:      desc.Digest,
+				Size:        desc.Size,
+				Annotations: desc.Annotations,
+				Platform:    desc.Platform,
+			}
+		}
+	}
+	return tags, nil
+}
+
+// refNameTag returns the tag of an org.opencontainers.image.ref.name
+// annotation, if any.
+func refNameTag(refName string) string {
+	if tagRegexp.MatchString(refName) {
+		return refName
+	}
+	ref, err := reference.ParseNormalizedNamed(refName)
+	if err != nil {
+		return ""
+	}
+	if tagged, ok := ref.(reference.Tagged); ok {
+		return tagged.Tag()
+	}
+	return ""
+}
+
+// blobPath returns the path of the blob identified by dgst.
+func (l layout) blobPath(dgst digest.Digest) (string, error) {
+	// validating the digest also keeps the path within the layout
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, v1.ImageBlobsDir, dgst.Algorithm().String(), dgst.Encoded()), nil
+}
+
+// stat returns the descriptor of the blob identified by dgst.
+func (l layout) stat(dgst digest.Digest) (distribution.Descriptor, error) {
+	p, err := l.blobPath(dgst)
+	if err != nil {
+		return distribution.Descriptor{}, distribution.ErrBlobUnknown
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return distribution.Descriptor{}, distribution.ErrBlobUnknown
+		}
+		return distribution.Descriptor{}, err
+	}
+	if !fi.Mode().IsRegular() {
+		return distribution.Descriptor{}, distribution.ErrBlobUnknown
+	}
+	return distribution.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    dgst,
+		Size:      fi.Size(),
+	}, nil
+}
+
+// open opens the blob identified by dgst.
+func (l layout) open(dgst digest.Digest) (*os.File, distribution.Descriptor, error) {
+	desc, err := l.stat(dgst)
+	if err != nil {
+		return nil, distribution.Descriptor{}, err
+	}
+	p, _ := l.blobPath(dgst)
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, distribution.Descriptor{}, distribution.ErrBlobUnknown
+		}
+		return nil, distribution.Descriptor{}, err
+	}
+	return f, desc, nil
+}
+
+// blobs calls f with the digest of each blob of the layout.
+func (l layout) blobs(f func(dgst digest.Digest) error) error {
+	root := filepath.Join(l.dir, v1.ImageBlobsDir)
+	algorithms, err := os.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(root, algorithm.Name()))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		for _, entry := range entries {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(algorithm.Name()), entry.Name())
+			if entry.IsDir() || dgst.Validate() != nil {
+				continue
+			}
+			if err := f(dgst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
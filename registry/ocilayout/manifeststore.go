@@ -0,0 +1,77 @@
+package ocilayout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	_ "github.com/distribution/distribution/v3/manifest/manifestlist" // register the manifest list schema
+	_ "github.com/distribution/distribution/v3/manifest/ocischema"    // register the OCI schemas
+	_ "github.com/distribution/distribution/v3/manifest/schema2"      // register the schema2 manifest
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// manifestStore provides read-only access to the manifests of an image
+// layout, which are blobs of the layout.
+type manifestStore struct {
+	repository *repository
+}
+
+var _ distribution.ManifestService = &manifestStore{}
+
+func (ms *manifestStore) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	_, err := ms.repository.layout.stat(dgst)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	content, err := ms.repository.Blobs(ctx).Get(ctx, dgst)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown {
+			return nil, distribution.ErrManifestUnknownRevision{
+				Name:     ms.repository.Named().Name(),
+				Revision: dgst,
+			}
+		}
+		return nil, err
+	}
+	if digest.FromBytes(content) != dgst {
+		return nil, fmt.Errorf("content of manifest %s in %s does not match its digest", dgst, ms.repository.layout.dir)
+	}
+
+	// the media type is in the content of the manifests, but optional in
+	// OCI images and image indexes
+	var versioned struct {
+		MediaType string          `json:"mediaType,omitempty"`
+		Manifests json.RawMessage `json:"manifests,omitempty"`
+	}
+	if err := json.Unmarshal(content, &versioned); err != nil {
+		return nil, err
+	}
+	mediaType := versioned.MediaType
+	if mediaType == "" {
+		mediaType = v1.MediaTypeImageManifest
+		if versioned.Manifests != nil {
+			mediaType = v1.MediaTypeImageIndex
+		}
+	}
+
+	m, _, err := distribution.UnmarshalManifest(mediaType, content)
+	return m, err
+}
+
+func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	return "", distribution.ErrUnsupported
+}
+
+func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	return distribution.ErrUnsupported
+}
@@ -0,0 +1,206 @@
+// Package ocilayout provides a read-only registry serving repositories from
+// OCI image-layout directories.
+//
+// Each repository is an image-layout directory, with an oci-layout file, an
+// index.json file and a blobs directory, at the path of its name under a
+// root directory. The tags of a repository are the descriptors of its
+// index.json file annotated with org.opencontainers.image.ref.name. The
+// directories are read on each request, so that layouts can be added,
+// replaced or removed while the registry runs.
+package ocilayout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// layoutRegistry is a namespace of the OCI image layouts under a root
+// directory.
+type layoutRegistry struct {
+	root string
+}
+
+var (
+	_ distribution.Namespace            = &layoutRegistry{}
+	_ distribution.RepositoryEnumerator = &layoutRegistry{}
+)
+
+// NewRegistry creates a read-only registry serving the OCI image layouts
+// under root as repositories.
+func NewRegistry(ctx context.Context, root string) (distribution.Namespace, error) {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	return &layoutRegistry{root: root}, nil
+}
+
+func (reg *layoutRegistry) Scope() distribution.Scope {
+	return distribution.GlobalScope
+}
+
+// Repository returns the repository of the image layout at the path of name,
+// which may not exist.
+func (reg *layoutRegistry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	return &repository{
+		name:   name,
+		layout: reg.layout(name.Name()),
+	}, nil
+}
+
+// layout returns the image layout of the repository name.
+func (reg *layoutRegistry) layout(name string) layout {
+	return layout{dir: filepath.Join(reg.root, filepath.FromSlash(name))}
+}
+
+// Repositories fills repos with the sorted names of the repositories after
+// last, returning io.EOF once all of them are listed.
+func (reg *layoutRegistry) Repositories(ctx context.Context, repos []string, last string) (int, error) {
+	if len(repos) == 0 {
+		return 0, errors.New("Attempted to list 0 repositories")
+	}
+
+	names, err := reg.repositories()
+	if err != nil {
+		return 0, err
+	}
+	names = names[sort.Search(len(names), func(i int) bool { return names[i] > last }):]
+
+	n := copy(repos, names)
+	if n == len(names) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Enumerate calls ingester with the name of each repository.
+func (reg *layoutRegistry) Enumerate(ctx context.Context, ingester func(string) error) error {
+	names, err := reg.repositories()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := ingester(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repositories returns the sorted names of the image layouts under the root
+// directory.
+func (reg *layoutRegistry) repositories() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(reg.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != reg.root && isLayout(filepath.Dir(p)) && d.Name() == v1.ImageBlobsDir {
+			return filepath.SkipDir
+		}
+		if !isLayout(p) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(reg.root, p)
+		if err != nil {
+			return err
+		}
+		if _, err := reference.WithName(filepath.ToSlash(rel)); err == nil {
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Blobs returns an enumerator of the blobs of all the repositories.
+func (reg *layoutRegistry) Blobs() distribution.BlobEnumerator {
+	return allBlobs{reg: reg}
+}
+
+// BlobStatter returns a statter of the blobs of all the repositories.
+func (reg *layoutRegistry) BlobStatter() distribution.BlobStatter {
+	return allBlobs{reg: reg}
+}
+
+// allBlobs provides access to the blobs of all the repositories.
+type allBlobs struct {
+	reg *layoutRegistry
+}
+
+// Enumerate calls ingester with the digest of each blob of the repositories,
+// once per repository holding the blob.
+func (b allBlobs) Enumerate(ctx context.Context, ingester func(dgst digest.Digest) error) error {
+	names, err := b.reg.repositories()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := b.reg.layout(name).blobs(ingester); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat returns the descriptor of the blob identified by dgst, in the first
+// repository holding it.
+func (b allBlobs) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	names, err := b.reg.repositories()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	for _, name := range names {
+		desc, err := b.reg.layout(name).stat(dgst)
+		if err != distribution.ErrBlobUnknown {
+			return desc, err
+		}
+	}
+	return distribution.Descriptor{}, distribution.ErrBlobUnknown
+}
+
+// repository is a repository served from an image layout.
+type repository struct {
+	name   reference.Named
+	layout layout
+}
+
+var _ distribution.Repository = &repository{}
+
+func (repo *repository) Named() reference.Named {
+	return repo.name
+}
+
+func (repo *repository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
+	return &manifestStore{repository: repo}, nil
+}
+
+func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
+	return &blobStore{layout: repo.layout}
+}
+
+func (repo *repository) Tags(ctx context.Context) distribution.TagService {
+	return &tagService{repository: repo}
+}
@@ -0,0 +1,214 @@
+package ocilayout
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// writeBlob writes p as a blob of the layout in dir.
+func writeBlob(t *testing.T, dir string, p []byte) v1.Descriptor {
+	dgst := digest.FromBytes(p)
+	blobDir := filepath.Join(dir, v1.ImageBlobsDir, dgst.Algorithm().String())
+	require.NoError(t, os.MkdirAll(blobDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(blobDir, dgst.Encoded()), p, 0o644))
+	return v1.Descriptor{Digest: dgst, Size: int64(len(p))}
+}
+
+// writeImage writes an image with a layer of the given content to the layout
+// in dir, and returns its manifest descriptor.
+func writeImage(t *testing.T, dir string, layer string) (v1.Descriptor, []byte) {
+	config := writeBlob(t, dir, []byte(`{"architecture":"amd64","os":"linux"}`))
+	config.MediaType = v1.MediaTypeImageConfig
+	layerDesc := writeBlob(t, dir, []byte(layer))
+	layerDesc.MediaType = v1.MediaTypeImageLayer
+
+	manifest, err := json.Marshal(v1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []v1.Descriptor{layerDesc},
+	})
+	require.NoError(t, err)
+	desc := writeBlob(t, dir, manifest)
+	desc.MediaType = v1.MediaTypeImageManifest
+	return desc, manifest
+}
+
+// writeLayout writes the oci-layout and index.json files of the layout in
+// dir.
+func writeLayout(t *testing.T, dir string, manifests ...v1.Descriptor) {
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, v1.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644))
+	index, err := json.Marshal(v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: manifests,
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, v1.ImageIndexFile), index, 0o644))
+}
+
+func withRefName(desc v1.Descriptor, refName string) v1.Descriptor {
+	desc.Annotations = map[string]string{v1.AnnotationRefName: refName}
+	return desc
+}
+
+func newTestRepository(t *testing.T, reg distribution.Namespace, name string) distribution.Repository {
+	named, err := reference.WithName(name)
+	require.NoError(t, err)
+	repo, err := reg.Repository(context.Background(), named)
+	require.NoError(t, err)
+	return repo
+}
+
+func TestRepositories(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	for _, name := range []string{"library/ubuntu", "library/debian", "alpine", "alpine/tools"} {
+		writeLayout(t, filepath.Join(root, filepath.FromSlash(name)))
+	}
+	// directories which are not layouts, or not valid names, are ignored
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "library", "empty"), 0o755))
+	writeLayout(t, filepath.Join(root, "Invalid"))
+
+	_, err := NewRegistry(ctx, filepath.Join(root, "missing"))
+	require.Error(t, err)
+	reg, err := NewRegistry(ctx, root)
+	require.NoError(t, err)
+
+	repos := make([]string, 3)
+	n, err := reg.Repositories(ctx, repos, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"alpine", "alpine/tools", "library/debian"}, repos[:n])
+	n, err = reg.Repositories(ctx, repos, repos[n-1])
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, []string{"library/ubuntu"}, repos[:n])
+
+	var enumerated []string
+	require.NoError(t, reg.(distribution.RepositoryEnumerator).Enumerate(ctx, func(name string) error {
+		enumerated = append(enumerated, name)
+		return nil
+	}))
+	require.Equal(t, []string{"alpine", "alpine/tools", "library/debian", "library/ubuntu"}, enumerated)
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	dir := filepath.Join(root, "library", "ubuntu")
+
+	latest, latestManifest := writeImage(t, dir, "latest layer")
+	old, _ := writeImage(t, dir, "old layer")
+	untagged, _ := writeImage(t, dir, "untagged layer")
+	writeLayout(t, dir,
+		withRefName(latest, "latest"),
+		withRefName(latest, "docker.io/library/ubuntu:24.04"),
+		withRefName(old, "22.04"),
+		withRefName(old, "latest"),
+		untagged,
+	)
+
+	reg, err := NewRegistry(ctx, root)
+	require.NoError(t, err)
+	repo := newTestRepository(t, reg, "library/ubuntu")
+
+	// tags
+	tags, err := repo.Tags(ctx).All(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"22.04", "24.04", "latest"}, tags)
+	desc, err := repo.Tags(ctx).Get(ctx, "latest")
+	require.NoError(t, err)
+	require.Equal(t, latest.Digest, desc.Digest)
+	require.Equal(t, v1.MediaTypeImageManifest, desc.MediaType)
+	_, err = repo.Tags(ctx).Get(ctx, "missing")
+	require.ErrorAs(t, err, &distribution.ErrTagUnknown{})
+	tags, err = repo.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: latest.Digest})
+	require.NoError(t, err)
+	require.Equal(t, []string{"24.04", "latest"}, tags)
+	require.ErrorIs(t, repo.Tags(ctx).Tag(ctx, "new", desc), distribution.ErrUnsupported)
+	require.ErrorIs(t, repo.Tags(ctx).Untag(ctx, "latest"), distribution.ErrUnsupported)
+
+	// manifests
+	manifests, err := repo.Manifests(ctx)
+	require.NoError(t, err)
+	exists, err := manifests.Exists(ctx, untagged.Digest)
+	require.NoError(t, err)
+	require.True(t, exists)
+	m, err := manifests.Get(ctx, latest.Digest)
+	require.NoError(t, err)
+	require.IsType(t, &ocischema.DeserializedManifest{}, m)
+	mediaType, payload, err := m.Payload()
+	require.NoError(t, err)
+	require.Equal(t, v1.MediaTypeImageManifest, mediaType)
+	require.Equal(t, latestManifest, payload)
+	_, err = manifests.Get(ctx, digest.FromString("missing"))
+	require.ErrorAs(t, err, &distribution.ErrManifestUnknownRevision{})
+	_, err = manifests.Put(ctx, m)
+	require.ErrorIs(t, err, distribution.ErrUnsupported)
+
+	// blobs
+	layer := m.References()[1]
+	blobs := repo.Blobs(ctx)
+	stat, err := blobs.Stat(ctx, layer.Digest)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("latest layer")), stat.Size)
+	p, err := blobs.Get(ctx, layer.Digest)
+	require.NoError(t, err)
+	require.Equal(t, "latest layer", string(p))
+	_, err = blobs.Stat(ctx, digest.FromString("missing"))
+	require.ErrorIs(t, err, distribution.ErrBlobUnknown)
+	_, err = blobs.Stat(ctx, digest.Digest("sha256:../../../oci-layout"))
+	require.ErrorIs(t, err, distribution.ErrBlobUnknown)
+	_, err = blobs.Create(ctx)
+	require.ErrorIs(t, err, distribution.ErrUnsupported)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, blobs.ServeBlob(ctx, w, r, layer.Digest))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "latest layer", w.Body.String())
+	require.Equal(t, layer.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+
+	// the blobs of all the repositories
+	stat, err = reg.BlobStatter().Stat(ctx, layer.Digest)
+	require.NoError(t, err)
+	require.Equal(t, layer.Digest, stat.Digest)
+	var all []digest.Digest
+	require.NoError(t, reg.Blobs().Enumerate(ctx, func(dgst digest.Digest) error {
+		all = append(all, dgst)
+		return nil
+	}))
+	require.Len(t, all, 7)
+}
+
+func TestMissingRepository(t *testing.T) {
+	ctx := context.Background()
+	reg, err := NewRegistry(ctx, t.TempDir())
+	require.NoError(t, err)
+	repo := newTestRepository(t, reg, "library/ubuntu")
+
+	_, err = repo.Tags(ctx).All(ctx)
+	require.ErrorAs(t, err, &distribution.ErrRepositoryUnknown{})
+	_, err = repo.Tags(ctx).Get(ctx, "latest")
+	require.ErrorAs(t, err, &distribution.ErrTagUnknown{})
+	manifests, err := repo.Manifests(ctx)
+	require.NoError(t, err)
+	_, err = manifests.Get(ctx, digest.FromString("manifest"))
+	require.ErrorAs(t, err, &distribution.ErrManifestUnknownRevision{})
+	_, err = repo.Blobs(ctx).Stat(ctx, digest.FromString("blob"))
+	require.ErrorIs(t, err, distribution.ErrBlobUnknown)
+}
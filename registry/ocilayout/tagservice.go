@@ -0,0 +1,82 @@
+package ocilayout
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+)
+
+// tagService provides read-only access to the tags of an image layout, the
+// descriptors of its index.json file annotated with
+// org.opencontainers.image.ref.name.
+type tagService struct {
+	repository *repository
+}
+
+var _ distribution.TagService = &tagService{}
+
+// tags returns the tags of the layout, and none if the layout does not
+// exist.
+func (ts *tagService) tags() (map[string]distribution.Descriptor, error) {
+	tags, err := ts.repository.layout.tags()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return tags, err
+}
+
+func (ts *tagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	tags, err := ts.tags()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	desc, ok := tags[tag]
+	if !ok {
+		return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+	return desc, nil
+}
+
+func (ts *tagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	return distribution.ErrUnsupported
+}
+
+func (ts *tagService) Untag(ctx context.Context, tag string) error {
+	return distribution.ErrUnsupported
+}
+
+func (ts *tagService) All(ctx context.Context) ([]string, error) {
+	if !isLayout(ts.repository.layout.dir) {
+		return nil, distribution.ErrRepositoryUnknown{Name: ts.repository.Named().Name()}
+	}
+	tags, err := ts.tags()
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]string, 0, len(tags))
+	for tag := range tags {
+		all = append(all, tag)
+	}
+	sort.Strings(all)
+	return all, nil
+}
+
+func (ts *tagService) Lookup(ctx context.Context, desc distribution.Descriptor) ([]string, error) {
+	tags, err := ts.tags()
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []string
+	for tag, tagDesc := range tags {
+		if tagDesc.Digest == desc.Digest {
+			matching = append(matching, tag)
+		}
+	}
+	sort.Strings(matching)
+	return matching, nil
+}
@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	replicate "github.com/distribution/distribution/v3/registry/storage/driver/middleware/replicate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replicateBackfill bool
+	replicatePrune    bool
+)
+
+func init() {
+	RootCmd.AddCommand(ReplicateCmd)
+	ReplicateCmd.AddCommand(replicateVerifyCmd)
+	replicateVerifyCmd.Flags().BoolVar(&replicateBackfill, "backfill", false, "copy missing and differing files to the secondaries")
+	replicateVerifyCmd.Flags().BoolVar(&replicatePrune, "prune", false, "delete files of the secondaries missing from the primary")
+}
+
+// ReplicateCmd is the cobra command that corresponds to the replicate subcommand
+var ReplicateCmd = &cobra.Command{
+	Use:   "replicate",
+	Short: "`replicate` manages the secondaries of the replicate storage middleware",
+	Long:  "`replicate` manages the secondary storage drivers mirroring the storage of the registry",
+}
+
+var replicateVerifyCmd = &cobra.Command{
+	Use:   "verify <config>",
+	Short: "`verify` compares the storage with its secondaries",
+	Long:  "`verify` compares the storage with the secondaries of the replicate storage middleware, reporting and optionally reconciling the differences",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		var options map[string]interface{}
+		for _, mw := range config.Middleware["storage"] {
+			if mw.Name == "replicate" && !mw.Disabled {
+				options = mw.Options
+			}
+		}
+		if options == nil {
+			fmt.Fprintln(os.Stderr, "the replicate storage middleware is not configured")
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		// the primary is the storage as seen by the middleware
+		driver, closers, err := maintenanceDriver(ctx, config, "replicate")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		unresolved := 0
+		err = replicate.Verify(ctx, driver, options, replicate.VerifyOptions{
+			Backfill: replicateBackfill,
+			Prune:    replicatePrune,
+			Report: func(drift replicate.Drift) {
+				result := "unresolved"
+				switch {
+				case drift.Fixed:
+					result = "fixed"
+				case drift.Err != nil:
+					result = fmt.Sprintf("failed: %v", drift.Err)
+				}
+				if !drift.Fixed {
+					unresolved++
+				}
+				fmt.Printf("%s\t%s\t%s\t%s\n", drift.Secondary, drift.Kind, drift.Path, result)
+			},
+		})
+		if closeErr := storagemiddleware.Close(closers); closeErr != nil {
+			dcontext.GetLogger(ctx).Errorf("unable to close storage middleware: %v", closeErr)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to verify secondaries: %v\n", err)
+			os.Exit(1)
+		}
+		if unresolved > 0 {
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/auth/robot"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cobra"
+)
+
+var (
+	robotDescription string
+	robotScopes      []string
+	robotGroups      []string
+	robotExpiresIn   time.Duration
+)
+
+func init() {
+	RootCmd.AddCommand(RobotCmd)
+	RobotCmd.AddCommand(robotCreateCmd)
+	RobotCmd.AddCommand(robotListCmd)
+	RobotCmd.AddCommand(robotRevokeCmd)
+	robotCreateCmd.Flags().StringVar(&robotDescription, "description", "", "description of the robot")
+	robotCreateCmd.Flags().StringArrayVarP(&robotScopes, "scope", "s", nil, "repository pattern and comma separated actions, e.g. \"ci/*:pull,push\" (repeatable)")
+	robotCreateCmd.Flags().StringArrayVarP(&robotGroups, "group", "g", nil, "group the robot is a member of (repeatable)")
+	robotCreateCmd.Flags().DurationVarP(&robotExpiresIn, "expires-in", "e", 90*24*time.Hour, "lifetime of the robot credentials")
+}
+
+// RobotCmd is the cobra command that corresponds to the robot subcommand
+var RobotCmd = &cobra.Command{
+	Use:   "robot",
+	Short: "`robot` manages robot accounts",
+	Long:  "`robot` manages robot accounts, which authenticate with scoped, expiring secrets",
+}
+
+var robotCreateCmd = &cobra.Command{
+	Use:   "create <config> <name>",
+	Short: "`create` creates a robot and prints its secret",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, store, closeStore := robotStore(cmd, args[:1])
+		defer closeStore()
+
+		scopes, err := parseRobotScopes(robotScopes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		rb, secret, err := robot.New(args[1], robotDescription, scopes, robotExpiresIn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create robot: %v\n", err)
+			os.Exit(1)
+		}
+		rb.Groups = robotGroups
+
+		if err := store.Create(ctx, rb); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to store robot: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("username: %s\n", rb.UserName())
+		fmt.Printf("secret:   %s\n", secret)
+		fmt.Printf("expires:  %s\n", rb.ExpiresAt.Format(time.RFC3339))
+	},
+}
+
+var robotListCmd = &cobra.Command{
+	Use:   "list <config>",
+	Short: "`list` lists robots",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, store, closeStore := robotStore(cmd, args)
+		defer closeStore()
+
+		robots, err := store.List(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list robots: %v\n", err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "USERNAME\tSCOPES\tEXPIRES\tDESCRIPTION")
+		for _, rb := range robots {
+			expires := rb.ExpiresAt.Format(time.RFC3339)
+			if rb.Expired() {
+				expires += " (expired)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", rb.UserName(), formatRobotScopes(rb.Scopes), expires, rb.Description)
+		}
+		// nolint:errcheck
+		w.Flush()
+	},
+}
+
+var robotRevokeCmd = &cobra.Command{
+	Use:   "revoke <config> <name>",
+	Short: "`revoke` deletes a robot, revoking its credentials",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, store, closeStore := robotStore(cmd, args[:1])
+		defer closeStore()
+
+		if err := store.Delete(ctx, strings.TrimPrefix(args[1], robot.UserPrefix)); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to revoke robot: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// robotStore resolves the configuration and opens the configured robot store,
+// exiting on failure. The returned function closes the store.
+func robotStore(cmd *cobra.Command, args []string) (context.Context, robot.Store, func()) {
+	config, err := resolveConfiguration(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		// nolint:errcheck
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	ctx := dcontext.Background()
+	ctx, err = configureLogging(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+		os.Exit(1)
+	}
+
+	store, closers, err := newRobotStore(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	return ctx, store, func() {
+		if err := storagemiddleware.Close(closers); err != nil {
+			dcontext.GetLogger(ctx).Errorf("unable to close storage middleware: %v", err)
+		}
+	}
+}
+
+// newRobotStore opens the configured robot store, and returns the storage
+// middleware to close once done with it.
+func newRobotStore(ctx context.Context, config *configuration.Configuration) (robot.Store, []io.Closer, error) {
+	if config.Robots.Store == "redis" {
+		if config.Redis.Options.Addrs == nil {
+			return nil, nil, fmt.Errorf("robot store %q requires redis configuration", config.Robots.Store)
+		}
+		store, err := robot.NewStore(config.Robots.Store, nil, redis.NewUniversalClient(&config.Redis.Options))
+		return store, nil, err
+	}
+
+	// The robots are stored as the registry stores them. The metadata
+	// middleware passes them through to the storage driver, and is skipped so
+	// that its database, locked by a running registry, is not opened.
+	driver, closers, err := maintenanceDriver(ctx, config, "", "metadata")
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := robot.NewStore(config.Robots.Store, driver, nil)
+	if err != nil {
+		return nil, nil, errors.Join(err, storagemiddleware.Close(closers))
+	}
+	return store, closers, nil
+}
+
+// parseRobotScopes parses scopes of the form "<pattern>:<action>[,<action>...]".
+func parseRobotScopes(values []string) ([]robot.Scope, error) {
+	scopes := make([]robot.Scope, 0, len(values))
+	for _, value := range values {
+		i := strings.LastIndex(value, ":")
+		if i <= 0 || i == len(value)-1 {
+			return nil, fmt.Errorf("invalid scope %q, expected <pattern>:<actions>", value)
+		}
+		scopes = append(scopes, robot.Scope{
+			Repository: value[:i],
+			Actions:    strings.Split(value[i+1:], ","),
+		})
+	}
+	return scopes, nil
+}
+
+func formatRobotScopes(scopes []robot.Scope) string {
+	formatted := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		formatted = append(formatted, scope.Repository+":"+strings.Join(scope.Actions, ","))
+	}
+	return strings.Join(formatted, " ")
+}
@@ -1,13 +1,18 @@
 package registry
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/storage"
-	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
 	"github.com/distribution/distribution/v3/version"
+	events "github.com/docker/go-events"
 	"github.com/spf13/cobra"
 )
 
@@ -41,6 +46,10 @@ var (
 	removeUntagged bool
 )
 
+// gcEventsFlushTimeout bounds the time garbage-collect waits for the
+// notification endpoints to deliver the queued events before exiting.
+const gcEventsFlushTimeout = 30 * time.Second
+
 // GCCmd is the cobra command that corresponds to the garbage-collect subcommand
 var GCCmd = &cobra.Command{
 	Use:   "garbage-collect <config>",
@@ -62,9 +71,9 @@ var GCCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		driver, closers, err := maintenanceDriver(ctx, config, "")
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
 
@@ -74,13 +83,52 @@ var GCCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
+		opts := storage.GCOpts{
 			DryRun:         dryRun,
 			RemoveUntagged: removeUntagged,
-		})
+		}
+		var sink events.Sink
+		if config.Notifications.EventConfig.IncludeGC && !dryRun {
+			opts.Listener, sink = handlers.NewMaintenanceListener(ctx, config, notifications.ActorRecord{Name: "garbage-collect"})
+		}
+
+		err = storage.MarkAndSweep(ctx, driver, registry, opts)
+		if sink != nil {
+			// deliver the events of the content removed so far, even if the
+			// sweep failed
+			closeEventSink(ctx, sink)
+		}
+		if closeErr := storagemiddleware.Close(closers); closeErr != nil {
+			dcontext.GetLogger(ctx).Errorf("unable to close storage middleware: %v", closeErr)
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
 			os.Exit(1)
 		}
+		if !dryRun {
+			for _, mw := range config.Middleware["storage"] {
+				if mw.Name == "replicate" && !mw.Disabled {
+					dcontext.GetLogger(ctx).Warnf("the deletions are not mirrored to the secondaries of the replicate storage middleware, run `registry replicate verify --prune` to remove them")
+				}
+			}
+		}
 	},
 }
+
+// closeEventSink closes sink, waiting up to gcEventsFlushTimeout for the
+// queued events to be delivered.
+func closeEventSink(ctx context.Context, sink events.Sink) {
+	done := make(chan error, 1)
+	go func() {
+		done <- sink.Close()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("error closing event sink: %v", err)
+		}
+	case <-time.After(gcEventsFlushTimeout):
+		dcontext.GetLogger(ctx).Warnf("timed out delivering garbage collection events after %s", gcEventsFlushTimeout)
+	}
+}
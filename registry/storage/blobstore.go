@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"path"
+	"sync"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
@@ -94,7 +95,9 @@ func (bs *blobStore) Enumerate(ctx context.Context, ingester func(dgst digest.Di
 		return err
 	}
 
-	return bs.driver.Walk(ctx, specPath, func(fileInfo driver.FileInfo) error {
+	// the blobs are walked in parallel, but ingested one at a time
+	var mu sync.Mutex
+	return driver.WalkParallel(ctx, bs.driver, specPath, DefaultConcurrencyLimit, func(fileInfo driver.FileInfo) error {
 		// skip directories
 		if fileInfo.IsDir() {
 			return nil
@@ -112,6 +115,8 @@ func (bs *blobStore) Enumerate(ctx context.Context, ingester func(dgst digest.Di
 			return err
 		}
 
+		mu.Lock()
+		defer mu.Unlock()
 		return ingester(digest)
 	})
 }
@@ -6,6 +6,7 @@ import (
 	"io"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
@@ -74,8 +75,14 @@ func (reg *registry) Enumerate(ctx context.Context, ingester func(string) error)
 		return err
 	}
 
-	err = reg.blobStore.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
-		return handleRepository(fileInfo, root, "", ingester)
+	// the repositories are walked in parallel, but ingested one at a time
+	var mu sync.Mutex
+	err = driver.WalkParallel(ctx, reg.blobStore.driver, root, DefaultConcurrencyLimit, func(fileInfo driver.FileInfo) error {
+		return handleRepository(fileInfo, root, "", func(repoPath string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return ingester(repoPath)
+		})
 	})
 
 	return err
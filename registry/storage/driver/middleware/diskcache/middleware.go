@@ -0,0 +1,498 @@
+// Package middleware provides a storage middleware caching the content of
+// blobs on local disk.
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	if err := storagemiddleware.Register("diskcache", newDiskCacheStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register diskcache storage middleware: %v", err)
+	}
+}
+
+// defaultMaxSize is the default bound of the total size of the cached
+// content, in bytes.
+const defaultMaxSize = 10 << 30
+
+// tempPrefix prefixes the names of the files being filled.
+const tempPrefix = ".fill-"
+
+// blobDataPathRegexp matches the paths of blob data, the only content which
+// never changes once written. Everything else, such as tag links and
+// uploads, is passed through.
+var blobDataPathRegexp = regexp.MustCompile(`/blobs/[a-z0-9]+/[0-9a-f]{2}/[0-9a-f]+/data$`)
+
+// diskCacheStorageMiddleware keeps a local copy of the blob data read from
+// the storage driver, evicting the least recently used copies when the
+// cache grows beyond maxSize.
+type diskCacheStorageMiddleware struct {
+	storagedriver.StorageDriver
+	root    string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List               // of *cacheEntry, most recently used first
+	entries map[string]*list.Element // by driver path
+	fills   map[string]*fillGeneration
+}
+
+type cacheEntry struct {
+	path string
+	size int64
+}
+
+// fillGeneration counts the changes to a path while it is being filled, so
+// that content read before a write or a delete is not cached after it.
+type fillGeneration struct {
+	n     uint64
+	fills int
+}
+
+var _ storagedriver.StorageDriver = &diskCacheStorageMiddleware{}
+
+func newDiskCacheStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	o, ok := options["root"]
+	if !ok {
+		return nil, fmt.Errorf("no root provided")
+	}
+	root, ok := o.(string)
+	if !ok || root == "" {
+		return nil, fmt.Errorf("root must be a non-empty string")
+	}
+
+	maxSize, err := base.GetLimitFromParameter(options["maxsize"], 1, defaultMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("maxsize: %v", err)
+	}
+
+	dc := &diskCacheStorageMiddleware{
+		StorageDriver: sd,
+		root:          root,
+		maxSize:       int64(maxSize),
+		lru:           list.New(),
+		entries:       make(map[string]*list.Element),
+		fills:         make(map[string]*fillGeneration),
+	}
+	if err := dc.load(); err != nil {
+		return nil, fmt.Errorf("unable to load disk cache %s: %v", root, err)
+	}
+
+	dcontext.GetLogger(ctx).Infof("diskcache: caching blob data in %s, %d entries, %d of %d bytes", root, len(dc.entries), dc.size, dc.maxSize)
+	return dc, nil
+}
+
+// cacheable reports whether the content at p can be cached.
+func cacheable(p string) bool {
+	return blobDataPathRegexp.MatchString(p)
+}
+
+// GetContent returns the content at p, from the cache if possible.
+func (dc *diskCacheStorageMiddleware) GetContent(ctx context.Context, p string) ([]byte, error) {
+	if !cacheable(p) {
+		return dc.StorageDriver.GetContent(ctx, p)
+	}
+
+	if f, ok := dc.open(p); ok {
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	gen := dc.beginFill(p)
+	content, err := dc.StorageDriver.GetContent(ctx, p)
+	if err != nil {
+		dc.endFill(p)
+		return nil, err
+	}
+
+	if err := dc.store(p, content, gen); err != nil {
+		dcontext.GetLogger(ctx).Warnf("diskcache: unable to cache %s: %v", p, err)
+	}
+	return content, nil
+}
+
+// Reader returns a reader of the content at p from offset, from the cache if
+// possible. Content read from the start is copied to the cache as it is
+// read, and cached once it has been read to the end with the size the
+// storage driver reported.
+func (dc *diskCacheStorageMiddleware) Reader(ctx context.Context, p string, offset int64) (io.ReadCloser, error) {
+	if !cacheable(p) {
+		return dc.StorageDriver.Reader(ctx, p, offset)
+	}
+
+	if f, ok := dc.open(p); ok {
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if offset > fi.Size() {
+			f.Close()
+			return nil, storagedriver.InvalidOffsetError{Path: p, Offset: offset, DriverName: dc.Name()}
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+
+	if offset != 0 {
+		return dc.StorageDriver.Reader(ctx, p, offset)
+	}
+
+	gen := dc.beginFill(p)
+	fi, err := dc.StorageDriver.Stat(ctx, p)
+	if err != nil {
+		dc.endFill(p)
+		return dc.StorageDriver.Reader(ctx, p, offset)
+	}
+	rc, err := dc.StorageDriver.Reader(ctx, p, offset)
+	if err != nil {
+		dc.endFill(p)
+		return nil, err
+	}
+
+	tmp, err := dc.createTemp()
+	if err != nil {
+		dc.endFill(p)
+		dcontext.GetLogger(ctx).Warnf("diskcache: unable to cache %s: %v", p, err)
+		return rc, nil
+	}
+	return &fillReader{ReadCloser: rc, ctx: ctx, cache: dc, path: p, gen: gen, size: fi.Size(), tmp: tmp}, nil
+}
+
+// PutContent writes content to p, dropping its cached copy.
+func (dc *diskCacheStorageMiddleware) PutContent(ctx context.Context, p string, content []byte) error {
+	err := dc.StorageDriver.PutContent(ctx, p, content)
+	dc.evict(p)
+	return err
+}
+
+// Writer returns a writer of the content at p, dropping its cached copy.
+func (dc *diskCacheStorageMiddleware) Writer(ctx context.Context, p string, append bool) (storagedriver.FileWriter, error) {
+	dc.evict(p)
+	return dc.StorageDriver.Writer(ctx, p, append)
+}
+
+// Move moves the content at sourcePath to destPath, dropping the cached copies
+// of both.
+func (dc *diskCacheStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	err := dc.StorageDriver.Move(ctx, sourcePath, destPath)
+	dc.evict(sourcePath)
+	dc.evict(destPath)
+	return err
+}
+
+// Delete deletes the content at p and below, dropping their cached copies.
+func (dc *diskCacheStorageMiddleware) Delete(ctx context.Context, p string) error {
+	err := dc.StorageDriver.Delete(ctx, p)
+
+	dc.mu.Lock()
+	within := func(entryPath string) bool {
+		return entryPath == p || strings.HasPrefix(entryPath, strings.TrimSuffix(p, "/")+"/")
+	}
+	var evicted []*list.Element
+	for entryPath, e := range dc.entries {
+		if within(entryPath) {
+			evicted = append(evicted, e)
+		}
+	}
+	for _, e := range evicted {
+		dc.removeLocked(e)
+	}
+	for fillPath, g := range dc.fills {
+		if within(fillPath) {
+			g.n++
+		}
+	}
+	dc.mu.Unlock()
+
+	return err
+}
+
+// open returns the cached copy of p, marking it as recently used.
+func (dc *diskCacheStorageMiddleware) open(p string) (*os.File, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	e, ok := dc.entries[p]
+	if !ok {
+		return nil, false
+	}
+
+	f, err := os.Open(dc.filePath(p))
+	if err != nil {
+		// removed behind our back
+		dc.removeLocked(e)
+		return nil, false
+	}
+	dc.lru.MoveToFront(e)
+	return f, true
+}
+
+// store caches content as the content of p, read at generation gen, and
+// ends the fill of p.
+func (dc *diskCacheStorageMiddleware) store(p string, content []byte, gen uint64) error {
+	tmp, err := dc.createTemp()
+	if err != nil {
+		dc.endFill(p)
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		dc.endFill(p)
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	return dc.commit(p, tmp, gen, int64(len(content)))
+}
+
+// beginFill registers a fill of p and returns the current generation of p.
+// Each call must be followed by a call to commit or endFill.
+func (dc *diskCacheStorageMiddleware) beginFill(p string) uint64 {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	g, ok := dc.fills[p]
+	if !ok {
+		g = &fillGeneration{}
+		dc.fills[p] = g
+	}
+	g.fills++
+	return g.n
+}
+
+// endFill unregisters a fill of p.
+func (dc *diskCacheStorageMiddleware) endFill(p string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.endFillLocked(p)
+}
+
+func (dc *diskCacheStorageMiddleware) endFillLocked(p string) {
+	if g, ok := dc.fills[p]; ok {
+		g.fills--
+		if g.fills == 0 {
+			delete(dc.fills, p)
+		}
+	}
+}
+
+// createTemp creates a file to fill with the content of a path.
+func (dc *diskCacheStorageMiddleware) createTemp() (*os.File, error) {
+	return os.CreateTemp(dc.root, tempPrefix+"*")
+}
+
+// commit closes tmp, filled with the content of p read at generation gen,
+// and ends the fill of p. tmp is added to the cache as the content of p if
+// it has the expected size and p did not change since it was read. It is
+// removed otherwise.
+func (dc *diskCacheStorageMiddleware) commit(p string, tmp *os.File, gen uint64, size int64) error {
+	fi, err := tmp.Stat()
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	changed := dc.fills[p] == nil || dc.fills[p].n != gen
+	dc.endFillLocked(p)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if fi.Size() != size {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("read %d bytes, expected %d", fi.Size(), size)
+	}
+
+	if _, exists := dc.entries[p]; exists || changed || fi.Size() > dc.maxSize {
+		os.Remove(tmp.Name())
+		return nil
+	}
+
+	dst := dc.filePath(p)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	dc.addLocked(p, fi.Size())
+	return nil
+}
+
+// evict drops the cached copy of p, if any, and the copies being filled.
+func (dc *diskCacheStorageMiddleware) evict(p string) {
+	if !cacheable(p) {
+		return
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if e, ok := dc.entries[p]; ok {
+		dc.removeLocked(e)
+	}
+	if g, ok := dc.fills[p]; ok {
+		g.n++
+	}
+}
+
+// addLocked adds an entry for the cached copy of p, evicting the least
+// recently used entries beyond the size bound.
+func (dc *diskCacheStorageMiddleware) addLocked(p string, size int64) {
+	dc.entries[p] = dc.lru.PushFront(&cacheEntry{path: p, size: size})
+	dc.size += size
+
+	for dc.size > dc.maxSize {
+		dc.removeLocked(dc.lru.Back())
+	}
+}
+
+// removeLocked removes the entry e and its file. Readers of the file keep
+// reading it until they close it.
+func (dc *diskCacheStorageMiddleware) removeLocked(e *list.Element) {
+	entry := dc.lru.Remove(e).(*cacheEntry)
+	delete(dc.entries, entry.path)
+	dc.size -= entry.size
+
+	file := dc.filePath(entry.path)
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("diskcache: unable to remove %s: %v", file, err)
+	}
+	// drop the empty directory of the blob, leaving the parents in place
+	os.Remove(filepath.Dir(file))
+}
+
+// filePath returns the path of the cached copy of p.
+func (dc *diskCacheStorageMiddleware) filePath(p string) string {
+	return filepath.Join(dc.root, filepath.FromSlash(p))
+}
+
+// load indexes the content cached by a previous run, oldest first, and
+// removes unfinished fills.
+func (dc *diskCacheStorageMiddleware) load() error {
+	if err := os.MkdirAll(dc.root, 0o755); err != nil {
+		return err
+	}
+
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var found []cached
+	err := filepath.WalkDir(dc.root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), tempPrefix) {
+			return os.Remove(file)
+		}
+
+		rel, err := filepath.Rel(dc.root, file)
+		if err != nil {
+			return err
+		}
+		p := path.Join("/", filepath.ToSlash(rel))
+		if !cacheable(p) {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		found = append(found, cached{path: p, size: fi.Size(), modTime: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].modTime.Before(found[j].modTime)
+	})
+	for _, c := range found {
+		dc.addLocked(c.path, c.size)
+	}
+	return nil
+}
+
+// fillReader copies the content read from the storage driver to a temporary
+// file, which is added to the cache once the whole content has been read.
+type fillReader struct {
+	io.ReadCloser
+	ctx   context.Context
+	cache *diskCacheStorageMiddleware
+	path  string
+	gen   uint64 // the generation of path when it was opened
+	size  int64  // the size of the content when it was opened
+	tmp   *os.File
+	err   error // set once filling failed or completed
+}
+
+func (fr *fillReader) Read(p []byte) (int, error) {
+	n, err := fr.ReadCloser.Read(p)
+	if fr.err == nil && n > 0 {
+		if _, werr := fr.tmp.Write(p[:n]); werr != nil {
+			fr.abort(werr)
+		}
+	}
+	if err == io.EOF && fr.err == nil {
+		fr.err = io.EOF
+		if cerr := fr.cache.commit(fr.path, fr.tmp, fr.gen, fr.size); cerr != nil {
+			dcontext.GetLogger(fr.ctx).Warnf("diskcache: unable to cache %s: %v", fr.path, cerr)
+		}
+	}
+	return n, err
+}
+
+func (fr *fillReader) Close() error {
+	if fr.err == nil {
+		// the content was not read to the end
+		fr.abort(io.ErrUnexpectedEOF)
+	}
+	return fr.ReadCloser.Close()
+}
+
+// abort stops filling the cache and removes the temporary file.
+func (fr *fillReader) abort(err error) {
+	fr.err = err
+	fr.cache.endFill(fr.path)
+	fr.tmp.Close()
+	os.Remove(fr.tmp.Name())
+	if err != io.ErrUnexpectedEOF {
+		dcontext.GetLogger(fr.ctx).Warnf("diskcache: unable to cache %s: %v", fr.path, err)
+	}
+}
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	blobPath = "/docker/registry/v2/blobs/sha256/ab/abcdef0123456789/data"
+	linkPath = "/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link"
+)
+
+// countingDriver counts the reads reaching the storage driver.
+type countingDriver struct {
+	storagedriver.StorageDriver
+	reads int
+	// truncate cuts the content returned by readers to its length, if
+	// positive.
+	truncate int64
+}
+
+func (d *countingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	d.reads++
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *countingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	d.reads++
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil || d.truncate <= 0 {
+		return rc, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, d.truncate), rc}, nil
+}
+
+func newTestCache(t *testing.T, root string, options map[string]interface{}) (*diskCacheStorageMiddleware, *countingDriver) {
+	sd := &countingDriver{StorageDriver: inmemory.New()}
+	if options == nil {
+		options = make(map[string]interface{})
+	}
+	options["root"] = root
+	middleware, err := newDiskCacheStorageMiddleware(context.Background(), sd, options)
+	require.NoError(t, err)
+	return middleware.(*diskCacheStorageMiddleware), sd
+}
+
+func readAll(t *testing.T, sd storagedriver.StorageDriver, path string, offset int64) string {
+	rc, err := sd.Reader(context.Background(), path, offset)
+	require.NoError(t, err)
+	defer rc.Close()
+	p, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(p)
+}
+
+func TestOptions(t *testing.T) {
+	_, err := newDiskCacheStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{})
+	require.ErrorContains(t, err, "no root provided")
+
+	_, err = newDiskCacheStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{"root": 1})
+	require.ErrorContains(t, err, "root must be a non-empty string")
+
+	_, err = newDiskCacheStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{"root": t.TempDir(), "maxsize": "lots"})
+	require.ErrorContains(t, err, "maxsize")
+
+	dc, _ := newTestCache(t, t.TempDir(), map[string]interface{}{"maxsize": 1024})
+	require.Equal(t, int64(1024), dc.maxSize)
+}
+
+func TestReaderCachesBlobData(t *testing.T) {
+	ctx := context.Background()
+	dc, sd := newTestCache(t, t.TempDir(), nil)
+	require.NoError(t, dc.PutContent(ctx, blobPath, []byte("blob content")))
+
+	// a read from an offset is passed through without filling the cache
+	require.Equal(t, "content", readAll(t, dc, blobPath, 5))
+	require.Equal(t, 1, sd.reads)
+
+	require.Equal(t, "blob content", readAll(t, dc, blobPath, 0))
+	require.Equal(t, 2, sd.reads)
+
+	// the content is now served from disk, from any offset
+	require.Equal(t, "blob content", readAll(t, dc, blobPath, 0))
+	require.Equal(t, "content", readAll(t, dc, blobPath, 5))
+	p, err := dc.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, "blob content", string(p))
+	require.Equal(t, 2, sd.reads)
+
+	_, err = dc.Reader(ctx, blobPath, 100)
+	require.ErrorAs(t, err, &storagedriver.InvalidOffsetError{})
+}
+
+func TestPartialReadIsNotCached(t *testing.T) {
+	ctx := context.Background()
+	dc, sd := newTestCache(t, t.TempDir(), nil)
+	require.NoError(t, dc.PutContent(ctx, blobPath, []byte("blob content")))
+
+	rc, err := dc.Reader(ctx, blobPath, 0)
+	require.NoError(t, err)
+	_, err = rc.Read(make([]byte, 4))
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+
+	require.Equal(t, "blob content", readAll(t, dc, blobPath, 0))
+	require.Equal(t, 2, sd.reads)
+	require.Len(t, dc.entries, 1)
+}
+
+func TestShortReadIsNotCached(t *testing.T) {
+	ctx := context.Background()
+	dc, sd := newTestCache(t, t.TempDir(), nil)
+	require.NoError(t, dc.PutContent(ctx, blobPath, []byte("blob content")))
+
+	sd.truncate = 4
+	require.Equal(t, "blob", readAll(t, dc, blobPath, 0))
+	require.Empty(t, dc.entries)
+	require.Empty(t, dc.fills)
+
+	sd.truncate = 0
+	require.Equal(t, "blob content", readAll(t, dc, blobPath, 0))
+	require.Len(t, dc.entries, 1)
+}
+
+func TestDeleteDuringFill(t *testing.T) {
+	ctx := context.Background()
+	dc, sd := newTestCache(t, t.TempDir(), nil)
+	require.NoError(t, dc.PutContent(ctx, blobPath, []byte("blob content")))
+
+	rc, err := dc.Reader(ctx, blobPath, 0)
+	require.NoError(t, err)
+	_, err = rc.Read(make([]byte, 4))
+	require.NoError(t, err)
+	// the blob is deleted, by the garbage collector say, while it is read
+	require.NoError(t, dc.Delete(ctx, "/docker/registry/v2/blobs/sha256/ab"))
+	_, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+
+	require.Empty(t, dc.entries)
+	require.Empty(t, dc.fills)
+	_, err = dc.Reader(ctx, blobPath, 0)
+	require.ErrorAs(t, err, &storagedriver.PathNotFoundError{})
+	require.Equal(t, 2, sd.reads)
+}
+
+func TestMutablePathsAreNotCached(t *testing.T) {
+	ctx := context.Background()
+	dc, sd := newTestCache(t, t.TempDir(), nil)
+	require.NoError(t, dc.PutContent(ctx, linkPath, []byte("sha256:1")))
+
+	for i := 0; i < 2; i++ {
+		p, err := dc.GetContent(ctx, linkPath)
+		require.NoError(t, err)
+		require.Equal(t, "sha256:1", string(p))
+		require.Equal(t, "sha256:1", readAll(t, dc, linkPath, 0))
+	}
+	require.Equal(t, 4, sd.reads)
+	require.Empty(t, dc.entries)
+}
+
+func TestWritesDropCachedCopies(t *testing.T) {
+	ctx := context.Background()
+	dc, _ := newTestCache(t, t.TempDir(), nil)
+	require.NoError(t, dc.PutContent(ctx, blobPath, []byte("old")))
+	_, err := dc.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.Len(t, dc.entries, 1)
+
+	require.NoError(t, dc.PutContent(ctx, blobPath, []byte("new")))
+	require.Empty(t, dc.entries)
+	require.Equal(t, "new", readAll(t, dc, blobPath, 0))
+	require.Len(t, dc.entries, 1)
+
+	// deleting a blob directory drops the copy of its data
+	require.NoError(t, dc.Delete(ctx, "/docker/registry/v2/blobs/sha256/ab/abcdef0123456789"))
+	require.Empty(t, dc.entries)
+	_, err = dc.GetContent(ctx, blobPath)
+	require.ErrorAs(t, err, &storagedriver.PathNotFoundError{})
+
+	// moving an upload in place drops the copy of the destination
+	require.NoError(t, dc.PutContent(ctx, blobPath, []byte("old")))
+	_, err = dc.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.NoError(t, dc.PutContent(ctx, "/docker/registry/v2/repositories/foo/_uploads/id/data", []byte("moved")))
+	require.NoError(t, dc.Move(ctx, "/docker/registry/v2/repositories/foo/_uploads/id/data", blobPath))
+	require.Equal(t, "moved", readAll(t, dc, blobPath, 0))
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	dc, sd := newTestCache(t, t.TempDir(), map[string]interface{}{"maxsize": 10})
+
+	paths := []string{
+		"/docker/registry/v2/blobs/sha256/aa/aa01/data",
+		"/docker/registry/v2/blobs/sha256/bb/bb01/data",
+		"/docker/registry/v2/blobs/sha256/cc/cc01/data",
+	}
+	for _, p := range paths {
+		require.NoError(t, dc.PutContent(ctx, p, []byte("four")))
+	}
+
+	readAll(t, dc, paths[0], 0)
+	readAll(t, dc, paths[1], 0)
+	readAll(t, dc, paths[0], 0) // paths[1] is now the least recently used
+	readAll(t, dc, paths[2], 0)
+	require.Equal(t, 3, sd.reads)
+	require.Equal(t, int64(8), dc.size)
+	require.Contains(t, dc.entries, paths[0])
+	require.NotContains(t, dc.entries, paths[1])
+	require.Contains(t, dc.entries, paths[2])
+
+	// content larger than the cache is never cached
+	large := "/docker/registry/v2/blobs/sha256/dd/dd01/data"
+	require.NoError(t, dc.PutContent(ctx, large, []byte("more than ten bytes")))
+	readAll(t, dc, large, 0)
+	require.NotContains(t, dc.entries, large)
+	require.Equal(t, int64(8), dc.size)
+}
+
+func TestLoadsExistingCache(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	dc, _ := newTestCache(t, root, nil)
+	require.NoError(t, dc.PutContent(ctx, blobPath, []byte("blob content")))
+	readAll(t, dc, blobPath, 0)
+
+	// an interrupted fill is cleaned up
+	tmp, err := dc.createTemp()
+	require.NoError(t, err)
+	require.NoError(t, tmp.Close())
+
+	reloaded, sd := newTestCache(t, root, nil)
+	require.Equal(t, int64(len("blob content")), reloaded.size)
+	require.Contains(t, reloaded.entries, blobPath)
+	require.NoFileExists(t, tmp.Name())
+
+	// the driver of the reloaded cache is empty, so the content must come
+	// from disk
+	require.Equal(t, "blob content", readAll(t, reloaded, blobPath, 0))
+	require.Equal(t, 0, sd.reads)
+}
@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Encrypted files start with a fixed size header naming the key and holding
+// the salt of the file key and the random id of the file, followed by the
+// plaintext split in frames of frameSize bytes, the last one possibly shorter
+// or even empty, each sealed with AES-GCM. As the sizes are fixed, the
+// plaintext size and the position of any frame are computed from the size of
+// the file. The additional data of each frame binds it to the id of the file
+// and flags the last frame, so that frames spliced from another file or files
+// truncated at a frame boundary fail to decrypt. As files are not bound to
+// their path, they are moved as they are.
+const (
+	magic        = "RENC"
+	version      = 3
+	maxKeyIDSize = 32
+	saltSize     = 32
+	fileIDSize   = 16
+	headerSize   = len(magic) + 2 + maxKeyIDSize + saltSize + fileIDSize
+
+	frameSize       = 64 << 10
+	tagSize         = 16
+	sealedFrameSize = frameSize + tagSize
+)
+
+var errInvalidFormat = errors.New("invalid encrypted content")
+
+// header is the header of an encrypted file.
+type header struct {
+	keyID  string
+	salt   [saltSize]byte
+	fileID [fileIDSize]byte
+}
+
+// newHeader returns the header of a new file encrypted with keyID.
+func newHeader(keyID string) (header, error) {
+	h := header{keyID: keyID}
+	if _, err := rand.Read(h.salt[:]); err != nil {
+		return header{}, err
+	}
+	if _, err := rand.Read(h.fileID[:]); err != nil {
+		return header{}, err
+	}
+	return h, nil
+}
+
+func (h header) marshal() []byte {
+	b := make([]byte, 0, headerSize)
+	b = append(b, magic...)
+	b = append(b, version, byte(len(h.keyID)))
+	keyID := make([]byte, maxKeyIDSize)
+	copy(keyID, h.keyID)
+	b = append(b, keyID...)
+	b = append(b, h.salt[:]...)
+	return append(b, h.fileID[:]...)
+}
+
+func parseHeader(b []byte) (header, error) {
+	if len(b) < headerSize || string(b[:len(magic)]) != magic {
+		return header{}, errInvalidFormat
+	}
+	if b[len(magic)] != version {
+		return header{}, fmt.Errorf("unsupported encrypted content version %d", b[len(magic)])
+	}
+	n := int(b[len(magic)+1])
+	if n > maxKeyIDSize {
+		return header{}, errInvalidFormat
+	}
+
+	var h header
+	keyID := b[len(magic)+2:]
+	h.keyID = string(keyID[:n])
+	copy(h.salt[:], keyID[maxKeyIDSize:])
+	copy(h.fileID[:], keyID[maxKeyIDSize+saltSize:])
+	return h, nil
+}
+
+// plainSize returns the size of the plaintext of an encrypted file of the
+// given size. Empty files hold no header.
+func plainSize(size int64) (int64, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	if size < int64(headerSize) {
+		return 0, errInvalidFormat
+	}
+
+	body := size - int64(headerSize)
+	frames, rem := body/sealedFrameSize, body%sealedFrameSize
+	switch {
+	case rem == 0:
+		return frames * frameSize, nil
+	case rem >= tagSize:
+		return frames*frameSize + rem - tagSize, nil
+	default:
+		return 0, errInvalidFormat
+	}
+}
+
+// frameOffset returns the offset of frame i in an encrypted file.
+func frameOffset(i int64) int64 {
+	return int64(headerSize) + i*sealedFrameSize
+}
+
+// additionalData returns the additional data of a frame of the file with
+// header h.
+func (h header) additionalData(last bool) []byte {
+	ad := make([]byte, 0, fileIDSize+1)
+	ad = append(ad, h.fileID[:]...)
+	if last {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// frameNonce returns the nonce of frame i. Nonces are only unique per file,
+// as each file has its own key.
+func frameNonce(i uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], i)
+	return nonce
+}
+
+// keyring holds the keys content is encrypted with, by key id. New content
+// is encrypted with the active key.
+type keyring struct {
+	keys   map[string][]byte
+	active string
+}
+
+// loadKeyring reads the key file p. Each line holds a key id and a base64
+// encoded AES key of 16, 24 or 32 bytes, separated by whitespace. Empty lines
+// and lines starting with # are ignored. The active key defaults to the last
+// key of the file.
+func loadKeyring(p, active string) (*keyring, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	kr := &keyring{keys: make(map[string][]byte)}
+	var last string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a key id and a key", p, line)
+		}
+		id := fields[0]
+		if len(id) > maxKeyIDSize {
+			return nil, fmt.Errorf("%s:%d: key id longer than %d bytes", p, line, maxKeyIDSize)
+		}
+		if _, exists := kr.keys[id]; exists {
+			return nil, fmt.Errorf("%s:%d: duplicate key id %s", p, line, id)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid key: %v", p, line, err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid key: %v", p, line, err)
+		}
+		kr.keys[id] = key
+		last = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(kr.keys) == 0 {
+		return nil, fmt.Errorf("no keys in %s", p)
+	}
+	if active == "" {
+		active = last
+	}
+	if _, ok := kr.keys[active]; !ok {
+		return nil, fmt.Errorf("active key %s not found in %s", active, p)
+	}
+	kr.active = active
+	return kr, nil
+}
+
+// aead returns the cipher of the file with header h.
+func (kr *keyring) aead(h header) (cipher.AEAD, error) {
+	key, ok := kr.keys[h.keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", h.keyID)
+	}
+
+	// derive a key per file, so that frame nonces never repeat for a key
+	mac := hmac.New(sha256.New, key)
+	mac.Write(h.salt[:])
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts content as a whole file with the active key.
+func (kr *keyring) seal(content []byte) ([]byte, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	h, err := newHeader(kr.active)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := kr.aead(h)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := h.marshal()
+	for i := uint64(0); len(content) > 0; i++ {
+		n := min(len(content), frameSize)
+		sealed = aead.Seal(sealed, frameNonce(i), content[:n], h.additionalData(n == len(content)))
+		content = content[n:]
+	}
+	return sealed, nil
+}
+
+// open decrypts a whole file.
+func (kr *keyring) open(sealed []byte) ([]byte, error) {
+	size, err := plainSize(int64(len(sealed)))
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	h, err := parseHeader(sealed)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := kr.aead(h)
+	if err != nil {
+		return nil, err
+	}
+
+	content := make([]byte, 0, size)
+	r := bytes.NewReader(sealed[headerSize:])
+	frame := make([]byte, sealedFrameSize)
+	for i := uint64(0); r.Len() > 0; i++ {
+		n, _ := r.Read(frame)
+		content, err = aead.Open(content, frameNonce(i), frame[:n], h.additionalData(r.Len() == 0))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
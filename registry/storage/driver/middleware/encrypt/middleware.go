@@ -0,0 +1,496 @@
+// Package middleware provides a storage middleware encrypting the content
+// stored by the storage driver.
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	if err := storagemiddleware.Register("encrypt", newEncryptStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register encrypt storage middleware: %v", err)
+	}
+}
+
+// partialSuffix suffixes the path of the file holding the state of an
+// uncommitted writer: the header of the file and its last partial frame,
+// which can only be sealed once complete or committed.
+const partialSuffix = ".partial"
+
+// encryptStorageMiddleware encrypts the content written to the storage
+// driver with AES-GCM, in frames so that content can be read from any
+// offset. Sizes are reported for the plaintext.
+type encryptStorageMiddleware struct {
+	storagedriver.StorageDriver
+	keys *keyring
+}
+
+var _ storagedriver.StorageDriver = &encryptStorageMiddleware{}
+
+func newEncryptStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	o, ok := options["keyfile"]
+	if !ok {
+		return nil, fmt.Errorf("no keyfile provided")
+	}
+	keyfile, ok := o.(string)
+	if !ok || keyfile == "" {
+		return nil, fmt.Errorf("keyfile must be a non-empty string")
+	}
+
+	var active string
+	if o, ok := options["activekey"]; ok {
+		if active, ok = o.(string); !ok {
+			return nil, fmt.Errorf("activekey must be a string")
+		}
+	}
+
+	keys, err := loadKeyring(keyfile, active)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load keys: %v", err)
+	}
+
+	dcontext.GetLogger(ctx).Infof("encrypt: encrypting content with key %s, %d keys loaded", keys.active, len(keys.keys))
+	return &encryptStorageMiddleware{StorageDriver: sd, keys: keys}, nil
+}
+
+// GetContent returns the decrypted content at p.
+func (e *encryptStorageMiddleware) GetContent(ctx context.Context, p string) ([]byte, error) {
+	sealed, err := e.StorageDriver.GetContent(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	content, err := e.keys.open(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt %s: %v", p, err)
+	}
+	return content, nil
+}
+
+// PutContent encrypts content and stores it at p.
+func (e *encryptStorageMiddleware) PutContent(ctx context.Context, p string, content []byte) error {
+	sealed, err := e.keys.seal(content)
+	if err != nil {
+		return err
+	}
+	return e.StorageDriver.PutContent(ctx, p, sealed)
+}
+
+// Reader returns a reader of the decrypted content at p, starting at offset.
+// Only the frames from the one holding offset are read.
+func (e *encryptStorageMiddleware) Reader(ctx context.Context, p string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, storagedriver.InvalidOffsetError{Path: p, Offset: offset, DriverName: e.Name()}
+	}
+
+	if offset > 0 {
+		fi, err := e.Stat(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if offset > fi.Size() {
+			return nil, storagedriver.InvalidOffsetError{Path: p, Offset: offset, DriverName: e.Name()}
+		}
+		if offset == fi.Size() {
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+	}
+
+	rc, err := e.StorageDriver.Reader(ctx, p, 0)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readHeader(rc)
+	if err == io.EOF && offset == 0 {
+		// empty file
+		return rc, nil
+	}
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("unable to decrypt %s: %v", p, err)
+	}
+	aead, err := e.keys.aead(h)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("unable to decrypt %s: %v", p, err)
+	}
+
+	frame := offset / frameSize
+	if frame > 0 {
+		// skip to the frame holding offset
+		rc.Close()
+		rc, err = e.StorageDriver.Reader(ctx, p, frameOffset(frame))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &decryptReader{
+		rc:     rc,
+		aead:   aead,
+		header: h,
+		path:   p,
+		frame:  uint64(frame),
+		skip:   int(offset % frameSize),
+		buf:    make([]byte, sealedFrameSize),
+		out:    make([]byte, 0, frameSize),
+	}, nil
+}
+
+// readHeader reads the header of an encrypted file from r. It returns io.EOF
+// if r is empty.
+func readHeader(r io.Reader) (header, error) {
+	b := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return header{}, errInvalidFormat
+		}
+		return header{}, err
+	}
+	return parseHeader(b)
+}
+
+// Writer returns a writer encrypting the content written to it.
+func (e *encryptStorageMiddleware) Writer(ctx context.Context, p string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := e.StorageDriver.Writer(ctx, p, append)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &encryptWriter{
+		fw:     fw,
+		parent: e,
+		ctx:    ctx,
+		path:   p,
+	}
+	if err := w.init(append); err != nil {
+		fw.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Stat returns the info of p, with the size of the plaintext for files.
+func (e *encryptStorageMiddleware) Stat(ctx context.Context, p string) (storagedriver.FileInfo, error) {
+	fi, err := e.StorageDriver.Stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return plainFileInfo(fi)
+}
+
+// Walk walks the files under p, with the size of their plaintext. Files whose
+// size is not that of encrypted content, such as files being written or
+// stored before encryption was enabled, are passed with their stored size,
+// so that the upload purger and the garbage collector can still remove them.
+func (e *encryptStorageMiddleware) Walk(ctx context.Context, p string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return e.StorageDriver.Walk(ctx, p, func(fi storagedriver.FileInfo) error {
+		plain, err := plainFileInfo(fi)
+		if err != nil {
+			dcontext.GetLogger(ctx).Warnf("encrypt: %v, walking it with its stored size", err)
+			return f(fi)
+		}
+		return f(plain)
+	}, options...)
+}
+
+// RedirectURL never redirects, as clients could not decrypt the content.
+func (e *encryptStorageMiddleware) RedirectURL(r *http.Request, path string) (string, error) {
+	return "", nil
+}
+
+// fileInfo is the info of an encrypted file, with the size of its plaintext.
+type fileInfo struct {
+	storagedriver.FileInfo
+	size int64
+}
+
+func (fi fileInfo) Size() int64 {
+	return fi.size
+}
+
+func plainFileInfo(fi storagedriver.FileInfo) (storagedriver.FileInfo, error) {
+	if fi.IsDir() {
+		return fi, nil
+	}
+	size, err := plainSize(fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fi.Path(), err)
+	}
+	return fileInfo{FileInfo: fi, size: size}, nil
+}
+
+// decryptReader decrypts the frames read from rc.
+type decryptReader struct {
+	rc     io.ReadCloser
+	aead   cipher.AEAD
+	header header
+	path   string
+	frame  uint64 // index of the next frame
+	skip   int    // plaintext to skip in the next frame
+	last   bool   // the last frame has been read
+	buf    []byte
+	out    []byte
+	plain  []byte // decrypted, not read yet
+	err    error
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next frame. A full frame may be the last one, which is
+// only known once it has been authenticated.
+func (r *decryptReader) next() error {
+	if r.last {
+		if n, _ := io.ReadFull(r.rc, r.buf[:1]); n > 0 {
+			return fmt.Errorf("unable to decrypt %s: content after the last frame", r.path)
+		}
+		return io.EOF
+	}
+
+	n, err := io.ReadFull(r.rc, r.buf)
+	switch err {
+	case nil, io.ErrUnexpectedEOF:
+		// the last frame may be shorter
+	case io.EOF:
+		return fmt.Errorf("unable to decrypt %s: truncated after frame %d", r.path, r.frame)
+	default:
+		return err
+	}
+
+	r.last = n < len(r.buf)
+	plain, err := r.aead.Open(r.out[:0], frameNonce(r.frame), r.buf[:n], r.header.additionalData(r.last))
+	if err != nil && !r.last {
+		r.last = true
+		plain, err = r.aead.Open(r.out[:0], frameNonce(r.frame), r.buf[:n], r.header.additionalData(true))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to decrypt frame %d of %s: %v", r.frame, r.path, err)
+	}
+	r.frame++
+	r.plain = plain[min(r.skip, len(plain)):]
+	r.skip = 0
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.rc.Close()
+}
+
+// encryptWriter seals the content written to it in frames, written to fw
+// once the next one starts, as the last frame is only known on commit. The
+// last frame is kept, encrypted, next to the file when the writer is closed
+// without being committed, and read back on resume, so that the driver only
+// ever appends.
+type encryptWriter struct {
+	fw     storagedriver.FileWriter
+	parent *encryptStorageMiddleware
+	ctx    context.Context
+	path   string
+
+	header        header
+	aead          cipher.AEAD
+	headerWritten bool
+	frame         uint64 // index of the next frame
+	buf           []byte // plaintext of the next frame
+	hasPartial    bool   // the partial file may exist
+
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+// init starts a new file, or restores the state of the file to append to.
+func (w *encryptWriter) init(append bool) error {
+	w.buf = make([]byte, 0, frameSize)
+
+	size := w.fw.Size()
+	if append {
+		state, err := w.parent.GetContent(w.ctx, w.partialPath())
+		switch {
+		case err == nil:
+			if len(state) < headerSize {
+				return fmt.Errorf("unable to resume %s: %v", w.path, errInvalidFormat)
+			}
+			if w.header, err = parseHeader(state); err != nil {
+				return fmt.Errorf("unable to resume %s: %v", w.path, err)
+			}
+			w.buf = w.buf[:copy(w.buf[:frameSize], state[headerSize:])]
+			w.hasPartial = true
+		case errors.As(err, new(storagedriver.PathNotFoundError)):
+			if size > 0 {
+				// no partial frame, read the header of the file
+				rc, err := w.parent.StorageDriver.Reader(w.ctx, w.path, 0)
+				if err != nil {
+					return err
+				}
+				w.header, err = readHeader(rc)
+				rc.Close()
+				if err != nil {
+					return fmt.Errorf("unable to resume %s: %v", w.path, err)
+				}
+			}
+		default:
+			return err
+		}
+	}
+
+	if size > 0 {
+		if _, err := plainSize(size); err != nil || (size-int64(headerSize))%sealedFrameSize != 0 {
+			return fmt.Errorf("unable to resume %s: %v", w.path, errInvalidFormat)
+		}
+		w.headerWritten = true
+		w.frame = uint64((size - int64(headerSize)) / sealedFrameSize)
+	}
+
+	if w.header.keyID == "" {
+		h, err := newHeader(w.parent.keys.active)
+		if err != nil {
+			return err
+		}
+		w.header = h
+	}
+
+	aead, err := w.parent.keys.aead(w.header)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt %s: %v", w.path, err)
+	}
+	w.aead = aead
+	return nil
+}
+
+func (w *encryptWriter) partialPath() string {
+	return w.path + partialSuffix
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		if len(w.buf) == frameSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(w.buf[len(w.buf):frameSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// flush seals the buffered frame and writes it.
+func (w *encryptWriter) flush(last bool) error {
+	sealed := make([]byte, 0, headerSize+len(w.buf)+tagSize)
+	if !w.headerWritten {
+		sealed = append(sealed, w.header.marshal()...)
+	}
+	sealed = w.aead.Seal(sealed, frameNonce(w.frame), w.buf, w.header.additionalData(last))
+	if _, err := w.fw.Write(sealed); err != nil {
+		return err
+	}
+	w.headerWritten = true
+	w.frame++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Size returns the size of the plaintext written.
+func (w *encryptWriter) Size() int64 {
+	return int64(w.frame)*frameSize + int64(len(w.buf))
+}
+
+// Close saves the partial frame of an uncommitted writer, so that it can be
+// resumed.
+func (w *encryptWriter) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if !w.committed && !w.cancelled {
+		state := append(w.header.marshal(), w.buf...)
+		if err := w.parent.PutContent(w.ctx, w.partialPath(), state); err != nil {
+			w.fw.Close()
+			return fmt.Errorf("unable to save partial frame of %s: %v", w.path, err)
+		}
+	}
+	return w.fw.Close()
+}
+
+func (w *encryptWriter) Cancel(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+
+	if err := w.fw.Cancel(ctx); err != nil {
+		return err
+	}
+	return w.deletePartial(ctx)
+}
+
+// Commit seals the last frame and commits the file. A file resumed without
+// its partial frame ends with an empty frame.
+func (w *encryptWriter) Commit(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+
+	if len(w.buf) > 0 || w.headerWritten {
+		if err := w.flush(true); err != nil {
+			return err
+		}
+	}
+	if err := w.fw.Commit(ctx); err != nil {
+		return err
+	}
+	w.committed = true
+	return w.deletePartial(ctx)
+}
+
+func (w *encryptWriter) deletePartial(ctx context.Context) error {
+	if !w.hasPartial {
+		return nil
+	}
+	err := w.parent.StorageDriver.Delete(ctx, w.partialPath())
+	if err != nil && !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		return err
+	}
+	w.hasPartial = false
+	return nil
+}
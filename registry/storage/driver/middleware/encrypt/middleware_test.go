@@ -0,0 +1,383 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+const blobPath = "/docker/registry/v2/blobs/sha256/ab/abcdef0123456789/data"
+
+func writeKeyfile(t *testing.T, ids ...string) string {
+	var b bytes.Buffer
+	b.WriteString("# test keys\n\n")
+	for _, id := range ids {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		require.NoError(t, err)
+		b.WriteString(id + " " + base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	p := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(p, b.Bytes(), 0o600))
+	return p
+}
+
+func newTestMiddleware(t *testing.T, sd storagedriver.StorageDriver, keyfile, active string) *encryptStorageMiddleware {
+	options := map[string]interface{}{"keyfile": keyfile}
+	if active != "" {
+		options["activekey"] = active
+	}
+	middleware, err := newEncryptStorageMiddleware(context.Background(), sd, options)
+	require.NoError(t, err)
+	return middleware.(*encryptStorageMiddleware)
+}
+
+func randomContent(t *testing.T, size int) []byte {
+	p := make([]byte, size)
+	_, err := rand.Read(p)
+	require.NoError(t, err)
+	return p
+}
+
+func readAll(t *testing.T, sd storagedriver.StorageDriver, path string, offset int64) []byte {
+	rc, err := sd.Reader(context.Background(), path, offset)
+	require.NoError(t, err)
+	defer rc.Close()
+	p, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return p
+}
+
+func TestOptions(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := newEncryptStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{})
+	require.ErrorContains(t, err, "no keyfile provided")
+
+	_, err = newEncryptStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{"keyfile": filepath.Join(t.TempDir(), "missing")})
+	require.ErrorContains(t, err, "unable to load keys")
+
+	keyfile := writeKeyfile(t, "a", "b")
+	_, err = newEncryptStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{"keyfile": keyfile, "activekey": "c"})
+	require.ErrorContains(t, err, "active key c not found")
+
+	require.Equal(t, "b", newTestMiddleware(t, inmemory.New(), keyfile, "").keys.active)
+	require.Equal(t, "a", newTestMiddleware(t, inmemory.New(), keyfile, "a").keys.active)
+
+	for _, content := range []string{
+		"a\n",
+		"a !!!\n",
+		"a " + base64.StdEncoding.EncodeToString([]byte("short")) + "\n",
+		"a " + base64.StdEncoding.EncodeToString(make([]byte, 16)) + "\na " + base64.StdEncoding.EncodeToString(make([]byte, 16)) + "\n",
+		"# no keys\n",
+	} {
+		p := filepath.Join(t.TempDir(), "keys")
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+		_, err := loadKeyring(p, "")
+		require.Error(t, err, content)
+	}
+}
+
+func TestPlainSize(t *testing.T) {
+	for _, size := range []int64{0, 1, frameSize - 1, frameSize, frameSize + 1, 3*frameSize + 17} {
+		sealed, err := (&keyring{keys: map[string][]byte{"a": make([]byte, 32)}, active: "a"}).seal(make([]byte, size))
+		require.NoError(t, err)
+		actual, err := plainSize(int64(len(sealed)))
+		require.NoError(t, err)
+		require.Equal(t, size, actual)
+	}
+
+	_, err := plainSize(int64(headerSize - 1))
+	require.Error(t, err)
+	_, err = plainSize(int64(headerSize + tagSize - 1))
+	require.Error(t, err)
+
+	// an empty last frame
+	actual, err := plainSize(int64(headerSize + sealedFrameSize + tagSize))
+	require.NoError(t, err)
+	require.Equal(t, int64(frameSize), actual)
+}
+
+func TestContent(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	e := newTestMiddleware(t, sd, writeKeyfile(t, "a"), "")
+
+	content := randomContent(t, 2*frameSize+100)
+	require.NoError(t, e.PutContent(ctx, blobPath, content))
+
+	raw, err := sd.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(raw, content[:64]), "content stored in plaintext")
+
+	actual, err := e.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, content, actual)
+
+	fi, err := e.Stat(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), fi.Size())
+
+	err = e.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			require.Equal(t, int64(len(content)), fi.Size())
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, e.PutContent(ctx, "/empty", nil))
+	actual, err = e.GetContent(ctx, "/empty")
+	require.NoError(t, err)
+	require.Empty(t, actual)
+	require.Empty(t, readAll(t, e, "/empty", 0))
+
+	url, err := e.RedirectURL(nil, blobPath)
+	require.NoError(t, err)
+	require.Empty(t, url)
+}
+
+func TestReader(t *testing.T) {
+	ctx := context.Background()
+	e := newTestMiddleware(t, inmemory.New(), writeKeyfile(t, "a"), "")
+
+	content := randomContent(t, 3*frameSize+123)
+	require.NoError(t, e.PutContent(ctx, blobPath, content))
+
+	for _, offset := range []int64{0, 1, frameSize - 1, frameSize, 2*frameSize + 5, 3 * frameSize, int64(len(content)) - 1, int64(len(content))} {
+		require.Equal(t, content[offset:], readAll(t, e, blobPath, offset), "offset %d", offset)
+	}
+
+	_, err := e.Reader(ctx, blobPath, int64(len(content))+1)
+	require.ErrorAs(t, err, new(storagedriver.InvalidOffsetError))
+	_, err = e.Reader(ctx, blobPath, -1)
+	require.ErrorAs(t, err, new(storagedriver.InvalidOffsetError))
+	_, err = e.Reader(ctx, "/missing", 0)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+}
+
+func TestTampering(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	e := newTestMiddleware(t, sd, writeKeyfile(t, "a"), "")
+
+	content := randomContent(t, 2*frameSize)
+	require.NoError(t, e.PutContent(ctx, blobPath, content))
+
+	raw, err := sd.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	raw[len(raw)-1] ^= 1
+	require.NoError(t, sd.PutContent(ctx, blobPath, raw))
+
+	_, err = e.GetContent(ctx, blobPath)
+	require.Error(t, err)
+
+	rc, err := e.Reader(ctx, blobPath, 0)
+	require.NoError(t, err)
+	defer rc.Close()
+	_, err = io.ReadAll(rc)
+	require.ErrorContains(t, err, "unable to decrypt frame 1")
+}
+
+func TestTruncation(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	e := newTestMiddleware(t, sd, writeKeyfile(t, "a"), "")
+
+	content := randomContent(t, 2*frameSize+10)
+	require.NoError(t, e.PutContent(ctx, blobPath, content))
+
+	// dropping the last frame leaves a valid size, but no last frame
+	raw, err := sd.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.NoError(t, sd.PutContent(ctx, blobPath, raw[:frameOffset(2)]))
+
+	_, err = e.GetContent(ctx, blobPath)
+	require.Error(t, err)
+	rc, err := e.Reader(ctx, blobPath, 0)
+	require.NoError(t, err)
+	defer rc.Close()
+	_, err = io.ReadAll(rc)
+	require.ErrorContains(t, err, "truncated after frame 2")
+}
+
+func TestSplicedFiles(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	e := newTestMiddleware(t, sd, writeKeyfile(t, "a"), "")
+
+	content := randomContent(t, frameSize+10)
+	require.NoError(t, e.PutContent(ctx, blobPath, content))
+	raw, err := sd.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+
+	// the frames are bound to the id of their file
+	spliced := bytes.Clone(raw)
+	spliced[headerSize-1] ^= 1
+	require.NoError(t, sd.PutContent(ctx, blobPath, spliced))
+	_, err = e.GetContent(ctx, blobPath)
+	require.Error(t, err)
+	rc, err := e.Reader(ctx, blobPath, 0)
+	require.NoError(t, err)
+	defer rc.Close()
+	_, err = io.ReadAll(rc)
+	require.ErrorContains(t, err, "unable to decrypt frame 0")
+
+	// moves keep the content as it is
+	require.NoError(t, sd.PutContent(ctx, "/upload/data", raw))
+	require.NoError(t, e.Move(ctx, "/upload/data", blobPath))
+	moved, err := sd.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, raw, moved)
+	require.Equal(t, content, readAll(t, e, blobPath, 0))
+	_, err = sd.Stat(ctx, "/upload/data")
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+}
+
+func TestWalkUndecodableSizes(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	e := newTestMiddleware(t, sd, writeKeyfile(t, "a"), "")
+
+	// stored before encryption was enabled
+	require.NoError(t, sd.PutContent(ctx, "/uploads/id/startedat", []byte("2024-01-01T00:00:00Z")))
+	require.NoError(t, e.PutContent(ctx, "/uploads/id/hashstates/sha256/0", []byte("state")))
+
+	sizes := make(map[string]int64)
+	err := e.Walk(ctx, "/uploads", func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			sizes[fi.Path()] = fi.Size()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{
+		"/uploads/id/startedat":           int64(len("2024-01-01T00:00:00Z")),
+		"/uploads/id/hashstates/sha256/0": int64(len("state")),
+	}, sizes)
+}
+
+func TestWriterResume(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	e := newTestMiddleware(t, sd, writeKeyfile(t, "a"), "")
+
+	content := randomContent(t, 2*frameSize+300)
+	chunks := [][]byte{content[:100], content[100 : frameSize+50], content[frameSize+50:]}
+
+	for i, chunk := range chunks {
+		w, err := e.Writer(ctx, blobPath, i > 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(content)-len(bytes.Join(chunks[i:], nil))), w.Size())
+
+		_, err = w.Write(chunk)
+		require.NoError(t, err)
+		if i == len(chunks)-1 {
+			require.NoError(t, w.Commit(ctx))
+		}
+		require.NoError(t, w.Close())
+	}
+
+	require.Equal(t, content, readAll(t, e, blobPath, 0))
+	fi, err := e.Stat(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), fi.Size())
+
+	_, err = sd.Stat(ctx, blobPath+partialSuffix)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+}
+
+func TestWriterFrameBoundary(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	e := newTestMiddleware(t, sd, writeKeyfile(t, "a"), "")
+
+	// the last frame is full
+	content := randomContent(t, 2*frameSize)
+	w, err := e.Writer(ctx, blobPath, false)
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, w.Close())
+	require.Equal(t, content, readAll(t, e, blobPath, 0))
+
+	// a writer resumed without its partial frame ends with an empty frame
+	w, err = e.Writer(ctx, "/upload", false)
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, sd.Delete(ctx, "/upload"+partialSuffix))
+
+	w, err = e.Writer(ctx, "/upload", true)
+	require.NoError(t, err)
+	require.Equal(t, int64(frameSize), w.Size())
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, w.Close())
+	require.Equal(t, content[:frameSize], readAll(t, e, "/upload", 0))
+	require.Equal(t, content[10:frameSize], readAll(t, e, "/upload", 10))
+	fi, err := e.Stat(ctx, "/upload")
+	require.NoError(t, err)
+	require.Equal(t, int64(frameSize), fi.Size())
+}
+
+func TestWriterCancel(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	e := newTestMiddleware(t, sd, writeKeyfile(t, "a"), "")
+
+	w, err := e.Writer(ctx, blobPath, false)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	partial, err := e.GetContent(ctx, blobPath+partialSuffix)
+	require.NoError(t, err)
+	require.Equal(t, "partial", string(partial[headerSize:]))
+
+	w, err = e.Writer(ctx, blobPath, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("partial")), w.Size())
+	require.NoError(t, w.Cancel(ctx))
+	require.NoError(t, w.Close())
+
+	_, err = sd.Stat(ctx, blobPath+partialSuffix)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	keyfile := writeKeyfile(t, "old", "new")
+
+	old := newTestMiddleware(t, sd, keyfile, "old")
+	require.NoError(t, old.PutContent(ctx, "/old", []byte("old content")))
+
+	rotated := newTestMiddleware(t, sd, keyfile, "new")
+	require.NoError(t, rotated.PutContent(ctx, "/new", []byte("new content")))
+
+	content, err := rotated.GetContent(ctx, "/old")
+	require.NoError(t, err)
+	require.Equal(t, "old content", string(content))
+
+	raw, err := sd.GetContent(ctx, "/new")
+	require.NoError(t, err)
+	h, err := parseHeader(raw)
+	require.NoError(t, err)
+	require.Equal(t, "new", h.keyID)
+
+	other := newTestMiddleware(t, sd, writeKeyfile(t, "other"), "")
+	_, err = other.GetContent(ctx, "/old")
+	require.ErrorContains(t, err, `unknown encryption key "old"`)
+}
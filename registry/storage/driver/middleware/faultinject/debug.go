@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// debugPath is the path of the handler controlling the faults, served by the
+// debug server. Each middleware is controlled under its name, as
+// debugPath/<name>, and the one named defaultName under debugPath too.
+const debugPath = "/debug/faultinject"
+
+var (
+	registerHandler sync.Once
+
+	instancesMu sync.Mutex
+	instances   = make(map[string]*faultInjectStorageMiddleware)
+)
+
+// register makes f controlled by the debug handler, which is registered on
+// the first call. A middleware created with the name of another one
+// replaces it.
+func register(f *faultInjectStorageMiddleware) (replaced bool) {
+	registerHandler.Do(func() {
+		http.Handle(debugPath, http.HandlerFunc(debugHandler))
+		http.Handle(debugPath+"/", http.HandlerFunc(debugHandler))
+	})
+
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	_, replaced = instances[f.name]
+	instances[f.name] = f
+	return replaced
+}
+
+// unregister stops controlling f, unless it has been replaced.
+func unregister(f *faultInjectStorageMiddleware) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	if instances[f.name] == f {
+		delete(instances, f.name)
+	}
+}
+
+func lookup(name string) *faultInjectStorageMiddleware {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	return instances[name]
+}
+
+// debugHandler controls the rules of the middleware at runtime. GET returns
+// the rules with their counters, PUT replaces them with the rules of the
+// request body, and DELETE removes them.
+func debugHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, debugPath), "/")
+	if name == "" {
+		name = defaultName
+	}
+	f := lookup(name)
+	if f == nil {
+		http.Error(w, fmt.Sprintf("no faultinject storage middleware named %q is configured", name), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Rules []rule `json:"rules"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid rules: %v", err), http.StatusBadRequest)
+			return
+		}
+		rules, err := compileRules(body.Rules)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.setRules(rules)
+	case http.MethodDelete:
+		f.setRules(nil)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Rules []ruleStatus `json:"rules"`
+	}{f.status()}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,307 @@
+// Package middleware provides a storage middleware injecting faults in the
+// calls to the storage driver, to test the behavior of the registry with
+// unreliable storage.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/optionutil"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	if err := storagemiddleware.Register("faultinject", newFaultInjectStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register faultinject storage middleware: %v", err)
+	}
+}
+
+// defaultName is the name of a middleware without a name option.
+const defaultName = "default"
+
+// faultInjectStorageMiddleware injects the faults of its rules in the calls
+// to the storage driver matching them.
+type faultInjectStorageMiddleware struct {
+	storagedriver.StorageDriver
+	name string
+
+	mu    sync.Mutex
+	rules []*faultRule
+	rand  *rand.Rand
+}
+
+var _ storagedriver.StorageDriver = &faultInjectStorageMiddleware{}
+
+func newFaultInjectStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	name := defaultName
+	if o, ok := options["name"]; ok {
+		s, ok := o.(string)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("name must be a non-empty string")
+		}
+		name = s
+	}
+
+	seed := time.Now().UnixNano()
+	if o, ok := options["seed"]; ok {
+		s, ok := o.(int)
+		if !ok {
+			return nil, fmt.Errorf("seed must be an integer")
+		}
+		seed = int64(s)
+	}
+
+	var rules []rule
+	if o, ok := options["rules"]; ok {
+		if err := optionutil.Decode(o, &rules, true); err != nil {
+			return nil, fmt.Errorf("invalid rules: %v", err)
+		}
+	}
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &faultInjectStorageMiddleware{
+		StorageDriver: sd,
+		name:          name,
+		rules:         compiled,
+		rand:          rand.New(rand.NewSource(seed)),
+	}
+	if register(f) {
+		dcontext.GetLogger(ctx).Warnf("faultinject: middleware %s replaces the one of the same name in %s/%s", name, debugPath, name)
+	}
+
+	dcontext.GetLogger(ctx).Warnf("faultinject: injecting faults in the storage driver, middleware %s, %d rules", name, len(compiled))
+	return f, nil
+}
+
+// Close stops controlling the middleware through the debug handler.
+func (f *faultInjectStorageMiddleware) Close() error {
+	unregister(f)
+	return nil
+}
+
+// setRules replaces the rules, resetting the counters.
+func (f *faultInjectStorageMiddleware) setRules(rules []*faultRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+}
+
+// status returns the rules with their counters.
+func (f *faultInjectStorageMiddleware) status() []ruleStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := make([]ruleStatus, 0, len(f.rules))
+	for _, r := range f.rules {
+		status = append(status, r.status())
+	}
+	return status
+}
+
+// inject applies the rules matching a call of method on path. Latency faults
+// are applied in full, then the first other fault selected is returned: its
+// error if it fails the call, or the rule itself for the faults the method
+// has to apply.
+func (f *faultInjectStorageMiddleware) inject(ctx context.Context, method, path string) (*faultRule, error) {
+	var latency time.Duration
+	var selected *faultRule
+
+	f.mu.Lock()
+	for _, r := range f.rules {
+		if !r.matches(method, path) || !r.scheduled() {
+			continue
+		}
+		if r.Probability < 1 && f.rand.Float64() >= r.Probability {
+			continue
+		}
+		if r.Fault == faultLatency {
+			r.injected++
+			latency += r.latency
+			continue
+		}
+		if selected == nil {
+			r.injected++
+			selected = r
+		}
+	}
+	f.mu.Unlock()
+
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	if selected == nil {
+		return nil, nil
+	}
+	dcontext.GetLogger(ctx).Debugf("faultinject: injecting %s in %s of %s", selected.Fault, method, path)
+	switch selected.Fault {
+	case faultError:
+		return nil, injectedError(method, path, selected.Message)
+	case faultNotFound:
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: f.Name()}
+	}
+	return selected, nil
+}
+
+// injectedError returns the error injected in a call of method on path.
+func injectedError(method, path, message string) error {
+	if message == "" {
+		message = fmt.Sprintf("injected fault in %s of %s", method, path)
+	}
+	return storagedriver.Error{DriverName: "faultinject", Detail: errors.New(message)}
+}
+
+// GetContent returns the content at p, possibly truncated.
+func (f *faultInjectStorageMiddleware) GetContent(ctx context.Context, p string) ([]byte, error) {
+	fault, err := f.inject(ctx, "GetContent", p)
+	if err != nil {
+		return nil, err
+	}
+	content, err := f.StorageDriver.GetContent(ctx, p)
+	if err != nil || fault == nil {
+		return content, err
+	}
+	return content[:fault.bytes(len(content))], nil
+}
+
+// PutContent stores content at p, possibly only part of it.
+func (f *faultInjectStorageMiddleware) PutContent(ctx context.Context, p string, content []byte) error {
+	fault, err := f.inject(ctx, "PutContent", p)
+	if err != nil {
+		return err
+	}
+	if fault == nil {
+		return f.StorageDriver.PutContent(ctx, p, content)
+	}
+	if err := f.StorageDriver.PutContent(ctx, p, content[:fault.bytes(len(content))]); err != nil {
+		return err
+	}
+	return injectedError("PutContent", p, fault.Message)
+}
+
+// Reader returns a reader of the content at p, possibly truncated.
+func (f *faultInjectStorageMiddleware) Reader(ctx context.Context, p string, offset int64) (io.ReadCloser, error) {
+	fault, err := f.inject(ctx, "Reader", p)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.StorageDriver.Reader(ctx, p, offset)
+	if err != nil || fault == nil {
+		return rc, err
+	}
+
+	limit := 0
+	if fault.Bytes != nil {
+		limit = *fault.Bytes
+	}
+	return &truncatedReader{Reader: io.LimitReader(rc, int64(limit)), Closer: rc}, nil
+}
+
+type truncatedReader struct {
+	io.Reader
+	io.Closer
+}
+
+// Writer returns a writer injecting faults in Write and Commit.
+func (f *faultInjectStorageMiddleware) Writer(ctx context.Context, p string, append bool) (storagedriver.FileWriter, error) {
+	if _, err := f.inject(ctx, "Writer", p); err != nil {
+		return nil, err
+	}
+	w, err := f.StorageDriver.Writer(ctx, p, append)
+	if err != nil {
+		return nil, err
+	}
+	return &faultInjectWriter{FileWriter: w, ctx: ctx, parent: f, path: p}, nil
+}
+
+func (f *faultInjectStorageMiddleware) Stat(ctx context.Context, p string) (storagedriver.FileInfo, error) {
+	if _, err := f.inject(ctx, "Stat", p); err != nil {
+		return nil, err
+	}
+	return f.StorageDriver.Stat(ctx, p)
+}
+
+func (f *faultInjectStorageMiddleware) List(ctx context.Context, p string) ([]string, error) {
+	if _, err := f.inject(ctx, "List", p); err != nil {
+		return nil, err
+	}
+	return f.StorageDriver.List(ctx, p)
+}
+
+func (f *faultInjectStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if _, err := f.inject(ctx, "Move", sourcePath); err != nil {
+		return err
+	}
+	return f.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+func (f *faultInjectStorageMiddleware) Delete(ctx context.Context, p string) error {
+	if _, err := f.inject(ctx, "Delete", p); err != nil {
+		return err
+	}
+	return f.StorageDriver.Delete(ctx, p)
+}
+
+func (f *faultInjectStorageMiddleware) RedirectURL(r *http.Request, p string) (string, error) {
+	if _, err := f.inject(r.Context(), "RedirectURL", p); err != nil {
+		return "", err
+	}
+	return f.StorageDriver.RedirectURL(r, p)
+}
+
+func (f *faultInjectStorageMiddleware) Walk(ctx context.Context, p string, fn storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	if _, err := f.inject(ctx, "Walk", p); err != nil {
+		return err
+	}
+	return f.StorageDriver.Walk(ctx, p, fn, options...)
+}
+
+// faultInjectWriter injects faults in the Write and Commit calls of a file
+// writer.
+type faultInjectWriter struct {
+	storagedriver.FileWriter
+	ctx    context.Context
+	parent *faultInjectStorageMiddleware
+	path   string
+}
+
+func (w *faultInjectWriter) Write(p []byte) (int, error) {
+	fault, err := w.parent.inject(w.ctx, "Write", w.path)
+	if err != nil {
+		return 0, err
+	}
+	if fault == nil {
+		return w.FileWriter.Write(p)
+	}
+	n, err := w.FileWriter.Write(p[:fault.bytes(len(p))])
+	if err != nil {
+		return n, err
+	}
+	return n, injectedError("Write", w.path, fault.Message)
+}
+
+func (w *faultInjectWriter) Commit(ctx context.Context) error {
+	if _, err := w.parent.inject(ctx, "Commit", w.path); err != nil {
+		return err
+	}
+	return w.FileWriter.Commit(ctx)
+}
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+const blobPath = "/docker/registry/v2/blobs/sha256/ab/abcdef0123456789/data"
+
+func newTestMiddleware(t *testing.T, rules ...map[string]interface{}) *faultInjectStorageMiddleware {
+	list := make([]interface{}, 0, len(rules))
+	for _, r := range rules {
+		list = append(list, r)
+	}
+	middleware, err := newFaultInjectStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{
+		"seed":  1,
+		"rules": list,
+	})
+	require.NoError(t, err)
+	f := middleware.(*faultInjectStorageMiddleware)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestOptions(t *testing.T) {
+	for _, tc := range []struct {
+		rule map[string]interface{}
+		err  string
+	}{
+		{map[string]interface{}{"fault": "explode"}, `unknown fault "explode"`},
+		{map[string]interface{}{"fault": "latency"}, "latency must be a positive duration"},
+		{map[string]interface{}{"fault": "partialwrite", "methods": []interface{}{"Stat"}}, "fault partialwrite cannot be injected in Stat"},
+		{map[string]interface{}{"fault": "error", "path": "("}, "invalid path"},
+		{map[string]interface{}{"fault": "error", "probability": 2.0}, "probability must be between 0 and 1"},
+		{map[string]interface{}{"fault": "error", "every": -1}, "must not be negative"},
+		{map[string]interface{}{"fault": "error", "unknown": 1}, "invalid rules"},
+	} {
+		_, err := newFaultInjectStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{
+			"rules": []interface{}{tc.rule},
+		})
+		require.ErrorContains(t, err, tc.err)
+	}
+
+	_, err := newFaultInjectStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{"seed": "x"})
+	require.ErrorContains(t, err, "seed must be an integer")
+	_, err = newFaultInjectStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{"name": ""})
+	require.ErrorContains(t, err, "name must be a non-empty string")
+}
+
+func TestSchedule(t *testing.T) {
+	ctx := context.Background()
+	f := newTestMiddleware(t, map[string]interface{}{
+		"fault":   "error",
+		"methods": []interface{}{"PutContent"},
+		"path":    "^/blobs/",
+		"after":   1,
+		"every":   2,
+		"count":   2,
+		"message": "flaky",
+	})
+
+	var failed []int
+	for i := 1; i <= 8; i++ {
+		err := f.PutContent(ctx, "/blobs/a", []byte("content"))
+		if err != nil {
+			require.ErrorContains(t, err, "flaky")
+			failed = append(failed, i)
+		}
+	}
+	require.Equal(t, []int{3, 5}, failed)
+
+	// other methods and paths are not affected
+	require.NoError(t, f.PutContent(ctx, "/other", []byte("content")))
+	_, err := f.GetContent(ctx, "/blobs/a")
+	require.NoError(t, err)
+
+	status := f.status()
+	require.Len(t, status, 1)
+	require.Equal(t, 8, status[0].Matched)
+	require.Equal(t, 2, status[0].Injected)
+}
+
+func TestProbability(t *testing.T) {
+	ctx := context.Background()
+	f := newTestMiddleware(t, map[string]interface{}{"fault": "notfound", "methods": []interface{}{"Stat"}, "probability": 0.5})
+	require.NoError(t, f.StorageDriver.PutContent(ctx, blobPath, []byte("content")))
+
+	failed := 0
+	for i := 0; i < 1000; i++ {
+		_, err := f.Stat(ctx, blobPath)
+		if err != nil {
+			require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+			failed++
+		}
+	}
+	require.InDelta(t, 500, failed, 100)
+}
+
+func TestLatency(t *testing.T) {
+	ctx := context.Background()
+	f := newTestMiddleware(t, map[string]interface{}{"fault": "latency", "latency": "50ms", "methods": []interface{}{"List"}})
+
+	start := time.Now()
+	_, err := f.List(ctx, "/")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = f.List(ctx, "/")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestPartialWrite(t *testing.T) {
+	ctx := context.Background()
+	bytes := 3
+	f := newTestMiddleware(t,
+		map[string]interface{}{"fault": "partialwrite", "methods": []interface{}{"PutContent"}},
+		map[string]interface{}{"fault": "partialwrite", "methods": []interface{}{"Write"}, "bytes": bytes},
+	)
+
+	require.Error(t, f.PutContent(ctx, "/put", []byte("0123456789")))
+	content, err := f.StorageDriver.GetContent(ctx, "/put")
+	require.NoError(t, err)
+	require.Equal(t, "01234", string(content))
+
+	w, err := f.Writer(ctx, "/write", false)
+	require.NoError(t, err)
+	n, err := w.Write([]byte("0123456789"))
+	require.Error(t, err)
+	require.Equal(t, bytes, n)
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, w.Close())
+
+	content, err = f.StorageDriver.GetContent(ctx, "/write")
+	require.NoError(t, err)
+	require.Equal(t, "012", string(content))
+}
+
+func TestTruncatedRead(t *testing.T) {
+	ctx := context.Background()
+	bytes := 4
+	f := newTestMiddleware(t,
+		map[string]interface{}{"fault": "truncatedread", "methods": []interface{}{"GetContent"}},
+		map[string]interface{}{"fault": "truncatedread", "methods": []interface{}{"Reader"}, "bytes": bytes},
+	)
+	require.NoError(t, f.StorageDriver.PutContent(ctx, blobPath, []byte("0123456789")))
+
+	content, err := f.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, "01234", string(content))
+
+	rc, err := f.Reader(ctx, blobPath, 2)
+	require.NoError(t, err)
+	defer rc.Close()
+	content, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "2345", string(content))
+}
+
+func TestDebugHandler(t *testing.T) {
+	ctx := context.Background()
+	f := newTestMiddleware(t)
+	server := httptest.NewServer(http.HandlerFunc(debugHandler))
+	defer server.Close()
+
+	do := func(method, body string) (int, []ruleStatus) {
+		req, err := http.NewRequest(method, server.URL, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var status struct {
+			Rules []ruleStatus `json:"rules"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		}
+		return resp.StatusCode, status.Rules
+	}
+
+	code, rules := do(http.MethodGet, "")
+	require.Equal(t, http.StatusOK, code)
+	require.Empty(t, rules)
+
+	code, _ = do(http.MethodPut, `{"rules": [{"fault": "explode"}]}`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, rules = do(http.MethodPut, `{"rules": [{"fault": "error", "methods": ["Delete"], "count": 1}]}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, rules, 1)
+
+	require.Error(t, f.Delete(ctx, "/a"))
+	require.ErrorAs(t, f.Delete(ctx, "/a"), new(storagedriver.PathNotFoundError))
+
+	code, rules = do(http.MethodGet, "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []string{"Delete"}, rules[0].Methods)
+	require.Equal(t, 2, rules[0].Matched)
+	require.Equal(t, 1, rules[0].Injected)
+
+	code, rules = do(http.MethodDelete, "")
+	require.Equal(t, http.StatusOK, code)
+	require.Empty(t, rules)
+
+	code, _ = do(http.MethodPost, "")
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func TestDebugHandlerNames(t *testing.T) {
+	ctx := context.Background()
+	middleware, err := newFaultInjectStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{"name": "cold"})
+	require.NoError(t, err)
+	cold := middleware.(*faultInjectStorageMiddleware)
+	hot := newTestMiddleware(t)
+
+	// the middlewares are controlled through the debug server
+	server := httptest.NewServer(http.DefaultServeMux)
+	defer server.Close()
+
+	put := func(path string) int {
+		req, err := http.NewRequest(http.MethodPut, server.URL+path, strings.NewReader(`{"rules": [{"fault": "error", "methods": ["Delete"]}]}`))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, put(debugPath+"/cold"))
+	require.Error(t, cold.Delete(ctx, "/a"))
+	require.ErrorAs(t, hot.Delete(ctx, "/a"), new(storagedriver.PathNotFoundError))
+
+	require.Equal(t, http.StatusOK, put(debugPath))
+	require.Error(t, hot.Delete(ctx, "/a"))
+
+	require.NoError(t, cold.Close())
+	require.Equal(t, http.StatusNotFound, put(debugPath+"/cold"))
+}
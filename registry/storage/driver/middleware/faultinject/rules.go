@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Faults injected by the rules.
+const (
+	// faultError fails the call.
+	faultError = "error"
+	// faultNotFound fails the call with a storagedriver.PathNotFoundError.
+	faultNotFound = "notfound"
+	// faultLatency delays the call.
+	faultLatency = "latency"
+	// faultPartialWrite writes part of the content, then fails the call.
+	faultPartialWrite = "partialwrite"
+	// faultTruncatedRead returns part of the content, as if it was truncated.
+	faultTruncatedRead = "truncatedread"
+)
+
+// Methods faults can be injected in. Write and Commit are the methods of
+// the file writers.
+var methods = []string{
+	"GetContent", "PutContent", "Reader", "Writer", "Write", "Commit",
+	"Stat", "List", "Move", "Delete", "RedirectURL", "Walk",
+}
+
+// faultMethods restricts the methods of the faults which only apply to some
+// methods.
+var faultMethods = map[string][]string{
+	faultPartialWrite:  {"PutContent", "Write"},
+	faultTruncatedRead: {"GetContent", "Reader"},
+}
+
+// rule configures a fault injected in the calls matching it. The matching
+// calls are counted, and the fault is injected in those selected by the
+// schedule, with the given probability.
+type rule struct {
+	// Fault is the fault injected.
+	Fault string `yaml:"fault" json:"fault"`
+
+	// Methods are the methods the rule applies to. Defaults to all the
+	// methods supporting the fault.
+	Methods []string `yaml:"methods,omitempty" json:"methods,omitempty"`
+
+	// Path is a regular expression matching the paths the rule applies to.
+	// The path of Move is its source. Defaults to all paths.
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+
+	// Probability is the probability of injecting the fault in a call
+	// selected by the schedule. Defaults to 1.
+	Probability float64 `yaml:"probability,omitempty" json:"probability,omitempty"`
+
+	// After skips the first matching calls.
+	After int `yaml:"after,omitempty" json:"after,omitempty"`
+
+	// Every selects one matching call out of every, after the skipped ones.
+	Every int `yaml:"every,omitempty" json:"every,omitempty"`
+
+	// Count is the maximum number of faults injected, unlimited if zero.
+	Count int `yaml:"count,omitempty" json:"count,omitempty"`
+
+	// Latency is the delay of the latency fault, such as "500ms".
+	Latency string `yaml:"latency,omitempty" json:"latency,omitempty"`
+
+	// Bytes is the number of bytes written by partialwrite or returned by
+	// truncatedread. Defaults to half of the content, or to 0 for Reader.
+	Bytes *int `yaml:"bytes,omitempty" json:"bytes,omitempty"`
+
+	// Message is the message of the error fault.
+	Message string `yaml:"message,omitempty" json:"message,omitempty"`
+}
+
+// faultRule is a validated rule with its counters.
+type faultRule struct {
+	rule
+	methods map[string]bool
+	path    *regexp.Regexp
+	latency time.Duration
+
+	matched  int
+	injected int
+}
+
+// ruleStatus is a rule with its counters, as reported by the debug handler.
+type ruleStatus struct {
+	rule
+	Matched  int `json:"matched"`
+	Injected int `json:"injected"`
+}
+
+func compileRule(r rule) (*faultRule, error) {
+	fr := &faultRule{rule: r, methods: make(map[string]bool)}
+
+	switch r.Fault {
+	case faultError, faultNotFound, faultPartialWrite, faultTruncatedRead:
+	case faultLatency:
+		latency, err := time.ParseDuration(r.Latency)
+		if err != nil || latency <= 0 {
+			return nil, fmt.Errorf("latency must be a positive duration")
+		}
+		fr.latency = latency
+	default:
+		return nil, fmt.Errorf("unknown fault %q", r.Fault)
+	}
+
+	supported := methods
+	if m, ok := faultMethods[r.Fault]; ok {
+		supported = m
+	}
+	if len(r.Methods) == 0 {
+		fr.Methods = supported
+	}
+	for _, m := range fr.Methods {
+		if !contains(supported, m) {
+			return nil, fmt.Errorf("fault %s cannot be injected in %s", r.Fault, m)
+		}
+		fr.methods[m] = true
+	}
+
+	if r.Path != "" {
+		path, err := regexp.Compile(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path: %v", err)
+		}
+		fr.path = path
+	}
+
+	if r.Probability < 0 || r.Probability > 1 {
+		return nil, fmt.Errorf("probability must be between 0 and 1")
+	}
+	if r.Probability == 0 {
+		fr.Probability = 1
+	}
+	if r.After < 0 || r.Every < 0 || r.Count < 0 {
+		return nil, fmt.Errorf("after, every and count must not be negative")
+	}
+	if r.Bytes != nil && *r.Bytes < 0 {
+		return nil, fmt.Errorf("bytes must not be negative")
+	}
+	return fr, nil
+}
+
+func compileRules(rules []rule) ([]*faultRule, error) {
+	compiled := make([]*faultRule, 0, len(rules))
+	for i, r := range rules {
+		fr, err := compileRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		compiled = append(compiled, fr)
+	}
+	return compiled, nil
+}
+
+func (fr *faultRule) matches(method, path string) bool {
+	return fr.methods[method] && (fr.path == nil || fr.path.MatchString(path))
+}
+
+// scheduled counts a matching call and reports whether the schedule selects
+// it.
+func (fr *faultRule) scheduled() bool {
+	fr.matched++
+	if fr.Count > 0 && fr.injected >= fr.Count {
+		return false
+	}
+	n := fr.matched - fr.After
+	if n <= 0 {
+		return false
+	}
+	return fr.Every == 0 || n%fr.Every == 0
+}
+
+// bytes returns the number of bytes of content of the given size written or
+// read by the fault.
+func (fr *faultRule) bytes(size int) int {
+	if fr.Bytes == nil {
+		return size / 2
+	}
+	return min(*fr.Bytes, size)
+}
+
+func (fr *faultRule) status() ruleStatus {
+	return ruleStatus{rule: fr.rule, Matched: fr.matched, Injected: fr.injected}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync/atomic"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// Import copies the link files of the repositories from driver into the
+// store configured by options, the options of the metadata storage
+// middleware, and returns the number of files copied. Files already in the
+// store with the same content are not copied. The registry using the store
+// must be stopped during the import.
+func Import(ctx context.Context, driver storagedriver.StorageDriver, options map[string]interface{}, concurrency int) (int, error) {
+	s, err := openStoreFromOptions(options)
+	if err != nil {
+		return 0, err
+	}
+	defer s.close()
+
+	var imported atomic.Int64
+	err = storagedriver.WalkParallel(ctx, driver, repositoriesRoot, concurrency, func(fi storagedriver.FileInfo) error {
+		p := fi.Path()
+		if !isMetadata(p) {
+			// uploads and the other directories of the repositories are not
+			// kept in the store
+			if fi.IsDir() && strings.HasPrefix(p[strings.LastIndex(p, "/")+1:], "_") {
+				return storagedriver.ErrSkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		content, err := driver.GetContent(ctx, p)
+		if err != nil {
+			if isNotFound(err) {
+				// deleted during the walk
+				return nil
+			}
+			return err
+		}
+		if e, ok := s.get(p); ok && bytes.Equal(e.content, content) {
+			return nil
+		}
+		if err := s.put(p, content); err != nil {
+			return err
+		}
+		imported.Add(1)
+		return nil
+	})
+	if isNotFound(err) {
+		err = nil
+	}
+	return int(imported.Load()), err
+}
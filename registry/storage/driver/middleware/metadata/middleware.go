@@ -0,0 +1,481 @@
+// Package middleware provides a storage middleware keeping the metadata of
+// repositories, their tag, manifest revision and layer links, in an embedded
+// database rather than in the storage driver.
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	if err := storagemiddleware.Register("metadata", newMetadataStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register metadata storage middleware: %v", err)
+	}
+}
+
+const driverName = "metadata"
+
+// repositoriesRoot is the root of the repositories.
+const repositoriesRoot = "/docker/registry/v2/repositories"
+
+// metadataStorageMiddleware keeps the _manifests and _layers trees of the
+// repositories in a store, so that tags, manifest revisions and layer links
+// are read, listed and written without round trips to the storage driver.
+// Blob data and uploads stay in the storage driver, and the directories
+// holding both, such as the repositories, are merged.
+type metadataStorageMiddleware struct {
+	storagedriver.StorageDriver
+	store metadataStore
+}
+
+var _ storagedriver.StorageDriver = &metadataStorageMiddleware{}
+
+func newMetadataStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	s, err := openStoreFromOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	dcontext.GetLogger(ctx).Infof("metadata: serving repository metadata from %s", s.path())
+	return &metadataStorageMiddleware{StorageDriver: sd, store: s}, nil
+}
+
+// openStoreFromOptions opens the store configured by the options of the
+// middleware.
+func openStoreFromOptions(options map[string]interface{}) (metadataStore, error) {
+	o, ok := options["path"]
+	if !ok {
+		return nil, fmt.Errorf("no path provided")
+	}
+	p, ok := o.(string)
+	if !ok || p == "" {
+		return nil, fmt.Errorf("path must be a non-empty string")
+	}
+
+	sync := true
+	if o, ok := options["sync"]; ok {
+		if sync, ok = o.(bool); !ok {
+			return nil, fmt.Errorf("sync must be a boolean")
+		}
+	}
+
+	s, err := openStore(p, sync)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open metadata store: %v", err)
+	}
+	return s, nil
+}
+
+// isMetadata reports whether p is kept in the store: p is in the _manifests
+// or _layers tree of a repository.
+func isMetadata(p string) bool {
+	if !strings.HasPrefix(p, repositoriesRoot+"/") {
+		return false
+	}
+	for _, component := range strings.Split(p[len(repositoriesRoot)+1:], "/") {
+		if component == "_manifests" || component == "_layers" {
+			return true
+		}
+		if strings.HasPrefix(component, "_") {
+			return false
+		}
+	}
+	return false
+}
+
+// isMixed reports whether p may be a directory holding both the store and
+// the storage driver content: the repositories, their root and its parents.
+func isMixed(p string) bool {
+	if p == "/" || p == repositoriesRoot || strings.HasPrefix(repositoriesRoot, p+"/") {
+		return true
+	}
+	if !strings.HasPrefix(p, repositoriesRoot+"/") {
+		return false
+	}
+	return !strings.Contains(p[len(repositoriesRoot):], "/_")
+}
+
+func isNotFound(err error) bool {
+	var notFound storagedriver.PathNotFoundError
+	return errors.As(err, &notFound)
+}
+
+func notFound(p string) error {
+	return storagedriver.PathNotFoundError{Path: p, DriverName: driverName}
+}
+
+// Close closes the store, so that another process can open it.
+func (m *metadataStorageMiddleware) Close() error {
+	return m.store.close()
+}
+
+// GetContent returns the content at p.
+func (m *metadataStorageMiddleware) GetContent(ctx context.Context, p string) ([]byte, error) {
+	if !isMetadata(p) {
+		return m.StorageDriver.GetContent(ctx, p)
+	}
+	e, ok := m.store.get(p)
+	if !ok {
+		return nil, notFound(p)
+	}
+	return e.content, nil
+}
+
+// PutContent stores content at p.
+func (m *metadataStorageMiddleware) PutContent(ctx context.Context, p string, content []byte) error {
+	if !isMetadata(p) {
+		return m.StorageDriver.PutContent(ctx, p, content)
+	}
+	return m.store.put(p, content)
+}
+
+// Reader returns a reader of the content at p, from offset.
+func (m *metadataStorageMiddleware) Reader(ctx context.Context, p string, offset int64) (io.ReadCloser, error) {
+	if !isMetadata(p) {
+		return m.StorageDriver.Reader(ctx, p, offset)
+	}
+	e, ok := m.store.get(p)
+	if !ok {
+		return nil, notFound(p)
+	}
+	if offset < 0 || offset > int64(len(e.content)) {
+		return nil, storagedriver.InvalidOffsetError{Path: p, Offset: offset, DriverName: driverName}
+	}
+	return io.NopCloser(bytes.NewReader(e.content[offset:])), nil
+}
+
+// Writer returns a writer of the content at p.
+func (m *metadataStorageMiddleware) Writer(ctx context.Context, p string, append bool) (storagedriver.FileWriter, error) {
+	if !isMetadata(p) {
+		return m.StorageDriver.Writer(ctx, p, append)
+	}
+	w := &storeWriter{store: m.store, path: p}
+	if append {
+		e, ok := m.store.get(p)
+		if !ok {
+			return nil, notFound(p)
+		}
+		w.buf.Write(e.content)
+	}
+	return w, nil
+}
+
+// Stat returns the info of p.
+func (m *metadataStorageMiddleware) Stat(ctx context.Context, p string) (storagedriver.FileInfo, error) {
+	if isMetadata(p) || isMixed(p) {
+		if fi, ok := m.store.stat(p); ok {
+			return fi, nil
+		}
+		if isMetadata(p) {
+			return nil, notFound(p)
+		}
+	}
+	return m.StorageDriver.Stat(ctx, p)
+}
+
+// List lists the children of p, merging those of the store and of the
+// storage driver for the directories holding both.
+func (m *metadataStorageMiddleware) List(ctx context.Context, p string) ([]string, error) {
+	switch {
+	case isMetadata(p):
+		children, ok := m.store.list(p)
+		if !ok {
+			return nil, notFound(p)
+		}
+		return children, nil
+	case !isMixed(p):
+		return m.StorageDriver.List(ctx, p)
+	}
+
+	children, found := m.store.list(p)
+	driverChildren, err := m.StorageDriver.List(ctx, p)
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+		if !found {
+			return nil, err
+		}
+	}
+	for _, child := range driverChildren {
+		// link files left in the storage driver are ignored
+		if !isMetadata(child) && !contains(children, child) {
+			children = append(children, child)
+		}
+	}
+	sort.Strings(children)
+	return children, nil
+}
+
+func contains(sorted []string, s string) bool {
+	i := sort.SearchStrings(sorted, s)
+	return i < len(sorted) && sorted[i] == s
+}
+
+// Move moves sourcePath to destPath, which must both be in the store or in
+// the storage driver.
+func (m *metadataStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	switch source, dest := isMetadata(sourcePath), isMetadata(destPath); {
+	case source && dest:
+		return m.store.move(sourcePath, destPath)
+	case source || dest:
+		return storagedriver.Error{
+			DriverName: driverName,
+			Detail:     fmt.Errorf("cannot move %s to %s between the metadata store and the storage driver", sourcePath, destPath),
+		}
+	}
+	return m.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+// Delete deletes p from the store and the storage driver.
+func (m *metadataStorageMiddleware) Delete(ctx context.Context, p string) error {
+	if !isMetadata(p) && !isMixed(p) {
+		return m.StorageDriver.Delete(ctx, p)
+	}
+
+	found, err := m.store.delete(p)
+	if err != nil || isMetadata(p) {
+		if err == nil && !found {
+			return notFound(p)
+		}
+		return err
+	}
+
+	err = m.StorageDriver.Delete(ctx, p)
+	if found && isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// RedirectURL returns a URL for the content at p, unless it is in the store.
+func (m *metadataStorageMiddleware) RedirectURL(r *http.Request, p string) (string, error) {
+	if isMetadata(p) {
+		return "", nil
+	}
+	return m.StorageDriver.RedirectURL(r, p)
+}
+
+// Walk walks the files under p, merging those of the store and of the storage
+// driver for the directories holding both. The walk of the storage driver is
+// streamed, and the entries of the store are walked along with it, in the
+// order of a depth-first walk, one directory of the store at a time.
+func (m *metadataStorageMiddleware) Walk(ctx context.Context, p string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	if !isMetadata(p) && !isMixed(p) {
+		return m.StorageDriver.Walk(ctx, p, f, options...)
+	}
+
+	walkOptions := &storagedriver.WalkOptions{}
+	for _, o := range options {
+		o(walkOptions)
+	}
+
+	_, found := m.store.stat(p)
+	sw := newStoreWalker(m.store, p, walkOptions.StartAfterHint)
+	if isMixed(p) {
+		err := m.StorageDriver.Walk(ctx, p, func(fi storagedriver.FileInfo) error {
+			if isMetadata(fi.Path()) {
+				// link files left in the storage driver are ignored
+				if fi.IsDir() {
+					return storagedriver.ErrSkipDir
+				}
+				return nil
+			}
+
+			key := walkOrder(fi.Path())
+			if err := sw.walkBefore(key, f); err != nil {
+				return err
+			}
+			if next := sw.peek(); next != nil && next.Path() == fi.Path() {
+				// a directory of both, walked once
+				return sw.walkNext(f)
+			}
+			err := f(fi)
+			if err == storagedriver.ErrFilledBuffer {
+				sw.filled = true
+			}
+			return err
+		}, options...)
+		switch {
+		case err == nil:
+			found = true
+		case !isNotFound(err) || !found:
+			return err
+		}
+		if sw.filled {
+			return nil
+		}
+	}
+	if !found {
+		return notFound(p)
+	}
+
+	err := sw.walkBefore("", f)
+	if err == storagedriver.ErrFilledBuffer {
+		return nil
+	}
+	return err
+}
+
+// walkOrder returns a key of p sorting paths in the order of a depth-first
+// walk, the separator sorting first.
+func walkOrder(p string) string {
+	return strings.ReplaceAll(p, "/", "\x00")
+}
+
+// storeWalker walks the tree of a store depth-first, listing a directory
+// when it is entered.
+type storeWalker struct {
+	store metadataStore
+	hint  string // walk order of the path to start after
+	// the children left to walk of the directories entered
+	pending [][]string
+	next    storagedriver.FileInfo
+	filled  bool
+}
+
+func newStoreWalker(s metadataStore, p, startAfterHint string) *storeWalker {
+	w := &storeWalker{store: s}
+	if startAfterHint != "" {
+		w.hint = walkOrder(startAfterHint)
+	}
+	if children, ok := s.list(p); ok {
+		w.pending = [][]string{children}
+	}
+	return w
+}
+
+// peek returns the next entry of the walk, or nil at its end. Entries up to
+// the start after hint are skipped, entering only the directories holding
+// it.
+func (w *storeWalker) peek() storagedriver.FileInfo {
+	for w.next == nil && len(w.pending) > 0 {
+		top := w.pending[len(w.pending)-1]
+		if len(top) == 0 {
+			w.pending = w.pending[:len(w.pending)-1]
+			continue
+		}
+		w.pending[len(w.pending)-1] = top[1:]
+
+		fi, ok := w.store.stat(top[0])
+		if !ok {
+			// deleted during the walk
+			continue
+		}
+		if key := walkOrder(fi.Path()); w.hint != "" && key <= w.hint {
+			if fi.IsDir() && strings.HasPrefix(w.hint, key+"\x00") {
+				w.enter(fi.Path())
+			}
+			continue
+		}
+		w.next = fi
+	}
+	return w.next
+}
+
+func (w *storeWalker) enter(dir string) {
+	if children, ok := w.store.list(dir); ok {
+		w.pending = append(w.pending, children)
+	}
+}
+
+// walkNext calls f with the next entry, entering it unless f skips it.
+func (w *storeWalker) walkNext(f storagedriver.WalkFn) error {
+	fi := w.next
+	w.next = nil
+	err := f(fi)
+	switch err {
+	case nil:
+		if fi.IsDir() {
+			w.enter(fi.Path())
+		}
+	case storagedriver.ErrFilledBuffer:
+		w.filled = true
+	}
+	return err
+}
+
+// walkBefore walks the entries sorting before key in the walk order, or all
+// the entries left if key is empty. ErrSkipDir skips the directory it is
+// returned for and is not returned.
+func (w *storeWalker) walkBefore(key string, f storagedriver.WalkFn) error {
+	for fi := w.peek(); fi != nil && (key == "" || walkOrder(fi.Path()) < key); fi = w.peek() {
+		if err := w.walkNext(f); err != nil && err != storagedriver.ErrSkipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeWriter writes content to the store, when closed or committed.
+type storeWriter struct {
+	store     metadataStore
+	path      string
+	buf       bytes.Buffer
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (w *storeWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+	return w.buf.Write(p)
+}
+
+func (w *storeWriter) Size() int64 {
+	return int64(w.buf.Len())
+}
+
+// Close saves the content of an uncommitted writer, so that it can be
+// resumed.
+func (w *storeWriter) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if !w.committed && !w.cancelled {
+		return w.store.put(w.path, w.buf.Bytes())
+	}
+	return nil
+}
+
+func (w *storeWriter) Cancel(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+	_, err := w.store.delete(w.path)
+	return err
+}
+
+func (w *storeWriter) Commit(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+	w.committed = true
+	return w.store.put(w.path, w.buf.Bytes())
+}
@@ -0,0 +1,336 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	repoPath   = repositoriesRoot + "/library/ubuntu"
+	tagPath    = repoPath + "/_manifests/tags/latest/current/link"
+	layerPath  = repoPath + "/_layers/sha256/abcdef/link"
+	uploadPath = repoPath + "/_uploads/0123/startedat"
+	blobPath   = "/docker/registry/v2/blobs/sha256/ab/abcdef/data"
+)
+
+func newTestMiddleware(t *testing.T, sd storagedriver.StorageDriver, p string) *metadataStorageMiddleware {
+	middleware, err := newMetadataStorageMiddleware(context.Background(), sd, map[string]interface{}{"path": p, "sync": false})
+	require.NoError(t, err)
+	m := middleware.(*metadataStorageMiddleware)
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestOptions(t *testing.T) {
+	ctx := context.Background()
+	_, err := newMetadataStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{})
+	require.Error(t, err)
+	_, err = newMetadataStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{"path": 1})
+	require.Error(t, err)
+	_, err = newMetadataStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{
+		"path": filepath.Join(t.TempDir(), "metadata.db"),
+		"sync": "yes",
+	})
+	require.Error(t, err)
+}
+
+func TestIsMetadata(t *testing.T) {
+	for p, expected := range map[string]bool{
+		tagPath:                          true,
+		layerPath:                        true,
+		repoPath + "/_manifests":         true,
+		repositoriesRoot + "/_manifests": true,
+		uploadPath:                       false,
+		repoPath:                         false,
+		repositoriesRoot:                 false,
+		blobPath:                         false,
+		"/_manifests/tags":               false,
+	} {
+		require.Equal(t, expected, isMetadata(p), p)
+	}
+
+	for p, expected := range map[string]bool{
+		"/":                         true,
+		"/docker/registry":          true,
+		repositoriesRoot:            true,
+		repoPath:                    true,
+		repoPath + "/_uploads":      false,
+		"/docker/registry/v2/blobs": false,
+	} {
+		require.Equal(t, expected, isMixed(p), p)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	m := newTestMiddleware(t, sd, filepath.Join(t.TempDir(), "metadata.db"))
+
+	require.NoError(t, m.PutContent(ctx, tagPath, []byte("sha256:abcdef")))
+	require.NoError(t, m.PutContent(ctx, uploadPath, []byte("now")))
+	require.NoError(t, m.PutContent(ctx, blobPath, []byte("data")))
+
+	_, err := sd.GetContent(ctx, tagPath)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+	content, err := sd.GetContent(ctx, uploadPath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("now"), content)
+
+	content, err = m.GetContent(ctx, tagPath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("sha256:abcdef"), content)
+
+	rc, err := m.Reader(ctx, tagPath, 7)
+	require.NoError(t, err)
+	content, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Equal(t, []byte("abcdef"), content)
+	_, err = m.Reader(ctx, tagPath, 100)
+	require.ErrorAs(t, err, new(storagedriver.InvalidOffsetError))
+
+	w, err := m.Writer(ctx, layerPath, false)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("sha256:"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	w, err = m.Writer(ctx, layerPath, true)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("abcdef"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, w.Close())
+	content, err = m.GetContent(ctx, layerPath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("sha256:abcdef"), content)
+
+	fi, err := m.Stat(ctx, repoPath)
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+	fi, err = m.Stat(ctx, layerPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(13), fi.Size())
+
+	require.NoError(t, m.Move(ctx, layerPath, layerPath+".moved"))
+	_, err = m.Stat(ctx, layerPath)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+	require.Error(t, m.Move(ctx, layerPath+".moved", blobPath))
+
+	url, err := m.RedirectURL(nil, tagPath)
+	require.NoError(t, err)
+	require.Empty(t, url)
+
+	// deleting a repository deletes it from the store and the driver
+	require.NoError(t, m.Delete(ctx, repoPath))
+	_, err = m.Stat(ctx, repoPath)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+	_, err = sd.Stat(ctx, uploadPath)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+	require.ErrorAs(t, m.Delete(ctx, repoPath), new(storagedriver.PathNotFoundError))
+}
+
+func TestListAndWalk(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	m := newTestMiddleware(t, sd, filepath.Join(t.TempDir(), "metadata.db"))
+
+	require.NoError(t, m.PutContent(ctx, tagPath, []byte("sha256:abcdef")))
+	require.NoError(t, m.PutContent(ctx, uploadPath, []byte("now")))
+	require.NoError(t, m.PutContent(ctx, blobPath, []byte("data")))
+	// a link file left in the driver is ignored
+	require.NoError(t, sd.PutContent(ctx, layerPath, []byte("sha256:abcdef")))
+
+	children, err := m.List(ctx, repoPath)
+	require.NoError(t, err)
+	require.Equal(t, []string{repoPath + "/_manifests", repoPath + "/_uploads"}, children)
+
+	children, err = m.List(ctx, repositoriesRoot)
+	require.NoError(t, err)
+	require.Equal(t, []string{repositoriesRoot + "/library"}, children)
+
+	children, err = m.List(ctx, repoPath+"/_manifests/tags")
+	require.NoError(t, err)
+	require.Equal(t, []string{repoPath + "/_manifests/tags/latest"}, children)
+
+	_, err = m.List(ctx, repoPath+"/_layers")
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+
+	var walked []string
+	require.NoError(t, m.Walk(ctx, "/docker/registry/v2", func(fi storagedriver.FileInfo) error {
+		walked = append(walked, fi.Path())
+		return nil
+	}))
+	require.Equal(t, []string{
+		"/docker/registry/v2/blobs",
+		"/docker/registry/v2/blobs/sha256",
+		"/docker/registry/v2/blobs/sha256/ab",
+		"/docker/registry/v2/blobs/sha256/ab/abcdef",
+		blobPath,
+		repositoriesRoot,
+		repositoriesRoot + "/library",
+		repoPath,
+		repoPath + "/_manifests",
+		repoPath + "/_manifests/tags",
+		repoPath + "/_manifests/tags/latest",
+		repoPath + "/_manifests/tags/latest/current",
+		tagPath,
+		repoPath + "/_uploads",
+		repoPath + "/_uploads/0123",
+		uploadPath,
+	}, walked)
+
+	walked = nil
+	require.NoError(t, m.Walk(ctx, repoPath, func(fi storagedriver.FileInfo) error {
+		walked = append(walked, fi.Path())
+		if fi.Path() == repoPath+"/_manifests" {
+			return storagedriver.ErrSkipDir
+		}
+		return nil
+	}, storagedriver.WithStartAfterHint(repoPath+"/_layers")))
+	require.Equal(t, []string{
+		repoPath + "/_manifests",
+		repoPath + "/_uploads",
+		repoPath + "/_uploads/0123",
+		uploadPath,
+	}, walked)
+
+	walked = nil
+	require.NoError(t, m.Walk(ctx, repoPath, func(fi storagedriver.FileInfo) error {
+		walked = append(walked, fi.Path())
+		return storagedriver.ErrFilledBuffer
+	}))
+	require.Equal(t, []string{repoPath + "/_manifests"}, walked)
+}
+
+// walkCountingDriver counts the entries visited by walks of the storage
+// driver.
+type walkCountingDriver struct {
+	storagedriver.StorageDriver
+	visited int
+}
+
+func (d *walkCountingDriver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return d.StorageDriver.Walk(ctx, path, func(fi storagedriver.FileInfo) error {
+		d.visited++
+		return f(fi)
+	}, options...)
+}
+
+func TestWalkStreams(t *testing.T) {
+	ctx := context.Background()
+	sd := &walkCountingDriver{StorageDriver: inmemory.New()}
+	m := newTestMiddleware(t, sd, filepath.Join(t.TempDir(), "metadata.db"))
+
+	require.NoError(t, m.PutContent(ctx, tagPath, []byte("sha256:abcdef")))
+	require.NoError(t, m.PutContent(ctx, blobPath, []byte("data")))
+	require.NoError(t, m.PutContent(ctx, "/docker/registry/v2/blobs/sha256/cd/cdef/data", []byte("data")))
+
+	// a full buffer stops the walk of the storage driver
+	var walked []string
+	require.NoError(t, m.Walk(ctx, "/docker/registry/v2", func(fi storagedriver.FileInfo) error {
+		walked = append(walked, fi.Path())
+		return storagedriver.ErrFilledBuffer
+	}))
+	require.Equal(t, []string{"/docker/registry/v2/blobs"}, walked)
+	require.Equal(t, 1, sd.visited)
+
+	// skipped directories of the storage driver are not walked
+	walked = nil
+	sd.visited = 0
+	require.NoError(t, m.Walk(ctx, "/docker/registry/v2", func(fi storagedriver.FileInfo) error {
+		walked = append(walked, fi.Path())
+		if fi.Path() == "/docker/registry/v2/blobs" {
+			return storagedriver.ErrSkipDir
+		}
+		return nil
+	}))
+	require.Equal(t, 1, sd.visited)
+	require.Equal(t, []string{
+		"/docker/registry/v2/blobs",
+		repositoriesRoot,
+		repositoriesRoot + "/library",
+		repoPath,
+		repoPath + "/_manifests",
+		repoPath + "/_manifests/tags",
+		repoPath + "/_manifests/tags/latest",
+		repoPath + "/_manifests/tags/latest/current",
+		tagPath,
+	}, walked)
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	require.NoError(t, sd.PutContent(ctx, tagPath, []byte("sha256:abcdef")))
+	require.NoError(t, sd.PutContent(ctx, layerPath, []byte("sha256:abcdef")))
+	require.NoError(t, sd.PutContent(ctx, uploadPath, []byte("now")))
+	require.NoError(t, sd.PutContent(ctx, blobPath, []byte("data")))
+
+	p := filepath.Join(t.TempDir(), "metadata.db")
+	options := map[string]interface{}{"path": p}
+	imported, err := Import(ctx, sd, options, 4)
+	require.NoError(t, err)
+	require.Equal(t, 2, imported)
+
+	// files already imported are not copied again
+	imported, err = Import(ctx, sd, options, 4)
+	require.NoError(t, err)
+	require.Equal(t, 0, imported)
+
+	m := newTestMiddleware(t, sd, p)
+	require.NoError(t, sd.Delete(ctx, repoPath+"/_manifests"))
+	require.NoError(t, sd.Delete(ctx, repoPath+"/_layers"))
+	content, err := m.GetContent(ctx, tagPath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("sha256:abcdef"), content)
+	content, err = m.GetContent(ctx, layerPath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("sha256:abcdef"), content)
+	_, err = m.Stat(ctx, uploadPath)
+	require.NoError(t, err)
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	sd := inmemory.New()
+	m := newTestMiddleware(t, sd, filepath.Join(t.TempDir(), "metadata.db"))
+
+	registry, err := storage.NewRegistry(ctx, m)
+	require.NoError(t, err)
+	named, err := reference.WithName("library/ubuntu")
+	require.NoError(t, err)
+	repo, err := registry.Repository(ctx, named)
+	require.NoError(t, err)
+
+	dgst := digest.FromString("manifest")
+	require.NoError(t, repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: dgst}))
+	require.NoError(t, repo.Tags(ctx).Tag(ctx, "stable", distribution.Descriptor{Digest: dgst}))
+
+	tags, err := repo.Tags(ctx).All(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"latest", "stable"}, tags)
+	desc, err := repo.Tags(ctx).Get(ctx, "stable")
+	require.NoError(t, err)
+	require.Equal(t, dgst, desc.Digest)
+
+	var repos []string
+	require.NoError(t, registry.(distribution.RepositoryEnumerator).Enumerate(ctx, func(name string) error {
+		repos = append(repos, name)
+		return nil
+	}))
+	require.Equal(t, []string{"library/ubuntu"}, repos)
+
+	// the links are not in the storage driver
+	_, err = sd.Stat(ctx, repoPath)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+}
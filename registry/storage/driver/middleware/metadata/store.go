@@ -0,0 +1,288 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	bolt "go.etcd.io/bbolt"
+)
+
+// metadataStore is the backend keeping the files of the metadata tree, such
+// as tag, manifest revision and layer links. Directories exist as long as
+// they hold files.
+type metadataStore interface {
+	// get returns the content of the file at p.
+	get(p string) (entry, bool)
+	// put sets the content of the file at p.
+	put(p string, content []byte) error
+	// delete deletes the file or directory at p, and reports whether it
+	// existed.
+	delete(p string) (bool, error)
+	// move moves the file at sourcePath to destPath.
+	move(sourcePath, destPath string) error
+	// stat returns the info of the file or directory at p.
+	stat(p string) (storagedriver.FileInfo, bool)
+	// list returns the sorted children of the directory at p.
+	list(p string) ([]string, bool)
+	// path returns the location of the store, for logging.
+	path() string
+	close() error
+}
+
+type entry struct {
+	content []byte
+	modTime time.Time
+}
+
+// treeBucket holds the entries of the tree, keyed by the path of their
+// directory and their name, separated by a zero byte, so that the children
+// of a directory are adjacent and sorted by name. Values start with the kind
+// of the entry: a directory, or a file followed by its modification time in
+// nanoseconds and its content.
+var treeBucket = []byte("tree")
+
+const (
+	kindDir  = 'd'
+	kindFile = 'f'
+)
+
+// lockTimeout is how long opening a store waits for another process to
+// close it.
+const lockTimeout = time.Second
+
+// boltStore is a metadataStore in a bbolt database, an embedded key/value
+// store in a single file. Only a single process can open it at a time.
+type boltStore struct {
+	db *bolt.DB
+}
+
+var _ metadataStore = &boltStore{}
+
+// openStore opens the store in the file at p, creating it if needed.
+// Changes are synced to disk before being acknowledged if sync is set.
+func openStore(p string, sync bool) (*boltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(p, 0o644, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("unable to lock %s, is it used by another registry? %v", p, err)
+		}
+		return nil, err
+	}
+	db.NoSync = !sync
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(treeBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) path() string {
+	return s.db.Path()
+}
+
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
+
+// treeKey returns the key of the entry at p, other than the root.
+func treeKey(p string) []byte {
+	dir, name := path.Split(p)
+	if len(dir) > 1 {
+		dir = dir[:len(dir)-1]
+	}
+	return append(append([]byte(dir), 0), name...)
+}
+
+// childrenPrefix returns the prefix of the keys of the children of the
+// directory at p.
+func childrenPrefix(p string) []byte {
+	return append([]byte(p), 0)
+}
+
+func hasChildren(b *bolt.Bucket, p string) bool {
+	prefix := childrenPrefix(p)
+	k, _ := b.Cursor().Seek(prefix)
+	return k != nil && bytes.HasPrefix(k, prefix)
+}
+
+// children returns the sorted paths of the children of the directory at p.
+func children(b *bolt.Bucket, p string) []string {
+	var list []string
+	prefix := childrenPrefix(p)
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		list = append(list, path.Join(p, string(k[len(prefix):])))
+	}
+	return list
+}
+
+func fileValue(content []byte, modTime time.Time) []byte {
+	v := make([]byte, 9, 9+len(content))
+	v[0] = kindFile
+	binary.BigEndian.PutUint64(v[1:], uint64(modTime.UnixNano()))
+	return append(v, content...)
+}
+
+// fileEntry decodes the value of a file, copying its content out of the
+// database.
+func fileEntry(v []byte) (entry, bool) {
+	if len(v) < 9 || v[0] != kindFile {
+		return entry{}, false
+	}
+	return entry{
+		content: bytes.Clone(v[9:]),
+		modTime: time.Unix(0, int64(binary.BigEndian.Uint64(v[1:9]))).UTC(),
+	}, true
+}
+
+func exists(b *bolt.Bucket, p string) bool {
+	if p == "/" {
+		return hasChildren(b, p)
+	}
+	return b.Get(treeKey(p)) != nil
+}
+
+func isDir(b *bolt.Bucket, p string) bool {
+	if p == "/" {
+		return hasChildren(b, p)
+	}
+	v := b.Get(treeKey(p))
+	return len(v) > 0 && v[0] == kindDir
+}
+
+func (s *boltStore) get(p string) (e entry, ok bool) {
+	s.db.View(func(tx *bolt.Tx) error {
+		e, ok = fileEntry(tx.Bucket(treeBucket).Get(treeKey(p)))
+		return nil
+	})
+	return e, ok
+}
+
+func (s *boltStore) put(p string, content []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putFile(tx.Bucket(treeBucket), p, content, time.Now().UTC())
+	})
+}
+
+// putFile sets the file at p, creating its missing parent directories.
+func putFile(b *bolt.Bucket, p string, content []byte, modTime time.Time) error {
+	if isDir(b, p) {
+		return fmt.Errorf("%s is a directory", p)
+	}
+	if err := b.Put(treeKey(p), fileValue(content, modTime)); err != nil {
+		return err
+	}
+	for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+		v := b.Get(treeKey(dir))
+		if len(v) > 0 && v[0] == kindDir {
+			break
+		}
+		if v != nil {
+			return fmt.Errorf("%s is a file", dir)
+		}
+		if err := b.Put(treeKey(dir), []byte{kindDir}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltStore) delete(p string) (found bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(treeBucket)
+		if !exists(b, p) {
+			return nil
+		}
+		found = true
+		return deleteTree(b, p)
+	})
+	return found, err
+}
+
+// deleteTree deletes the file or directory at p, and prunes the directories
+// left empty.
+func deleteTree(b *bolt.Bucket, p string) error {
+	for _, child := range children(b, p) {
+		if err := deleteTree(b, child); err != nil {
+			return err
+		}
+	}
+	if p == "/" {
+		return nil
+	}
+	if err := b.Delete(treeKey(p)); err != nil {
+		return err
+	}
+
+	for dir := path.Dir(p); dir != "/" && !hasChildren(b, dir); dir = path.Dir(dir) {
+		if err := b.Delete(treeKey(dir)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltStore) move(sourcePath, destPath string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(treeBucket)
+		e, ok := fileEntry(b.Get(treeKey(sourcePath)))
+		if !ok {
+			return storagedriver.PathNotFoundError{Path: sourcePath, DriverName: driverName}
+		}
+		if sourcePath == destPath {
+			return nil
+		}
+		if err := putFile(b, destPath, e.content, e.modTime); err != nil {
+			return err
+		}
+		return deleteTree(b, sourcePath)
+	})
+}
+
+func (s *boltStore) stat(p string) (fi storagedriver.FileInfo, ok bool) {
+	s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(treeBucket)
+		if isDir(b, p) {
+			fi, ok = storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+				Path:  p,
+				IsDir: true,
+			}}, true
+			return nil
+		}
+		if e, isFile := fileEntry(b.Get(treeKey(p))); isFile {
+			fi, ok = storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+				Path:    p,
+				Size:    int64(len(e.content)),
+				ModTime: e.modTime,
+			}}, true
+		}
+		return nil
+	})
+	return fi, ok
+}
+
+func (s *boltStore) list(p string) (list []string, ok bool) {
+	s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(treeBucket)
+		if isDir(b, p) {
+			list, ok = children(b, p), true
+		}
+		return nil
+	})
+	return list, ok
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"path/filepath"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestStore(t *testing.T, p string) *boltStore {
+	s, err := openStore(p, false)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.close() })
+	return s
+}
+
+func TestStore(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "metadata.db"))
+
+	require.NoError(t, s.put("/a/b/c", []byte("c")))
+	require.NoError(t, s.put("/a/b/d", []byte("d")))
+	require.NoError(t, s.put("/a/e", nil))
+	require.Error(t, s.put("/a/b", []byte("b")))
+
+	e, ok := s.get("/a/b/c")
+	require.True(t, ok)
+	require.Equal(t, []byte("c"), e.content)
+	e, ok = s.get("/a/e")
+	require.True(t, ok)
+	require.Empty(t, e.content)
+
+	children, ok := s.list("/a")
+	require.True(t, ok)
+	require.Equal(t, []string{"/a/b", "/a/e"}, children)
+	_, ok = s.list("/a/e")
+	require.False(t, ok)
+
+	fi, ok := s.stat("/a/b")
+	require.True(t, ok)
+	require.True(t, fi.IsDir())
+	fi, ok = s.stat("/a/b/c")
+	require.True(t, ok)
+	require.False(t, fi.IsDir())
+	require.Equal(t, int64(1), fi.Size())
+
+	walk := func(hint string, skip string) []string {
+		var walked []string
+		err := newStoreWalker(s, "/", hint).walkBefore("", func(fi storagedriver.FileInfo) error {
+			walked = append(walked, fi.Path())
+			if fi.Path() == skip {
+				return storagedriver.ErrSkipDir
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		return walked
+	}
+	require.Equal(t, []string{"/a", "/a/b", "/a/b/c", "/a/b/d", "/a/e"}, walk("", ""))
+	require.Equal(t, []string{"/a/b/d", "/a/e"}, walk("/a/b/c", ""))
+	require.Equal(t, []string{"/a", "/a/b", "/a/e"}, walk("", "/a/b"))
+
+	require.NoError(t, s.move("/a/b/c", "/f/g"))
+	_, ok = s.get("/a/b/c")
+	require.False(t, ok)
+	e, ok = s.get("/f/g")
+	require.True(t, ok)
+	require.Equal(t, []byte("c"), e.content)
+
+	found, err := s.delete("/a/b")
+	require.NoError(t, err)
+	require.True(t, found)
+	found, err = s.delete("/a/b")
+	require.NoError(t, err)
+	require.False(t, found)
+	children, ok = s.list("/a")
+	require.True(t, ok)
+	require.Equal(t, []string{"/a/e"}, children)
+
+	// deleting the last file prunes its directories
+	_, err = s.delete("/f/g")
+	require.NoError(t, err)
+	_, ok = s.stat("/f")
+	require.False(t, ok)
+}
+
+func TestStorePersistence(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "metadata.db")
+	s, err := openStore(p, true)
+	require.NoError(t, err)
+	require.NoError(t, s.put("/a/b", []byte("b")))
+	require.NoError(t, s.put("/a/c", []byte("c")))
+	_, err = s.delete("/a/c")
+	require.NoError(t, err)
+
+	// a second store cannot be opened while the first is
+	_, err = openStore(p, true)
+	require.ErrorContains(t, err, "is it used by another registry")
+	require.NoError(t, s.close())
+
+	s = openTestStore(t, p)
+	e, ok := s.get("/a/b")
+	require.True(t, ok)
+	require.Equal(t, []byte("b"), e.content)
+	_, ok = s.get("/a/c")
+	require.False(t, ok)
+	children, ok := s.list("/a")
+	require.True(t, ok)
+	require.Equal(t, []string{"/a/b"}, children)
+}
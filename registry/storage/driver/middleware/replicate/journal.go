@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// operation is a change to replay on a secondary driver.
+type operation struct {
+	// Action is one of "copy", "move" or "delete".
+	Action string `json:"action"`
+
+	// Path is the path copied from the primary, moved or deleted.
+	Path string `json:"path"`
+
+	// Dest is the destination of a move.
+	Dest string `json:"dest,omitempty"`
+}
+
+const (
+	actionCopy   = "copy"
+	actionMove   = "move"
+	actionDelete = "delete"
+)
+
+// journal is the queue of the operations pending for a secondary. If it has
+// a file, the operations are appended to it as JSON lines and survive
+// restarts: the number of operations of the file already applied is kept
+// next to it, and both are truncated once the queue is empty.
+type journal struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	path    string // empty for an in-memory journal
+	file    *os.File
+	ops     []operation // pending, oldest first
+	applied int         // operations of the file applied
+	closed  bool
+}
+
+// newJournal returns an in-memory journal.
+func newJournal() *journal {
+	j := &journal{}
+	j.cond = sync.NewCond(&j.mu)
+	return j
+}
+
+// openJournal opens the journal file at p, loading its pending operations.
+func openJournal(p string) (*journal, error) {
+	j := newJournal()
+	j.path = p
+
+	applied, err := readApplied(j.appliedPath())
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []operation
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var op operation
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			// a torn write at the end of the file, from a crash
+			break
+		}
+		ops = append(ops, op)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if applied > len(ops) {
+		applied = len(ops)
+	}
+
+	j.file = f
+	j.ops = ops[applied:]
+	j.applied = applied
+	return j, nil
+}
+
+// readApplied reads the number of applied operations of a journal file.
+func readApplied(p string) (int, error) {
+	content, err := os.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	applied, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid journal progress %s: %v", p, err)
+	}
+	return applied, nil
+}
+
+func (j *journal) appliedPath() string {
+	return j.path + ".applied"
+}
+
+// append queues op.
+func (j *journal) append(op operation) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file != nil {
+		line, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		if _, err := j.file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		if err := j.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	j.ops = append(j.ops, op)
+	j.cond.Signal()
+	return nil
+}
+
+// next blocks until an operation is pending and returns it, without
+// removing it. ok is false once the journal is closed.
+func (j *journal) next() (op operation, ok bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for len(j.ops) == 0 && !j.closed {
+		j.cond.Wait()
+	}
+	if j.closed {
+		return operation{}, false
+	}
+	return j.ops[0], true
+}
+
+// ack removes the oldest pending operation, once it has been applied.
+func (j *journal) ack() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.ops) == 0 || j.closed {
+		// an operation applied while the journal was closed is replayed
+		// by the next instance, which is harmless
+		return nil
+	}
+	j.ops = j.ops[1:]
+	j.applied++
+	if j.file == nil {
+		return nil
+	}
+
+	if len(j.ops) == 0 {
+		// start over with empty files
+		if err := j.file.Truncate(0); err != nil {
+			return err
+		}
+		j.applied = 0
+	}
+	return writeFileAtomic(j.appliedPath(), []byte(strconv.Itoa(j.applied)))
+}
+
+// len returns the number of pending operations.
+func (j *journal) len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.ops)
+}
+
+// close wakes up the readers of the journal and closes its file.
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.closed = true
+	j.cond.Broadcast()
+	if j.file != nil {
+		return j.file.Close()
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file p with content.
+func writeFileAtomic(p string, content []byte) error {
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
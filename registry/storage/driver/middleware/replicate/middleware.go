@@ -0,0 +1,434 @@
+// Package middleware provides a storage middleware mirroring the changes
+// made through the storage driver to secondary storage drivers.
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	if err := storagemiddleware.Register("replicate", newReplicateStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register replicate storage middleware: %v", err)
+	}
+}
+
+const (
+	// modeSync applies the changes to the secondaries before returning.
+	modeSync = "sync"
+	// modeAsync queues the changes in the journal, and applies them in the
+	// background.
+	modeAsync = "async"
+)
+
+const (
+	minRetryBackoff = time.Second
+	maxRetryBackoff = time.Minute
+)
+
+// replicateStorageMiddleware mirrors PutContent, committed writers, Move and
+// Delete to its secondaries, once they have succeeded on the primary driver.
+// Content is always copied from the primary, so an operation replayed late
+// mirrors the current state of the primary.
+type replicateStorageMiddleware struct {
+	storagedriver.StorageDriver
+	mode        string
+	secondaries []*secondary
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// secondary is a storage driver mirroring the primary, with the journal of
+// the operations it has not applied yet.
+type secondary struct {
+	name    string
+	driver  storagedriver.StorageDriver
+	journal *journal
+	// id identifies the secondary by its driver name and parameters, so
+	// that its journal follows it when the list of secondaries changes.
+	id string
+}
+
+var _ storagedriver.StorageDriver = &replicateStorageMiddleware{}
+
+func newReplicateStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	mode := modeSync
+	if o, ok := options["mode"]; ok {
+		s, ok := o.(string)
+		if !ok || (s != modeSync && s != modeAsync) {
+			return nil, fmt.Errorf("mode must be %q or %q", modeSync, modeAsync)
+		}
+		mode = s
+	}
+
+	var journalDir string
+	if o, ok := options["journal"]; ok {
+		s, ok := o.(string)
+		if !ok {
+			return nil, fmt.Errorf("journal must be a string")
+		}
+		journalDir = s
+	}
+	if mode == modeAsync && journalDir == "" {
+		return nil, fmt.Errorf("the async mode requires a journal")
+	}
+
+	secondaries, err := createSecondaries(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if journalDir != "" {
+		if err := os.MkdirAll(journalDir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range secondaries {
+		if journalDir == "" {
+			s.journal = newJournal()
+			continue
+		}
+		s.journal, err = openJournal(filepath.Join(journalDir, s.id+".log"))
+		if err != nil {
+			closeJournals(secondaries)
+			return nil, fmt.Errorf("unable to open journal of secondary %s: %v", s.name, err)
+		}
+		if n := s.journal.len(); n > 0 {
+			dcontext.GetLogger(ctx).Infof("replicate: resuming %d pending operations of secondary %s", n, s.name)
+		}
+	}
+	if journalDir != "" {
+		warnOrphanedJournals(ctx, journalDir, secondaries)
+	}
+
+	runCtx, cancel := context.WithCancel(dcontext.Background())
+	r := &replicateStorageMiddleware{
+		StorageDriver: sd,
+		mode:          mode,
+		secondaries:   secondaries,
+		cancel:        cancel,
+	}
+	for _, s := range secondaries {
+		r.wg.Add(1)
+		go func(s *secondary) {
+			defer r.wg.Done()
+			r.run(runCtx, s)
+		}(s)
+	}
+
+	return r, nil
+}
+
+// warnOrphanedJournals warns about the journals of dir with pending
+// operations which belong to none of the secondaries, such as the journal of
+// a secondary whose parameters changed. Their operations are not replayed.
+func warnOrphanedJournals(ctx context.Context, dir string, secondaries []*secondary) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return
+	}
+	used := make(map[string]bool, len(secondaries))
+	for _, s := range secondaries {
+		used[s.journal.path] = true
+	}
+	for _, p := range paths {
+		if used[p] {
+			continue
+		}
+		if fi, err := os.Stat(p); err == nil && fi.Size() > 0 {
+			dcontext.GetLogger(ctx).Warnf("replicate: journal %s belongs to no configured secondary, its operations are not replayed", p)
+		}
+	}
+}
+
+func closeJournals(secondaries []*secondary) {
+	for _, s := range secondaries {
+		if s.journal != nil {
+			s.journal.close()
+		}
+	}
+}
+
+// createSecondaries creates the secondaries option, a list of single entry
+// maps from a storage driver name to its parameters, like the storage section
+// of the configuration. Their journals are left to the caller.
+func createSecondaries(ctx context.Context, options map[string]interface{}) ([]*secondary, error) {
+	list, ok := options["secondaries"].([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("secondaries must be a non-empty list of storage drivers")
+	}
+
+	secondaries := make([]*secondary, 0, len(list))
+	for i, entry := range list {
+		name, params, err := driverEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("secondary %d: %v", i, err)
+		}
+		d, err := factory.Create(ctx, name, params)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create secondary %d: %v", i, err)
+		}
+		secondaries = append(secondaries, &secondary{
+			name:   fmt.Sprintf("%s#%d", d.Name(), i),
+			driver: d,
+			id:     secondaryID(name, params),
+		})
+	}
+	return secondaries, nil
+}
+
+// secondaryID returns a stable identifier of the storage driver name with
+// params. Maps are formatted in key order, so the identifier does not depend
+// on the order of the parameters.
+func secondaryID(name string, params map[string]interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s %v", name, params)))
+	return name + "-" + hex.EncodeToString(sum[:8])
+}
+
+// driverEntry returns the name and parameters of a storage driver entry.
+func driverEntry(entry interface{}) (string, map[string]interface{}, error) {
+	var name string
+	var value interface{}
+	switch m := entry.(type) {
+	case map[interface{}]interface{}:
+		if len(m) != 1 {
+			return "", nil, fmt.Errorf("must have exactly one storage driver")
+		}
+		for k, v := range m {
+			name, _ = k.(string)
+			value = v
+		}
+	case map[string]interface{}:
+		if len(m) != 1 {
+			return "", nil, fmt.Errorf("must have exactly one storage driver")
+		}
+		for k, v := range m {
+			name, value = k, v
+		}
+	default:
+		return "", nil, fmt.Errorf("must be a map of a storage driver name to its parameters")
+	}
+
+	params := make(map[string]interface{})
+	switch v := value.(type) {
+	case nil:
+	case map[interface{}]interface{}:
+		for k, p := range v {
+			key, ok := k.(string)
+			if !ok {
+				return "", nil, fmt.Errorf("invalid parameter %v of storage driver %s", k, name)
+			}
+			params[key] = p
+		}
+	case map[string]interface{}:
+		for k, p := range v {
+			params[k] = p
+		}
+	default:
+		return "", nil, fmt.Errorf("parameters of storage driver %s must be a map", name)
+	}
+	return name, params, nil
+}
+
+// PutContent stores content at p and mirrors it.
+func (r *replicateStorageMiddleware) PutContent(ctx context.Context, p string, content []byte) error {
+	if err := r.StorageDriver.PutContent(ctx, p, content); err != nil {
+		return err
+	}
+	r.replicate(ctx, operation{Action: actionCopy, Path: p})
+	return nil
+}
+
+// Writer returns a writer mirroring its content once committed.
+func (r *replicateStorageMiddleware) Writer(ctx context.Context, p string, append bool) (storagedriver.FileWriter, error) {
+	w, err := r.StorageDriver.Writer(ctx, p, append)
+	if err != nil {
+		return nil, err
+	}
+	return &replicatingWriter{FileWriter: w, ctx: ctx, parent: r, path: p}, nil
+}
+
+// Move moves sourcePath to destPath and mirrors the move.
+func (r *replicateStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if err := r.StorageDriver.Move(ctx, sourcePath, destPath); err != nil {
+		return err
+	}
+	r.replicate(ctx, operation{Action: actionMove, Path: sourcePath, Dest: destPath})
+	return nil
+}
+
+// Delete deletes p and mirrors the deletion.
+func (r *replicateStorageMiddleware) Delete(ctx context.Context, p string) error {
+	if err := r.StorageDriver.Delete(ctx, p); err != nil {
+		return err
+	}
+	r.replicate(ctx, operation{Action: actionDelete, Path: p})
+	return nil
+}
+
+// replicate applies op to the secondaries. In the async mode, or when a
+// secondary has older operations pending, op is queued in its journal. In
+// the sync mode, failed operations are queued too, to be retried in the
+// background. The change has already been made on the primary, so failures
+// are logged rather than returned: the client would retry a change which
+// succeeded.
+func (r *replicateStorageMiddleware) replicate(ctx context.Context, op operation) {
+	for _, s := range r.secondaries {
+		if r.mode == modeSync && s.journal.len() == 0 {
+			err := s.apply(ctx, r.StorageDriver, op)
+			if err == nil {
+				continue
+			}
+			dcontext.GetLogger(ctx).Warnf("replicate: queuing %s of %s for secondary %s: %v", op.Action, op.Path, s.name, err)
+		}
+
+		if err := s.journal.append(op); err != nil {
+			dcontext.GetLogger(ctx).Errorf("replicate: unable to queue %s of %s for secondary %s, run registry replicate verify to reconcile it: %v", op.Action, op.Path, s.name, err)
+		}
+	}
+}
+
+// run applies the operations queued in the journal of s, retrying failed
+// ones with an exponential backoff, until ctx is done or the journal is
+// closed.
+func (r *replicateStorageMiddleware) run(ctx context.Context, s *secondary) {
+	backoff := minRetryBackoff
+	for {
+		op, ok := s.journal.next()
+		if !ok {
+			return
+		}
+
+		if err := s.apply(ctx, r.StorageDriver, op); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			dcontext.GetLogger(ctx).Errorf("replicate: %s of %s to secondary %s failed, retrying in %s: %v", op.Action, op.Path, s.name, backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(backoff*2, maxRetryBackoff)
+			continue
+		}
+
+		backoff = minRetryBackoff
+		if err := s.journal.ack(); err != nil {
+			dcontext.GetLogger(ctx).Errorf("replicate: unable to update journal of secondary %s: %v", s.name, err)
+		}
+	}
+}
+
+// Close stops replicating to the secondaries, waiting for the operations in
+// progress to stop. Queued operations are kept in persistent journals and
+// resumed by the next instance of the middleware.
+func (r *replicateStorageMiddleware) Close() error {
+	r.cancel()
+	var errs []error
+	for _, s := range r.secondaries {
+		errs = append(errs, s.journal.close())
+	}
+	r.wg.Wait()
+	return errors.Join(errs...)
+}
+
+// apply applies op to s, reading the content to copy from primary.
+// Operations on content which no longer exists are complete.
+func (s *secondary) apply(ctx context.Context, primary storagedriver.StorageDriver, op operation) error {
+	switch op.Action {
+	case actionCopy:
+		return ignoreNotFound(copyContent(ctx, primary, s.driver, op.Path))
+	case actionMove:
+		err := s.driver.Move(ctx, op.Path, op.Dest)
+		if !isNotFound(err) {
+			return err
+		}
+		// the source was never mirrored, copy the result of the move
+		return ignoreNotFound(copyContent(ctx, primary, s.driver, op.Dest))
+	case actionDelete:
+		return ignoreNotFound(s.driver.Delete(ctx, op.Path))
+	default:
+		return fmt.Errorf("unknown action %q", op.Action)
+	}
+}
+
+// copyContent copies the content at p from src to dst.
+func copyContent(ctx context.Context, src, dst storagedriver.StorageDriver, p string) error {
+	rc, err := src.Reader(ctx, p, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w, err := dst.Writer(ctx, p, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		w.Cancel(ctx)
+		w.Close()
+		return err
+	}
+	if err := w.Commit(ctx); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func isNotFound(err error) bool {
+	var notFound storagedriver.PathNotFoundError
+	return errors.As(err, &notFound)
+}
+
+func ignoreNotFound(err error) error {
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// replicatingWriter mirrors the content of a file writer once it has been
+// committed and closed, as some drivers only expose the content on close.
+type replicatingWriter struct {
+	storagedriver.FileWriter
+	ctx       context.Context
+	parent    *replicateStorageMiddleware
+	path      string
+	committed bool
+}
+
+func (w *replicatingWriter) Commit(ctx context.Context) error {
+	if err := w.FileWriter.Commit(ctx); err != nil {
+		return err
+	}
+	w.committed = true
+	return nil
+}
+
+func (w *replicatingWriter) Close() error {
+	if err := w.FileWriter.Close(); err != nil {
+		return err
+	}
+	if !w.committed {
+		return nil
+	}
+	w.committed = false
+	w.parent.replicate(w.ctx, operation{Action: actionCopy, Path: w.path})
+	return nil
+}
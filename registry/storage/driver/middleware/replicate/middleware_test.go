@@ -0,0 +1,343 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMiddleware(t *testing.T, options map[string]interface{}) (*replicateStorageMiddleware, storagedriver.StorageDriver) {
+	if options == nil {
+		options = make(map[string]interface{})
+	}
+	if _, ok := options["secondaries"]; !ok {
+		options["secondaries"] = []interface{}{
+			map[interface{}]interface{}{"inmemory": nil},
+		}
+	}
+
+	primary := inmemory.New()
+	middleware, err := newReplicateStorageMiddleware(context.Background(), primary, options)
+	require.NoError(t, err)
+	r := middleware.(*replicateStorageMiddleware)
+	t.Cleanup(func() { r.Close() })
+	return r, primary
+}
+
+func requireContent(t *testing.T, d storagedriver.StorageDriver, p string, expected string) {
+	t.Helper()
+	content, err := d.GetContent(context.Background(), p)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(content))
+}
+
+func requireNotFound(t *testing.T, d storagedriver.StorageDriver, p string) {
+	t.Helper()
+	_, err := d.Stat(context.Background(), p)
+	require.ErrorAs(t, err, &storagedriver.PathNotFoundError{})
+}
+
+// failingDriver fails every change until it is repaired.
+type failingDriver struct {
+	storagedriver.StorageDriver
+	broken atomic.Bool
+}
+
+func newFailingDriver(d storagedriver.StorageDriver) *failingDriver {
+	fd := &failingDriver{StorageDriver: d}
+	fd.broken.Store(true)
+	return fd
+}
+
+var errBroken = errors.New("broken")
+
+func (d *failingDriver) Writer(ctx context.Context, p string, append bool) (storagedriver.FileWriter, error) {
+	if d.broken.Load() {
+		return nil, errBroken
+	}
+	return d.StorageDriver.Writer(ctx, p, append)
+}
+
+func (d *failingDriver) Delete(ctx context.Context, p string) error {
+	if d.broken.Load() {
+		return errBroken
+	}
+	return d.StorageDriver.Delete(ctx, p)
+}
+
+func TestOptions(t *testing.T) {
+	ctx := context.Background()
+	secondaries := []interface{}{map[interface{}]interface{}{"inmemory": nil}}
+
+	for _, tc := range []struct {
+		options map[string]interface{}
+		err     string
+	}{
+		{map[string]interface{}{}, "secondaries must be a non-empty list"},
+		{map[string]interface{}{"secondaries": []interface{}{"inmemory"}}, "must be a map of a storage driver name"},
+		{map[string]interface{}{"secondaries": []interface{}{map[interface{}]interface{}{"unknown": nil}}}, "unable to create secondary 0"},
+		{map[string]interface{}{"secondaries": secondaries, "mode": "eventually"}, "mode must be"},
+		{map[string]interface{}{"secondaries": secondaries, "mode": "async"}, "the async mode requires a journal"},
+	} {
+		_, err := newReplicateStorageMiddleware(ctx, inmemory.New(), tc.options)
+		require.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestSyncReplication(t *testing.T) {
+	ctx := context.Background()
+	r, primary := newTestMiddleware(t, nil)
+	secondary := r.secondaries[0].driver
+
+	require.NoError(t, r.PutContent(ctx, "/a/content", []byte("put")))
+	requireContent(t, secondary, "/a/content", "put")
+
+	// writers are mirrored once committed and closed
+	w, err := r.Writer(ctx, "/a/upload", false)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("written"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	requireNotFound(t, secondary, "/a/upload")
+	require.NoError(t, w.Close())
+	requireContent(t, secondary, "/a/upload", "written")
+
+	// writers which are not committed are not mirrored
+	w, err = r.Writer(ctx, "/a/cancelled", false)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("cancelled"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	requireNotFound(t, secondary, "/a/cancelled")
+
+	require.NoError(t, r.Move(ctx, "/a/upload", "/b/data"))
+	requireContent(t, secondary, "/b/data", "written")
+	requireNotFound(t, secondary, "/a/upload")
+
+	// a move of content missing from the secondary copies the result
+	require.NoError(t, r.Move(ctx, "/a/cancelled", "/b/cancelled"))
+	requireContent(t, secondary, "/b/cancelled", "cancelled")
+
+	require.NoError(t, r.Delete(ctx, "/a"))
+	requireNotFound(t, secondary, "/a/content")
+	requireContent(t, primary, "/b/data", "written")
+}
+
+func TestSyncReplicationFailure(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newTestMiddleware(t, nil)
+	failing := newFailingDriver(r.secondaries[0].driver)
+	r.secondaries[0].driver = failing
+
+	// without a journal, failures are queued in memory and retried, as the
+	// change has been made on the primary
+	require.NoError(t, r.PutContent(ctx, "/content", []byte("put")))
+	requireContent(t, r, "/content", "put")
+	require.Equal(t, 1, r.secondaries[0].journal.len())
+
+	failing.broken.Store(false)
+	require.Eventually(t, func() bool {
+		return r.secondaries[0].journal.len() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	requireContent(t, failing, "/content", "put")
+}
+
+func TestJournalFollowsSecondary(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	first := map[interface{}]interface{}{"inmemory": map[interface{}]interface{}{"name": "first", "region": "a"}}
+	second := map[interface{}]interface{}{"inmemory": map[interface{}]interface{}{"name": "second"}}
+	primary := inmemory.New()
+
+	middleware, err := newReplicateStorageMiddleware(ctx, primary, map[string]interface{}{
+		"journal":     dir,
+		"secondaries": []interface{}{first, second},
+	})
+	require.NoError(t, err)
+	r := middleware.(*replicateStorageMiddleware)
+	failing := newFailingDriver(r.secondaries[1].driver)
+	r.secondaries[1].driver = failing
+	require.NoError(t, r.PutContent(ctx, "/content", []byte("put")))
+	require.Equal(t, 1, r.secondaries[1].journal.len())
+	journalPath := r.secondaries[1].journal.path
+	require.NoError(t, r.Close())
+
+	// the secondaries are reordered: the pending operation stays with the
+	// secondary it was queued for
+	middleware, err = newReplicateStorageMiddleware(ctx, primary, map[string]interface{}{
+		"journal":     dir,
+		"secondaries": []interface{}{second, first},
+	})
+	require.NoError(t, err)
+	r = middleware.(*replicateStorageMiddleware)
+	defer r.Close()
+	require.Equal(t, journalPath, r.secondaries[0].journal.path)
+	require.Eventually(t, func() bool {
+		return r.secondaries[0].journal.len() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	requireContent(t, r.secondaries[0].driver, "/content", "put")
+}
+
+func TestSyncReplicationJournal(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newTestMiddleware(t, map[string]interface{}{"journal": t.TempDir()})
+	failing := newFailingDriver(r.secondaries[0].driver)
+	r.secondaries[0].driver = failing
+
+	// with a journal, failures are queued and retried in order
+	require.NoError(t, r.PutContent(ctx, "/content", []byte("put")))
+	require.NoError(t, r.Delete(ctx, "/content"))
+	require.NoError(t, r.PutContent(ctx, "/other", []byte("other")))
+	require.Equal(t, 3, r.secondaries[0].journal.len())
+
+	failing.broken.Store(false)
+	require.Eventually(t, func() bool {
+		return r.secondaries[0].journal.len() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	requireNotFound(t, failing, "/content")
+	requireContent(t, failing, "/other", "other")
+}
+
+func TestAsyncReplication(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newTestMiddleware(t, map[string]interface{}{"mode": "async", "journal": t.TempDir()})
+	secondary := r.secondaries[0].driver
+
+	require.NoError(t, r.PutContent(ctx, "/content", []byte("put")))
+	require.NoError(t, r.Move(ctx, "/content", "/moved"))
+	require.Eventually(t, func() bool {
+		return r.secondaries[0].journal.len() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	requireContent(t, secondary, "/moved", "put")
+	requireNotFound(t, secondary, "/content")
+}
+
+func TestJournal(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "0.log")
+	j, err := openJournal(p)
+	require.NoError(t, err)
+
+	ops := []operation{
+		{Action: actionCopy, Path: "/a"},
+		{Action: actionMove, Path: "/a", Dest: "/b"},
+		{Action: actionDelete, Path: "/b"},
+	}
+	for _, op := range ops {
+		require.NoError(t, j.append(op))
+	}
+	op, ok := j.next()
+	require.True(t, ok)
+	require.Equal(t, ops[0], op)
+	require.NoError(t, j.ack())
+	require.NoError(t, j.close())
+
+	// the applied operations are not replayed
+	j, err = openJournal(p)
+	require.NoError(t, err)
+	require.Equal(t, ops[1:], j.ops)
+	require.NoError(t, j.ack())
+	require.NoError(t, j.ack())
+	require.NoError(t, j.close())
+
+	j, err = openJournal(p)
+	require.NoError(t, err)
+	require.Equal(t, 0, j.len())
+	require.NoError(t, j.close())
+
+	_, ok = j.next()
+	require.False(t, ok)
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	r, primary := newTestMiddleware(t, nil)
+	secondary := r.secondaries[0].driver
+
+	require.NoError(t, r.PutContent(ctx, "/same", []byte("same")))
+	require.NoError(t, primary.PutContent(ctx, "/missing", []byte("missing")))
+	require.NoError(t, r.PutContent(ctx, "/differs", []byte("sha256:1")))
+	require.NoError(t, primary.PutContent(ctx, "/differs", []byte("sha256:2")))
+	require.NoError(t, secondary.PutContent(ctx, "/extra", []byte("extra")))
+
+	verify := func(opts VerifyOptions) map[string]Drift {
+		drifts := make(map[string]Drift)
+		opts.Report = func(drift Drift) {
+			drifts[drift.Path] = drift
+		}
+		// Verify creates its own secondaries, so compare with the one of
+		// the middleware directly
+		require.NoError(t, verifySecondary(ctx, primary, "inmemory#0", secondary, opts))
+		return drifts
+	}
+
+	drifts := verify(VerifyOptions{})
+	require.Len(t, drifts, 3)
+	require.Equal(t, DriftMissing, drifts["/missing"].Kind)
+	require.Equal(t, DriftDiffers, drifts["/differs"].Kind)
+	require.Equal(t, DriftExtra, drifts["/extra"].Kind)
+	for _, drift := range drifts {
+		require.False(t, drift.Fixed)
+	}
+
+	drifts = verify(VerifyOptions{Backfill: true, Prune: true})
+	require.Len(t, drifts, 3)
+	for _, drift := range drifts {
+		require.True(t, drift.Fixed, drift.Path)
+	}
+	requireContent(t, secondary, "/missing", "missing")
+	requireContent(t, secondary, "/differs", "sha256:2")
+	requireNotFound(t, secondary, "/extra")
+
+	require.Empty(t, verify(VerifyOptions{}))
+}
+
+func TestVerifyPrunesGarbageCollectedBlobs(t *testing.T) {
+	ctx := context.Background()
+	r, primary := newTestMiddleware(t, nil)
+	secondary := r.secondaries[0].driver
+
+	// the blob is pushed through the middleware, but referenced by no
+	// manifest
+	registry, err := storage.NewRegistry(ctx, r, storage.EnableDelete)
+	require.NoError(t, err)
+	named, err := reference.WithName("test/repo")
+	require.NoError(t, err)
+	repo, err := registry.Repository(ctx, named)
+	require.NoError(t, err)
+	desc, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("garbage"))
+	require.NoError(t, err)
+	dataPath := path.Join("/docker/registry/v2/blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded()[:2], desc.Digest.Encoded(), "data")
+	requireContent(t, secondary, dataPath, "garbage")
+
+	// like the garbage-collect command, collect the garbage without the
+	// middleware, so that the deletion is not mirrored
+	registry, err = storage.NewRegistry(ctx, primary, storage.EnableDelete)
+	require.NoError(t, err)
+	require.NoError(t, storage.MarkAndSweep(ctx, primary, registry, storage.GCOpts{}))
+	requireNotFound(t, primary, dataPath)
+	requireContent(t, secondary, dataPath, "garbage")
+
+	var drifts []Drift
+	err = verifySecondary(ctx, primary, "inmemory#0", secondary, VerifyOptions{
+		Prune: true,
+		Report: func(drift Drift) {
+			drifts = append(drifts, drift)
+		},
+	})
+	require.NoError(t, err)
+	require.Contains(t, drifts, Drift{Secondary: "inmemory#0", Path: dataPath, Kind: DriftExtra, Fixed: true})
+	for _, drift := range drifts {
+		require.True(t, drift.Fixed, drift.Path)
+	}
+	requireNotFound(t, secondary, dataPath)
+}
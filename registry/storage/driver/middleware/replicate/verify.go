@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// verifyContentSize is the size up to which the content of files is
+// compared. Larger files, such as blob data, which never changes once
+// written, are compared by size.
+const verifyContentSize = 64 << 10
+
+// Drift kinds reported by Verify.
+const (
+	// DriftMissing is a file of the primary missing from a secondary.
+	DriftMissing = "missing"
+	// DriftDiffers is a file whose content differs between the primary and
+	// a secondary.
+	DriftDiffers = "differs"
+	// DriftExtra is a file of a secondary missing from the primary.
+	DriftExtra = "extra"
+)
+
+// Drift is a difference between the primary and a secondary found by Verify.
+type Drift struct {
+	// Secondary names the secondary driver.
+	Secondary string
+
+	// Path is the path of the file.
+	Path string
+
+	// Kind is one of DriftMissing, DriftDiffers or DriftExtra.
+	Kind string
+
+	// Fixed is true if the drift was reconciled.
+	Fixed bool
+
+	// Err is the error reconciling the drift, if any.
+	Err error
+}
+
+// VerifyOptions configures Verify.
+type VerifyOptions struct {
+	// Backfill copies the missing and differing files to the secondaries.
+	Backfill bool
+
+	// Prune deletes the files of the secondaries missing from the primary,
+	// such as content removed by the garbage collector.
+	Prune bool
+
+	// Report is called for each drift found.
+	Report func(Drift)
+}
+
+// Verify compares the content of primary with each secondary configured in
+// the options of the replicate middleware, reporting the drifts and
+// optionally reconciling them.
+func Verify(ctx context.Context, primary storagedriver.StorageDriver, options map[string]interface{}, opts VerifyOptions) error {
+	secondaries, err := createSecondaries(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range secondaries {
+		if err := verifySecondary(ctx, primary, s.name, s.driver, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifySecondary(ctx context.Context, primary storagedriver.StorageDriver, name string, secondary storagedriver.StorageDriver, opts VerifyOptions) error {
+	report := func(drift Drift) {
+		if opts.Report != nil {
+			opts.Report(drift)
+		}
+	}
+
+	err := primary.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			return nil
+		}
+
+		kind, err := compareFile(ctx, primary, secondary, fi)
+		if err != nil || kind == "" {
+			return err
+		}
+
+		drift := Drift{Secondary: name, Path: fi.Path(), Kind: kind}
+		if opts.Backfill {
+			drift.Err = copyContent(ctx, primary, secondary, fi.Path())
+			drift.Fixed = drift.Err == nil
+		}
+		report(drift)
+		return nil
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("unable to walk primary: %v", err)
+	}
+
+	err = secondary.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			return nil
+		}
+
+		if _, err := primary.Stat(ctx, fi.Path()); !isNotFound(err) {
+			return err
+		}
+
+		drift := Drift{Secondary: name, Path: fi.Path(), Kind: DriftExtra}
+		if opts.Prune {
+			drift.Err = ignoreNotFound(secondary.Delete(ctx, fi.Path()))
+			drift.Fixed = drift.Err == nil
+		}
+		report(drift)
+		return nil
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("unable to walk secondary %s: %v", name, err)
+	}
+	return nil
+}
+
+// compareFile returns the kind of drift of the file fi of primary on
+// secondary, or an empty string if they match.
+func compareFile(ctx context.Context, primary, secondary storagedriver.StorageDriver, fi storagedriver.FileInfo) (string, error) {
+	sfi, err := secondary.Stat(ctx, fi.Path())
+	if isNotFound(err) {
+		return DriftMissing, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if sfi.IsDir() || sfi.Size() != fi.Size() {
+		return DriftDiffers, nil
+	}
+	if fi.Size() > verifyContentSize {
+		return "", nil
+	}
+
+	expected, err := primary.GetContent(ctx, fi.Path())
+	if err != nil {
+		// removed while walking
+		return "", ignoreNotFound(err)
+	}
+	actual, err := secondary.GetContent(ctx, fi.Path())
+	if err != nil {
+		if isNotFound(err) {
+			return DriftMissing, nil
+		}
+		return "", err
+	}
+	if !bytes.Equal(expected, actual) {
+		return DriftDiffers, nil
+	}
+	return "", nil
+}
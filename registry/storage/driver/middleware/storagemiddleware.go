@@ -2,8 +2,12 @@ package storagemiddleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
 
+	"github.com/distribution/distribution/v3/configuration"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 )
 
@@ -38,3 +42,51 @@ func Get(ctx context.Context, name string, options map[string]interface{}, stora
 
 	return nil, fmt.Errorf("no storage middleware registered with name: %s", name)
 }
+
+// Apply wraps storageDriver in the enabled middleware of chain, in order,
+// skipping those named in exclude. It returns the middleware implementing
+// io.Closer, to be closed in reverse order once the driver is no longer used.
+func Apply(ctx context.Context, storageDriver storagedriver.StorageDriver, chain []configuration.Middleware, exclude ...string) (storagedriver.StorageDriver, []io.Closer, error) {
+	var closers []io.Closer
+	for _, mw := range chain {
+		if mw.Disabled || slices.Contains(exclude, mw.Name) {
+			continue
+		}
+		smw, err := Get(ctx, mw.Name, mw.Options, storageDriver)
+		if err != nil {
+			return nil, nil, errors.Join(fmt.Errorf("unable to configure storage middleware (%s): %v", mw.Name, err), Close(closers))
+		}
+		if c, ok := smw.(io.Closer); ok {
+			closers = append(closers, c)
+		}
+		storageDriver = smw
+	}
+	return storageDriver, closers, nil
+}
+
+// Close closes the middleware returned by Apply, in reverse order.
+func Close(closers []io.Closer) error {
+	var errs []error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type maintenanceKey struct{}
+
+// WithMaintenance returns a context for constructing middleware in a
+// maintenance command, such as garbage-collect, rather than in a registry
+// serving requests. Middleware constructed with it do not start their
+// background work nor record reads.
+func WithMaintenance(ctx context.Context) context.Context {
+	return context.WithValue(ctx, maintenanceKey{}, true)
+}
+
+// IsMaintenance reports whether ctx was returned by WithMaintenance.
+func IsMaintenance(ctx context.Context) bool {
+	maintenance, _ := ctx.Value(maintenanceKey{}).(bool)
+	return maintenance
+}
@@ -0,0 +1,356 @@
+// Package middleware provides a storage middleware moving the data of blobs
+// which are not read anymore from the storage driver, the hot tier, to a
+// cheaper cold tier.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/optionutil"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	if err := storagemiddleware.Register("tiering", newTieringStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register tiering storage middleware: %v", err)
+	}
+}
+
+const (
+	defaultWindow   = 30 * 24 * time.Hour
+	defaultInterval = time.Hour
+)
+
+// blobsRoot is the root of the blob data.
+const blobsRoot = "/docker/registry/v2/blobs"
+
+// lastReadName is the name of the marker next to hot blob data whose
+// modification time is the last read of the data, so that reads survive
+// restarts and are shared by the registry instances using the storage.
+const lastReadName = "lastread"
+
+// maxReadResolution is the longest interval between the updates of the read
+// marker of blob data read continuously.
+const maxReadResolution = time.Hour
+
+// blobDataPathRegexp matches the paths of blob data, the only content moved
+// to the cold tier. Everything else, such as links, tags and uploads, stays
+// on the hot tier.
+var blobDataPathRegexp = regexp.MustCompile(`^/docker/registry/v2/blobs/[a-z0-9]+/[0-9a-f]{2}/[0-9a-f]+/data$`)
+
+// tieringStorageMiddleware serves blob data from the hot tier, or from the
+// cold tier once it has been demoted. Blob data not read within window is
+// demoted by a mover running every interval, and cold blob data is promoted
+// back to the hot tier when read if promote is set.
+type tieringStorageMiddleware struct {
+	storagedriver.StorageDriver // the hot tier
+	cold                        storagedriver.StorageDriver
+	window                      time.Duration
+	interval                    time.Duration
+	promote                     bool
+	readResolution              time.Duration
+	untracked                   bool // reads are not recorded
+
+	mu        sync.Mutex
+	reads     map[string]time.Time // last reads of hot blob data recorded in their marker
+	promoting map[string]bool
+	stop      chan struct{}
+}
+
+var _ storagedriver.StorageDriver = &tieringStorageMiddleware{}
+
+func newTieringStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	o, ok := options["cold"]
+	if !ok {
+		return nil, fmt.Errorf("no cold storage driver provided")
+	}
+	// decode the generic options like the storage section
+	var storage configuration.Storage
+	if err := optionutil.Decode(o, &storage, false); err != nil {
+		return nil, fmt.Errorf("invalid cold storage driver: %v", err)
+	}
+	if storage.Type() == "" {
+		return nil, fmt.Errorf("no cold storage driver provided")
+	}
+
+	window, err := optionutil.Duration(options, "window", defaultWindow)
+	if err != nil {
+		return nil, err
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("window must be positive")
+	}
+	interval, err := optionutil.Duration(options, "interval", defaultInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	promote := false
+	if o, ok := options["promote"]; ok {
+		if promote, ok = o.(bool); !ok {
+			return nil, fmt.Errorf("promote must be a boolean")
+		}
+	}
+
+	cold, err := factory.Create(ctx, storage.Type(), storage.Parameters())
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cold storage driver: %v", err)
+	}
+
+	// maintenance commands leave the tiers as they are
+	maintenance := storagemiddleware.IsMaintenance(ctx)
+	t := newTiering(sd, cold, window, interval, promote && !maintenance)
+	t.untracked = maintenance
+	if interval > 0 && !maintenance {
+		go t.run()
+	}
+
+	dcontext.GetLogger(ctx).Infof("tiering: demoting blob data not read for %s to %s", window, cold.Name())
+	return t, nil
+}
+
+func newTiering(hot, cold storagedriver.StorageDriver, window, interval time.Duration, promote bool) *tieringStorageMiddleware {
+	return &tieringStorageMiddleware{
+		StorageDriver: hot,
+		cold:          cold,
+		window:        window,
+		interval:      interval,
+		promote:       promote,
+		// reads need not be recorded more precisely than the window
+		readResolution: min(window/10, maxReadResolution),
+		reads:          make(map[string]time.Time),
+		promoting:      make(map[string]bool),
+		stop:           make(chan struct{}),
+	}
+}
+
+// isBlobData reports whether p is the path of blob data.
+func isBlobData(p string) bool {
+	return blobDataPathRegexp.MatchString(p)
+}
+
+// lastReadPath returns the path of the read marker of the blob data at p.
+func lastReadPath(p string) string {
+	return path.Join(path.Dir(p), lastReadName)
+}
+
+// overlapsBlobs reports whether the tree at p may hold blob data.
+func overlapsBlobs(p string) bool {
+	return p == "/" || strings.HasPrefix(p+"/", blobsRoot+"/") || strings.HasPrefix(blobsRoot, p+"/")
+}
+
+func isNotFound(err error) bool {
+	var notFound storagedriver.PathNotFoundError
+	return errors.As(err, &notFound)
+}
+
+// touch records a read of the hot blob data at p in its read marker, unless
+// it was recorded less than the read resolution ago.
+func (t *tieringStorageMiddleware) touch(ctx context.Context, p string) {
+	if t.untracked {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	if now.Sub(t.reads[p]) < t.readResolution {
+		t.mu.Unlock()
+		return
+	}
+	t.reads[p] = now
+	t.mu.Unlock()
+
+	if err := t.StorageDriver.PutContent(ctx, lastReadPath(p), nil); err != nil {
+		dcontext.GetLogger(ctx).Warnf("tiering: unable to record the read of %s: %v", p, err)
+	}
+}
+
+// GetContent returns the content at p, from the cold tier if it has been
+// demoted.
+func (t *tieringStorageMiddleware) GetContent(ctx context.Context, p string) ([]byte, error) {
+	content, err := t.StorageDriver.GetContent(ctx, p)
+	if !isBlobData(p) {
+		return content, err
+	}
+	if err == nil {
+		t.touch(ctx, p)
+		return content, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	content, err = t.cold.GetContent(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	t.promoteAsync(ctx, p)
+	return content, nil
+}
+
+// Reader returns a reader of the content at p, from the cold tier if it has
+// been demoted.
+func (t *tieringStorageMiddleware) Reader(ctx context.Context, p string, offset int64) (io.ReadCloser, error) {
+	rc, err := t.StorageDriver.Reader(ctx, p, offset)
+	if !isBlobData(p) {
+		return rc, err
+	}
+	if err == nil {
+		t.touch(ctx, p)
+		return rc, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	rc, err = t.cold.Reader(ctx, p, offset)
+	if err != nil {
+		return nil, err
+	}
+	t.promoteAsync(ctx, p)
+	return rc, nil
+}
+
+// Stat returns the info of p, from the cold tier if it has been demoted.
+func (t *tieringStorageMiddleware) Stat(ctx context.Context, p string) (storagedriver.FileInfo, error) {
+	fi, err := t.StorageDriver.Stat(ctx, p)
+	if isBlobData(p) && isNotFound(err) {
+		return t.cold.Stat(ctx, p)
+	}
+	return fi, err
+}
+
+// List lists the children of p on both tiers.
+func (t *tieringStorageMiddleware) List(ctx context.Context, p string) ([]string, error) {
+	hot, err := t.StorageDriver.List(ctx, p)
+	if !overlapsBlobs(p) || (err != nil && !isNotFound(err)) {
+		return hot, err
+	}
+
+	cold, coldErr := t.cold.List(ctx, p)
+	if coldErr != nil {
+		if isNotFound(coldErr) {
+			return hot, err
+		}
+		return nil, coldErr
+	}
+
+	seen := make(map[string]bool, len(hot)+len(cold))
+	children := make([]string, 0, len(hot)+len(cold))
+	for _, child := range append(hot, cold...) {
+		if !seen[child] {
+			seen[child] = true
+			children = append(children, child)
+		}
+	}
+	sort.Strings(children)
+	return children, nil
+}
+
+// Move moves sourcePath to destPath on the tier holding it.
+func (t *tieringStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	err := t.StorageDriver.Move(ctx, sourcePath, destPath)
+	if isBlobData(sourcePath) && isBlobData(destPath) && isNotFound(err) {
+		return t.cold.Move(ctx, sourcePath, destPath)
+	}
+	return err
+}
+
+// Delete deletes p from both tiers.
+func (t *tieringStorageMiddleware) Delete(ctx context.Context, p string) error {
+	err := t.StorageDriver.Delete(ctx, p)
+	if !overlapsBlobs(p) {
+		return err
+	}
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	coldErr := t.cold.Delete(ctx, p)
+	switch {
+	case coldErr == nil:
+		return nil
+	case isNotFound(coldErr):
+		return err
+	default:
+		return coldErr
+	}
+}
+
+// RedirectURL returns a URL for the content at p on the tier holding it.
+func (t *tieringStorageMiddleware) RedirectURL(r *http.Request, p string) (string, error) {
+	if !isBlobData(p) {
+		return t.StorageDriver.RedirectURL(r, p)
+	}
+
+	_, err := t.StorageDriver.Stat(r.Context(), p)
+	if err == nil {
+		t.touch(r.Context(), p)
+		return t.StorageDriver.RedirectURL(r, p)
+	}
+	if !isNotFound(err) {
+		return "", err
+	}
+
+	url, err := t.cold.RedirectURL(r, p)
+	if err == nil && url != "" {
+		// the content is read from the cold tier
+		t.promoteAsync(r.Context(), p)
+	}
+	return url, err
+}
+
+// Walk walks the files under p on both tiers. The files of the cold tier
+// are walked after those of the hot tier, and skipped if they are on both.
+func (t *tieringStorageMiddleware) Walk(ctx context.Context, p string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	if !overlapsBlobs(p) {
+		return t.StorageDriver.Walk(ctx, p, f, options...)
+	}
+
+	filled := false
+	skipped := make(map[string]bool)
+	hotErr := t.StorageDriver.Walk(ctx, p, func(fi storagedriver.FileInfo) error {
+		err := f(fi)
+		switch err {
+		case storagedriver.ErrFilledBuffer:
+			filled = true
+		case storagedriver.ErrSkipDir:
+			skipped[fi.Path()] = true
+		}
+		return err
+	}, options...)
+	if filled || (hotErr != nil && !isNotFound(hotErr)) {
+		return hotErr
+	}
+
+	err := t.cold.Walk(ctx, p, func(fi storagedriver.FileInfo) error {
+		if skipped[fi.Path()] {
+			return storagedriver.ErrSkipDir
+		}
+		if _, err := t.StorageDriver.Stat(ctx, fi.Path()); err == nil {
+			// already walked
+			return nil
+		} else if !isNotFound(err) {
+			return err
+		}
+		return f(fi)
+	}, options...)
+	if isNotFound(err) {
+		return hotErr
+	}
+	return err
+}
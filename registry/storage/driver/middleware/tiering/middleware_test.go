@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	blobDir  = "/docker/registry/v2/blobs/sha256/ab/abcdef0123456789"
+	blobPath = blobDir + "/data"
+	linkPath = "/docker/registry/v2/repositories/foo/_layers/sha256/abcdef0123456789/link"
+)
+
+func newTestTiering(t *testing.T, promote bool) *tieringStorageMiddleware {
+	tiering := newTiering(inmemory.New(), inmemory.New(), time.Hour, 0, promote)
+	t.Cleanup(func() { tiering.Close() })
+	return tiering
+}
+
+// demoteAll demotes all the blob data, whatever its last read.
+func demoteAll(t *testing.T, tiering *tieringStorageMiddleware) int {
+	window := tiering.window
+	tiering.window = -time.Hour
+	defer func() { tiering.window = window }()
+
+	n, err := tiering.demoteIdle(context.Background())
+	require.NoError(t, err)
+	return n
+}
+
+func readAll(t *testing.T, sd storagedriver.StorageDriver, path string, offset int64) string {
+	rc, err := sd.Reader(context.Background(), path, offset)
+	require.NoError(t, err)
+	defer rc.Close()
+	p, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(p)
+}
+
+func TestOptions(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := newTieringStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{})
+	require.ErrorContains(t, err, "no cold storage driver provided")
+
+	_, err = newTieringStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{"cold": "unknown"})
+	require.ErrorContains(t, err, "unable to create cold storage driver")
+
+	_, err = newTieringStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{"cold": "inmemory", "window": "soon"})
+	require.ErrorContains(t, err, "invalid window")
+
+	_, err = newTieringStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{"cold": "inmemory", "window": "-1h"})
+	require.ErrorContains(t, err, "window must be positive")
+
+	_, err = newTieringStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{"cold": "inmemory", "promote": "yes"})
+	require.ErrorContains(t, err, "promote must be a boolean")
+
+	middleware, err := newTieringStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{
+		"cold":     map[interface{}]interface{}{"inmemory": nil},
+		"window":   "24h",
+		"interval": "0s",
+		"promote":  true,
+	})
+	require.NoError(t, err)
+	tiering := middleware.(*tieringStorageMiddleware)
+	require.Equal(t, 24*time.Hour, tiering.window)
+	require.True(t, tiering.promote)
+	require.Equal(t, "inmemory", tiering.cold.Name())
+}
+
+func TestDemote(t *testing.T) {
+	ctx := context.Background()
+	tiering := newTestTiering(t, false)
+
+	require.NoError(t, tiering.PutContent(ctx, blobPath, []byte("blob content")))
+	require.NoError(t, tiering.PutContent(ctx, linkPath, []byte("link")))
+
+	// recently written data is not idle
+	n, err := tiering.demoteIdle(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	require.Equal(t, 1, demoteAll(t, tiering))
+
+	_, err = tiering.StorageDriver.Stat(ctx, blobPath)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+	_, err = tiering.StorageDriver.Stat(ctx, linkPath)
+	require.NoError(t, err)
+	_, err = tiering.cold.Stat(ctx, linkPath)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+
+	// reads fall back to the cold tier
+	content, err := tiering.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, "blob content", string(content))
+	require.Equal(t, "content", readAll(t, tiering, blobPath, 5))
+	fi, err := tiering.Stat(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("blob content")), fi.Size())
+
+	// without promotion, the data stays cold
+	_, err = tiering.StorageDriver.Stat(ctx, blobPath)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+}
+
+func TestRecentReadsStayHot(t *testing.T) {
+	ctx := context.Background()
+	tiering := newTestTiering(t, false)
+	tiering.window = time.Nanosecond
+
+	require.NoError(t, tiering.PutContent(ctx, blobPath, []byte("blob content")))
+	time.Sleep(time.Millisecond)
+	readAll(t, tiering, blobPath, 0)
+	tiering.window = time.Hour
+
+	n, err := tiering.demoteIdle(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	_, err = tiering.StorageDriver.Stat(ctx, blobPath)
+	require.NoError(t, err)
+}
+
+func TestReadsAreShared(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := inmemory.New(), inmemory.New()
+	tiering := newTiering(hot, cold, time.Hour, 0, false)
+
+	require.NoError(t, tiering.PutContent(ctx, blobPath, []byte("blob content")))
+	tiering.window = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	readAll(t, tiering, blobPath, 0)
+	_, err := hot.Stat(ctx, blobDir+"/"+lastReadName)
+	require.NoError(t, err)
+
+	// the read is seen by another instance, or after a restart
+	other := newTiering(hot, cold, time.Hour, 0, false)
+	n, err := other.demoteIdle(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// the marker is deleted with the hot copy of the data
+	require.Equal(t, 1, demoteAll(t, other))
+	_, err = hot.Stat(ctx, blobDir+"/"+lastReadName)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+}
+
+func TestMaintenance(t *testing.T) {
+	ctx := storagemiddleware.WithMaintenance(context.Background())
+	middleware, err := newTieringStorageMiddleware(ctx, inmemory.New(), map[string]interface{}{
+		"cold":    "inmemory",
+		"promote": true,
+	})
+	require.NoError(t, err)
+	tiering := middleware.(*tieringStorageMiddleware)
+	t.Cleanup(func() { tiering.Close() })
+
+	// the content of both tiers is served, but reads are neither recorded
+	// nor promote cold blob data
+	require.NoError(t, tiering.PutContent(ctx, blobPath, []byte("blob content")))
+	readAll(t, tiering, blobPath, 0)
+	_, err = tiering.StorageDriver.Stat(ctx, blobDir+"/"+lastReadName)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+
+	require.Equal(t, 1, demoteAll(t, tiering))
+	require.Equal(t, "blob content", readAll(t, tiering, blobPath, 0))
+	require.False(t, tiering.promote)
+	_, err = tiering.cold.Stat(ctx, blobPath)
+	require.NoError(t, err)
+}
+
+func TestPromote(t *testing.T) {
+	ctx := context.Background()
+	tiering := newTestTiering(t, true)
+
+	require.NoError(t, tiering.PutContent(ctx, blobPath, []byte("blob content")))
+	require.Equal(t, 1, demoteAll(t, tiering))
+
+	require.Equal(t, "blob content", readAll(t, tiering, blobPath, 0))
+	require.Eventually(t, func() bool {
+		_, err := tiering.cold.Stat(ctx, blobPath)
+		return isNotFound(err)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	content, err := tiering.StorageDriver.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, "blob content", string(content))
+
+	// the temporary copy is gone
+	children, err := tiering.StorageDriver.List(ctx, promotePath)
+	if err == nil {
+		require.Empty(t, children)
+	}
+}
+
+func TestListWalkDelete(t *testing.T) {
+	ctx := context.Background()
+	tiering := newTestTiering(t, false)
+
+	otherPath := "/docker/registry/v2/blobs/sha256/ab/abcdef0000000000/data"
+	require.NoError(t, tiering.PutContent(ctx, blobPath, []byte("cold")))
+	require.Equal(t, 1, demoteAll(t, tiering))
+	require.NoError(t, tiering.PutContent(ctx, otherPath, []byte("hot")))
+
+	children, err := tiering.List(ctx, "/docker/registry/v2/blobs/sha256/ab")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/docker/registry/v2/blobs/sha256/ab/abcdef0000000000", blobDir}, children)
+
+	var files []string
+	err = tiering.Walk(ctx, "/docker/registry/v2", func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			files = append(files, fi.Path())
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{otherPath, blobPath}, files)
+
+	// blob directories are deleted from both tiers, as by the garbage collector
+	require.NoError(t, tiering.Delete(ctx, blobDir))
+	_, err = tiering.Stat(ctx, blobPath)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+	require.ErrorAs(t, tiering.Delete(ctx, blobDir), new(storagedriver.PathNotFoundError))
+
+	_, err = tiering.Stat(ctx, otherPath)
+	require.NoError(t, err)
+}
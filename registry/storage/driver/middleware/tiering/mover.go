@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/google/uuid"
+)
+
+// promotePath is the directory of the blob data being promoted, copied there
+// from the cold tier before being moved in place, so that it is never read
+// partially written.
+const promotePath = "/docker/registry/v2/_tiering"
+
+// run demotes the idle blob data every interval, until the middleware is
+// closed.
+func (t *tieringStorageMiddleware) run() {
+	ctx := dcontext.Background()
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.stop:
+			return
+		}
+
+		start := time.Now()
+		n, err := t.demoteIdle(ctx)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("tiering: demoting idle blob data failed: %v", err)
+		}
+		dcontext.GetLogger(ctx).Infof("tiering: demoted %d blobs in %s", n, time.Since(start))
+	}
+}
+
+// Close stops the mover.
+func (t *tieringStorageMiddleware) Close() error {
+	close(t.stop)
+	return nil
+}
+
+// readSince reports whether the hot blob data at p has been read since
+// deadline, by this instance or, according to its read marker, by any.
+func (t *tieringStorageMiddleware) readSince(ctx context.Context, p string, deadline time.Time) (bool, error) {
+	t.mu.Lock()
+	read := t.reads[p]
+	t.mu.Unlock()
+	if !read.Before(deadline) {
+		return true, nil
+	}
+
+	fi, err := t.StorageDriver.Stat(ctx, lastReadPath(p))
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !fi.ModTime().Before(deadline), nil
+}
+
+// demoteIdle demotes the hot blob data neither written nor read within the
+// window, and returns the number of blobs demoted. Blobs failing to be
+// demoted are logged and left on the hot tier.
+func (t *tieringStorageMiddleware) demoteIdle(ctx context.Context) (int, error) {
+	deadline := time.Now().Add(-t.window)
+
+	// the read markers are walked with the data, next to it
+	written := make(map[string]time.Time)
+	read := make(map[string]time.Time)
+	err := t.StorageDriver.Walk(ctx, blobsRoot, func(fi storagedriver.FileInfo) error {
+		switch {
+		case fi.IsDir():
+		case isBlobData(fi.Path()):
+			written[fi.Path()] = fi.ModTime()
+		case path.Base(fi.Path()) == lastReadName:
+			read[path.Join(path.Dir(fi.Path()), "data")] = fi.ModTime()
+		}
+		return nil
+	})
+	if err != nil && !isNotFound(err) {
+		return 0, err
+	}
+
+	var idle []string
+	t.mu.Lock()
+	for p, modTime := range written {
+		if modTime.Before(deadline) && read[p].Before(deadline) && t.reads[p].Before(deadline) {
+			idle = append(idle, p)
+		}
+	}
+	t.mu.Unlock()
+	sort.Strings(idle)
+
+	demoted := 0
+	for _, p := range idle {
+		select {
+		case <-t.stop:
+			return demoted, nil
+		default:
+		}
+
+		ok, err := t.demote(ctx, p, deadline)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("tiering: unable to demote %s: %v", p, err)
+			continue
+		}
+		if ok {
+			demoted++
+		}
+	}
+	return demoted, nil
+}
+
+// demote copies the blob data at p to the cold tier, then removes it from the
+// hot tier, unless it has been read since deadline in the meantime.
+func (t *tieringStorageMiddleware) demote(ctx context.Context, p string, deadline time.Time) (bool, error) {
+	fi, err := t.StorageDriver.Stat(ctx, p)
+	if err != nil {
+		if isNotFound(err) {
+			// deleted in the meantime
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := copyContent(ctx, t.StorageDriver, p, t.cold, p); err != nil {
+		return false, err
+	}
+	cfi, err := t.cold.Stat(ctx, p)
+	if err != nil {
+		return false, err
+	}
+	if cfi.Size() != fi.Size() {
+		return false, fmt.Errorf("copied %d of %d bytes", cfi.Size(), fi.Size())
+	}
+
+	read, err := t.readSince(ctx, p, deadline)
+	if err != nil || read {
+		// read while being copied, keep both copies
+		return false, err
+	}
+
+	if err := t.StorageDriver.Delete(ctx, p); err != nil && !isNotFound(err) {
+		return false, err
+	}
+	if err := t.StorageDriver.Delete(ctx, lastReadPath(p)); err != nil && !isNotFound(err) {
+		dcontext.GetLogger(ctx).Warnf("tiering: unable to delete the read marker of %s: %v", p, err)
+	}
+	t.mu.Lock()
+	delete(t.reads, p)
+	t.mu.Unlock()
+	return true, nil
+}
+
+// promoteAsync promotes the cold blob data at p in the background, if
+// promotion is enabled and p is not being promoted already.
+func (t *tieringStorageMiddleware) promoteAsync(ctx context.Context, p string) {
+	if !t.promote {
+		return
+	}
+
+	t.mu.Lock()
+	if t.promoting[p] {
+		t.mu.Unlock()
+		return
+	}
+	t.promoting[p] = true
+	t.mu.Unlock()
+
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.promoting, p)
+			t.mu.Unlock()
+		}()
+
+		ctx := dcontext.WithLogger(dcontext.Background(), dcontext.GetLogger(ctx))
+		if err := t.promoteBlob(ctx, p); err != nil {
+			dcontext.GetLogger(ctx).Errorf("tiering: unable to promote %s: %v", p, err)
+		}
+	}()
+}
+
+// promoteBlob copies the cold blob data at p back to the hot tier, then
+// removes it from the cold tier.
+func (t *tieringStorageMiddleware) promoteBlob(ctx context.Context, p string) error {
+	tmp := path.Join(promotePath, uuid.NewString())
+	if err := copyContent(ctx, t.cold, p, t.StorageDriver, tmp); err != nil {
+		t.StorageDriver.Delete(ctx, tmp)
+		return err
+	}
+	if err := t.StorageDriver.Move(ctx, tmp, p); err != nil {
+		t.StorageDriver.Delete(ctx, tmp)
+		return err
+	}
+	t.touch(ctx, p)
+
+	if err := t.cold.Delete(ctx, p); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// copyContent copies the content at srcPath of src to dstPath of dst.
+func copyContent(ctx context.Context, src storagedriver.StorageDriver, srcPath string, dst storagedriver.StorageDriver, dstPath string) error {
+	rc, err := src.Reader(ctx, srcPath, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w, err := dst.Writer(ctx, dstPath, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		w.Cancel(ctx)
+		w.Close()
+		return err
+	}
+	if err := w.Commit(ctx); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
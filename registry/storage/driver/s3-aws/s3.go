@@ -153,7 +153,10 @@ func (factory *s3DriverFactory) Create(ctx context.Context, parameters map[strin
 	return FromParameters(ctx, parameters)
 }
 
-var _ storagedriver.StorageDriver = &driver{}
+var (
+	_ storagedriver.StorageDriver   = &driver{}
+	_ storagedriver.UploadPresigner = &Driver{}
+)
 
 type driver struct {
 	S3                          *s3.S3
@@ -1105,6 +1108,56 @@ func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
 	return req.Presign(expiresIn)
 }
 
+// PresignUploadParts returns presigned URLs to upload the given parts of the
+// content at path. The parts belong to the multipart upload Writer resumes in
+// append mode, which must have been started by Writer beforehand: starting one
+// here would race with concurrent requests, each starting its own upload.
+func (d *driver) PresignUploadParts(ctx context.Context, path string, partNumbers []int, expiry time.Duration) ([]string, error) {
+	key := d.s3Path(path)
+	uploadID, err := d.multipartUploadID(ctx, key)
+	if err != nil {
+		return nil, parseError(path, err)
+	}
+	if uploadID == "" {
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+
+	urls := make([]string, 0, len(partNumbers))
+	for _, partNumber := range partNumbers {
+		req, _ := d.S3.UploadPartRequest(&s3.UploadPartInput{
+			Bucket:     aws.String(d.Bucket),
+			Key:        aws.String(key),
+			PartNumber: aws.Int64(int64(partNumber)),
+			UploadId:   aws.String(uploadID),
+		})
+		url, err := req.Presign(expiry)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	return urls, nil
+}
+
+// multipartUploadID returns the id of the multipart upload of key resumed by
+// Writer in append mode, or an empty string if there is none.
+func (d *driver) multipartUploadID(ctx context.Context, key string) (string, error) {
+	var uploadID string
+	err := d.S3.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(d.Bucket),
+		Prefix: aws.String(key),
+	}, func(resp *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, multi := range resp.Uploads {
+			if key == *multi.Key {
+				uploadID = *multi.UploadId
+				return false
+			}
+		}
+		return true
+	})
+	return uploadID, err
+}
+
 // Walk traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file
 func (d *driver) Walk(ctx context.Context, from string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
@@ -1276,6 +1329,12 @@ func (d *Driver) S3BucketKey(path string) string {
 	return d.StorageDriver.(*driver).s3Path(path)
 }
 
+// PresignUploadParts returns presigned URLs to upload the given parts of the
+// content at path directly to S3.
+func (d *Driver) PresignUploadParts(ctx context.Context, path string, partNumbers []int, expiry time.Duration) ([]string, error) {
+	return d.StorageDriver.(*driver).PresignUploadParts(ctx, path, partNumbers, expiry)
+}
+
 func parseError(path string, err error) error {
 	if s3Err, ok := err.(awserr.Error); ok && s3Err.Code() == "NoSuchKey" {
 		return storagedriver.PathNotFoundError{Path: path}
@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Version is a string representing the storage driver version, of the form
@@ -131,6 +132,20 @@ type FileWriter interface {
 	Commit(context.Context) error
 }
 
+// UploadPresigner is implemented by storage drivers which let clients write
+// content directly to the storage backend, in parts uploaded to presigned
+// URLs. Once the parts are uploaded, a FileWriter opened in append mode at
+// the same path sees them as written, and commits them on Commit. The content
+// must have been opened with Writer beforehand, PresignUploadParts returning a
+// PathNotFoundError otherwise.
+type UploadPresigner interface {
+	// PresignUploadParts returns, for each of the part numbers, a URL to
+	// which the client may PUT that part of the content of path, valid for
+	// expiry. Parts are assembled in the order of their numbers, starting at
+	// 1.
+	PresignUploadParts(ctx context.Context, path string, partNumbers []int, expiry time.Duration) ([]string, error)
+}
+
 // PathRegexp is the regular expression which each file path must match. A
 // file path is absolute, beginning with a slash and containing a positive
 // number of path components separated by slashes, where each component is
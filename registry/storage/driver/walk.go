@@ -6,8 +6,11 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 )
 
 // ErrSkipDir is used as a return value from onFileFunc to indicate that
@@ -123,3 +126,98 @@ func doWalkFallback(ctx context.Context, driver StorageDriver, from string, star
 	}
 	return true, nil
 }
+
+// fanOutDepth is the number of levels below the root of WalkParallel which
+// are listed to fan out the walk of the subtrees below them.
+const fanOutDepth = 2
+
+// WalkParallel traverses a filesystem defined within driver, starting from
+// the given path, calling f on each file, like the Walk method of driver but
+// with up to concurrency operations at a time. The first levels of the tree
+// are listed and stat'ed concurrently, then the subtrees below them are
+// walked concurrently with the Walk method, so that drivers walking a prefix
+// at once, such as S3, fan out by prefix.
+//
+// Files are not walked in order, and f may be called concurrently. ErrSkipDir
+// and ErrFilledBuffer have the same meaning as with Walk: once f returns
+// ErrFilledBuffer, the walk stops without error.
+func WalkParallel(ctx context.Context, driver StorageDriver, from string, concurrency int, f WalkFn) error {
+	if concurrency <= 1 {
+		return driver.Walk(ctx, from, f)
+	}
+
+	children, err := driver.List(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var filled atomic.Bool
+	walkFn := func(fileInfo FileInfo) error {
+		if filled.Load() {
+			return ErrFilledBuffer
+		}
+		err := f(fileInfo)
+		if err == ErrFilledBuffer {
+			filled.Store(true)
+			cancel()
+		}
+		return err
+	}
+
+	for depth := 0; depth < fanOutDepth && len(children) > 0; depth++ {
+		var (
+			mu   sync.Mutex
+			next []string
+		)
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(concurrency)
+		for _, child := range children {
+			if filled.Load() {
+				break
+			}
+			g.Go(func() error {
+				fileInfo, err := driver.Stat(gctx, child)
+				if err != nil {
+					if _, ok := err.(PathNotFoundError); ok {
+						// removed in between listing and enumeration
+						return nil
+					}
+					return err
+				}
+
+				switch err := walkFn(fileInfo); err {
+				case nil:
+				case ErrSkipDir, ErrFilledBuffer:
+					return nil
+				default:
+					return err
+				}
+				if !fileInfo.IsDir() {
+					return nil
+				}
+
+				if depth == fanOutDepth-1 {
+					err = driver.Walk(gctx, child, walkFn)
+				} else {
+					var grandChildren []string
+					grandChildren, err = driver.List(gctx, child)
+					mu.Lock()
+					next = append(next, grandChildren...)
+					mu.Unlock()
+				}
+				if _, ok := err.(PathNotFoundError); ok {
+					return nil
+				}
+				return err
+			})
+		}
+		if err := g.Wait(); err != nil && !filled.Load() {
+			return err
+		}
+		children = next
+	}
+	return nil
+}
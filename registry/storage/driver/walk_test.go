@@ -3,6 +3,10 @@ package driver
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -323,3 +327,134 @@ func compareWalked(t *testing.T, expected, walked []string) {
 		}
 	}
 }
+
+// walkingFileSystem is a fileSystem walked with WalkFallback.
+type walkingFileSystem struct {
+	*fileSystem
+}
+
+func (wfs walkingFileSystem) Walk(ctx context.Context, path string, f WalkFn, options ...func(*WalkOptions)) error {
+	return WalkFallback(ctx, wfs, path, f, options...)
+}
+
+func TestWalkParallel(t *testing.T) {
+	d := walkingFileSystem{&fileSystem{
+		fileset: map[string][]string{
+			"/":                              {"/file1", "/folder1", "/folder2", "/folder3"},
+			"/folder1":                       {"/folder1/file1"},
+			"/folder2":                       {"/folder2/file1", "/folder2/file2"},
+			"/folder3":                       {"/folder3/subfolder1", "/folder3/subfolder2"},
+			"/folder3/subfolder1":            {"/folder3/subfolder1/subfolder1"},
+			"/folder3/subfolder1/subfolder1": {"/folder3/subfolder1/subfolder1/file1"},
+			"/folder3/subfolder2":            {"/folder3/subfolder2/file1"},
+		},
+	}}
+
+	tcs := []struct {
+		name     string
+		fn       WalkFn
+		expected []string
+		err      bool
+	}{
+		{
+			name: "walk all",
+			fn:   func(fileInfo FileInfo) error { return nil },
+			expected: []string{
+				"/file1",
+				"/folder1",
+				"/folder1/file1",
+				"/folder2",
+				"/folder2/file1",
+				"/folder2/file2",
+				"/folder3",
+				"/folder3/subfolder1",
+				"/folder3/subfolder1/subfolder1",
+				"/folder3/subfolder1/subfolder1/file1",
+				"/folder3/subfolder2",
+				"/folder3/subfolder2/file1",
+			},
+		},
+		{
+			name: "skip directories",
+			fn: func(fileInfo FileInfo) error {
+				if fileInfo.Path() == "/folder2" || fileInfo.Path() == "/folder3/subfolder1" {
+					return ErrSkipDir
+				}
+				return nil
+			},
+			expected: []string{
+				"/file1",
+				"/folder1",
+				"/folder1/file1",
+				"/folder2",
+				"/folder3",
+				"/folder3/subfolder1",
+				"/folder3/subfolder2",
+				"/folder3/subfolder2/file1",
+			},
+		},
+		{
+			name: "error",
+			fn: func(fileInfo FileInfo) error {
+				if fileInfo.Path() == "/folder3/subfolder2/file1" {
+					return errors.New("foo")
+				}
+				return nil
+			},
+			err: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				walked []string
+			)
+			err := WalkParallel(context.Background(), d, "/", 4, func(fileInfo FileInfo) error {
+				mu.Lock()
+				walked = append(walked, fileInfo.Path())
+				mu.Unlock()
+				return tc.fn(fileInfo)
+			})
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected err")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(walked)
+			compareWalked(t, tc.expected, walked)
+		})
+	}
+}
+
+func TestWalkParallelFilledBuffer(t *testing.T) {
+	fileset := map[string][]string{"/": nil}
+	for i := 0; i < 10; i++ {
+		dir := fmt.Sprintf("/folder%d", i)
+		fileset["/"] = append(fileset["/"], dir)
+		for j := 0; j < 10; j++ {
+			fileset[dir] = append(fileset[dir], fmt.Sprintf("%s/file%d", dir, j))
+		}
+	}
+	d := walkingFileSystem{&fileSystem{fileset: fileset}}
+
+	var walked atomic.Int32
+	err := WalkParallel(context.Background(), d, "/", 4, func(fileInfo FileInfo) error {
+		if walked.Add(1) >= 5 {
+			return ErrFilledBuffer
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// calls in progress when the buffer is filled may still complete
+	if n := walked.Load(); n < 5 || n > 5+4 {
+		t.Fatalf("unexpected number of files walked: %d", n)
+	}
+}
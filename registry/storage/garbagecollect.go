@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
@@ -19,6 +20,19 @@ func emit(format string, a ...interface{}) {
 type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
+
+	// Listener, if set, is notified of each manifest revision, layer link
+	// and blob removed by the sweep.
+	Listener GCListener
+}
+
+// GCListener describes a listener that can respond to content removed by
+// the garbage collector. Errors returned by the listener are logged and do
+// not stop the sweep.
+type GCListener interface {
+	ManifestRemoved(repo string, dgst digest.Digest) error
+	LayerRemoved(repo string, dgst digest.Digest) error
+	BlobRemoved(dgst digest.Digest) error
 }
 
 // ManifestDel contains manifest structure which will be deleted
@@ -141,6 +155,11 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			if err != nil {
 				return fmt.Errorf("failed to delete manifest %s: %v", obj.Digest, err)
 			}
+			if opts.Listener != nil {
+				if err := opts.Listener.ManifestRemoved(obj.Name, obj.Digest); err != nil {
+					dcontext.GetLogger(ctx).Errorf("error dispatching manifest removal to listener: %v", err)
+				}
+			}
 		}
 	}
 	blobService := registry.Blobs()
@@ -165,6 +184,11 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		if err != nil {
 			return fmt.Errorf("failed to delete blob %s: %v", dgst, err)
 		}
+		if opts.Listener != nil {
+			if err := opts.Listener.BlobRemoved(dgst); err != nil {
+				dcontext.GetLogger(ctx).Errorf("error dispatching blob removal to listener: %v", err)
+			}
+		}
 	}
 
 	for repo, dgsts := range deleteLayerSet {
@@ -177,6 +201,11 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			if err != nil {
 				return fmt.Errorf("failed to delete layer link %s of repo %s: %v", dgst, repo, err)
 			}
+			if opts.Listener != nil {
+				if err := opts.Listener.LayerRemoved(repo, dgst); err != nil {
+					dcontext.GetLogger(ctx).Errorf("error dispatching layer link removal to listener: %v", err)
+				}
+			}
 		}
 	}
 
@@ -922,3 +922,91 @@ func TestTaggedManifestlistWithDeletedReference(t *testing.T) {
 		t.Fatalf("Garbage collection affected storage: %d != %d", len(after), 0)
 	}
 }
+
+type testGCListener struct {
+	manifests map[digest.Digest]string
+	layers    map[digest.Digest]string
+	blobs     map[digest.Digest]struct{}
+}
+
+func (l *testGCListener) ManifestRemoved(repo string, dgst digest.Digest) error {
+	l.manifests[dgst] = repo
+	return nil
+}
+
+func (l *testGCListener) LayerRemoved(repo string, dgst digest.Digest) error {
+	l.layers[dgst] = repo
+	return nil
+}
+
+func (l *testGCListener) BlobRemoved(dgst digest.Digest) error {
+	l.blobs[dgst] = struct{}{}
+	return nil
+}
+
+func TestGCListener(t *testing.T) {
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "listened")
+
+	image1 := uploadRandomSchema2Image(t, repo)
+	image2 := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(dcontext.Background()).Tag(dcontext.Background(), "kept", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	before := allBlobs(t, registry)
+
+	listener := &testGCListener{
+		manifests: make(map[digest.Digest]string),
+		layers:    make(map[digest.Digest]string),
+		blobs:     make(map[digest.Digest]struct{}),
+	}
+
+	// a dry run must not notify the listener
+	err := MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+		DryRun:         true,
+		RemoveUntagged: true,
+		Listener:       listener,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	if len(listener.manifests) != 0 || len(listener.layers) != 0 || len(listener.blobs) != 0 {
+		t.Fatalf("dry run notified listener: %v %v %v", listener.manifests, listener.layers, listener.blobs)
+	}
+
+	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+		Listener:       listener,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	// only the untagged image is removed
+	if len(listener.manifests) != 1 || listener.manifests[image2.manifestDigest] != "listened" {
+		t.Errorf("unexpected manifest removals: %v", listener.manifests)
+	}
+	for layer := range image2.layers {
+		if listener.layers[layer] != "listened" {
+			t.Errorf("layer link removal of %s not notified: %v", layer, listener.layers)
+		}
+	}
+	for layer := range image1.layers {
+		if _, ok := listener.layers[layer]; ok {
+			t.Errorf("unexpected layer link removal of %s", layer)
+		}
+	}
+
+	after := allBlobs(t, registry)
+	if len(listener.blobs) != len(before)-len(after) {
+		t.Fatalf("unexpected blob removals: %d != %d", len(listener.blobs), len(before)-len(after))
+	}
+	for dgst := range listener.blobs {
+		if _, ok := after[dgst]; ok {
+			t.Errorf("blob removal of %s notified but blob is present", dgst)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/sync/errgroup"
+)
+
+// MigrateOpts contains options for Migrate
+type MigrateOpts struct {
+	// Concurrency is the number of files copied at a time. Defaults to
+	// DefaultConcurrencyLimit.
+	Concurrency int
+
+	// Verify checks the digest of the blobs already on the destination,
+	// instead of trusting those of the expected size.
+	Verify bool
+
+	// Prune deletes the files of the repositories of the destination which
+	// are missing from the source, such as deleted tags.
+	Prune bool
+
+	// AllowCorrupt copies the repositories even if blobs of the source do
+	// not match their digest, without the links referencing those blobs.
+	// Otherwise the migration stops once the blobs are copied.
+	AllowCorrupt bool
+}
+
+// MigrateStats counts the files handled by Migrate.
+type MigrateStats struct {
+	BlobsCopied  int
+	BlobsSkipped int
+	BytesCopied  int64
+
+	// BlobsCorrupt is the number of blobs of the source whose content does
+	// not match their digest, which are not copied.
+	BlobsCorrupt int
+
+	LinksCopied  int
+	LinksSkipped int
+	LinksPruned  int
+
+	// LinksCorrupt is the number of links of the source referencing corrupt
+	// blobs, which are not copied.
+	LinksCorrupt int
+}
+
+// Migrate copies the blobs and the repositories, with their tags, manifest
+// revisions and layer links, from the src storage driver to dst. Uploads in
+// progress are not copied.
+//
+// Blobs already on the destination are skipped, so that an interrupted
+// migration resumes where it stopped, and a later migration only copies what
+// changed since. The blobs are copied before the links referencing them. For
+// a final, incremental migration, the source should be read-only.
+//
+// Blobs of the source which do not match their digest are not copied, and
+// the repositories are then only copied if opts.AllowCorrupt is set.
+func Migrate(ctx context.Context, src, dst driver.StorageDriver, opts MigrateOpts) (MigrateStats, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrencyLimit
+	}
+
+	m := &migration{src: src, dst: dst, opts: opts}
+	if err := m.migrateBlobs(ctx); err != nil {
+		return m.stats, err
+	}
+	if m.stats.BlobsCorrupt > 0 && !opts.AllowCorrupt {
+		return m.stats, fmt.Errorf("%d blobs do not match their digest, not copying the repositories", m.stats.BlobsCorrupt)
+	}
+	if err := m.migrateRepositories(ctx); err != nil {
+		return m.stats, err
+	}
+	return m.stats, nil
+}
+
+type migration struct {
+	src, dst driver.StorageDriver
+	opts     MigrateOpts
+
+	mu    sync.Mutex
+	stats MigrateStats
+
+	// corrupt holds the digests of the corrupt blobs of the source
+	corrupt sync.Map
+}
+
+func (m *migration) count(f func(stats *MigrateStats)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(&m.stats)
+}
+
+// walk walks the files under root of d, calling f on each of them with up
+// to the concurrency of the migration. A missing root is walked as empty.
+func (m *migration) walk(ctx context.Context, d driver.StorageDriver, root string, f func(ctx context.Context, fileInfo driver.FileInfo) error) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(m.opts.Concurrency)
+
+	err := driver.WalkParallel(gctx, d, root, m.opts.Concurrency, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			if path.Base(fileInfo.Path()) == "_uploads" {
+				return driver.ErrSkipDir
+			}
+			return nil
+		}
+		g.Go(func() error {
+			return f(gctx, fileInfo)
+		})
+		return nil
+	})
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		err = nil
+	}
+	return errors.Join(g.Wait(), err)
+}
+
+func (m *migration) migrateBlobs(ctx context.Context) error {
+	root, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	return m.walk(ctx, m.src, root, func(ctx context.Context, fileInfo driver.FileInfo) error {
+		if path.Base(fileInfo.Path()) != "data" {
+			return nil
+		}
+		dgst, err := digestFromPath(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		return m.migrateBlob(ctx, dgst, fileInfo.Path(), fileInfo.Size())
+	})
+}
+
+// migrateBlob copies the blob at p, of the given size, unless it is already
+// on the destination.
+func (m *migration) migrateBlob(ctx context.Context, dgst digest.Digest, p string, size int64) error {
+	fileInfo, err := m.dst.Stat(ctx, p)
+	switch {
+	case err == nil && fileInfo.Size() == size:
+		verified := true
+		if m.opts.Verify {
+			if verified, err = verifyBlob(ctx, m.dst, p, dgst); err != nil {
+				return err
+			}
+		}
+		if verified {
+			m.count(func(stats *MigrateStats) { stats.BlobsSkipped++ })
+			return nil
+		}
+	case err != nil && !errors.As(err, &driver.PathNotFoundError{}):
+		return err
+	}
+
+	r, err := m.src.Reader(ctx, p, 0)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := m.dst.Writer(ctx, p, false)
+	if err != nil {
+		return err
+	}
+	verifier := dgst.Verifier()
+	n, err := io.Copy(w, io.TeeReader(r, verifier))
+	if err == nil && !verifier.Verified() {
+		dcontext.GetLogger(ctx).Errorf("migrate: blob %s does not match its digest, skipping", dgst)
+		m.corrupt.Store(dgst, struct{}{})
+		m.count(func(stats *MigrateStats) { stats.BlobsCorrupt++ })
+		return cancelWriter(ctx, w)
+	}
+	if err != nil {
+		// nolint:errcheck
+		cancelWriter(ctx, w)
+		return fmt.Errorf("unable to copy blob %s: %v", dgst, err)
+	}
+	if err := w.Commit(ctx); err != nil {
+		return fmt.Errorf("unable to copy blob %s: %v", dgst, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("unable to copy blob %s: %v", dgst, err)
+	}
+
+	m.count(func(stats *MigrateStats) {
+		stats.BlobsCopied++
+		stats.BytesCopied += n
+	})
+	return nil
+}
+
+// cancelWriter cancels w, then closes it.
+func cancelWriter(ctx context.Context, w driver.FileWriter) error {
+	err := w.Cancel(ctx)
+	if closeErr := w.Close(); closeErr != nil {
+		dcontext.GetLogger(ctx).Errorf("migrate: error closing cancelled writer: %v", closeErr)
+	}
+	return err
+}
+
+// verifyBlob reports whether the content of the blob at p of d matches its
+// digest.
+func verifyBlob(ctx context.Context, d driver.StorageDriver, p string, dgst digest.Digest) (bool, error) {
+	r, err := d.Reader(ctx, p, 0)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(verifier, r); err != nil {
+		return false, err
+	}
+	return verifier.Verified(), nil
+}
+
+func (m *migration) migrateRepositories(ctx context.Context) error {
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	// the directories and files of the source, to prune the destination
+	var seen sync.Map
+	err = m.walk(ctx, m.src, root, func(ctx context.Context, fileInfo driver.FileInfo) error {
+		p := fileInfo.Path()
+		if m.opts.Prune {
+			for dir := p; dir != root; dir = path.Dir(dir) {
+				if _, loaded := seen.LoadOrStore(dir, struct{}{}); loaded {
+					break
+				}
+			}
+		}
+
+		content, err := m.src.GetContent(ctx, p)
+		if err != nil {
+			return err
+		}
+		if _, ok := m.corrupt.Load(digest.Digest(content)); ok {
+			dcontext.GetLogger(ctx).Errorf("migrate: %s references corrupt blob %s, skipping", p, content)
+			m.count(func(stats *MigrateStats) { stats.LinksCorrupt++ })
+			return nil
+		}
+		existing, err := m.dst.GetContent(ctx, p)
+		switch {
+		case err == nil && bytes.Equal(existing, content):
+			m.count(func(stats *MigrateStats) { stats.LinksSkipped++ })
+			return nil
+		case err != nil && !errors.As(err, &driver.PathNotFoundError{}):
+			return err
+		}
+
+		if err := m.dst.PutContent(ctx, p, content); err != nil {
+			return err
+		}
+		m.count(func(stats *MigrateStats) { stats.LinksCopied++ })
+		return nil
+	})
+	if err != nil || !m.opts.Prune {
+		return err
+	}
+
+	// the topmost directories and files missing from the source are deleted
+	var pruned []string
+	err = driver.WalkParallel(ctx, m.dst, root, m.opts.Concurrency, func(fileInfo driver.FileInfo) error {
+		p := fileInfo.Path()
+		if fileInfo.IsDir() && path.Base(p) == "_uploads" {
+			return driver.ErrSkipDir
+		}
+		if _, ok := seen.Load(p); ok {
+			return nil
+		}
+		m.mu.Lock()
+		pruned = append(pruned, p)
+		m.mu.Unlock()
+		if fileInfo.IsDir() {
+			return driver.ErrSkipDir
+		}
+		return nil
+	})
+	if err != nil && !errors.As(err, &driver.PathNotFoundError{}) {
+		return err
+	}
+
+	for _, p := range pruned {
+		if err := m.dst.Delete(ctx, p); err != nil && !errors.As(err, &driver.PathNotFoundError{}) {
+			return err
+		}
+		m.stats.LinksPruned++
+	}
+	return nil
+}
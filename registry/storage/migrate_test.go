@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func allRepositories(t *testing.T, registry distribution.Namespace) []string {
+	var repos []string
+	err := registry.(distribution.RepositoryEnumerator).Enumerate(dcontext.Background(), func(name string) error {
+		repos = append(repos, name)
+		return nil
+	})
+	require.NoError(t, err)
+	return repos
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := dcontext.Background()
+	src, dst := inmemory.New(), inmemory.New()
+	srcRegistry := createRegistry(t, src)
+
+	images := make(map[string]image)
+	for _, name := range []string{"library/ubuntu", "library/debian"} {
+		repo := makeRepository(t, srcRegistry, name)
+		im := uploadRandomSchema2Image(t, repo)
+		require.NoError(t, repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: im.manifestDigest}))
+		require.NoError(t, repo.Tags(ctx).Tag(ctx, "stable", distribution.Descriptor{Digest: im.manifestDigest}))
+		images[name] = im
+	}
+	// uploads in progress are not migrated
+	_, err := makeRepository(t, srcRegistry, "library/ubuntu").Blobs(ctx).Create(ctx)
+	require.NoError(t, err)
+
+	stats, err := Migrate(ctx, src, dst, MigrateOpts{Concurrency: 4})
+	require.NoError(t, err)
+	require.Equal(t, len(allBlobs(t, srcRegistry)), stats.BlobsCopied)
+	require.Zero(t, stats.BlobsSkipped)
+	require.Zero(t, stats.BlobsCorrupt)
+	require.NotZero(t, stats.LinksCopied)
+
+	dstRegistry := createRegistry(t, dst)
+	require.Equal(t, allBlobs(t, srcRegistry), allBlobs(t, dstRegistry))
+	require.ElementsMatch(t, allRepositories(t, srcRegistry), allRepositories(t, dstRegistry))
+	for name, im := range images {
+		repo := makeRepository(t, dstRegistry, name)
+		tags, err := repo.Tags(ctx).All(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"latest", "stable"}, tags)
+		desc, err := repo.Tags(ctx).Get(ctx, "latest")
+		require.NoError(t, err)
+		require.Equal(t, im.manifestDigest, desc.Digest)
+		_, err = makeManifestService(t, repo).Get(ctx, im.manifestDigest)
+		require.NoError(t, err)
+		for dgst := range im.layers {
+			_, err := repo.Blobs(ctx).Stat(ctx, dgst)
+			require.NoError(t, err)
+		}
+	}
+	uploads, err := dst.List(ctx, "/docker/registry/v2/repositories/library/ubuntu")
+	require.NoError(t, err)
+	require.NotContains(t, uploads, "/docker/registry/v2/repositories/library/ubuntu/_uploads")
+
+	// an incremental migration copies the changes only, and prunes the
+	// deleted tags and repositories
+	repo := makeRepository(t, srcRegistry, "library/ubuntu")
+	im := uploadRandomSchema2Image(t, repo)
+	require.NoError(t, repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: im.manifestDigest}))
+	require.NoError(t, repo.Tags(ctx).Untag(ctx, "stable"))
+	require.NoError(t, src.Delete(ctx, "/docker/registry/v2/repositories/library/debian"))
+
+	skipped := stats.BlobsCopied
+	stats, err = Migrate(ctx, src, dst, MigrateOpts{Prune: true})
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.BlobsCopied)
+	require.Equal(t, skipped, stats.BlobsSkipped)
+	require.NotZero(t, stats.LinksSkipped)
+	require.Equal(t, 2, stats.LinksPruned)
+
+	require.Equal(t, []string{"library/ubuntu"}, allRepositories(t, dstRegistry))
+	repo = makeRepository(t, dstRegistry, "library/ubuntu")
+	tags, err := repo.Tags(ctx).All(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"latest"}, tags)
+	desc, err := repo.Tags(ctx).Get(ctx, "latest")
+	require.NoError(t, err)
+	require.Equal(t, im.manifestDigest, desc.Digest)
+}
+
+func TestMigrateBlobs(t *testing.T) {
+	ctx := dcontext.Background()
+	src, dst := inmemory.New(), inmemory.New()
+
+	put := func(d driver.StorageDriver, dgst digest.Digest, content string) {
+		p, err := pathFor(blobDataPathSpec{digest: dgst})
+		require.NoError(t, err)
+		require.NoError(t, d.PutContent(ctx, p, []byte(content)))
+	}
+	get := func(d driver.StorageDriver, dgst digest.Digest) string {
+		p, err := pathFor(blobDataPathSpec{digest: dgst})
+		require.NoError(t, err)
+		content, err := d.GetContent(ctx, p)
+		if err != nil {
+			require.ErrorAs(t, err, &driver.PathNotFoundError{})
+			return ""
+		}
+		return string(content)
+	}
+
+	partial := digest.FromString("partial")
+	put(src, partial, "partial")
+	put(dst, partial, "part")
+	replaced := digest.FromString("replaced")
+	put(src, replaced, "replaced")
+	put(dst, replaced, "REPLACED")
+	corrupt := digest.FromString("corrupt")
+	put(src, corrupt, "tpurroc")
+
+	// blobs partially copied are copied again, and blobs of the expected
+	// size are trusted
+	stats, err := Migrate(ctx, src, dst, MigrateOpts{AllowCorrupt: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.BlobsCopied)
+	require.Equal(t, 1, stats.BlobsSkipped)
+	require.Equal(t, 1, stats.BlobsCorrupt)
+	require.Equal(t, "partial", get(dst, partial))
+	require.Equal(t, "REPLACED", get(dst, replaced))
+	require.Empty(t, get(dst, corrupt))
+
+	// unless verified
+	stats, err = Migrate(ctx, src, dst, MigrateOpts{Verify: true, AllowCorrupt: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.BlobsCopied)
+	require.Equal(t, 1, stats.BlobsSkipped)
+	require.Equal(t, 1, stats.BlobsCorrupt)
+	require.Equal(t, "replaced", get(dst, replaced))
+}
+
+func TestMigrateCorruptBlobs(t *testing.T) {
+	ctx := dcontext.Background()
+	src, dst := inmemory.New(), inmemory.New()
+
+	link := func(dgst digest.Digest, content string) string {
+		p, err := pathFor(blobDataPathSpec{digest: dgst})
+		require.NoError(t, err)
+		require.NoError(t, src.PutContent(ctx, p, []byte(content)))
+		p, err = pathFor(layerLinkPathSpec{name: "library/ubuntu", digest: dgst})
+		require.NoError(t, err)
+		require.NoError(t, src.PutContent(ctx, p, []byte(dgst)))
+		return p
+	}
+	valid := link(digest.FromString("valid"), "valid")
+	corrupt := link(digest.FromString("corrupt"), "tpurroc")
+
+	// the repositories are not copied
+	stats, err := Migrate(ctx, src, dst, MigrateOpts{})
+	require.ErrorContains(t, err, "1 blobs do not match their digest")
+	require.Equal(t, 1, stats.BlobsCopied)
+	require.Equal(t, 1, stats.BlobsCorrupt)
+	require.Zero(t, stats.LinksCopied)
+	_, err = dst.Stat(ctx, valid)
+	require.ErrorAs(t, err, &driver.PathNotFoundError{})
+
+	// unless allowed, without the links referencing the corrupt blobs
+	stats, err = Migrate(ctx, src, dst, MigrateOpts{AllowCorrupt: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.BlobsCorrupt)
+	require.Equal(t, 1, stats.LinksCopied)
+	require.Equal(t, 1, stats.LinksCorrupt)
+	_, err = dst.Stat(ctx, valid)
+	require.NoError(t, err)
+	_, err = dst.Stat(ctx, corrupt)
+	require.ErrorAs(t, err, &driver.PathNotFoundError{})
+}
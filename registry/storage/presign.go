@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// PresignUploadParts returns presigned URLs for clients to upload the parts
+// numbered partNumbers of the blob upload id of the repository name directly
+// to the storage backend. The uploaded parts are committed as the content of
+// the upload once it completes. It returns distribution.ErrUnsupported if the
+// driver does not implement storagedriver.UploadPresigner, and
+// distribution.ErrBlobUploadUnknown if the upload or the content the driver
+// started for it when the upload was created is not found.
+func PresignUploadParts(ctx context.Context, driver storagedriver.StorageDriver, name, id string, partNumbers []int, expiry time.Duration) ([]string, error) {
+	presigner, ok := driver.(storagedriver.UploadPresigner)
+	if !ok {
+		return nil, distribution.ErrUnsupported
+	}
+
+	startedAtPath, err := pathFor(uploadStartedAtPathSpec{name: name, id: id})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := driver.Stat(ctx, startedAtPath); err != nil {
+		switch err := err.(type) {
+		case storagedriver.PathNotFoundError:
+			return nil, distribution.ErrBlobUploadUnknown
+		default:
+			return nil, err
+		}
+	}
+
+	path, err := pathFor(uploadDataPathSpec{name: name, id: id})
+	if err != nil {
+		return nil, err
+	}
+	urls, err := presigner.PresignUploadParts(ctx, path, partNumbers, expiry)
+	if err != nil {
+		if errors.As(err, new(storagedriver.PathNotFoundError)) {
+			return nil, distribution.ErrBlobUploadUnknown
+		}
+		return nil, err
+	}
+	return urls, nil
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type presigningDriver struct {
+	driver.StorageDriver
+	notStarted bool
+}
+
+func (d presigningDriver) PresignUploadParts(ctx context.Context, path string, partNumbers []int, expiry time.Duration) ([]string, error) {
+	if d.notStarted {
+		return nil, driver.PathNotFoundError{Path: path, DriverName: "presigning"}
+	}
+	urls := make([]string, 0, len(partNumbers))
+	for _, n := range partNumbers {
+		urls = append(urls, fmt.Sprintf("https://storage.example.com%s?part=%d&expiry=%s", path, n, expiry))
+	}
+	return urls, nil
+}
+
+func TestPresignUploadParts(t *testing.T) {
+	ctx := context.Background()
+	d := presigningDriver{StorageDriver: inmemory.New()}
+	id := uuid.NewString()
+	addUploads(ctx, t, d, id, "test-repo", time.Now())
+
+	_, err := PresignUploadParts(ctx, d.StorageDriver, "test-repo", id, []int{1}, time.Hour)
+	require.ErrorIs(t, err, distribution.ErrUnsupported)
+
+	_, err = PresignUploadParts(ctx, d, "test-repo", uuid.NewString(), []int{1}, time.Hour)
+	require.ErrorIs(t, err, distribution.ErrBlobUploadUnknown)
+
+	urls, err := PresignUploadParts(ctx, d, "test-repo", id, []int{1, 2}, time.Hour)
+	require.NoError(t, err)
+
+	dataPath, err := pathFor(uploadDataPathSpec{name: "test-repo", id: id})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"https://storage.example.com" + dataPath + "?part=1&expiry=1h0m0s",
+		"https://storage.example.com" + dataPath + "?part=2&expiry=1h0m0s",
+	}, urls)
+
+	_, err = PresignUploadParts(ctx, presigningDriver{StorageDriver: d.StorageDriver, notStarted: true}, "test-repo", id, []int{1}, time.Hour)
+	require.ErrorIs(t, err, distribution.ErrBlobUploadUnknown)
+}
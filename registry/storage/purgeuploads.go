@@ -4,6 +4,7 @@ import (
 	"context"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	storageDriver "github.com/distribution/distribution/v3/registry/storage/driver"
@@ -61,20 +62,18 @@ func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriv
 	var errors []error
 	uploads := make(map[string]uploadData)
 
-	inUploadDir := false
 	root, err := pathFor(repositoriesRootPathSpec{})
 	if err != nil {
 		return uploads, append(errors, err)
 	}
 
-	err = driver.Walk(ctx, root, func(fileInfo storageDriver.FileInfo) error {
+	var mu sync.Mutex
+	err = storageDriver.WalkParallel(ctx, driver, root, DefaultConcurrencyLimit, func(fileInfo storageDriver.FileInfo) error {
 		filePath := fileInfo.Path()
 		_, file := path.Split(filePath)
 		if file[0] == '_' {
 			// Reserved directory
-			inUploadDir = (file == "_uploads")
-
-			if fileInfo.IsDir() && !inUploadDir {
+			if fileInfo.IsDir() && file != "_uploads" {
 				return storageDriver.ErrSkipDir
 			}
 
@@ -85,6 +84,15 @@ func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriv
 			// Cannot reliably delete
 			return nil
 		}
+
+		var startedAt time.Time
+		var startedAtErr error
+		if file == "startedat" {
+			startedAt, startedAtErr = readStartedAtFile(ctx, driver, filePath)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
 		ud, ok := uploads[uuid]
 		if !ok {
 			ud = newUploadData()
@@ -93,10 +101,10 @@ func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriv
 			ud.containingDir = filePath
 		}
 		if file == "startedat" {
-			if t, err := readStartedAtFile(ctx, driver, filePath); err == nil {
-				ud.startedAt = t
+			if startedAtErr == nil {
+				ud.startedAt = startedAt
 			} else {
-				errors = pushError(errors, filePath, err)
+				errors = pushError(errors, filePath, startedAtErr)
 			}
 		}
 
@@ -123,6 +131,33 @@ func uuidFromPath(path string) (string, bool) {
 	return "", false
 }
 
+// UploadFromPath returns the repository name and upload id of an upload
+// directory path, such as the paths returned by PurgeUploads. ok is false if
+// p is not an upload directory.
+func UploadFromPath(p string) (name, id string, ok bool) {
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return "", "", false
+	}
+
+	rel := strings.TrimPrefix(p, root+"/")
+	if rel == p {
+		return "", "", false
+	}
+
+	dir, id := path.Split(rel)
+	name, uploads := path.Split(strings.TrimSuffix(dir, "/"))
+	name = strings.TrimSuffix(name, "/")
+	if uploads != "_uploads" || name == "" {
+		return "", "", false
+	}
+	if _, isContainingDir := uuidFromPath(id); !isContainingDir {
+		return "", "", false
+	}
+
+	return name, id, true
+}
+
 // readStartedAtFile reads the date from an upload's startedAtFile
 func readStartedAtFile(ctx context.Context, driver storageDriver.StorageDriver, path string) (time.Time, error) {
 	startedAtBytes, err := driver.GetContent(ctx, path)
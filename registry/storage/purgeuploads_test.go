@@ -163,3 +163,34 @@ func TestPurgeMissingStartedAt(t *testing.T) {
 		t.Errorf("Files unexpectedly deleted: %s", deleted)
 	}
 }
+
+func TestUploadFromPath(t *testing.T) {
+	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	fs, ctx := testUploadFS(t, 0, "library/test-repo", oneHourAgo)
+	id := uuid.NewString()
+	addUploads(ctx, t, fs, id, "library/test-repo", oneHourAgo)
+
+	deleted, errs := PurgeUploads(ctx, fs, time.Now(), true)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(deleted) != 1 {
+		t.Fatalf("Unexpectedly deleted file count %d != 1", len(deleted))
+	}
+
+	name, uploadID, ok := UploadFromPath(deleted[0])
+	if !ok || name != "library/test-repo" || uploadID != id {
+		t.Errorf("unexpected upload for %s: %q %q %v", deleted[0], name, uploadID, ok)
+	}
+
+	for _, p := range []string{
+		"/docker/registry/v2/blobs/sha256/ab/abcd",
+		"/docker/registry/v2/repositories/test-repo/_uploads",
+		"/docker/registry/v2/repositories/_uploads/" + id,
+		"/docker/registry/v2/repositories/test-repo/_manifests/" + id,
+	} {
+		if _, _, ok := UploadFromPath(p); ok {
+			t.Errorf("expected %s not to be an upload path", p)
+		}
+	}
+}